@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"log/slog"
+	"time"
+
+	"github.com/koki1610168/go-payment-ledger/internal/config"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+	"github.com/koki1610168/go-payment-ledger/pkg/client"
+)
+
+// backend is what the commands need from the ledger. apiBackend goes
+// through the HTTP API and its authorization; dbBackend talks to the
+// database directly and is only used with -mode db.
+type backend interface {
+	Balance(ctx context.Context, clientID string) (client.Balance, error)
+	Ledger(ctx context.Context, clientID string, pageSize int) iter.Seq2[client.LedgerEntry, error]
+	CreateClient(ctx context.Context, clientID, currency string) (client.Account, error)
+	Adjust(ctx context.Context, req client.AdjustmentRequest, actor string) (client.Adjustment, error)
+	Reconcile(ctx context.Context) (client.ReconciliationReport, error)
+	// Clients lists every client, for exports without -client
+	Clients(ctx context.Context) ([]string, error)
+	Close()
+}
+
+var errNeedsDBMode = errors.New("listing all clients needs -mode db; pass -client to export one client")
+
+type apiBackend struct {
+	c *client.Client
+}
+
+func (b apiBackend) Balance(ctx context.Context, clientID string) (client.Balance, error) {
+	return b.c.GetBalance(ctx, clientID)
+}
+
+func (b apiBackend) Ledger(ctx context.Context, clientID string, pageSize int) iter.Seq2[client.LedgerEntry, error] {
+	return b.c.GetLedger(ctx, clientID, pageSize)
+}
+
+func (b apiBackend) CreateClient(ctx context.Context, clientID, currency string) (client.Account, error) {
+	return b.c.CreateClient(ctx, clientID, currency)
+}
+
+// Adjust ignores actor: the server records the API key that posted it
+func (b apiBackend) Adjust(ctx context.Context, req client.AdjustmentRequest, actor string) (client.Adjustment, error) {
+	return b.c.PostAdjustment(ctx, req)
+}
+
+func (b apiBackend) Reconcile(ctx context.Context) (client.ReconciliationReport, error) {
+	return b.c.Reconcile(ctx)
+}
+
+func (b apiBackend) Clients(ctx context.Context) ([]string, error) {
+	return nil, errNeedsDBMode
+}
+
+func (b apiBackend) Close() {}
+
+type dbBackend struct {
+	db    *server.DB
+	store *server.Store
+}
+
+func openDBBackend(ctx context.Context, url string, logger *slog.Logger) (*dbBackend, error) {
+	db, err := server.OpenDB(ctx, config.Database{
+		URL:            url,
+		MaxConns:       2,
+		ConnectTimeout: 10 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &dbBackend{db: db, store: server.NewStore(db.Pool).WithLogger(logger)}, nil
+}
+
+func (b *dbBackend) Balance(ctx context.Context, clientID string) (client.Balance, error) {
+	balance, currency, err := b.store.GetBalance(ctx, clientID)
+	if err != nil {
+		return client.Balance{}, err
+	}
+	return client.Balance{ClientID: clientID, Balance: balance, Currency: currency}, nil
+}
+
+func (b *dbBackend) Ledger(ctx context.Context, clientID string, pageSize int) iter.Seq2[client.LedgerEntry, error] {
+	return func(yield func(client.LedgerEntry, error) bool) {
+		// GetLedgerPage does not tell an empty ledger from a missing client
+		if _, _, err := b.store.GetBalance(ctx, clientID); err != nil {
+			yield(client.LedgerEntry{}, err)
+			return
+		}
+		var cursor *server.LedgerCursor
+		for {
+			entries, more, err := b.store.GetLedgerPage(ctx, clientID, cursor, pageSize)
+			if err != nil {
+				yield(client.LedgerEntry{}, err)
+				return
+			}
+			for _, e := range entries {
+				entry := client.LedgerEntry{
+					ID:             e.EntryId.String(),
+					ClientID:       e.ClientId,
+					Amount:         e.Amount,
+					CreatedAt:      e.CreatedAt,
+					IdempotencyKey: e.IdempotencyKey.String,
+				}
+				if !yield(entry, nil) {
+					return
+				}
+			}
+			if !more || len(entries) == 0 {
+				return
+			}
+			last := entries[len(entries)-1]
+			cursor = &server.LedgerCursor{CreatedAt: last.CreatedAt, EntryID: last.EntryId}
+		}
+	}
+}
+
+func (b *dbBackend) CreateClient(ctx context.Context, clientID, currency string) (client.Account, error) {
+	c, err := b.store.CreateClient(ctx, clientID, currency)
+	if err != nil {
+		return client.Account{}, err
+	}
+	return client.Account(c), nil
+}
+
+func (b *dbBackend) Adjust(ctx context.Context, req client.AdjustmentRequest, actor string) (client.Adjustment, error) {
+	adj, err := b.store.PostAdjustment(ctx, server.Adjustment{
+		ClientID:       req.ClientID,
+		Amount:         req.Amount,
+		Reason:         req.Reason,
+		Actor:          actor,
+		IdempotencyKey: req.IdempotencyKey,
+	})
+	if err != nil {
+		return client.Adjustment{}, err
+	}
+	return client.Adjustment(adj), nil
+}
+
+func (b *dbBackend) Reconcile(ctx context.Context) (client.ReconciliationReport, error) {
+	r, err := b.store.Reconcile(ctx)
+	if err != nil {
+		return client.ReconciliationReport{}, err
+	}
+	report := client.ReconciliationReport{
+		CheckedAt:      r.CheckedAt,
+		ClientsChecked: r.ClientsChecked,
+		Discrepancies:  make([]client.Discrepancy, len(r.Discrepancies)),
+	}
+	for i, d := range r.Discrepancies {
+		report.Discrepancies[i] = client.Discrepancy(d)
+	}
+	return report, nil
+}
+
+func (b *dbBackend) Clients(ctx context.Context) ([]string, error) {
+	clients, err := b.store.ListClients(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(clients))
+	for i, c := range clients {
+		ids[i] = c.ID
+	}
+	return ids, nil
+}
+
+func (b *dbBackend) Close() {
+	b.db.Close()
+}
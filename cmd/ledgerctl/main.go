@@ -0,0 +1,436 @@
+// Command ledgerctl is the operator tool for the payment ledger.
+//
+//	ledgerctl [global flags] <command> [flags] [args]
+//
+// By default it talks to the HTTP API with an API key, so every action
+// goes through the server's authorization and audit logging. -mode db
+// connects to the database directly for when the API is down; it must be
+// asked for explicitly and records the operator given by -actor.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/pkg/client"
+)
+
+// Exit codes
+const (
+	exitOK = 0
+	// exitError is any failed command
+	exitError = 1
+	exitUsage = 2
+	// exitDiscrepancies means reconciliation ran and found mismatches
+	exitDiscrepancies = 3
+)
+
+const usage = `Usage: ledgerctl [global flags] <command> [flags] [args]
+
+Commands:
+  balance <client-id>...               show balances
+  ledger <client-id>                   show a client's ledger entries
+  create-client -currency C <id>       open an account with a zero balance
+  adjust -amount N -reason R <id>      post a manual correction
+  reconcile                            compare balances with their ledgers
+  export [-client id] [-format F]      write ledger entries as csv or jsonl
+
+Global flags:
+`
+
+type cli struct {
+	stdout, stderr io.Writer
+	backend        backend
+	output         string
+	actor          string
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Getenv, os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, getenv func(string) string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("ledgerctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	serverURL := fs.String("server", envOr(getenv, "LEDGER_URL", "http://localhost:8080"), "API base URL (LEDGER_URL)")
+	apiKey := fs.String("api-key", getenv("LEDGER_API_KEY"), "API key sent as a bearer token (LEDGER_API_KEY)")
+	mode := fs.String("mode", "api", `"api", or "db" to bypass the API and use the database directly`)
+	databaseURL := fs.String("database-url", getenv("DATABASE_URL"), "database for -mode db (DATABASE_URL)")
+	actor := fs.String("actor", "", `operator recorded on adjustments in -mode db (default "ledgerctl:$USER")`)
+	output := fs.String("o", "table", `output format: "table" or "json"`)
+	timeout := fs.Duration("timeout", 30*time.Second, "time limit for the whole command")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "ledgerctl: unknown output format %q\n", *output)
+		return exitUsage
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	c := &cli{stdout: stdout, stderr: stderr, output: *output, actor: *actor}
+	switch *mode {
+	case "api":
+		if *actor != "" {
+			fmt.Fprintln(stderr, "ledgerctl: -actor is only used with -mode db; the API records the API key")
+			return exitUsage
+		}
+		c.backend = apiBackend{client.New(*serverURL).WithAPIKey(*apiKey)}
+	case "db":
+		if c.actor == "" {
+			c.actor = "ledgerctl:" + envOr(getenv, "USER", "unknown")
+		}
+		logger := slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+		b, err := openDBBackend(ctx, *databaseURL, logger)
+		if err != nil {
+			fmt.Fprintln(stderr, "ledgerctl:", err)
+			return exitError
+		}
+		c.backend = b
+	default:
+		fmt.Fprintf(stderr, "ledgerctl: unknown mode %q\n", *mode)
+		return exitUsage
+	}
+	defer c.backend.Close()
+
+	cmd, cmdArgs := fs.Arg(0), fs.Args()[1:]
+	var err error
+	switch cmd {
+	case "balance":
+		err = c.balance(ctx, cmdArgs)
+	case "ledger":
+		err = c.ledger(ctx, cmdArgs)
+	case "create-client":
+		err = c.createClient(ctx, cmdArgs)
+	case "adjust":
+		err = c.adjust(ctx, cmdArgs)
+	case "reconcile":
+		err = c.reconcile(ctx, cmdArgs)
+	case "export":
+		err = c.export(ctx, cmdArgs)
+	default:
+		fmt.Fprintf(stderr, "ledgerctl: unknown command %q\n", cmd)
+		fs.Usage()
+		return exitUsage
+	}
+
+	var exit exitStatus
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &exit):
+		if exit.msg != "" {
+			fmt.Fprintln(stderr, "ledgerctl:", exit.msg)
+		}
+		return exit.code
+	default:
+		fmt.Fprintf(stderr, "ledgerctl %s: %v\n", cmd, err)
+		return exitError
+	}
+}
+
+// exitStatus ends a command with a specific exit code
+type exitStatus struct {
+	code int
+	msg  string
+}
+
+func (e exitStatus) Error() string { return e.msg }
+
+func usageError(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	return exitUsage
+}
+
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("ledgerctl "+name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// parse parses flags given before or after the positional arguments,
+// so "adjust client_001 -amount 5" works like "adjust -amount 5 client_001"
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, exitStatus{code: exitOK}
+			}
+			return nil, exitStatus{code: exitUsage}
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func (c *cli) balance(ctx context.Context, args []string) error {
+	ids, err := parse(c.flags("balance"), args)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return exitStatus{code: exitUsage, msg: "balance needs at least one client ID"}
+	}
+
+	balances := make([]client.Balance, 0, len(ids))
+	for _, id := range ids {
+		b, err := c.backend.Balance(ctx, id)
+		if err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		balances = append(balances, b)
+	}
+	if c.output == "json" {
+		return c.writeJSON(balances)
+	}
+	return c.table([]string{"CLIENT", "BALANCE", "CURRENCY"}, func(row func(...any)) {
+		for _, b := range balances {
+			row(b.ClientID, b.Balance, b.Currency)
+		}
+	})
+}
+
+func (c *cli) ledger(ctx context.Context, args []string) error {
+	fs := c.flags("ledger")
+	pageSize := fs.Int("page-size", client.DefaultPageSize, "entries fetched per request")
+	limit := fs.Int("limit", 0, "stop after this many entries (0 for all)")
+	ids, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 {
+		return exitStatus{code: exitUsage, msg: "ledger needs exactly one client ID"}
+	}
+
+	entries := []client.LedgerEntry{}
+	for e, err := range c.backend.Ledger(ctx, ids[0], *pageSize) {
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		if *limit > 0 && len(entries) >= *limit {
+			break
+		}
+	}
+	if c.output == "json" {
+		return c.writeJSON(entries)
+	}
+	return c.table([]string{"CREATED", "ENTRY", "AMOUNT", "IDEMPOTENCY KEY"}, func(row func(...any)) {
+		for _, e := range entries {
+			row(e.CreatedAt.Format(time.RFC3339), e.ID, e.Amount, e.IdempotencyKey)
+		}
+	})
+}
+
+func (c *cli) createClient(ctx context.Context, args []string) error {
+	fs := c.flags("create-client")
+	currency := fs.String("currency", "", "ISO 4217 currency code, e.g. JPY (required)")
+	ids, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 || *currency == "" {
+		return exitStatus{code: exitUsage, msg: "usage: create-client -currency C <client-id>"}
+	}
+
+	acct, err := c.backend.CreateClient(ctx, ids[0], *currency)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.writeJSON(acct)
+	}
+	return c.table([]string{"CLIENT", "BALANCE", "CURRENCY", "CREATED"}, func(row func(...any)) {
+		row(acct.ID, acct.Balance, acct.Currency, acct.CreatedAt.Format(time.RFC3339))
+	})
+}
+
+func (c *cli) adjust(ctx context.Context, args []string) error {
+	fs := c.flags("adjust")
+	amount := fs.Int64("amount", 0, "signed amount in minor units; negative debits the client (required)")
+	reason := fs.String("reason", "", "why the correction is needed, kept with the ledger entry (required)")
+	key := fs.String("idempotency-key", "", "key that makes retrying safe (default: random)")
+	ids, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(ids) != 1:
+		return exitStatus{code: exitUsage, msg: "adjust needs exactly one client ID"}
+	case *amount == 0:
+		return exitStatus{code: exitUsage, msg: "adjust needs a non-zero -amount"}
+	case strings.TrimSpace(*reason) == "":
+		return exitStatus{code: exitUsage, msg: "adjust needs a -reason"}
+	}
+	if *key == "" {
+		// Printed so that a timed-out adjustment can be retried with the
+		// same key instead of being posted twice
+		*key = uuid.NewString()
+		fmt.Fprintln(c.stderr, "idempotency key:", *key)
+	}
+
+	adj, err := c.backend.Adjust(ctx, client.AdjustmentRequest{
+		ClientID:       ids[0],
+		Amount:         *amount,
+		Reason:         strings.TrimSpace(*reason),
+		IdempotencyKey: *key,
+	}, c.actor)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.writeJSON(adj)
+	}
+	return c.table([]string{"ADJUSTMENT", "CLIENT", "AMOUNT", "BALANCE", "ACTOR", "REASON"}, func(row func(...any)) {
+		row(adj.ID, adj.ClientID, adj.Amount, adj.Balance, adj.Actor, adj.Reason)
+	})
+}
+
+func (c *cli) reconcile(ctx context.Context, args []string) error {
+	if _, err := parse(c.flags("reconcile"), args); err != nil {
+		return err
+	}
+	report, err := c.backend.Reconcile(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		err = c.writeJSON(report)
+	} else {
+		fmt.Fprintf(c.stdout, "checked %d clients at %s\n", report.ClientsChecked, report.CheckedAt.Format(time.RFC3339))
+		if len(report.Discrepancies) > 0 {
+			err = c.table([]string{"CLIENT", "BALANCE", "LEDGER SUM", "DIFFERENCE"}, func(row func(...any)) {
+				for _, d := range report.Discrepancies {
+					row(d.ClientID, d.Balance, d.LedgerSum, d.Difference)
+				}
+			})
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if n := len(report.Discrepancies); n > 0 {
+		return exitStatus{code: exitDiscrepancies, msg: fmt.Sprintf("%d clients do not match their ledger", n)}
+	}
+	return nil
+}
+
+func (c *cli) export(ctx context.Context, args []string) error {
+	fs := c.flags("export")
+	clientID := fs.String("client", "", "client to export (default: every client, -mode db only)")
+	format := fs.String("format", "csv", `"csv" or "jsonl"`)
+	pageSize := fs.Int("page-size", 1000, "entries fetched per request")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	var write func(client.LedgerEntry) error
+	switch *format {
+	case "csv":
+		w := csv.NewWriter(c.stdout)
+		defer w.Flush()
+		if err := w.Write([]string{"entry_id", "client_id", "amount", "created_at", "idempotency_key"}); err != nil {
+			return err
+		}
+		write = func(e client.LedgerEntry) error {
+			return w.Write([]string{e.ID, e.ClientID, strconv.FormatInt(e.Amount, 10),
+				e.CreatedAt.Format(time.RFC3339Nano), e.IdempotencyKey})
+		}
+	case "jsonl":
+		enc := json.NewEncoder(c.stdout)
+		write = func(e client.LedgerEntry) error {
+			return enc.Encode(exportEntry{e.ID, e.ClientID, e.Amount, e.CreatedAt, e.IdempotencyKey})
+		}
+	default:
+		return exitStatus{code: exitUsage, msg: fmt.Sprintf("unknown export format %q", *format)}
+	}
+
+	ids := []string{*clientID}
+	if *clientID == "" {
+		var err error
+		if ids, err = c.backend.Clients(ctx); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		for e, err := range c.backend.Ledger(ctx, id, *pageSize) {
+			if err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+			if err := write(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exportEntry is one jsonl line, named like the csv columns
+type exportEntry struct {
+	EntryID        string    `json:"entry_id"`
+	ClientID       string    `json:"client_id"`
+	Amount         int64     `json:"amount"`
+	CreatedAt      time.Time `json:"created_at"`
+	IdempotencyKey string    `json:"idempotency_key,omitempty"`
+}
+
+func (c *cli) writeJSON(v any) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (c *cli) table(header []string, rows func(row func(...any))) error {
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	rows(func(cols ...any) {
+		for i, col := range cols {
+			if i > 0 {
+				fmt.Fprint(w, "\t")
+			}
+			fmt.Fprint(w, col)
+		}
+		fmt.Fprintln(w)
+	})
+	return w.Flush()
+}
+
+func envOr(getenv func(string) string, key, fallback string) string {
+	if v := getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/config"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// stubStore is a minimal in-memory server.ClientStore and
+// server.AdminStore
+type stubStore struct {
+	mu          sync.Mutex
+	balances    map[string]int64
+	ledger      map[string][]server.Ledger
+	adjustments map[string]server.Adjustment
+	mismatched  []server.Discrepancy
+}
+
+func newStubStore() *stubStore {
+	s := &stubStore{
+		balances:    map[string]int64{"client_001": 0},
+		ledger:      map[string][]server.Ledger{},
+		adjustments: map[string]server.Adjustment{},
+	}
+	for _, amount := range []int64{500, -200, 50} {
+		s.post("client_001", amount)
+	}
+	return s
+}
+
+func (s *stubStore) post(clientID string, amount int64) int64 {
+	s.balances[clientID] += amount
+	s.ledger[clientID] = append(s.ledger[clientID], server.Ledger{
+		EntryId: uuid.New(), ClientId: clientID, Amount: amount,
+		CreatedAt: time.Date(2026, 1, 1, 0, 0, len(s.ledger[clientID]), 0, time.UTC),
+	})
+	return s.balances[clientID]
+}
+
+func (s *stubStore) GetBalance(ctx context.Context, clientID string) (int64, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.balances[clientID]
+	if !ok {
+		return 0, "", server.ErrClientNotFound
+	}
+	return b, "JPY", nil
+}
+
+func (s *stubStore) GetLedger(ctx context.Context, clientID string) ([]server.Ledger, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]server.Ledger{}, s.ledger[clientID]...), nil
+}
+
+func (s *stubStore) CreatePayment(ctx context.Context, clientID string, amount int64, key string) (int64, error) {
+	return 0, server.ErrClientNotFound
+}
+
+func (s *stubStore) Transfer(ctx context.Context, from, to string, amount int64, key string) (int64, int64, error) {
+	return 0, 0, server.ErrClientNotFound
+}
+
+func (s *stubStore) CreateClient(ctx context.Context, clientID, currency string) (server.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.balances[clientID]; ok {
+		return server.Client{}, server.ErrClientExists
+	}
+	s.balances[clientID] = 0
+	return server.Client{ID: clientID, Currency: currency, CreatedAt: time.Now().UTC()}, nil
+}
+
+func (s *stubStore) PostAdjustment(ctx context.Context, adj server.Adjustment) (server.Adjustment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.adjustments[adj.IdempotencyKey]; ok {
+		return prev, nil
+	}
+	if _, ok := s.balances[adj.ClientID]; !ok {
+		return server.Adjustment{}, server.ErrClientNotFound
+	}
+	adj.ID, adj.EntryID = uuid.NewString(), uuid.NewString()
+	adj.Balance = s.post(adj.ClientID, adj.Amount)
+	s.adjustments[adj.IdempotencyKey] = adj
+	return adj, nil
+}
+
+func (s *stubStore) Reconcile(ctx context.Context) (server.ReconciliationReport, error) {
+	return server.ReconciliationReport{
+		CheckedAt:      time.Now().UTC(),
+		ClientsChecked: len(s.balances),
+		Discrepancies:  append([]server.Discrepancy{}, s.mismatched...),
+	}, nil
+}
+
+// testAPIKey is the admin key the ledger in runCLI accepts
+const testAPIKey = "ops-secret"
+
+// runCLI runs ledgerctl against store served by NewHandler, behind an
+// API key check that accepts testAPIKey as an admin
+func runCLI(t *testing.T, store *stubStore, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sum := sha256.Sum256([]byte(testAPIKey))
+	keys, err := server.NewAPIKeys([]config.APIKey{
+		{Name: "ops", KeySHA256: hex.EncodeToString(sum[:]), ClientIDs: []string{server.AnyClient}, Admin: true},
+	})
+	if err != nil {
+		t.Fatalf("api keys: %v", err)
+	}
+	srv := httptest.NewServer(keys.Middleware(server.NewHandler(store).WithLogger(logger)))
+	t.Cleanup(srv.Close)
+
+	getenv := func(key string) string {
+		switch key {
+		case "LEDGER_URL":
+			return srv.URL
+		case "LEDGER_API_KEY":
+			return testAPIKey
+		}
+		return ""
+	}
+	var out, errOut bytes.Buffer
+	code = run(context.Background(), args, getenv, &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestBalance(t *testing.T) {
+	store := newStubStore()
+
+	code, out, stderr := runCLI(t, store, "balance", "client_001")
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if !strings.Contains(out, "CLIENT") || !strings.Contains(out, "client_001  350") {
+		t.Errorf("table output:\n%s", out)
+	}
+
+	code, out, _ = runCLI(t, store, "-o", "json", "balance", "client_001")
+	var balances []struct {
+		ClientID string
+		Balance  int64
+	}
+	if err := json.Unmarshal([]byte(out), &balances); code != exitOK || err != nil {
+		t.Fatalf("exit %d, %v: %s", code, err, out)
+	}
+	if len(balances) != 1 || balances[0].Balance != 350 {
+		t.Errorf("got %+v", balances)
+	}
+
+	if code, _, stderr := runCLI(t, store, "balance", "nobody"); code != exitError || !strings.Contains(stderr, "404") {
+		t.Errorf("unknown client: exit %d, stderr %q", code, stderr)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	code, out, stderr := runCLI(t, newStubStore(), "-o", "json", "ledger", "client_001", "-page-size", "2")
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	var entries []struct{ Amount int64 }
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatalf("decode: %v: %s", err, out)
+	}
+	if len(entries) != 3 || entries[0].Amount != 500 || entries[2].Amount != 50 {
+		t.Errorf("got %+v", entries)
+	}
+}
+
+func TestAdjust(t *testing.T) {
+	store := newStubStore()
+
+	if code, _, stderr := runCLI(t, store, "adjust", "-amount", "100", "client_001"); code != exitUsage || !strings.Contains(stderr, "-reason") {
+		t.Errorf("without reason: exit %d, stderr %q", code, stderr)
+	}
+	if code, _, _ := runCLI(t, store, "-actor", "me", "adjust", "-amount", "1", "-reason", "x", "client_001"); code != exitUsage {
+		t.Errorf("-actor in api mode: exit %d, want %d", code, exitUsage)
+	}
+
+	code, out, stderr := runCLI(t, store, "-o", "json",
+		"adjust", "client_001", "-amount", "-50", "-reason", "duplicate capture", "-idempotency-key", "adj-1")
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	var adj struct {
+		Balance int64
+		Reason  string
+	}
+	if err := json.Unmarshal([]byte(out), &adj); err != nil {
+		t.Fatalf("decode: %v: %s", err, out)
+	}
+	if adj.Balance != 300 || adj.Reason != "duplicate capture" {
+		t.Errorf("got %+v", adj)
+	}
+	if store.balances["client_001"] != 300 {
+		t.Errorf("balance is %d, want 300", store.balances["client_001"])
+	}
+}
+
+func TestCreateClient(t *testing.T) {
+	store := newStubStore()
+	if code, _, stderr := runCLI(t, store, "create-client", "-currency", "EUR", "client_002"); code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if code, _, stderr := runCLI(t, store, "create-client", "-currency", "EUR", "client_002"); code != exitError || !strings.Contains(stderr, "409") {
+		t.Errorf("existing client: exit %d, stderr %q", code, stderr)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	store := newStubStore()
+	if code, out, stderr := runCLI(t, store, "reconcile"); code != exitOK || !strings.Contains(out, "checked 1 clients") {
+		t.Fatalf("exit %d, stdout %q, stderr %q", code, out, stderr)
+	}
+
+	store.mismatched = []server.Discrepancy{{ClientID: "client_001", Balance: 350, LedgerSum: 300, Difference: 50}}
+	code, out, _ := runCLI(t, store, "reconcile")
+	if code != exitDiscrepancies || !strings.Contains(out, "client_001") {
+		t.Errorf("exit %d, stdout %q", code, out)
+	}
+}
+
+func TestExport(t *testing.T) {
+	store := newStubStore()
+
+	code, out, stderr := runCLI(t, store, "export", "-client", "client_001")
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	if len(records) != 4 || records[0][0] != "entry_id" || records[1][2] != "500" {
+		t.Errorf("got %q", records)
+	}
+
+	code, out, _ = runCLI(t, store, "export", "-client", "client_001", "-format", "jsonl")
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); code != exitOK || len(lines) != 3 || !strings.Contains(lines[0], `"entry_id"`) {
+		t.Errorf("exit %d, jsonl %q", code, out)
+	}
+
+	if code, _, stderr := runCLI(t, store, "export"); code != exitError || !strings.Contains(stderr, "-mode db") {
+		t.Errorf("export all over the API: exit %d, stderr %q", code, stderr)
+	}
+}
@@ -2,26 +2,292 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/koki1610168/go-payment-ledger/internal/config"
+	"github.com/koki1610168/go-payment-ledger/internal/memstore"
 	"github.com/koki1610168/go-payment-ledger/internal/server"
+	"github.com/koki1610168/go-payment-ledger/internal/sqlitestore"
 )
 
 func main() {
 	ctx := context.Background()
 
-	db, err := server.NewDB(ctx)
+	cfg, printConfig, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(2)
+	}
+	if printConfig {
+		if err := cfg.WriteYAML(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	logger, err := server.NewLogger(os.Stdout, cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "configure logging:", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	if err := run(ctx, cfg, logger); err != nil {
+		logger.Error("server stopped", "err", err)
+		os.Exit(1)
+	}
+	logger.Info("server stopped")
+}
+
+func run(ctx context.Context, cfg config.Config, logger *slog.Logger) error {
+	// ctx is cancelled on SIGINT/SIGTERM. It only drives shutdown and
+	// background workers; request contexts are independent of it.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := server.SetupTracing(ctx, cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(flushCtx)
+	}()
+
+	metrics := server.NewMetrics()
+	approvals := server.ApprovalPolicy{
+		Threshold: cfg.Approval.Threshold,
+		Hold:      cfg.Approval.Hold,
+		TTL:       cfg.Approval.TTL,
+	}
+
+	// pool stays nil unless the driver is postgres, which also leaves
+	// out the PostgreSQL health checks
+	var store server.ClientStore
+	var pool *pgxpool.Pool
+	var sqlite *sqlitestore.Store
+	switch cfg.Database.Driver {
+	case "memory":
+		logger.Warn("using the in-memory demo store; all data is lost on exit")
+		demo, err := demoStore(ctx)
+		if err != nil {
+			return err
+		}
+		store = demo.WithApprovals(approvals)
+	case "sqlite":
+		if sqlite, err = sqlitestore.Open(ctx, cfg.Database.URL); err != nil {
+			return err
+		}
+		defer sqlite.Close()
+
+		if cfg.Database.MigrateOnStart {
+			if err := sqlite.Migrate(ctx); err != nil {
+				return err
+			}
+		}
+		store = sqlite.WithLogger(logger).WithApprovals(approvals)
+	default:
+		db, err := server.OpenDB(ctx, cfg.Database)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		if cfg.Database.MigrateOnStart {
+			if err := server.Migrate(ctx, db.Pool); err != nil {
+				return err
+			}
+		}
+		metrics.RegisterPool(db.Pool)
+		pool = db.Pool
+		store = server.NewStore(db.Pool).WithMetrics(metrics).WithLogger(logger).WithApprovals(approvals)
+	}
+	handler := server.NewHandler(store).WithLogger(logger)
+
+	limiter := server.NewRateLimiter(cfg.RateLimit.RPS, cfg.RateLimit.Burst).
+		WithEviction(cfg.RateLimit.IdleTTL, cfg.RateLimit.MaxEntries).
+		WithTrustedProxies(cfg.RateLimit.TrustedProxyPrefixes()).
+		WithMetrics(metrics).
+		WithLogger(logger)
+	for _, r := range cfg.RateLimit.Routes {
+		limiter.WithRoute(r.Route, r.RPS, r.Burst, r.Cost)
+	}
+	if cfg.RateLimit.Backend == "postgres" {
+		limiter.WithBackend(server.NewPostgresBackend(pool).WithLogger(logger))
+	}
+
+	apiKeys, err := server.NewAPIKeys(cfg.Auth.APIKeys)
+	if err != nil {
+		return err
+	}
+
+	spec, err := server.LoadOpenAPI(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	specHandler, err := server.OpenAPIHandler(spec)
+	if err != nil {
+		return err
+	}
+	validator, err := server.NewRequestValidator(spec)
+	if err != nil {
+		return err
+	}
+
+	app := middleware(handler, cfg, logger, limiter, metrics, validator, apiKeys)
+
+	health := server.NewHealth(pool)
+	if sqlite != nil {
+		health.AddCheck("database", sqlite.Ping)
+		health.AddCheck("migrations", func(ctx context.Context) error {
+			pending, err := sqlite.PendingMigrations(ctx)
+			if err != nil {
+				return err
+			}
+			if len(pending) > 0 {
+				return fmt.Errorf("pending: %s", strings.Join(pending, ", "))
+			}
+			return nil
+		})
+	}
+
+	// Probes, /metrics and the spec are polled by infrastructure and
+	// tooling and must not eat rate-limit tokens
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/openapi.json", specHandler)
+	health.Register(mux)
+	mux.Handle("/", app)
+
+	var workers server.Workers
+	workers.Go(ctx, limiter.Run)
+	if interest, ok := store.(server.InterestStore); ok && cfg.Interest.Interval > 0 {
+		workers.Go(ctx, server.NewInterestJob(interest, cfg.Interest.Interval).WithLogger(logger).Run)
+	}
+	if pending, ok := store.(server.ApprovalStore); ok && cfg.Approval.Threshold > 0 {
+		workers.Go(ctx, server.NewApprovalExpiryJob(pending, cfg.Approval.SweepInterval).WithLogger(logger).Run)
+	}
+
+	srv := server.NewHTTPServer(cfg.Server, mux)
+	srv.ErrorLog = slog.NewLogLogger(logger.Handler(), slog.LevelWarn)
+
+	if cfg.TLS.Enabled() {
+		certs, err := server.NewCertReloader(cfg.TLS, logger)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = certs.TLSConfig()
+		workers.Go(ctx, certs.Run)
 	}
-	defer db.Close()
 
-	store := server.NewStore(db.Pool)
-	handler := server.NewHandler(store)
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	logger.Info("listening", "addr", ln.Addr().String(), "tls", cfg.TLS.Enabled())
+
+	// A failing gRPC server takes the HTTP server down with it
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	grpcErr := make(chan error, 1)
+	if cfg.GRPC.Addr != "" {
+		grpcOpts := server.GRPCOptions{
+			TLSConfig: srv.TLSConfig,
+			APIKeys:   apiKeys,
+			Logger:    logger,
+		}
+		if cfg.TLS.ClientCAFile != "" {
+			grpcOpts.ClientSubjects = cfg.TLS.ClientSubjects
+		}
+		grpcSrv, grpcHealth := server.NewGRPCServer(
+			server.NewGRPCService(store).WithLogger(logger), grpcOpts)
+
+		grpcLn, err := net.Listen("tcp", cfg.GRPC.Addr)
+		if err != nil {
+			return err
+		}
+		logger.Info("listening for grpc", "addr", grpcLn.Addr().String(), "tls", cfg.TLS.Enabled())
+
+		go func() {
+			err := server.ServeGRPC(ctx, grpcSrv, grpcLn, server.ServeOptions{
+				OnDrain:         grpcHealth.Shutdown,
+				DrainDelay:      cfg.Server.DrainDelay,
+				ShutdownTimeout: cfg.Server.ShutdownTimeout,
+			})
+			if err != nil {
+				abort()
+			}
+			grpcErr <- err
+		}()
+	} else {
+		grpcErr <- nil
+	}
 
-	limiter := server.NewRateLimiter(10, 20)
+	err = server.Serve(ctx, srv, ln, server.ServeOptions{
+		OnDrain: func() {
+			logger.Info("shutdown signal received, draining")
+			health.SetDraining()
+		},
+		DrainDelay:      cfg.Server.DrainDelay,
+		ShutdownTimeout: cfg.Server.ShutdownTimeout,
+	})
+	abort()
+	if err := errors.Join(err, <-grpcErr); err != nil {
+		return err
+	}
+
+	// Workers watch the same ctx, so they are already stopping
+	waitCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	return workers.Wait(waitCtx)
+}
 
-	log.Println("Listening on port 8080")
-	log.Fatal(http.ListenAndServe(":8080", limiter.Middleware(handler)))
+// middleware wraps handler in the layers every API request goes through,
+// innermost first
+func middleware(handler http.Handler, cfg config.Config, logger *slog.Logger, limiter *server.RateLimiter,
+	metrics *server.Metrics, validator *server.RequestValidator, apiKeys *server.APIKeys) http.Handler {
+	app := limiter.Middleware(metrics.Middleware(validator.Middleware(handler)))
+	app = apiKeys.Middleware(app)
+	if cfg.TLS.ClientCAFile != "" {
+		app = server.ClientCertAuth(cfg.TLS.ClientSubjects, app)
+	}
+	app = server.AccessLog(logger, app)
+	app = server.RequestID(app)
+	return server.Tracing(app)
+}
+
+// demoStore returns an in-memory store with two funded clients to try
+// the API with
+func demoStore(ctx context.Context) (*memstore.Store, error) {
+	store := memstore.New()
+	for _, c := range []struct {
+		id      string
+		balance int64
+	}{{"client_001", 10000}, {"client_002", 10000}} {
+		if _, err := store.CreateClient(ctx, c.id, "JPY"); err != nil {
+			return nil, err
+		}
+		if _, err := store.CreatePayment(ctx, c.id, c.balance, "demo-seed-"+c.id); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/koki1610168/go-payment-ledger/internal/config"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// The API key and client certificate layers hand a new request to the
+// layers inside them, so spans and access logs only see the route if
+// it reaches them some other way than r.Pattern
+func TestMiddleware_ReportsRoute(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { tp.Shutdown(t.Context()) })
+
+	var logs bytes.Buffer
+	logger, err := server.NewLogger(&logs, "info", "json")
+	if err != nil {
+		t.Fatalf("logger: %v", err)
+	}
+	store, err := demoStore(t.Context())
+	if err != nil {
+		t.Fatalf("demo store: %v", err)
+	}
+	spec, err := server.LoadOpenAPI(t.Context())
+	if err != nil {
+		t.Fatalf("load openapi: %v", err)
+	}
+	validator, err := server.NewRequestValidator(spec)
+	if err != nil {
+		t.Fatalf("request validator: %v", err)
+	}
+	sum := sha256.Sum256([]byte("portal-secret"))
+	apiKeys, err := server.NewAPIKeys([]config.APIKey{
+		{Name: "portal", KeySHA256: hex.EncodeToString(sum[:]), ClientIDs: []string{"client_001"}},
+	})
+	if err != nil {
+		t.Fatalf("api keys: %v", err)
+	}
+	cfg := config.Default()
+	cfg.TLS.ClientCAFile = "ca.pem"
+	app := middleware(server.NewHandler(store).WithLogger(logger), cfg, logger,
+		server.NewRateLimiter(100, 100), server.NewMetrics(), validator, apiKeys)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/clients/client_001/balance", nil)
+	req.Header.Set("X-API-Key", "portal-secret")
+	res := httptest.NewRecorder()
+	app.ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("got %d (%s)", res.Code, res.Body)
+	}
+
+	const route = "/v1/clients/{id}/balance"
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if got, want := spans[0].Name(), "GET "+route; got != want {
+		t.Errorf("got span name %q, want %q", got, want)
+	}
+	var spanRoute string
+	for _, attr := range spans[0].Attributes() {
+		if attr.Key == attribute.Key("http.route") {
+			spanRoute = attr.Value.AsString()
+		}
+	}
+	if spanRoute != route {
+		t.Errorf("got http.route %q, want %q", spanRoute, route)
+	}
+
+	var found bool
+	dec := json.NewDecoder(&logs)
+	for dec.More() {
+		var record map[string]any
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("decode log: %v", err)
+		}
+		if record["msg"] != "request" {
+			continue
+		}
+		found = true
+		if record["route"] != route {
+			t.Errorf("access log has route %v, want %q", record["route"], route)
+		}
+	}
+	if !found {
+		t.Errorf("no access log record in %s", logs.String())
+	}
+}
@@ -0,0 +1,596 @@
+// Package config loads the server configuration.
+//
+// Values are resolved in increasing order of precedence:
+// built-in defaults, the optional YAML file, environment variables and
+// finally command-line flags.
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server    Server    `yaml:"server"`
+	GRPC      GRPC      `yaml:"grpc"`
+	TLS       TLS       `yaml:"tls"`
+	Database  Database  `yaml:"database"`
+	Auth      Auth      `yaml:"auth"`
+	RateLimit RateLimit `yaml:"rate_limit"`
+	Interest  Interest  `yaml:"interest"`
+	Approval  Approval  `yaml:"approval"`
+	Log       Log       `yaml:"log"`
+	Tracing   Tracing   `yaml:"tracing"`
+}
+
+type Server struct {
+	Addr              string        `yaml:"addr"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	DrainDelay        time.Duration `yaml:"drain_delay"`
+}
+
+// GRPC serves the gRPC API on its own port. It shares the TLS, auth and
+// shutdown settings with the HTTP server. An empty Addr disables it.
+type GRPC struct {
+	Addr string `yaml:"addr"`
+}
+
+// TLS is enabled when CertFile and KeyFile are set
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// How often the files are checked for rotation
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+	// Client certificates are verified against this CA bundle when set
+	ClientCAFile      string `yaml:"client_ca_file"`
+	RequireClientCert bool   `yaml:"require_client_cert"`
+	// Maps a client certificate subject common name to the client IDs it
+	// may act on. "*" allows every client.
+	ClientSubjects map[string][]string `yaml:"client_subjects"`
+}
+
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+type Database struct {
+	// "postgres"; "sqlite" for an embedded database file; or "memory"
+	// for a demo server that keeps everything in memory and loses it on
+	// exit
+	Driver string `yaml:"driver"`
+	// A PostgreSQL connection string, or the database file's path for
+	// sqlite
+	URL             string        `yaml:"url"`
+	MaxConns        int32         `yaml:"max_conns"`
+	MinConns        int32         `yaml:"min_conns"`
+	MaxConnIdleTime time.Duration `yaml:"max_conn_idle_time"`
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout"`
+	MigrateOnStart  bool          `yaml:"migrate_on_start"`
+}
+
+type RateLimit struct {
+	// "memory" limits each replica on its own; "postgres" shares the
+	// buckets between all replicas through the database
+	Backend string  `yaml:"backend"`
+	RPS     float64 `yaml:"rps"`
+	Burst   int     `yaml:"burst"`
+	// Buckets unused for this long are dropped
+	IdleTTL time.Duration `yaml:"idle_ttl"`
+	// Upper bound on buckets held in memory
+	MaxEntries int `yaml:"max_entries"`
+	// CIDRs of load balancers whose Forwarded/X-Forwarded-For headers are
+	// believed. Empty means the peer address is always used.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// Per-route overrides. Routes without their own rps share the default
+	// bucket but can still charge a different cost.
+	Routes []RouteLimit `yaml:"routes"`
+}
+
+type RouteLimit struct {
+	// A ServeMux pattern such as "POST /transfer" or "GET /clients/"
+	Route string  `yaml:"route"`
+	RPS   float64 `yaml:"rps"`
+	Burst int     `yaml:"burst"`
+	// Tokens taken per request, 1 when unset
+	Cost int `yaml:"cost"`
+}
+
+type Auth struct {
+	APIKeys []APIKey `yaml:"api_keys"`
+}
+
+// APIKey is stored as a SHA-256 hash so the config file never holds the
+// secret itself
+type APIKey struct {
+	Name      string   `yaml:"name"`
+	KeySHA256 string   `yaml:"key_sha256"`
+	ClientIDs []string `yaml:"client_ids"`
+	// Admin keys may manage risk settings such as velocity limits
+	Admin bool `yaml:"admin"`
+}
+
+// TrustedProxyPrefixes parses TrustedProxies. Invalid entries are skipped;
+// Validate reports them.
+func (r RateLimit) TrustedProxyPrefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, p := range r.TrustedProxies {
+		if prefix, err := netip.ParsePrefix(p); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// Interest runs the interest accrual job every Interval. Zero disables
+// it, leaving POST /v1/interest-runs to run it.
+type Interest struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Approval holds transfers above Threshold, in minor units, until a
+// second principal approves them. Zero disables approvals. Hold takes the
+// amount out of the sender's balance meanwhile; transfers nobody decides
+// within TTL expire, which a job checks every SweepInterval.
+type Approval struct {
+	Threshold     int64         `yaml:"threshold"`
+	Hold          bool          `yaml:"hold"`
+	TTL           time.Duration `yaml:"ttl"`
+	SweepInterval time.Duration `yaml:"sweep_interval"`
+}
+
+type Log struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type Tracing struct {
+	// none, stdout, file or otlp. The OTLP exporter reads the standard
+	// OTEL_EXPORTER_OTLP_* variables itself.
+	Exporter string `yaml:"exporter"`
+	File     string `yaml:"file"`
+}
+
+// Default returns the settings the server used before they were configurable
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:              ":8080",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       10 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			ShutdownTimeout:   30 * time.Second,
+			DrainDelay:        0,
+		},
+		GRPC: GRPC{
+			Addr: ":9090",
+		},
+		TLS: TLS{
+			ReloadInterval: time.Minute,
+		},
+		Database: Database{
+			Driver:          "postgres",
+			MaxConns:        10,
+			MinConns:        1,
+			MaxConnIdleTime: 5 * time.Second,
+			MaxConnLifetime: 30 * time.Second,
+			ConnectTimeout:  3 * time.Second,
+			MigrateOnStart:  true,
+		},
+		RateLimit: RateLimit{
+			Backend:    "memory",
+			RPS:        10,
+			Burst:      20,
+			IdleTTL:    10 * time.Minute,
+			MaxEntries: 100_000,
+		},
+		Interest: Interest{
+			Interval: time.Hour,
+		},
+		Approval: Approval{
+			Hold:          true,
+			TTL:           24 * time.Hour,
+			SweepInterval: time.Minute,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: Tracing{
+			Exporter: "none",
+		},
+	}
+}
+
+// setting ties one configuration value to its flag and environment variable
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	apply func(c *Config, v string) error
+}
+
+func settings() []setting {
+	return []setting{
+		{"addr", "HTTP_ADDR", "listen address", str(func(c *Config) *string { return &c.Server.Addr })},
+		{"read-header-timeout", "HTTP_READ_HEADER_TIMEOUT", "time allowed to read request headers", dur(func(c *Config) *time.Duration { return &c.Server.ReadHeaderTimeout })},
+		{"read-timeout", "HTTP_READ_TIMEOUT", "time allowed to read the whole request", dur(func(c *Config) *time.Duration { return &c.Server.ReadTimeout })},
+		{"write-timeout", "HTTP_WRITE_TIMEOUT", "time allowed to write the response", dur(func(c *Config) *time.Duration { return &c.Server.WriteTimeout })},
+		{"idle-timeout", "HTTP_IDLE_TIMEOUT", "keep-alive idle timeout", dur(func(c *Config) *time.Duration { return &c.Server.IdleTimeout })},
+		{"max-header-bytes", "HTTP_MAX_HEADER_BYTES", "maximum size of request headers", integer(func(c *Config) *int { return &c.Server.MaxHeaderBytes })},
+		{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "time allowed for in-flight work on shutdown", dur(func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout })},
+		{"drain-delay", "SHUTDOWN_DRAIN_DELAY", "time to keep serving after readiness starts failing", dur(func(c *Config) *time.Duration { return &c.Server.DrainDelay })},
+
+		{"grpc-addr", "GRPC_ADDR", "gRPC listen address, empty to disable", str(func(c *Config) *string { return &c.GRPC.Addr })},
+
+		{"tls-cert-file", "TLS_CERT_FILE", "PEM server certificate", str(func(c *Config) *string { return &c.TLS.CertFile })},
+		{"tls-key-file", "TLS_KEY_FILE", "PEM server private key", str(func(c *Config) *string { return &c.TLS.KeyFile })},
+		{"tls-reload-interval", "TLS_RELOAD_INTERVAL", "how often to check certificate files for rotation", dur(func(c *Config) *time.Duration { return &c.TLS.ReloadInterval })},
+		{"tls-client-ca-file", "TLS_CLIENT_CA_FILE", "PEM CA bundle for verifying client certificates", str(func(c *Config) *string { return &c.TLS.ClientCAFile })},
+		{"tls-require-client-cert", "TLS_REQUIRE_CLIENT_CERT", "reject connections without a verified client certificate", boolean(func(c *Config) *bool { return &c.TLS.RequireClientCert })},
+
+		{"db-driver", "DATABASE_DRIVER", "postgres, sqlite, or memory for a database-free demo", str(func(c *Config) *string { return &c.Database.Driver })},
+		{"database-url", "DATABASE_URL", "PostgreSQL connection string, or the database file for sqlite", str(func(c *Config) *string { return &c.Database.URL })},
+		{"db-max-conns", "DB_MAX_CONNS", "maximum pool size", int32v(func(c *Config) *int32 { return &c.Database.MaxConns })},
+		{"db-min-conns", "DB_MIN_CONNS", "minimum pool size", int32v(func(c *Config) *int32 { return &c.Database.MinConns })},
+		{"db-max-conn-idle-time", "DB_MAX_CONN_IDLE_TIME", "close connections idle for longer than this", dur(func(c *Config) *time.Duration { return &c.Database.MaxConnIdleTime })},
+		{"db-max-conn-lifetime", "DB_MAX_CONN_LIFETIME", "recycle connections older than this", dur(func(c *Config) *time.Duration { return &c.Database.MaxConnLifetime })},
+		{"db-connect-timeout", "DB_CONNECT_TIMEOUT", "time allowed for the startup ping", dur(func(c *Config) *time.Duration { return &c.Database.ConnectTimeout })},
+		{"migrate-on-start", "MIGRATE_ON_START", "apply pending migrations at startup", boolean(func(c *Config) *bool { return &c.Database.MigrateOnStart })},
+
+		{"rate-limit-backend", "RATE_LIMIT_BACKEND", "where buckets are kept: memory or postgres", str(func(c *Config) *string { return &c.RateLimit.Backend })},
+		{"rate-limit-rps", "RATE_LIMIT_RPS", "requests per second per client", float(func(c *Config) *float64 { return &c.RateLimit.RPS })},
+		{"rate-limit-burst", "RATE_LIMIT_BURST", "burst size per client", integer(func(c *Config) *int { return &c.RateLimit.Burst })},
+		{"rate-limit-idle-ttl", "RATE_LIMIT_IDLE_TTL", "drop client buckets unused for this long", dur(func(c *Config) *time.Duration { return &c.RateLimit.IdleTTL })},
+		{"rate-limit-max-entries", "RATE_LIMIT_MAX_ENTRIES", "maximum number of client buckets", integer(func(c *Config) *int { return &c.RateLimit.MaxEntries })},
+		{"trusted-proxies", "TRUSTED_PROXIES", "comma-separated CIDRs whose forwarding headers are trusted", list(func(c *Config) *[]string { return &c.RateLimit.TrustedProxies })},
+
+		{"interest-interval", "INTEREST_INTERVAL", "how often to accrue and capitalize interest, 0 to disable", dur(func(c *Config) *time.Duration { return &c.Interest.Interval })},
+
+		{"approval-threshold", "APPROVAL_THRESHOLD", "transfers above this amount need a second principal's approval, 0 to disable", int64v(func(c *Config) *int64 { return &c.Approval.Threshold })},
+		{"approval-hold", "APPROVAL_HOLD", "hold the funds of transfers awaiting approval", boolean(func(c *Config) *bool { return &c.Approval.Hold })},
+		{"approval-ttl", "APPROVAL_TTL", "how long a transfer may await approval before it expires", dur(func(c *Config) *time.Duration { return &c.Approval.TTL })},
+		{"approval-sweep-interval", "APPROVAL_SWEEP_INTERVAL", "how often to expire transfers awaiting approval", dur(func(c *Config) *time.Duration { return &c.Approval.SweepInterval })},
+
+		{"log-level", "LOG_LEVEL", "debug, info, warn or error", str(func(c *Config) *string { return &c.Log.Level })},
+		{"log-format", "LOG_FORMAT", "json or text", str(func(c *Config) *string { return &c.Log.Format })},
+
+		{"tracing-exporter", "OTEL_TRACES_EXPORTER", "none, stdout, file or otlp", str(func(c *Config) *string { return &c.Tracing.Exporter })},
+		{"tracing-file", "OTEL_TRACES_FILE", "output path for the file exporter", str(func(c *Config) *string { return &c.Tracing.File })},
+	}
+}
+
+// Load resolves the configuration from args (without the program name)
+// and getenv. printConfig reports whether --print-config was given.
+func Load(args []string, getenv func(string) string) (cfg Config, printConfig bool, err error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	configFile := fs.String("config", "", "path to a YAML config file (env CONFIG_FILE)")
+	fs.BoolVar(&printConfig, "print-config", false, "print the resolved configuration with secrets redacted and exit")
+
+	all := settings()
+	flagValues := map[string]string{}
+	for _, s := range all {
+		name := s.flag
+		fs.Func(name, fmt.Sprintf("%s (env %s)", s.usage, s.env), func(v string) error {
+			flagValues[name] = v
+			return nil
+		})
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stderr)
+			fs.PrintDefaults()
+		}
+		return Config{}, false, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, false, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	cfg = Default()
+
+	path := *configFile
+	if path == "" {
+		path = getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadFile(&cfg, path); err != nil {
+			return Config{}, false, err
+		}
+	}
+
+	for _, s := range all {
+		if v := getenv(s.env); v != "" {
+			if err := s.apply(&cfg, v); err != nil {
+				return Config{}, false, fmt.Errorf("env %s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, s := range all {
+		if v, ok := flagValues[s.flag]; ok {
+			if err := s.apply(&cfg, v); err != nil {
+				return Config{}, false, fmt.Errorf("flag --%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	cfg.normalize()
+	if err := cfg.Validate(); err != nil {
+		return Config{}, false, err
+	}
+	return cfg, printConfig, nil
+}
+
+// normalize lowercases the settings that pick one of a fixed set of
+// values, which are compared exactly wherever they are used
+func (c *Config) normalize() {
+	for _, v := range []*string{&c.Database.Driver, &c.RateLimit.Backend, &c.Log.Level, &c.Log.Format, &c.Tracing.Exporter} {
+		*v = strings.ToLower(*v)
+	}
+}
+
+// validPattern reports whether http.ServeMux accepts pattern
+func validPattern(pattern string) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	http.NewServeMux().Handle(pattern, http.NotFoundHandler())
+	return true
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid value at once
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check(c.Server.ReadHeaderTimeout > 0, "server.read_header_timeout must be positive")
+	check(c.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idle_timeout must be positive")
+	check(c.Server.MaxHeaderBytes > 0, "server.max_header_bytes must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.DrainDelay >= 0, "server.drain_delay must not be negative")
+	check(c.GRPC.Addr == "" || c.GRPC.Addr != c.Server.Addr, "grpc.addr must differ from server.addr")
+
+	if c.TLS.Enabled() {
+		check(c.TLS.CertFile != "" && c.TLS.KeyFile != "", "tls.cert_file and tls.key_file must be set together")
+		check(c.TLS.ReloadInterval > 0, "tls.reload_interval must be positive")
+	}
+	check(c.TLS.ClientCAFile == "" || c.TLS.Enabled(), "tls.client_ca_file requires tls.cert_file and tls.key_file")
+	check(!c.TLS.RequireClientCert || c.TLS.ClientCAFile != "", "tls.require_client_cert requires tls.client_ca_file")
+	check(len(c.TLS.ClientSubjects) == 0 || c.TLS.ClientCAFile != "", "tls.client_subjects requires tls.client_ca_file")
+
+	check(oneOf(c.Database.Driver, "postgres", "sqlite", "memory"), "database.driver must be postgres, sqlite or memory")
+	check(c.Database.URL != "" || c.Database.Driver == "memory", "database.url is required (DATABASE_URL)")
+	check(c.Database.MaxConns >= 1, "database.max_conns must be at least 1")
+	check(c.Database.MinConns >= 0, "database.min_conns must not be negative")
+	check(c.Database.MinConns <= c.Database.MaxConns, "database.min_conns must not exceed database.max_conns")
+	check(c.Database.MaxConnIdleTime > 0, "database.max_conn_idle_time must be positive")
+	check(c.Database.MaxConnLifetime > 0, "database.max_conn_lifetime must be positive")
+	check(c.Database.ConnectTimeout > 0, "database.connect_timeout must be positive")
+
+	check(oneOf(c.RateLimit.Backend, "memory", "postgres"), "rate_limit.backend must be memory or postgres")
+	check(c.RateLimit.Backend != "postgres" || c.Database.Driver == "postgres", "rate_limit.backend postgres requires database.driver postgres")
+	check(c.RateLimit.RPS > 0, "rate_limit.rps must be positive")
+	check(c.RateLimit.Burst >= 1, "rate_limit.burst must be at least 1")
+	check(c.RateLimit.IdleTTL > 0, "rate_limit.idle_ttl must be positive")
+	check(c.RateLimit.MaxEntries >= 1, "rate_limit.max_entries must be at least 1")
+	for _, p := range c.RateLimit.TrustedProxies {
+		_, err := netip.ParsePrefix(p)
+		check(err == nil, "rate_limit.trusted_proxies: %q is not a CIDR", p)
+	}
+	routes := map[string]bool{}
+	for i, r := range c.RateLimit.Routes {
+		check(r.Route != "", "rate_limit.routes[%d].route is required", i)
+		check(r.Route == "" || validPattern(r.Route), "rate_limit.routes[%d]: %q is not a valid route pattern", i, r.Route)
+		check(!routes[r.Route], "rate_limit.routes[%d]: duplicate route %q", i, r.Route)
+		routes[r.Route] = true
+		check(r.RPS >= 0, "rate_limit.routes[%d].rps must not be negative", i)
+		check(r.RPS == 0 || r.Burst >= 1, "rate_limit.routes[%d].burst must be at least 1 when rps is set", i)
+		check(r.Cost >= 0, "rate_limit.routes[%d].cost must not be negative", i)
+		burst := c.RateLimit.Burst
+		if r.RPS > 0 {
+			burst = r.Burst
+		}
+		check(r.Cost <= burst, "rate_limit.routes[%d].cost must not exceed the burst it draws from", i)
+	}
+
+	names := map[string]bool{}
+	for i, k := range c.Auth.APIKeys {
+		check(k.Name != "", "auth.api_keys[%d].name is required", i)
+		check(!names[k.Name], "auth.api_keys[%d]: duplicate name %q", i, k.Name)
+		names[k.Name] = true
+		sum, err := hex.DecodeString(k.KeySHA256)
+		check(err == nil && len(sum) == sha256.Size, "auth.api_keys[%d].key_sha256 must be a hex SHA-256 digest", i)
+		check(len(k.ClientIDs) > 0, "auth.api_keys[%d].client_ids must not be empty", i)
+	}
+
+	check(c.Interest.Interval >= 0, "interest.interval must not be negative")
+
+	check(c.Approval.Threshold >= 0, "approval.threshold must not be negative")
+	check(c.Approval.Threshold == 0 || c.Approval.TTL > 0, "approval.ttl must be positive when approval.threshold is set")
+	check(c.Approval.Threshold == 0 || c.Approval.SweepInterval > 0, "approval.sweep_interval must be positive when approval.threshold is set")
+
+	check(oneOf(c.Log.Level, "debug", "info", "warn", "error"), "log.level must be debug, info, warn or error")
+	check(oneOf(c.Log.Format, "json", "text"), "log.format must be json or text")
+
+	check(oneOf(c.Tracing.Exporter, "none", "stdout", "file", "otlp"), "tracing.exporter must be none, stdout, file or otlp")
+	check(c.Tracing.Exporter != "file" || c.Tracing.File != "", "tracing.file is required when tracing.exporter is file")
+
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy that is safe to print or log
+func (c Config) Redacted() Config {
+	c.Database.URL = redactDSN(c.Database.URL)
+	return c
+}
+
+// WriteYAML writes the redacted configuration in the file format Load reads
+func (c Config) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// Handles both URL and key=value connection strings
+func redactDSN(dsn string) string {
+	if dsn == "" {
+		return ""
+	}
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		}
+		q := u.Query()
+		if q.Has("password") {
+			q.Set("password", "REDACTED")
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}REDACTED")
+}
+
+func oneOf(v string, allowed ...string) bool {
+	return slices.Contains(allowed, v)
+}
+
+func str(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func dur(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func integer(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func int32v(field func(*Config) *int32) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return err
+		}
+		*field(c) = int32(n)
+		return nil
+	}
+}
+
+func int64v(field func(*Config) *int64) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func float(field func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func list(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(c) = items
+		return nil
+	}
+}
+
+func boolean(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
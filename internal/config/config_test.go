@@ -0,0 +1,394 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envMap(m map[string]string) func(string) string {
+	return func(k string) string { return m[k] }
+}
+
+func writeFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, _, err := Load(nil, envMap(map[string]string{"DATABASE_URL": "postgres://localhost/ledger"}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	want := Default()
+	want.Database.URL = "postgres://localhost/ledger"
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, `
+server:
+  addr: ":9000"
+database:
+  url: postgres://file/ledger
+  max_conns: 20
+  max_conn_lifetime: 5m
+rate_limit:
+  rps: 50
+`)
+	env := envMap(map[string]string{
+		"CONFIG_FILE":    path,
+		"DB_MAX_CONNS":   "30",
+		"RATE_LIMIT_RPS": "75",
+	})
+
+	cfg, _, err := Load([]string{"--rate-limit-rps", "100"}, env)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if cfg.Server.Addr != ":9000" {
+		t.Errorf("file should override default addr, got %q", cfg.Server.Addr)
+	}
+	if cfg.Database.URL != "postgres://file/ledger" {
+		t.Errorf("file should set url, got %q", cfg.Database.URL)
+	}
+	if cfg.Database.MaxConnLifetime != 5*time.Minute {
+		t.Errorf("file should set lifetime, got %v", cfg.Database.MaxConnLifetime)
+	}
+	if cfg.Database.MaxConns != 30 {
+		t.Errorf("env should override file max_conns, got %d", cfg.Database.MaxConns)
+	}
+	if cfg.RateLimit.RPS != 100 {
+		t.Errorf("flag should override env rps, got %v", cfg.RateLimit.RPS)
+	}
+	if cfg.RateLimit.Burst != Default().RateLimit.Burst {
+		t.Errorf("untouched values keep their default, got burst %d", cfg.RateLimit.Burst)
+	}
+}
+
+func TestLoad_ConfigFlagBeatsEnv(t *testing.T) {
+	fromEnv := writeFile(t, "server:\n  addr: \":1111\"\n")
+	fromFlag := writeFile(t, "server:\n  addr: \":2222\"\n")
+	env := envMap(map[string]string{"CONFIG_FILE": fromEnv, "DATABASE_URL": "postgres://x"})
+
+	cfg, _, err := Load([]string{"--config", fromFlag}, env)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Server.Addr != ":2222" {
+		t.Errorf("got addr %q, want :2222", cfg.Server.Addr)
+	}
+}
+
+func TestLoad_RejectsUnknownFileFields(t *testing.T) {
+	path := writeFile(t, "database:\n  max_connections: 5\n")
+	_, _, err := Load([]string{"--config", path}, envMap(map[string]string{"DATABASE_URL": "postgres://x"}))
+	if err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+}
+
+func TestLoad_ValidationReportsEveryProblem(t *testing.T) {
+	env := envMap(map[string]string{
+		"DB_MAX_CONNS":       "2",
+		"DB_MIN_CONNS":       "5",
+		"LOG_FORMAT":         "xml",
+		"RATE_LIMIT_BACKEND": "redis",
+	})
+	_, _, err := Load(nil, env)
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"database.url", "min_conns", "log.format", "rate_limit.backend"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestLoad_TLSClientSubjects(t *testing.T) {
+	path := writeFile(t, `
+tls:
+  cert_file: /etc/ledger/tls.crt
+  key_file: /etc/ledger/tls.key
+  client_ca_file: /etc/ledger/ca.crt
+  client_subjects:
+    payments-gateway: ["*"]
+    merchant-portal: [client_001, client_002]
+`)
+	cfg, _, err := Load([]string{"--config", path}, envMap(map[string]string{"DATABASE_URL": "postgres://x"}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := cfg.TLS.ClientSubjects["merchant-portal"]; len(got) != 2 || got[1] != "client_002" {
+		t.Errorf("got subjects %v", cfg.TLS.ClientSubjects)
+	}
+	if cfg.TLS.ReloadInterval != time.Minute {
+		t.Errorf("got reload interval %v, want the default", cfg.TLS.ReloadInterval)
+	}
+}
+
+func TestLoad_TLSValidation(t *testing.T) {
+	env := envMap(map[string]string{
+		"DATABASE_URL":            "postgres://x",
+		"TLS_CERT_FILE":           "/tls.crt",
+		"TLS_REQUIRE_CLIENT_CERT": "true",
+	})
+	_, _, err := Load(nil, env)
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"tls.key_file", "tls.require_client_cert"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestLoad_GRPCAddr(t *testing.T) {
+	env := envMap(map[string]string{"DATABASE_URL": "postgres://x"})
+
+	cfg, _, err := Load([]string{"--grpc-addr", ""}, env)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.GRPC.Addr != "" {
+		t.Errorf("got grpc addr %q, want it disabled", cfg.GRPC.Addr)
+	}
+
+	_, _, err = Load([]string{"--addr", ":9000", "--grpc-addr", ":9000"}, env)
+	if err == nil || !strings.Contains(err.Error(), "grpc.addr") {
+		t.Errorf("got %v, want a grpc.addr conflict", err)
+	}
+}
+
+func TestLoad_MemoryDriver(t *testing.T) {
+	cfg, _, err := Load([]string{"--db-driver", "memory"}, envMap(nil))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Database.Driver != "memory" {
+		t.Errorf("got driver %q, want memory", cfg.Database.Driver)
+	}
+
+	_, _, err = Load([]string{"--db-driver", "memory", "--rate-limit-backend", "postgres"}, envMap(nil))
+	if err == nil || !strings.Contains(err.Error(), "rate_limit.backend") {
+		t.Errorf("got %v, want a rate_limit.backend error", err)
+	}
+	_, _, err = Load([]string{"--db-driver", "mysql"}, envMap(map[string]string{"DATABASE_URL": "postgres://x"}))
+	if err == nil || !strings.Contains(err.Error(), "database.driver") {
+		t.Errorf("got %v, want a database.driver error", err)
+	}
+}
+
+func TestLoad_SQLiteDriver(t *testing.T) {
+	cfg, _, err := Load([]string{"--db-driver", "sqlite"}, envMap(map[string]string{"DATABASE_URL": "ledger.db"}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Database.Driver != "sqlite" || cfg.Database.URL != "ledger.db" {
+		t.Errorf("got driver %q and url %q, want sqlite and ledger.db", cfg.Database.Driver, cfg.Database.URL)
+	}
+
+	_, _, err = Load([]string{"--db-driver", "sqlite"}, envMap(nil))
+	if err == nil || !strings.Contains(err.Error(), "database.url") {
+		t.Errorf("got %v, want a database.url error", err)
+	}
+}
+
+// The server compares these settings exactly, so any case is accepted
+// and lowercased
+func TestLoad_MixedCaseChoices(t *testing.T) {
+	path := writeFile(t, `
+database:
+  driver: Postgres
+log:
+  level: INFO
+  format: Text
+`)
+	cfg, _, err := Load([]string{"--config", path, "--rate-limit-backend", "POSTGRES", "--tracing-exporter", "StdOut"},
+		envMap(map[string]string{"DATABASE_URL": "postgres://x"}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	got := []string{cfg.Database.Driver, cfg.RateLimit.Backend, cfg.Log.Level, cfg.Log.Format, cfg.Tracing.Exporter}
+	want := []string{"postgres", "postgres", "info", "text", "stdout"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// A memory driver needs no URL however it is spelled
+	cfg, _, err = Load([]string{"--db-driver", "Memory"}, envMap(nil))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Database.Driver != "memory" {
+		t.Errorf("got driver %q, want memory", cfg.Database.Driver)
+	}
+}
+
+func TestLoad_TrustedProxies(t *testing.T) {
+	env := envMap(map[string]string{
+		"DATABASE_URL":    "postgres://x",
+		"TRUSTED_PROXIES": "10.0.0.0/8, 192.168.1.0/24",
+	})
+	cfg, _, err := Load(nil, env)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.RateLimit.TrustedProxies, []string{"10.0.0.0/8", "192.168.1.0/24"}) {
+		t.Errorf("got %v", cfg.RateLimit.TrustedProxies)
+	}
+
+	_, _, err = Load([]string{"--trusted-proxies", "10.0.0.1"}, env)
+	if err == nil || !strings.Contains(err.Error(), "trusted_proxies") {
+		t.Errorf("got %v, want an error for a bare address", err)
+	}
+}
+
+func TestLoad_Approval(t *testing.T) {
+	env := envMap(map[string]string{
+		"DATABASE_URL":       "postgres://x",
+		"APPROVAL_THRESHOLD": "1000000",
+		"APPROVAL_HOLD":      "false",
+	})
+	cfg, _, err := Load([]string{"--approval-ttl", "2h"}, env)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := Approval{Threshold: 1_000_000, Hold: false, TTL: 2 * time.Hour, SweepInterval: time.Minute}
+	if cfg.Approval != want {
+		t.Errorf("got %+v, want %+v", cfg.Approval, want)
+	}
+
+	_, _, err = Load([]string{"--approval-ttl", "0s"}, env)
+	if err == nil || !strings.Contains(err.Error(), "approval.ttl") {
+		t.Errorf("got %v, want an approval.ttl error", err)
+	}
+	_, _, err = Load([]string{"--approval-threshold", "-1"}, env)
+	if err == nil || !strings.Contains(err.Error(), "approval.threshold") {
+		t.Errorf("got %v, want an approval.threshold error", err)
+	}
+}
+
+func TestLoad_RouteLimitsAndAPIKeys(t *testing.T) {
+	path := writeFile(t, `
+rate_limit:
+  routes:
+    - route: "POST /transfer"
+      cost: 5
+    - route: "GET /clients/"
+      rps: 50
+      burst: 100
+auth:
+  api_keys:
+    - name: portal
+      key_sha256: 2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b
+      client_ids: [client_001]
+`)
+	cfg, _, err := Load([]string{"--config", path}, envMap(map[string]string{"DATABASE_URL": "postgres://x"}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := []RouteLimit{
+		{Route: "POST /transfer", Cost: 5},
+		{Route: "GET /clients/", RPS: 50, Burst: 100},
+	}
+	if !reflect.DeepEqual(cfg.RateLimit.Routes, want) {
+		t.Errorf("got routes %+v", cfg.RateLimit.Routes)
+	}
+	if len(cfg.Auth.APIKeys) != 1 || cfg.Auth.APIKeys[0].Name != "portal" {
+		t.Errorf("got api keys %+v", cfg.Auth.APIKeys)
+	}
+
+	path = writeFile(t, `
+rate_limit:
+  routes:
+    - route: "FETCH"
+    - route: "POST /transfer"
+      cost: 50
+auth:
+  api_keys:
+    - name: portal
+      key_sha256: nothex
+`)
+	_, _, err = Load([]string{"--config", path}, envMap(map[string]string{"DATABASE_URL": "postgres://x"}))
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"routes[0]", "routes[1].cost", "api_keys[0].key_sha256", "api_keys[0].client_ids"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestLoad_BadValue(t *testing.T) {
+	_, _, err := Load([]string{"--shutdown-timeout", "soon"}, envMap(map[string]string{"DATABASE_URL": "postgres://x"}))
+	if err == nil || !strings.Contains(err.Error(), "shutdown-timeout") {
+		t.Errorf("got %v, want an error naming the flag", err)
+	}
+}
+
+func TestLoad_PrintConfig(t *testing.T) {
+	_, printConfig, err := Load([]string{"--print-config"}, envMap(map[string]string{"DATABASE_URL": "postgres://x"}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !printConfig {
+		t.Error("expected printConfig to be true")
+	}
+}
+
+func TestWriteYAML_RedactsSecretsAndRoundTrips(t *testing.T) {
+	cfg := Default()
+	cfg.Database.URL = "postgres://ledger:hunter2@db:5432/ledger?sslmode=disable"
+
+	var buf bytes.Buffer
+	if err := cfg.WriteYAML(&buf); err != nil {
+		t.Fatalf("write yaml: %v", err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "hunter2") {
+		t.Errorf("password leaked:\n%s", out)
+	}
+	if !strings.Contains(out, "REDACTED") {
+		t.Errorf("expected a redaction marker:\n%s", out)
+	}
+
+	path := writeFile(t, out)
+	loaded, _, err := Load([]string{"--config", path}, envMap(nil))
+	if err != nil {
+		t.Fatalf("reload printed config: %v", err)
+	}
+	if loaded.Database.MaxConnLifetime != cfg.Database.MaxConnLifetime {
+		t.Errorf("durations should round-trip, got %v", loaded.Database.MaxConnLifetime)
+	}
+}
+
+func TestRedactDSN(t *testing.T) {
+	cases := map[string]string{
+		"postgres://u:secret@h/db":               "postgres://u:REDACTED@h/db",
+		"postgres://u@h/db":                      "postgres://u@h/db",
+		"postgres://h/db?password=secret":        "postgres://h/db?password=REDACTED",
+		"host=h user=u password=secret dbname=d": "host=h user=u password=REDACTED dbname=d",
+		"host=h password='se cret' dbname=d":     "host=h password=REDACTED dbname=d",
+	}
+	for in, want := range cases {
+		if got := redactDSN(in); got != want {
+			t.Errorf("redactDSN(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package memstore
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// WithApprovals holds the transfers policy requires approval for
+func (s *Store) WithApprovals(policy server.ApprovalPolicy) *Store {
+	s.approvals = policy
+	return s
+}
+
+func (s *Store) GetPendingTransfer(ctx context.Context, id string) (server.PendingTransfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.pending[id]
+	if !ok {
+		return server.PendingTransfer{}, server.ErrTransferNotFound
+	}
+	return clonePending(t), nil
+}
+
+func (s *Store) ListPendingTransfers(ctx context.Context, status string) ([]server.PendingTransfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transfers := []server.PendingTransfer{}
+	for _, t := range s.transfers {
+		if status == "" || t.Status == status {
+			listed := *t
+			listed.Events = nil
+			transfers = append(transfers, listed)
+		}
+	}
+	return transfers, nil
+}
+
+func (s *Store) ApproveTransfer(ctx context.Context, id string, reason string) (server.ApprovedTransfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.decide(ctx, id)
+	if err != nil {
+		return server.ApprovedTransfer{}, err
+	}
+	fromBalance, toBalance, err := s.transfer(t.FromClientID, t.ToClientID, t.Amount, t.IdempotencyKey, t.Held)
+	if err != nil {
+		return server.ApprovedTransfer{}, err
+	}
+	s.decideTransfer(t, server.TransferApproved, server.Actor(ctx), reason)
+	return server.ApprovedTransfer{
+		PendingTransfer: clonePending(t),
+		FromNewBalance:  fromBalance,
+		ToNewBalance:    toBalance,
+	}, nil
+}
+
+func (s *Store) RejectTransfer(ctx context.Context, id string, reason string) (server.PendingTransfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.decide(ctx, id)
+	if err != nil {
+		return server.PendingTransfer{}, err
+	}
+	if err := s.releaseHold(t); err != nil {
+		return server.PendingTransfer{}, err
+	}
+	s.decideTransfer(t, server.TransferRejected, server.Actor(ctx), reason)
+	return clonePending(t), nil
+}
+
+func (s *Store) ExpireTransfers(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := 0
+	for _, t := range s.transfers {
+		if t.Status != server.TransferPending || t.ExpiresAt.After(now) {
+			continue
+		}
+		if err := s.expire(t); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// requestApproval records a transfer that needs approval, holding its
+// amount when the policy says so. The transfer must be possible as
+// requested: both clients hold one currency, and the sender can cover
+// the amount if it is held.
+func (s *Store) requestApproval(ctx context.Context, fromClientID, toClientID string, amount int64, idempotencyKey string) (server.PendingTransfer, error) {
+	from, to := s.accounts[fromClientID], s.accounts[toClientID]
+	sent := money.New(amount, from.currency)
+	newFrom, err := from.funds().Sub(sent)
+	if err != nil {
+		return server.PendingTransfer{}, err
+	}
+	if _, err := to.funds().Add(sent); err != nil {
+		return server.PendingTransfer{}, err
+	}
+	if s.approvals.Hold {
+		if newFrom.IsNegative() {
+			return server.PendingTransfer{}, server.ErrInsufficientBalance
+		}
+		if err := s.moveHold(fromClientID, sent); err != nil {
+			return server.PendingTransfer{}, err
+		}
+	}
+
+	now := s.tick()
+	t := &server.PendingTransfer{
+		ID:             uuid.NewString(),
+		FromClientID:   fromClientID,
+		ToClientID:     toClientID,
+		Amount:         amount,
+		Currency:       from.currency,
+		IdempotencyKey: idempotencyKey,
+		Status:         server.TransferPending,
+		Held:           s.approvals.Hold,
+		RequestedBy:    server.Actor(ctx),
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(s.approvals.TTL).Truncate(time.Microsecond),
+	}
+	t.Events = []server.TransferEvent{{Action: server.TransferRequested, Actor: t.RequestedBy, CreatedAt: now}}
+	s.transfers = append(s.transfers, t)
+	s.pending[t.ID] = t
+	if idempotencyKey != "" {
+		s.pendingKeys[idempotencyKey] = t.ID
+	}
+	return clonePending(t), nil
+}
+
+// decide finds transfer id so the principal in ctx can approve or reject
+// it. A transfer past its expiry is expired instead, and
+// ErrTransferExpired returned.
+func (s *Store) decide(ctx context.Context, id string) (*server.PendingTransfer, error) {
+	if _, ok := server.PrincipalFromContext(ctx); !ok {
+		return nil, server.ErrApproverRequired
+	}
+	t, ok := s.pending[id]
+	if !ok {
+		return nil, server.ErrTransferNotFound
+	}
+	if t.Status != server.TransferPending {
+		return nil, server.ErrTransferNotPending
+	}
+	if !time.Now().Before(t.ExpiresAt) {
+		if err := s.expire(t); err != nil {
+			return nil, err
+		}
+		return nil, server.ErrTransferExpired
+	}
+	if server.Actor(ctx) == t.RequestedBy {
+		return nil, server.ErrSelfApproval
+	}
+	return t, nil
+}
+
+// expire releases the hold of t and marks it expired
+func (s *Store) expire(t *server.PendingTransfer) error {
+	if err := s.releaseHold(t); err != nil {
+		return err
+	}
+	s.decideTransfer(t, server.TransferExpired, server.SystemActor, "")
+	return nil
+}
+
+// decideTransfer moves t to status and records who did it
+func (s *Store) decideTransfer(t *server.PendingTransfer, status, actor, reason string) {
+	t.Status = status
+	t.Events = append(t.Events, server.TransferEvent{Action: status, Actor: actor, Reason: reason, CreatedAt: s.tick()})
+}
+
+func (s *Store) releaseHold(t *server.PendingTransfer) error {
+	if !t.Held {
+		return nil
+	}
+	return s.moveHold(t.FromClientID, money.New(-t.Amount, t.Currency))
+}
+
+// holdFunds is the balance of the approval hold account of currency
+func (s *Store) holdFunds(currency string) money.Money {
+	if hold, ok := s.accounts[server.ApprovalHoldAccount(currency)]; ok {
+		return hold.funds()
+	}
+	return money.New(0, currency)
+}
+
+// moveHold moves amount from the client to the approval hold account of
+// its currency, creating it on first use, or back when amount is
+// negative. Nothing changes if either balance would overflow.
+func (s *Store) moveHold(clientID string, amount money.Money) error {
+	client := s.accounts[clientID]
+	nextClient, err := client.funds().Sub(amount)
+	if err != nil {
+		return err
+	}
+	nextHold, err := s.holdFunds(amount.Currency).Add(amount)
+	if err != nil {
+		return err
+	}
+	id := server.ApprovalHoldAccount(amount.Currency)
+	hold, ok := s.accounts[id]
+	if !ok {
+		hold = &account{currency: amount.Currency, createdAt: s.tick()}
+		s.accounts[id] = hold
+	}
+	client.balance, hold.balance = nextClient.Amount, nextHold.Amount
+
+	now := s.tick()
+	client.entries = append(client.entries, server.Ledger{
+		EntryId: uuid.New(), ClientId: clientID, Amount: -amount.Amount, CreatedAt: now,
+	})
+	hold.entries = append(hold.entries, server.Ledger{
+		EntryId: uuid.New(), ClientId: id, Amount: amount.Amount, CreatedAt: now,
+	})
+	return nil
+}
+
+// clonePending copies t so callers cannot change the store's audit trail
+func clonePending(t *server.PendingTransfer) server.PendingTransfer {
+	c := *t
+	c.Events = slices.Clone(t.Events)
+	return c
+}
@@ -0,0 +1,223 @@
+package memstore
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// interest is a client's rate and what was accrued under it
+type interest struct {
+	rate server.InterestRate
+	// accrued sums the accruals of each month; last is the last day
+	// accrued, zero before the first
+	accrued  map[time.Time]int64
+	last     time.Time
+	postings []server.InterestPosting
+}
+
+func (s *Store) GetInterestRate(ctx context.Context, clientID string) (server.InterestRate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[clientID]
+	if !ok {
+		return server.InterestRate{}, server.ErrClientNotFound
+	}
+	if a.interest == nil {
+		return server.InterestRate{}, server.ErrInterestRateNotFound
+	}
+	return a.interest.rate, nil
+}
+
+func (s *Store) SetInterestRate(ctx context.Context, rate server.InterestRate) (server.InterestRate, error) {
+	if err := rate.Validate(); err != nil {
+		return server.InterestRate{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[rate.ClientID]
+	if !ok {
+		return server.InterestRate{}, server.ErrClientNotFound
+	}
+	rate.UpdatedAt = s.tick()
+	if a.interest == nil {
+		rate.CreatedAt = rate.UpdatedAt
+		a.interest = &interest{accrued: map[time.Time]int64{}}
+	} else {
+		rate.CreatedAt = a.interest.rate.CreatedAt
+	}
+	a.interest.rate = rate
+	return rate, nil
+}
+
+func (s *Store) ListInterestPostings(ctx context.Context, clientID string) ([]server.InterestPosting, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[clientID]
+	if !ok {
+		return nil, server.ErrClientNotFound
+	}
+	postings := []server.InterestPosting{}
+	if a.interest != nil {
+		postings = append(postings, a.interest.postings...)
+	}
+	return postings, nil
+}
+
+// RunInterest holds the store's lock throughout, so each client is
+// accrued atomically
+func (s *Store) RunInterest(ctx context.Context, asOf time.Time) (server.InterestRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run := server.InterestRun{AsOf: asOf, Postings: []server.InterestPosting{}, Failed: []string{}}
+	for _, clientID := range slices.Sorted(maps.Keys(s.accounts)) {
+		if s.accounts[clientID].interest == nil {
+			continue
+		}
+		days, postings, err := s.accrueInterest(clientID, asOf)
+		if err != nil {
+			run.Failed = append(run.Failed, clientID)
+			continue
+		}
+		if days > 0 {
+			run.ClientsAccrued++
+			run.DaysAccrued += days
+		}
+		run.Postings = append(run.Postings, postings...)
+	}
+	return run, nil
+}
+
+// accrueInterest accrues the client's days up to asOf, and capitalizes
+// each month as soon as its last day is accrued. Everything is computed
+// before anything is changed, so a failure leaves no trace.
+func (s *Store) accrueInterest(clientID string, asOf time.Time) (int, []server.InterestPosting, error) {
+	a := s.accounts[clientID]
+	in := a.interest
+
+	day := server.InterestDay(in.rate.CreatedAt)
+	if !in.last.IsZero() {
+		day = in.last.AddDate(0, 0, 1)
+	}
+	accrued := maps.Clone(in.accrued)
+	postings := slices.Clone(in.postings)
+	balance := a.funds()
+	house := map[string]money.Money{}
+	days, last := 0, in.last
+	for end := server.InterestDay(asOf); day.Before(end); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		eod, err := endOfDay(a, postings, next)
+		if err != nil {
+			return 0, nil, err
+		}
+		amount, err := in.rate.Accrue(day, eod)
+		if err != nil {
+			return 0, nil, err
+		}
+		period := server.InterestPeriod(day)
+		sum, err := money.New(accrued[period], "").Add(money.New(amount, ""))
+		if err != nil {
+			return 0, nil, err
+		}
+		accrued[period] = sum.Amount
+		days, last = days+1, day
+
+		if next.Day() != 1 {
+			continue
+		}
+		var carried int64
+		if len(postings) > 0 {
+			carried = postings[len(postings)-1].Residual
+		}
+		p, err := server.NewInterestPosting(clientID, a.currency, period, carried, accrued[period])
+		if err != nil {
+			return 0, nil, err
+		}
+		if p.Amount != 0 {
+			if balance, err = balance.Add(money.New(p.Amount, a.currency)); err != nil {
+				return 0, nil, err
+			}
+			account := p.Account()
+			h, ok := house[account]
+			if !ok {
+				h = money.New(0, a.currency)
+				if existing, ok := s.accounts[account]; ok {
+					h = existing.funds()
+				}
+			}
+			if house[account], err = h.Sub(money.New(p.Amount, a.currency)); err != nil {
+				return 0, nil, err
+			}
+			p.EntryID = uuid.NewString()
+		}
+		postings = append(postings, p)
+	}
+
+	a.balance = balance.Amount
+	for id, h := range house {
+		if _, ok := s.accounts[id]; !ok {
+			s.accounts[id] = &account{currency: h.Currency, createdAt: s.tick()}
+		}
+		s.accounts[id].balance = h.Amount
+	}
+	posted := postings[len(in.postings):]
+	for i := range posted {
+		p := &posted[i]
+		p.CreatedAt = s.tick()
+		if p.Amount == 0 {
+			continue
+		}
+		h := s.accounts[p.Account()]
+		a.entries = append(a.entries, server.Ledger{
+			EntryId: uuid.MustParse(p.EntryID), ClientId: clientID, Amount: p.Amount, CreatedAt: p.CreatedAt,
+		})
+		h.entries = append(h.entries, server.Ledger{
+			EntryId: uuid.New(), ClientId: p.Account(), Amount: -p.Amount, CreatedAt: p.CreatedAt,
+		})
+	}
+	in.accrued, in.last, in.postings = accrued, last, postings
+	return days, slices.Clone(posted), nil
+}
+
+// endOfDay is the client's balance at end, not counting its interest
+// entries, to which postings are added as of the end of their month
+func endOfDay(a *account, postings []server.InterestPosting, end time.Time) (int64, error) {
+	interestEntries := map[string]bool{}
+	balance := money.New(0, a.currency)
+	var err error
+	for _, p := range postings {
+		interestEntries[p.EntryID] = true
+		period, perr := time.Parse("2006-01", p.Period)
+		if perr != nil {
+			return 0, perr
+		}
+		if period.AddDate(0, 1, 0).Before(end) {
+			if balance, err = balance.Add(money.New(p.Amount, a.currency)); err != nil {
+				return 0, err
+			}
+		}
+	}
+	for _, e := range a.entries {
+		if !e.CreatedAt.Before(end) {
+			break
+		}
+		if interestEntries[e.EntryId.String()] {
+			continue
+		}
+		if balance, err = balance.Add(money.New(e.Amount, a.currency)); err != nil {
+			return 0, err
+		}
+	}
+	return balance.Amount, nil
+}
@@ -0,0 +1,442 @@
+// Package memstore keeps the ledger in memory. It implements
+// server.ClientStore, server.AdminStore, server.FeeStore,
+// server.InterestStore, server.ApprovalStore and
+// server.SplitTransferStore with the same semantics as the PostgreSQL
+// store, which the storetest suite checks for both, so it can stand in
+// for a database in tests and in the server's demo mode. Everything is
+// lost when the process exits.
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+type account struct {
+	currency  string
+	balance   int64
+	createdAt time.Time
+	entries   []server.Ledger
+	feeGroup  string
+	interest  *interest
+}
+
+func (a *account) funds() money.Money {
+	return money.New(a.balance, a.currency)
+}
+
+// Store is safe for concurrent use. One mutex guards everything, so each
+// operation is atomic like a database transaction.
+type Store struct {
+	mu       sync.Mutex
+	accounts map[string]*account
+	// keys maps each idempotency key to the client whose ledger entry
+	// carries it
+	keys        map[string]string
+	adjustments map[string]server.Adjustment
+	// schedules holds the fee schedules in the order they were created
+	schedules []server.FeeSchedule
+	// transfers holds the transfers that needed approval in the order
+	// they were requested, pending indexes them by ID and pendingKeys by
+	// idempotency key
+	transfers   []*server.PendingTransfer
+	pending     map[string]*server.PendingTransfer
+	pendingKeys map[string]string
+	approvals   server.ApprovalPolicy
+	last        time.Time
+}
+
+func New() *Store {
+	return &Store{
+		accounts:    map[string]*account{},
+		keys:        map[string]string{},
+		adjustments: map[string]server.Adjustment{},
+		pending:     map[string]*server.PendingTransfer{},
+		pendingKeys: map[string]string{},
+	}
+}
+
+func (s *Store) GetBalance(ctx context.Context, clientID string) (int64, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[clientID]
+	if !ok {
+		return 0, "", server.ErrClientNotFound
+	}
+	return a.balance, a.currency, nil
+}
+
+// GetLedger returns the client's entries oldest first. Unknown clients
+// have an empty ledger.
+func (s *Store) GetLedger(ctx context.Context, clientID string) ([]server.Ledger, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[clientID]
+	if !ok {
+		return nil, nil
+	}
+	entries := append([]server.Ledger(nil), a.entries...)
+	for i := range entries {
+		entries[i].Currency = a.currency
+	}
+	return entries, nil
+}
+
+func (s *Store) CreatePayment(ctx context.Context, clientID string, amount int64, idempotencyKey string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if owner, ok := s.keys[idempotencyKey]; ok {
+		return s.accounts[owner].balance, nil
+	}
+	a, ok := s.accounts[clientID]
+	if !ok {
+		return 0, server.ErrClientNotFound
+	}
+	next, err := a.funds().Add(money.New(amount, a.currency))
+	if err != nil {
+		return 0, err
+	}
+	fee, err := s.quoteFee(clientID, server.FeePayment, amount)
+	if err != nil {
+		return 0, err
+	}
+	if next, err = next.Sub(money.New(fee.Fee, a.currency)); err != nil {
+		return 0, err
+	}
+	if next.IsNegative() {
+		return 0, server.ErrInsufficientBalance
+	}
+	revenue, err := s.feeRevenue(fee, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	a.balance = next.Amount
+	s.post(clientID, amount, idempotencyKey)
+	s.chargeFee(fee, revenue)
+	return a.balance, nil
+}
+
+// Transfer moves amount between two clients. The sender may not go below
+// zero. Transfers the approval policy requires approval for are held
+// instead, and returned in a *server.ApprovalRequiredError.
+func (s *Store) Transfer(ctx context.Context, fromClientID, toClientID string, amount int64, idempotencyKey string) (int64, int64, error) {
+	if fromClientID == toClientID {
+		return 0, 0, server.ErrSelfTransfer
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, ok1 := s.accounts[fromClientID]
+	to, ok2 := s.accounts[toClientID]
+	if !ok1 || !ok2 {
+		return 0, 0, server.ErrClientNotFound
+	}
+	if _, ok := s.keys[idempotencyKey]; ok {
+		return from.balance, to.balance, nil
+	}
+	if id, ok := s.pendingKeys[idempotencyKey]; ok {
+		return 0, 0, server.ReplayPendingTransfer(clonePending(s.pending[id]))
+	}
+	if s.approvals.Requires(amount) {
+		t, err := s.requestApproval(ctx, fromClientID, toClientID, amount, idempotencyKey)
+		if err != nil {
+			return 0, 0, err
+		}
+		return 0, 0, &server.ApprovalRequiredError{Transfer: t}
+	}
+	return s.transfer(fromClientID, toClientID, amount, idempotencyKey, false)
+}
+
+// transfer moves amount between two existing clients and charges the
+// sender's fee. When held, the amount is released from the approval hold
+// account first. Nothing changes if the transfer fails.
+func (s *Store) transfer(fromClientID, toClientID string, amount int64, idempotencyKey string, held bool) (int64, int64, error) {
+	from, to := s.accounts[fromClientID], s.accounts[toClientID]
+	sent := money.New(amount, from.currency)
+	balance := from.funds()
+	if held {
+		if _, err := s.holdFunds(from.currency).Sub(sent); err != nil {
+			return 0, 0, err
+		}
+		var err error
+		if balance, err = balance.Add(sent); err != nil {
+			return 0, 0, err
+		}
+	}
+	newFrom, err := balance.Sub(sent)
+	if err != nil {
+		return 0, 0, err
+	}
+	newTo, err := to.funds().Add(sent)
+	if err != nil {
+		return 0, 0, err
+	}
+	fee, err := s.quoteFee(fromClientID, server.FeeTransfer, amount)
+	if err != nil {
+		return 0, 0, err
+	}
+	if newFrom, err = newFrom.Sub(money.New(fee.Fee, from.currency)); err != nil {
+		return 0, 0, err
+	}
+	if newFrom.IsNegative() {
+		return 0, 0, server.ErrInsufficientBalance
+	}
+	revenue, err := s.feeRevenue(fee, map[string]money.Money{toClientID: newTo})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if held {
+		if err := s.moveHold(fromClientID, money.New(-amount, from.currency)); err != nil {
+			return 0, 0, err
+		}
+	}
+	from.balance, to.balance = newFrom.Amount, newTo.Amount
+	now := s.post(fromClientID, -amount, idempotencyKey)
+	to.entries = append(to.entries, server.Ledger{
+		EntryId: uuid.New(), ClientId: toClientID, Amount: amount, CreatedAt: now,
+	})
+	s.chargeFee(fee, revenue)
+	return from.balance, to.balance, nil
+}
+
+func (s *Store) CreateClient(ctx context.Context, clientID, currency string) (server.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[clientID]; ok {
+		return server.Client{}, server.ErrClientExists
+	}
+	a := &account{currency: currency, createdAt: s.tick()}
+	s.accounts[clientID] = a
+	return server.Client{ID: clientID, Currency: currency, CreatedAt: a.createdAt}, nil
+}
+
+// PostAdjustment applies adj like server.Store.PostAdjustment: replays
+// return the original adjustment, and a key used by anything else is a
+// conflict
+func (s *Store) PostAdjustment(ctx context.Context, adj server.Adjustment) (server.Adjustment, error) {
+	if err := adj.Validate(); err != nil {
+		return server.Adjustment{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[adj.IdempotencyKey]; ok {
+		prev, ok := s.adjustments[adj.IdempotencyKey]
+		if !ok || prev.ClientID != adj.ClientID || prev.Amount != adj.Amount || prev.Reason != adj.Reason {
+			return server.Adjustment{}, server.ErrIdempotencyConflict
+		}
+		prev.Balance = s.accounts[prev.ClientID].balance
+		return prev, nil
+	}
+	a, ok := s.accounts[adj.ClientID]
+	if !ok {
+		return server.Adjustment{}, server.ErrClientNotFound
+	}
+	next, err := a.funds().Add(money.New(adj.Amount, a.currency))
+	if err != nil {
+		return server.Adjustment{}, err
+	}
+	if next.IsNegative() {
+		return server.Adjustment{}, server.ErrInsufficientBalance
+	}
+
+	a.balance = next.Amount
+	adj.CreatedAt = s.post(adj.ClientID, adj.Amount, adj.IdempotencyKey)
+	adj.ID = uuid.NewString()
+	adj.EntryID = a.entries[len(a.entries)-1].EntryId.String()
+	adj.Balance = a.balance
+	s.adjustments[adj.IdempotencyKey] = adj
+	return adj, nil
+}
+
+// Reconcile never finds discrepancies unless balances were changed
+// outside the store's methods, which cannot happen in memory. It is
+// implemented for parity with server.Store.
+func (s *Store) Reconcile(ctx context.Context) (server.ReconciliationReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := server.ReconciliationReport{
+		CheckedAt:      time.Now().UTC(),
+		ClientsChecked: len(s.accounts),
+		Discrepancies:  []server.Discrepancy{},
+	}
+	for id, a := range s.accounts {
+		var sum int64
+		for _, e := range a.entries {
+			sum += e.Amount
+		}
+		if sum != a.balance {
+			report.Discrepancies = append(report.Discrepancies, server.Discrepancy{
+				ClientID: id, Balance: a.balance, LedgerSum: sum, Difference: a.balance - sum,
+			})
+		}
+	}
+	return report, nil
+}
+
+// post appends a keyed entry to the client's ledger. Like the SQL store
+// it records an empty key as given, but only non-empty keys make later
+// requests replay.
+func (s *Store) post(clientID string, amount int64, idempotencyKey string) time.Time {
+	now := s.tick()
+	a := s.accounts[clientID]
+	a.entries = append(a.entries, server.Ledger{
+		EntryId:        uuid.New(),
+		ClientId:       clientID,
+		Amount:         amount,
+		CreatedAt:      now,
+		IdempotencyKey: sql.NullString{String: idempotencyKey, Valid: true},
+	})
+	if idempotencyKey != "" {
+		s.keys[idempotencyKey] = clientID
+	}
+	return now
+}
+
+// tick returns the current time at PostgreSQL's microsecond precision,
+// later than any time it returned before, so entries sort in the order
+// they were posted
+func (s *Store) tick() time.Time {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if !now.After(s.last) {
+		now = s.last.Add(time.Microsecond)
+	}
+	s.last = now
+	return now
+}
+
+func (s *Store) ListFeeSchedules(ctx context.Context) ([]server.FeeSchedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]server.FeeSchedule{}, s.schedules...), nil
+}
+
+func (s *Store) CreateFeeSchedule(ctx context.Context, schedule server.FeeSchedule) (server.FeeSchedule, error) {
+	if err := schedule.Validate(); err != nil {
+		return server.FeeSchedule{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[schedule.ClientID]; schedule.ClientID != "" && !ok {
+		return server.FeeSchedule{}, server.ErrClientNotFound
+	}
+	for _, f := range s.schedules {
+		if f.ClientID == schedule.ClientID && f.Group == schedule.Group && f.Operation == schedule.Operation {
+			return server.FeeSchedule{}, server.ErrFeeScheduleExists
+		}
+	}
+	schedule.ID, schedule.CreatedAt = uuid.NewString(), s.tick()
+	s.schedules = append(s.schedules, schedule)
+	return schedule, nil
+}
+
+func (s *Store) DeleteFeeSchedule(ctx context.Context, scheduleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, f := range s.schedules {
+		if f.ID == scheduleID {
+			s.schedules = append(s.schedules[:i], s.schedules[i+1:]...)
+			return nil
+		}
+	}
+	return server.ErrFeeScheduleNotFound
+}
+
+func (s *Store) SetFeeGroup(ctx context.Context, clientID string, group string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[clientID]
+	if !ok {
+		return server.ErrClientNotFound
+	}
+	a.feeGroup = group
+	return nil
+}
+
+func (s *Store) QuoteFee(ctx context.Context, clientID string, operation string, amount int64) (server.FeeQuote, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[clientID]; !ok {
+		return server.FeeQuote{}, server.ErrClientNotFound
+	}
+	return s.quoteFee(clientID, operation, amount)
+}
+
+// quoteFee prices an operation with the client's own schedule, or its
+// group's when it has none
+func (s *Store) quoteFee(clientID, operation string, amount int64) (server.FeeQuote, error) {
+	a := s.accounts[clientID]
+	var group *server.FeeSchedule
+	for i, f := range s.schedules {
+		if f.Operation != operation {
+			continue
+		}
+		if f.ClientID == clientID {
+			return server.NewFeeQuote(clientID, operation, amount, a.currency, &s.schedules[i])
+		}
+		if f.Group != "" && f.Group == a.feeGroup {
+			group = &s.schedules[i]
+		}
+	}
+	return server.NewFeeQuote(clientID, operation, amount, a.currency, group)
+}
+
+// feeRevenue is the balance of the fee revenue account once q is charged,
+// on top of pending, the balances the operation is about to write. It is
+// computed before anything is changed, so a failing fee leaves no trace.
+func (s *Store) feeRevenue(q server.FeeQuote, pending map[string]money.Money) (money.Money, error) {
+	id := server.FeeRevenueAccount(q.Currency)
+	balance := money.New(0, q.Currency)
+	if b, ok := pending[id]; ok {
+		balance = b
+	} else if revenue, ok := s.accounts[id]; ok {
+		balance = revenue.funds()
+	}
+	return balance.Add(money.New(q.Fee, q.Currency))
+}
+
+// chargeFee credits q.Fee to the fee revenue account, creating it on
+// first use, and records the debit of the client, whose balance the
+// caller has already lowered by it. balance comes from feeRevenue.
+func (s *Store) chargeFee(q server.FeeQuote, balance money.Money) {
+	if q.Fee == 0 {
+		return
+	}
+	id := server.FeeRevenueAccount(q.Currency)
+	revenue, ok := s.accounts[id]
+	if !ok {
+		revenue = &account{currency: q.Currency, createdAt: s.tick()}
+		s.accounts[id] = revenue
+	}
+	revenue.balance = balance.Amount
+
+	now := s.tick()
+	client := s.accounts[q.ClientID]
+	client.entries = append(client.entries, server.Ledger{
+		EntryId: uuid.New(), ClientId: q.ClientID, Amount: -q.Fee, CreatedAt: now,
+	})
+	revenue.entries = append(revenue.entries, server.Ledger{
+		EntryId: uuid.New(), ClientId: id, Amount: q.Fee, CreatedAt: now,
+	})
+}
@@ -0,0 +1,23 @@
+package memstore
+
+import (
+	"testing"
+
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+	"github.com/koki1610168/go-payment-ledger/internal/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Store { return New() })
+}
+
+func TestConformance_SharedStore(t *testing.T) {
+	store := New()
+	storetest.Run(t, func(t *testing.T) storetest.Store { return store })
+}
+
+func TestConformance_Approvals(t *testing.T) {
+	storetest.RunApprovals(t, func(t *testing.T, policy server.ApprovalPolicy) storetest.Store {
+		return New().WithApprovals(policy)
+	})
+}
@@ -0,0 +1,96 @@
+package memstore
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+func (s *Store) SplitTransfer(ctx context.Context, legs []server.TransferLeg, idempotencyKey string) ([]int64, error) {
+	if err := server.ValidateLegs(legs); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, leg := range legs {
+		if _, ok := s.accounts[leg.ClientID]; !ok {
+			return nil, server.ErrClientNotFound
+		}
+	}
+	if _, ok := s.keys[idempotencyKey]; ok {
+		return s.legBalances(legs), nil
+	}
+	if _, ok := s.pendingKeys[idempotencyKey]; ok {
+		return nil, server.ErrIdempotencyConflict
+	}
+	if s.approvals.Requires(server.SplitTotal(legs)) {
+		return nil, server.ErrApprovalRequired
+	}
+
+	// Every balance is computed before anything changes, so a failing leg
+	// leaves no trace. pending also carries the fee revenue account as the
+	// fees add up.
+	currency := s.accounts[legs[0].ClientID].currency
+	pending := make(map[string]money.Money, len(legs)+1)
+	fees := make([]server.FeeQuote, len(legs))
+	revenue := make([]money.Money, len(legs))
+	for i, leg := range legs {
+		next, err := s.accounts[leg.ClientID].funds().Add(money.New(leg.Amount, currency))
+		if err != nil {
+			return nil, err
+		}
+		if leg.Amount < 0 {
+			if fees[i], err = s.quoteFee(leg.ClientID, server.FeeTransfer, -leg.Amount); err != nil {
+				return nil, err
+			}
+			if next, err = next.Sub(money.New(fees[i].Fee, currency)); err != nil {
+				return nil, err
+			}
+			if next.IsNegative() {
+				return nil, server.ErrInsufficientBalance
+			}
+		}
+		pending[leg.ClientID] = next
+	}
+	for i := range legs {
+		if fees[i].Fee == 0 {
+			continue
+		}
+		var err error
+		if revenue[i], err = s.feeRevenue(fees[i], pending); err != nil {
+			return nil, err
+		}
+		pending[server.FeeRevenueAccount(currency)] = revenue[i]
+	}
+
+	balances := make([]int64, len(legs))
+	for i, leg := range legs {
+		a := s.accounts[leg.ClientID]
+		a.balance = pending[leg.ClientID].Amount
+		balances[i] = a.balance
+		if leg.Amount < 0 {
+			s.post(leg.ClientID, leg.Amount, idempotencyKey)
+			continue
+		}
+		a.entries = append(a.entries, server.Ledger{
+			EntryId: uuid.New(), ClientId: leg.ClientID, Amount: leg.Amount, CreatedAt: s.tick(),
+		})
+	}
+	for i := range legs {
+		s.chargeFee(fees[i], revenue[i])
+	}
+	return balances, nil
+}
+
+// legBalances returns the balance of each leg's client, in leg order
+func (s *Store) legBalances(legs []server.TransferLeg) []int64 {
+	balances := make([]int64, len(legs))
+	for i, leg := range legs {
+		balances[i] = s.accounts[leg.ClientID].balance
+	}
+	return balances
+}
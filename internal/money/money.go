@@ -0,0 +1,219 @@
+// Package money holds amounts of one currency in minor units and does
+// arithmetic on them that fails instead of overflowing.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrOverflow         = errors.New("amount out of range")
+	ErrCurrencyMismatch = errors.New("currencies do not match")
+	ErrInvalidAmount    = errors.New("invalid amount")
+)
+
+// Money is an amount in the minor unit of its currency, e.g. cents for
+// USD or yen for JPY
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Add returns m + o. Both must be in the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	sum := m.Amount + o.Amount
+	// Overflow flips the sign away from that of both operands
+	if (m.Amount >= 0) == (o.Amount >= 0) && (sum >= 0) != (m.Amount >= 0) {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+// Sub returns m - o. Both must be in the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	neg, err := o.Neg()
+	if err != nil {
+		return Money{}, err
+	}
+	return m.Add(neg)
+}
+
+// Neg returns -m. The smallest int64 has no negation.
+func (m Money) Neg() (Money, error) {
+	if m.Amount == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: -m.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// Mul returns m × num / den rounded to the nearest minor unit, halves
+// away from zero, e.g. a 2.5% rate on 1.30 USD is Mul(250, 10000) = 0.03
+func (m Money) Mul(num, den int64) (Money, error) {
+	if den == 0 {
+		return Money{}, errors.New("mul: zero denominator")
+	}
+	q, r := new(big.Int).QuoRem(
+		new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(num)), big.NewInt(den), new(big.Int))
+	// Round the magnitude up when the remainder is at least half of den
+	if r.Sign() != 0 && new(big.Int).Abs(new(big.Int).Lsh(r, 1)).CmpAbs(big.NewInt(den)) >= 0 {
+		if (r.Sign() < 0) != (den < 0) {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	if !q.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: q.Int64(), Currency: m.Currency}, nil
+}
+
+// Allocate splits m into parts proportional to ratios. The parts always
+// add up to m: the minor units lost to rounding go one each to the first
+// parts, so Allocate(1, 1, 1) of 100 is 34, 33, 33.
+func (m Money) Allocate(ratios ...int64) ([]Money, error) {
+	if len(ratios) == 0 {
+		return nil, errors.New("allocate: no ratios")
+	}
+	total := new(big.Int)
+	for _, r := range ratios {
+		if r < 0 {
+			return nil, errors.New("allocate: negative ratio")
+		}
+		total.Add(total, big.NewInt(r))
+	}
+	if total.Sign() == 0 {
+		return nil, errors.New("allocate: ratios add up to zero")
+	}
+
+	amount := big.NewInt(m.Amount)
+	parts := make([]Money, len(ratios))
+	remainder := m.Amount
+	for i, r := range ratios {
+		// amount*r/total truncates towards zero, so every share is at
+		// most its exact value and the remainder has the sign of m
+		share := new(big.Int).Mul(amount, big.NewInt(r))
+		share.Quo(share, total)
+		parts[i] = Money{Amount: share.Int64(), Currency: m.Currency}
+		remainder -= parts[i].Amount
+	}
+	step := int64(1)
+	if remainder < 0 {
+		step = -1
+	}
+	for i := 0; remainder != 0; i = (i + 1) % len(parts) {
+		if ratios[i] == 0 {
+			continue
+		}
+		parts[i].Amount += step
+		remainder -= step
+	}
+	return parts, nil
+}
+
+// Split divides m into n parts that differ by at most one minor unit
+func (m Money) Split(n int) ([]Money, error) {
+	if n <= 0 {
+		return nil, errors.New("split: n must be positive")
+	}
+	ratios := make([]int64, n)
+	for i := range ratios {
+		ratios[i] = 1
+	}
+	return m.Allocate(ratios...)
+}
+
+// Decimal formats the amount in major units with the currency's number of
+// decimals, e.g. "-12.50" for -1250 USD or "1400" for 1400 JPY
+func (m Money) Decimal() string {
+	exp := Exponent(m.Currency)
+	// Formatting the magnitude as uint64 keeps MinInt64 intact
+	sign, mag := "", uint64(m.Amount)
+	if m.Amount < 0 {
+		sign, mag = "-", uint64(-(m.Amount+1))+1
+	}
+	digits := strconv.FormatUint(mag, 10)
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Parse reads a decimal amount in major units, e.g. "12.5" USD, into
+// minor units. It fails with ErrInvalidAmount when the amount has more
+// decimals than the currency allows, and with ErrOverflow when it does
+// not fit.
+func Parse(s string, currency string) (Money, error) {
+	exp := Exponent(currency)
+	neg := strings.HasPrefix(s, "-")
+	whole, frac, hasPoint := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if whole == "" || (hasPoint && frac == "") || len(frac) > exp ||
+		strings.TrimLeft(whole+frac, "0123456789") != "" {
+		return Money{}, fmt.Errorf("%w: %q in %s", ErrInvalidAmount, s, currency)
+	}
+	digits := strings.TrimLeft(whole+frac+strings.Repeat("0", exp-len(frac)), "0")
+	if digits == "" {
+		return Money{Currency: currency}, nil
+	}
+	if neg {
+		digits = "-" + digits
+	}
+	amount, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// jsonMoney is the wire form of Money. The amount is a decimal string so
+// that clients never see it through a float64.
+type jsonMoney struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON encodes m as {"amount": "12.50", "currency": "USD"}
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonMoney{Amount: m.Decimal(), Currency: m.Currency})
+}
+
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var v jsonMoney
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	parsed, err := Parse(v.Amount, v.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,187 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestAddSubNeg(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      func() (Money, error)
+		want    Money
+		wantErr error
+	}{
+		{"add", func() (Money, error) { return New(100, "USD").Add(New(-250, "USD")) }, New(-150, "USD"), nil},
+		{"sub", func() (Money, error) { return New(100, "USD").Sub(New(250, "USD")) }, New(-150, "USD"), nil},
+		{"add up to max", func() (Money, error) { return New(math.MaxInt64-1, "JPY").Add(New(1, "JPY")) }, New(math.MaxInt64, "JPY"), nil},
+		{"add overflow", func() (Money, error) { return New(math.MaxInt64, "JPY").Add(New(1, "JPY")) }, Money{}, ErrOverflow},
+		{"add underflow", func() (Money, error) { return New(math.MinInt64, "JPY").Add(New(-1, "JPY")) }, Money{}, ErrOverflow},
+		{"sub overflow", func() (Money, error) { return New(math.MaxInt64, "JPY").Sub(New(-1, "JPY")) }, Money{}, ErrOverflow},
+		{"sub min", func() (Money, error) { return New(-1, "JPY").Sub(New(math.MinInt64, "JPY")) }, Money{}, ErrOverflow},
+		{"neg min", func() (Money, error) { return New(math.MinInt64, "JPY").Neg() }, Money{}, ErrOverflow},
+		{"currency mismatch", func() (Money, error) { return New(1, "USD").Add(New(1, "JPY")) }, Money{}, ErrCurrencyMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("got %v, %v; want %v, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMul(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		num, den int64
+		want     int64
+	}{
+		{130, 250, 10000, 3},
+		{120, 250, 10000, 3},
+		{119, 250, 10000, 3},
+		{100, 250, 10000, 3},
+		{99, 250, 10000, 2},
+		{-100, 250, 10000, -3},
+		{100, -250, 10000, -3},
+		{100, 250, -10000, -3},
+		{-100, -250, 10000, 3},
+		{10, 1, 3, 3},
+		{20, 1, 3, 7},
+		{math.MaxInt64, 10000, 10000, math.MaxInt64},
+	}
+	for _, tt := range tests {
+		got, err := New(tt.amount, "USD").Mul(tt.num, tt.den)
+		if err != nil || got != New(tt.want, "USD") {
+			t.Errorf("%d × %d/%d: got %v, %v; want %d", tt.amount, tt.num, tt.den, got, err, tt.want)
+		}
+	}
+	if _, err := New(math.MaxInt64, "USD").Mul(2, 1); !errors.Is(err, ErrOverflow) {
+		t.Errorf("overflow: got %v, want ErrOverflow", err)
+	}
+	if _, err := New(1, "USD").Mul(1, 0); err == nil {
+		t.Error("zero denominator: got no error")
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount int64
+		ratios []int64
+		want   []int64
+	}{
+		{"even", 100, []int64{1, 1}, []int64{50, 50}},
+		{"remainder to the first parts", 100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{"weighted", 5, []int64{3, 7}, []int64{2, 3}},
+		{"negative", -100, []int64{1, 1, 1}, []int64{-34, -33, -33}},
+		{"zero ratio gets nothing", 10, []int64{0, 1, 1}, []int64{0, 5, 5}},
+		{"zero ratio skipped for the remainder", 11, []int64{0, 1, 1}, []int64{0, 6, 5}},
+		{"large amount", math.MaxInt64, []int64{math.MaxInt64, math.MaxInt64}, []int64{math.MaxInt64/2 + 1, math.MaxInt64 / 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := New(tt.amount, "USD").Allocate(tt.ratios...)
+			if err != nil {
+				t.Fatalf("allocate: %v", err)
+			}
+			var sum int64
+			for i, p := range parts {
+				if p.Amount != tt.want[i] || p.Currency != "USD" {
+					t.Errorf("part %d: got %v, want %d USD", i, p, tt.want[i])
+				}
+				sum += p.Amount
+			}
+			if sum != tt.amount {
+				t.Errorf("parts add up to %d, want %d", sum, tt.amount)
+			}
+		})
+	}
+
+	for _, ratios := range [][]int64{nil, {0, 0}, {1, -1}} {
+		if _, err := New(100, "USD").Allocate(ratios...); err == nil {
+			t.Errorf("allocate %v: got no error", ratios)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	parts, err := New(1001, "JPY").Split(4)
+	if err != nil {
+		t.Fatalf("split: %v", err)
+	}
+	want := []int64{251, 250, 250, 250}
+	for i, p := range parts {
+		if p.Amount != want[i] {
+			t.Errorf("part %d: got %d, want %d", i, p.Amount, want[i])
+		}
+	}
+	if _, err := New(1, "JPY").Split(0); err == nil {
+		t.Error("split into 0 parts: got no error")
+	}
+}
+
+func TestDecimalAndParse(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		decimal  string
+	}{
+		{1250, "USD", "12.50"},
+		{-1250, "USD", "-12.50"},
+		{5, "USD", "0.05"},
+		{-5, "EUR", "-0.05"},
+		{0, "USD", "0.00"},
+		{1400, "JPY", "1400"},
+		{-1, "KWD", "-0.001"},
+		{12345, "CLF", "1.2345"},
+		{math.MinInt64, "JPY", "-9223372036854775808"},
+		{math.MaxInt64, "USD", "92233720368547758.07"},
+	}
+	for _, tt := range tests {
+		m := New(tt.amount, tt.currency)
+		if got := m.Decimal(); got != tt.decimal {
+			t.Errorf("%d %s: got %q, want %q", tt.amount, tt.currency, got, tt.decimal)
+		}
+		parsed, err := Parse(tt.decimal, tt.currency)
+		if err != nil || parsed != m {
+			t.Errorf("parse %q %s: got %v, %v; want %v", tt.decimal, tt.currency, parsed, err, m)
+		}
+	}
+
+	if m, err := Parse("12.5", "USD"); err != nil || m.Amount != 1250 {
+		t.Errorf("parse 12.5 USD: got %v, %v; want 1250", m, err)
+	}
+	for _, s := range []string{"", "-", "1.", ".5", "1.234", "1e3", "+1", "1,00", "0x10"} {
+		if _, err := Parse(s, "USD"); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("parse %q: got %v, want ErrInvalidAmount", s, err)
+		}
+	}
+	if _, err := Parse("1.5", "JPY"); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("parse 1.5 JPY: got %v, want ErrInvalidAmount", err)
+	}
+	if _, err := Parse("92233720368547758.08", "USD"); !errors.Is(err, ErrOverflow) {
+		t.Errorf("parse past max: got %v, want ErrOverflow", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	b, err := json.Marshal(New(-1250, "USD"))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if got, want := string(b), `{"amount":"-12.50","currency":"USD"}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	var m Money
+	if err := json.Unmarshal([]byte(`{"amount":"1400","currency":"JPY"}`), &m); err != nil || m != New(1400, "JPY") {
+		t.Errorf("unmarshal: got %v, %v", m, err)
+	}
+	if err := json.Unmarshal([]byte(`{"amount":"1.5","currency":"JPY"}`), &m); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("unmarshal fractional yen: got %v, want ErrInvalidAmount", err)
+	}
+}
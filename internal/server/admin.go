@@ -0,0 +1,399 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+)
+
+var ErrClientExists = errors.New("client already exists")
+var ErrIdempotencyConflict = errors.New("idempotency key already used for a different request")
+
+var (
+	clientIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// Longest reason accepted for an adjustment
+const maxReasonLength = 500
+
+// Client is a ledger account
+type Client struct {
+	ID        string    `json:"client_id"`
+	Currency  string    `json:"currency"`
+	Balance   int64     `json:"balance"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Adjustment is a manual correction of a client's balance. It is posted
+// as a ledger entry and recorded with the operator and reason.
+type Adjustment struct {
+	ID             string `json:"id"`
+	ClientID       string `json:"client_id"`
+	Amount         int64  `json:"amount"`
+	Reason         string `json:"reason"`
+	Actor          string `json:"actor"`
+	IdempotencyKey string `json:"idempotency_key"`
+	EntryID        string `json:"entry_id"`
+	// Balance is the client's balance right after the adjustment
+	Balance   int64     `json:"balance"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate reports every problem with a before it is posted
+func (a Adjustment) Validate() error {
+	var v validator
+	v.clientID("client_id", a.ClientID)
+	v.amount("amount", a.Amount, false)
+	if strings.TrimSpace(a.Reason) == "" {
+		v.check(false, "reason", "is required")
+	} else {
+		v.check(len(a.Reason) <= maxReasonLength, "reason", "must be at most %d bytes", maxReasonLength)
+	}
+	v.idempotencyKey("idempotency_key", a.IdempotencyKey)
+	return v.err()
+}
+
+// Discrepancy is a client whose balance differs from the sum of its
+// ledger entries
+type Discrepancy struct {
+	ClientID   string `json:"client_id"`
+	Balance    int64  `json:"balance"`
+	LedgerSum  int64  `json:"ledger_sum"`
+	Difference int64  `json:"difference"`
+}
+
+type ReconciliationReport struct {
+	CheckedAt      time.Time     `json:"checked_at"`
+	ClientsChecked int           `json:"clients_checked"`
+	Discrepancies  []Discrepancy `json:"discrepancies"`
+}
+
+// AdminStore is implemented by stores that support operator actions:
+// opening clients, correcting balances with adjustments and checking
+// balances against the ledger.
+type AdminStore interface {
+	CreateClient(ctx context.Context, clientID string, currency string) (Client, error)
+	PostAdjustment(ctx context.Context, adj Adjustment) (Adjustment, error)
+	Reconcile(ctx context.Context) (ReconciliationReport, error)
+}
+
+func (s *Store) CreateClient(ctx context.Context, clientID string, currency string) (_ Client, err error) {
+	ctx, span := startSpan(ctx, "Store.CreateClient",
+		attribute.String("client.id", clientID))
+	defer func() { endSpan(span, err) }()
+
+	c := Client{ID: clientID, Currency: currency}
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO clients (client_id, balance, currency) VALUES ($1, 0, $2)
+		RETURNING created_at`, clientID, currency).Scan(&c.CreatedAt)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+		return Client{}, ErrClientExists
+	}
+	if err != nil {
+		return Client{}, err
+	}
+	return c, nil
+}
+
+// ListClients returns every client ordered by ID
+func (s *Store) ListClients(ctx context.Context) (_ []Client, err error) {
+	ctx, span := startSpan(ctx, "Store.ListClients")
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.Query(ctx,
+		`SELECT client_id, currency, balance, created_at FROM clients ORDER BY client_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []Client
+	for rows.Next() {
+		var c Client
+		if err := rows.Scan(&c.ID, &c.Currency, &c.Balance, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	return clients, rows.Err()
+}
+
+// PostAdjustment applies adj.Amount to the client's balance. Reusing an
+// idempotency key returns the original adjustment, or
+// ErrIdempotencyConflict when the key belongs to a different request.
+// Adjustments may not overdraw a client and are not subject to velocity
+// limits.
+func (s *Store) PostAdjustment(ctx context.Context, adj Adjustment) (_ Adjustment, err error) {
+	ctx, span := startSpan(ctx, "Store.PostAdjustment",
+		attribute.String("client.id", adj.ClientID))
+	defer func() { endSpan(span, err) }()
+
+	if err := adj.Validate(); err != nil {
+		return Adjustment{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return Adjustment{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Adjustment columns are NULL when the key belongs to a payment or
+	// transfer
+	existing := Adjustment{IdempotencyKey: adj.IdempotencyKey}
+	var existingID, existingReason, existingActor *string
+	var createdAt *time.Time
+	err = tx.QueryRow(ctx,
+		`SELECT a.adjustment_id::text, le.client_id, le.amount, a.reason, a.actor,
+			le.entry_id::text, c.balance, a.created_at
+		FROM ledger_entries le
+		JOIN clients c ON c.client_id = le.client_id
+		LEFT JOIN adjustments a ON a.entry_id = le.entry_id
+		WHERE le.idempotency_key = $1
+		LIMIT 1`, adj.IdempotencyKey,
+	).Scan(&existingID, &existing.ClientID, &existing.Amount, &existingReason, &existingActor,
+		&existing.EntryID, &existing.Balance, &createdAt)
+	if err == nil {
+		if existingID == nil || existing.ClientID != adj.ClientID ||
+			existing.Amount != adj.Amount || *existingReason != adj.Reason {
+			return Adjustment{}, ErrIdempotencyConflict
+		}
+		existing.ID, existing.Reason, existing.Actor, existing.CreatedAt = *existingID, *existingReason, *existingActor, *createdAt
+		s.logger.DebugContext(ctx, "adjustment replayed",
+			"client_id", adj.ClientID, "idempotency_key", adj.IdempotencyKey)
+		return existing, nil
+	}
+	if err != pgx.ErrNoRows {
+		return Adjustment{}, err
+	}
+
+	var balance int64
+	var currency string
+	err = tx.QueryRow(ctx,
+		`SELECT balance, currency FROM clients WHERE client_id = $1 FOR UPDATE`,
+		adj.ClientID).Scan(&balance, &currency)
+	if err == pgx.ErrNoRows {
+		return Adjustment{}, ErrClientNotFound
+	}
+	if err != nil {
+		return Adjustment{}, err
+	}
+
+	next, err := money.New(balance, currency).Add(money.New(adj.Amount, currency))
+	if err != nil {
+		return Adjustment{}, err
+	}
+	adj.Balance = next.Amount
+	if adj.Balance < 0 {
+		s.metrics.observeInsufficientBalance("adjustment")
+		return Adjustment{}, ErrInsufficientBalance
+	}
+
+	if _, err = tx.Exec(ctx,
+		`UPDATE clients SET balance = $1 WHERE client_id = $2`, adj.Balance, adj.ClientID); err != nil {
+		return Adjustment{}, err
+	}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO ledger_entries (entry_id, client_id, amount, idempotency_key)
+		VALUES (gen_random_uuid(), $1, $2, $3)
+		RETURNING entry_id::text`, adj.ClientID, adj.Amount, adj.IdempotencyKey).Scan(&adj.EntryID)
+	if err != nil {
+		return Adjustment{}, err
+	}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO adjustments (entry_id, client_id, amount, reason, actor)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING adjustment_id::text, created_at`,
+		adj.EntryID, adj.ClientID, adj.Amount, adj.Reason, adj.Actor).Scan(&adj.ID, &adj.CreatedAt)
+	if err != nil {
+		return Adjustment{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return Adjustment{}, err
+	}
+	s.logger.InfoContext(ctx, "adjustment posted",
+		"client_id", adj.ClientID, "amount", adj.Amount, "currency", currency,
+		"actor", adj.Actor, "reason", adj.Reason, "adjustment_id", adj.ID)
+	return adj, nil
+}
+
+// Reconcile compares every client's balance with the sum of its ledger
+// entries, from one consistent snapshot
+func (s *Store) Reconcile(ctx context.Context) (_ ReconciliationReport, err error) {
+	ctx, span := startSpan(ctx, "Store.Reconcile")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return ReconciliationReport{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	report := ReconciliationReport{Discrepancies: []Discrepancy{}}
+	err = tx.QueryRow(ctx, `SELECT now(), (SELECT COUNT(*) FROM clients)`).
+		Scan(&report.CheckedAt, &report.ClientsChecked)
+	if err != nil {
+		return ReconciliationReport{}, err
+	}
+
+	rows, err := tx.Query(ctx,
+		`SELECT c.client_id, c.balance, COALESCE(SUM(e.amount), 0)::bigint
+		FROM clients c
+		LEFT JOIN ledger_entries e ON e.client_id = c.client_id
+		GROUP BY c.client_id, c.balance
+		HAVING c.balance <> COALESCE(SUM(e.amount), 0)
+		ORDER BY c.client_id`)
+	if err != nil {
+		return ReconciliationReport{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d Discrepancy
+		if err := rows.Scan(&d.ClientID, &d.Balance, &d.LedgerSum); err != nil {
+			return ReconciliationReport{}, err
+		}
+		d.Difference = d.Balance - d.LedgerSum
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+	if err := rows.Err(); err != nil {
+		return ReconciliationReport{}, err
+	}
+
+	if n := len(report.Discrepancies); n > 0 {
+		s.logger.WarnContext(ctx, "reconciliation found discrepancies",
+			"clients_checked", report.ClientsChecked, "discrepancies", n)
+	}
+	return report, nil
+}
+
+// CreateClientRequest is the body of POST /clients
+type CreateClientRequest struct {
+	ClientID string `json:"client_id"`
+	Currency string `json:"currency"`
+}
+
+// AdjustmentRequest is the body of POST /clients/{id}/adjustments
+type AdjustmentRequest struct {
+	Amount         int64  `json:"amount"`
+	Reason         string `json:"reason"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+func (h *Handler) adminStore(w http.ResponseWriter, r *http.Request) (AdminStore, bool) {
+	store, ok := h.store.(AdminStore)
+	if !ok {
+		writeProblem(w, r, http.StatusNotImplemented, CodeNotImplemented, "admin operations are not supported by this store")
+	}
+	return store, ok
+}
+
+// createClient serves POST /clients
+func (h *Handler) createClient(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	store, ok := h.adminStore(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateClientRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	var v validator
+	v.clientID("client_id", req.ClientID)
+	v.currency("currency", req.Currency)
+	if err := v.err(); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	c, err := store.CreateClient(r.Context(), req.ClientID, req.Currency)
+	if err != nil {
+		h.writeError(w, r, "create client failed", err, "client_id", req.ClientID)
+		return
+	}
+	h.logger.InfoContext(r.Context(), "client created",
+		"client_id", c.ID, "currency", c.Currency, "actor", Actor(r.Context()))
+	writeJSON(w, http.StatusCreated, c)
+}
+
+// postAdjustment serves POST /clients/{id}/adjustments
+func (h *Handler) postAdjustment(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	store, ok := h.adminStore(w, r)
+	if !ok {
+		return
+	}
+
+	clientID := r.PathValue("id")
+	var req AdjustmentRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	adj := Adjustment{
+		ClientID:       clientID,
+		Amount:         req.Amount,
+		Reason:         strings.TrimSpace(req.Reason),
+		IdempotencyKey: req.IdempotencyKey,
+		Actor:          Actor(r.Context()),
+	}
+	if err := adj.Validate(); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	posted, err := store.PostAdjustment(r.Context(), adj)
+	if err != nil {
+		h.writeError(w, r, "post adjustment failed", err, "client_id", clientID, "amount", req.Amount)
+		return
+	}
+	writeJSON(w, http.StatusCreated, posted)
+}
+
+// reconcile serves POST /reconciliation
+func (h *Handler) reconcile(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	store, ok := h.adminStore(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := store.Reconcile(r.Context())
+	if err != nil {
+		h.writeError(w, r, "reconciliation failed", err)
+		return
+	}
+	h.logger.InfoContext(r.Context(), "reconciliation run",
+		"clients_checked", report.ClientsChecked, "discrepancies", len(report.Discrepancies),
+		"actor", Actor(r.Context()))
+	writeJSON(w, http.StatusOK, report)
+}
+
+// Actor names the caller in audit records. Stores use it to record who
+// requested and who decided a transfer that needs approval.
+func Actor(ctx context.Context) string {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		return p.ID
+	}
+	return "anonymous"
+}
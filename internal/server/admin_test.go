@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// adminStub implements AdminStore on top of StubStore
+type adminStub struct {
+	*StubStore
+	adjustments map[string]Adjustment
+}
+
+func newAdminStub() *adminStub {
+	s := &adminStub{StubStore: NewStubClient(), adjustments: map[string]Adjustment{}}
+	s.SeedClient("client_001", 1000, "JPY")
+	return s
+}
+
+func (s *adminStub) CreateClient(ctx context.Context, clientID, currency string) (Client, error) {
+	if _, ok := s.balances[clientID]; ok {
+		return Client{}, ErrClientExists
+	}
+	s.SeedClient(clientID, 0, currency)
+	return Client{ID: clientID, Currency: currency, CreatedAt: time.Now().UTC()}, nil
+}
+
+func (s *adminStub) PostAdjustment(ctx context.Context, adj Adjustment) (Adjustment, error) {
+	if prev, ok := s.adjustments[adj.IdempotencyKey]; ok {
+		if prev.ClientID != adj.ClientID || prev.Amount != adj.Amount || prev.Reason != adj.Reason {
+			return Adjustment{}, ErrIdempotencyConflict
+		}
+		return prev, nil
+	}
+	b, ok := s.balances[adj.ClientID]
+	if !ok {
+		return Adjustment{}, ErrClientNotFound
+	}
+	if b+adj.Amount < 0 {
+		return Adjustment{}, ErrInsufficientBalance
+	}
+	s.balances[adj.ClientID] = b + adj.Amount
+	adj.ID, adj.EntryID = uuid.NewString(), uuid.NewString()
+	adj.Balance, adj.CreatedAt = b+adj.Amount, time.Now().UTC()
+	s.adjustments[adj.IdempotencyKey] = adj
+	return adj, nil
+}
+
+func (s *adminStub) Reconcile(ctx context.Context) (ReconciliationReport, error) {
+	return ReconciliationReport{
+		CheckedAt:      time.Now().UTC(),
+		ClientsChecked: len(s.balances),
+		Discrepancies:  []Discrepancy{{ClientID: "client_001", Balance: 1000, LedgerSum: 900, Difference: 100}},
+	}, nil
+}
+
+func TestHandler_CreateClient(t *testing.T) {
+	h := NewHandler(newAdminStub())
+	admin := &Principal{ID: "key:ops", ClientIDs: []string{AnyClient}, Admin: true}
+	portal := &Principal{ID: "key:portal", ClientIDs: []string{AnyClient}}
+
+	res := serveAs(h, admin, http.MethodPost, "/clients", `{"client_id":"client_009","currency":"EUR"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("create: got %d (%s)", res.Code, res.Body)
+	}
+	if c := decodeJSON[Client](t, res); c.ID != "client_009" || c.Currency != "EUR" || c.Balance != 0 {
+		t.Errorf("got %+v", c)
+	}
+
+	tests := []struct {
+		name string
+		as   *Principal
+		body string
+		want int
+	}{
+		{"exists", admin, `{"client_id":"client_009","currency":"EUR"}`, http.StatusConflict},
+		{"not admin", portal, `{"client_id":"client_010","currency":"EUR"}`, http.StatusForbidden},
+		{"no key", nil, `{"client_id":"client_010","currency":"EUR"}`, http.StatusUnauthorized},
+		{"bad id", admin, `{"client_id":"a/b","currency":"EUR"}`, http.StatusBadRequest},
+		{"bad currency", admin, `{"client_id":"client_010","currency":"euro"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if res := serveAs(h, tt.as, http.MethodPost, "/clients", tt.body); res.Code != tt.want {
+			t.Errorf("%s: got %d (%s), want %d", tt.name, res.Code, res.Body, tt.want)
+		}
+	}
+
+	if res := serveAs(h, admin, http.MethodPost, "/clients", `{"client_id":"","currency":""}`); res.Code != http.StatusBadRequest {
+		t.Errorf("empty request: got %d", res.Code)
+	} else if body := res.Body.String(); !strings.Contains(body, "client_id") || !strings.Contains(body, "currency") {
+		t.Errorf("error %q should report both fields", body)
+	}
+}
+
+func TestHandler_PostAdjustment(t *testing.T) {
+	store := newAdminStub()
+	h := NewHandler(store)
+	admin := &Principal{ID: "key:ops", ClientIDs: []string{AnyClient}, Admin: true}
+	path := "/clients/client_001/adjustments"
+
+	res := serveAs(h, admin, http.MethodPost, path, `{"amount":-250,"reason":"duplicate card capture","idempotency_key":"adj-1"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("adjust: got %d (%s)", res.Code, res.Body)
+	}
+	adj := decodeJSON[Adjustment](t, res)
+	if adj.Balance != 750 || adj.Actor != "key:ops" || adj.Reason != "duplicate card capture" {
+		t.Errorf("got %+v", adj)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"replay", path, `{"amount":-250,"reason":"duplicate card capture","idempotency_key":"adj-1"}`, http.StatusCreated},
+		{"key reused", path, `{"amount":-1,"reason":"other","idempotency_key":"adj-1"}`, http.StatusConflict},
+		{"missing reason", path, `{"amount":10,"reason":"  ","idempotency_key":"adj-2"}`, http.StatusBadRequest},
+		{"zero amount", path, `{"amount":0,"reason":"noop","idempotency_key":"adj-3"}`, http.StatusBadRequest},
+		{"overdraw", path, `{"amount":-5000,"reason":"chargeback","idempotency_key":"adj-4"}`, http.StatusUnprocessableEntity},
+		{"unknown client", "/clients/nobody/adjustments", `{"amount":10,"reason":"x","idempotency_key":"adj-5"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if res := serveAs(h, admin, http.MethodPost, tt.path, tt.body); res.Code != tt.want {
+			t.Errorf("%s: got %d (%s), want %d", tt.name, res.Code, res.Body, tt.want)
+		}
+	}
+	if store.balances["client_001"] != 750 {
+		t.Errorf("balance is %d, want 750 after one adjustment", store.balances["client_001"])
+	}
+
+	portal := &Principal{ID: "key:portal", ClientIDs: []string{"client_001"}}
+	if res := serveAs(h, portal, http.MethodPost, path, `{"amount":10,"reason":"x","idempotency_key":"adj-6"}`); res.Code != http.StatusForbidden {
+		t.Errorf("non-admin: got %d, want 403", res.Code)
+	}
+	if res := serveAs(h, nil, http.MethodPost, path, `{"amount":10,"reason":"x","idempotency_key":"adj-7"}`); res.Code != http.StatusUnauthorized {
+		t.Errorf("no key: got %d, want 401", res.Code)
+	}
+}
+
+func TestHandler_Reconcile(t *testing.T) {
+	h := NewHandler(newAdminStub())
+	admin := &Principal{ID: "key:ops", ClientIDs: []string{AnyClient}, Admin: true}
+	res := serveAs(h, admin, http.MethodPost, "/reconciliation", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("got %d (%s)", res.Code, res.Body)
+	}
+	report := decodeJSON[ReconciliationReport](t, res)
+	if len(report.Discrepancies) != 1 || report.Discrepancies[0].Difference != 100 {
+		t.Errorf("got %+v", report)
+	}
+
+	if res := serveAs(h, nil, http.MethodPost, "/reconciliation", ""); res.Code != http.StatusUnauthorized {
+		t.Errorf("no key: got %d, want 401", res.Code)
+	}
+	if res := serveAs(h, admin, http.MethodGet, "/reconciliation", ""); res.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got %d, want 405", res.Code)
+	}
+	if res := serveAs(NewHandler(NewStubClient()), admin, http.MethodPost, "/reconciliation", ""); res.Code != http.StatusNotImplemented {
+		t.Errorf("store without AdminStore: got %d, want 501", res.Code)
+	}
+}
+
+func TestStore_AdminOperations(t *testing.T) {
+	ctx, db, store := newTestStore(t)
+	if err := Migrate(ctx, db.Pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	clientID := "admin_" + uniqueKey(t)[:12]
+
+	if _, err := store.CreateClient(ctx, clientID, "JPY"); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if _, err := store.CreateClient(ctx, clientID, "JPY"); !errors.Is(err, ErrClientExists) {
+		t.Fatalf("second create: got %v, want ErrClientExists", err)
+	}
+
+	key := uniqueKey(t)
+	adj, err := store.PostAdjustment(ctx, Adjustment{
+		ClientID: clientID, Amount: 500, Reason: "opening balance", Actor: "test", IdempotencyKey: key,
+	})
+	if err != nil {
+		t.Fatalf("adjust: %v", err)
+	}
+	if adj.Balance != 500 || adj.ID == "" || adj.EntryID == "" {
+		t.Errorf("got %+v", adj)
+	}
+
+	replay, err := store.PostAdjustment(ctx, Adjustment{
+		ClientID: clientID, Amount: 500, Reason: "opening balance", Actor: "test", IdempotencyKey: key,
+	})
+	if err != nil || replay.ID != adj.ID {
+		t.Errorf("replay: got %+v, %v", replay, err)
+	}
+	_, err = store.PostAdjustment(ctx, Adjustment{
+		ClientID: clientID, Amount: 1, Reason: "different", Actor: "test", IdempotencyKey: key,
+	})
+	if !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("reused key: got %v, want ErrIdempotencyConflict", err)
+	}
+
+	paymentKey := uniqueKey(t)
+	if _, err := store.CreatePayment(ctx, clientID, 10, paymentKey); err != nil {
+		t.Fatalf("payment: %v", err)
+	}
+	_, err = store.PostAdjustment(ctx, Adjustment{
+		ClientID: clientID, Amount: 10, Reason: "x", Actor: "test", IdempotencyKey: paymentKey,
+	})
+	if !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("payment key: got %v, want ErrIdempotencyConflict", err)
+	}
+
+	// A balance changed behind the ledger's back shows up in the report
+	if _, err := db.Pool.Exec(ctx, `UPDATE clients SET balance = balance + 7 WHERE client_id = $1`, clientID); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	report, err := store.Reconcile(ctx)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	var found bool
+	for _, d := range report.Discrepancies {
+		if d.ClientID == clientID {
+			found = true
+			if d.Balance != 517 || d.LedgerSum != 510 || d.Difference != 7 {
+				t.Errorf("got %+v", d)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("reconciliation missed %s: %+v", clientID, report)
+	}
+}
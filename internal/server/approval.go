@@ -0,0 +1,775 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+)
+
+// Statuses of a transfer that needs approval
+const (
+	TransferPending  = "pending"
+	TransferApproved = "approved"
+	TransferRejected = "rejected"
+	TransferExpired  = "expired"
+)
+
+// TransferRequested is the event recorded when a transfer is held for
+// approval. The events of its decision are named after the status it
+// moves to.
+const TransferRequested = "requested"
+
+// SystemActor is the actor of the events the ledger records by itself,
+// such as expiring a transfer nobody decided in time
+const SystemActor = "system"
+
+// ApprovalHoldAccount is the house account that holds the funds of
+// transfers awaiting approval in currency
+func ApprovalHoldAccount(currency string) string {
+	return SystemAccountPrefix + "approval_holds." + currency
+}
+
+var ErrApprovalRequired = errors.New("transfer requires approval")
+var ErrTransferNotFound = errors.New("transfer not found")
+var ErrTransferNotPending = errors.New("transfer is no longer pending")
+var ErrTransferExpired = errors.New("transfer expired before it was approved")
+var ErrTransferRejected = errors.New("transfer was rejected")
+var ErrSelfApproval = errors.New("a transfer must be decided by someone other than who requested it")
+var ErrApproverRequired = errors.New("a transfer must be decided with an admin API key")
+
+// ApprovalPolicy decides which transfers need approval (maker-checker).
+// Its zero value requires none.
+type ApprovalPolicy struct {
+	// Threshold is the largest amount transferred without approval, in
+	// minor units. Zero disables approvals.
+	Threshold int64
+	// Hold moves the funds out of the sender's balance while the transfer
+	// waits, so they cannot be spent twice
+	Hold bool
+	// TTL is how long a transfer may wait before it expires
+	TTL time.Duration
+}
+
+// Requires reports whether a transfer of amount needs approval
+func (p ApprovalPolicy) Requires(amount int64) bool {
+	return p.Threshold > 0 && amount > p.Threshold
+}
+
+// PendingTransfer is a transfer held for approval, whatever became of it
+// since. Fees and velocity limits apply when it is approved, not when it
+// is requested.
+type PendingTransfer struct {
+	ID             string `json:"id"`
+	FromClientID   string `json:"from_client_id"`
+	ToClientID     string `json:"to_client_id"`
+	Amount         int64  `json:"amount"`
+	Currency       string `json:"currency"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	Status         string `json:"status"`
+	// Held tells whether the amount sits in the approval hold account
+	// until the transfer is decided
+	Held        bool      `json:"held"`
+	RequestedBy string    `json:"requested_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// Events is the audit trail, oldest first. Lists leave it out.
+	Events []TransferEvent `json:"events,omitempty"`
+}
+
+// TransferEvent is one step of a pending transfer's audit trail
+type TransferEvent struct {
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ApprovalRequiredError is returned by Transfer when the transfer was
+// held for approval instead of executed, including when its idempotency
+// key is replayed while it waits. It matches ErrApprovalRequired with
+// errors.Is.
+type ApprovalRequiredError struct {
+	Transfer PendingTransfer
+}
+
+func (e *ApprovalRequiredError) Error() string {
+	return fmt.Sprintf("transfer %s requires approval: status %s", e.Transfer.ID, e.Transfer.Status)
+}
+
+func (e *ApprovalRequiredError) Unwrap() error {
+	return ErrApprovalRequired
+}
+
+// ReplayPendingTransfer is what Transfer returns when the idempotency key
+// of t is replayed before its debit is posted: t again while it waits for
+// a decision, and ErrTransferRejected or ErrTransferExpired once it never
+// will be posted.
+func ReplayPendingTransfer(t PendingTransfer) error {
+	switch t.Status {
+	case TransferRejected:
+		return ErrTransferRejected
+	case TransferExpired:
+		return ErrTransferExpired
+	}
+	return &ApprovalRequiredError{Transfer: t}
+}
+
+// ApprovedTransfer is a pending transfer once approved, with the balances
+// its execution left
+type ApprovedTransfer struct {
+	PendingTransfer
+	FromNewBalance int64 `json:"from_new_balance"`
+	ToNewBalance   int64 `json:"to_new_balance"`
+}
+
+// ApprovalStore is implemented by stores that hold large transfers for
+// approval. Their Transfer moves the funds of such a transfer into
+// ApprovalHoldAccount and fails with an *ApprovalRequiredError.
+//
+// The principal in the context of Transfer is recorded as who requested
+// it; approving or rejecting takes a different one, so a single key
+// cannot move funds above the threshold alone.
+type ApprovalStore interface {
+	// GetPendingTransfer returns the transfer with its audit trail
+	GetPendingTransfer(ctx context.Context, id string) (PendingTransfer, error)
+	// ListPendingTransfers returns the transfers with status, or every
+	// transfer when it is empty, oldest first
+	ListPendingTransfers(ctx context.Context, status string) ([]PendingTransfer, error)
+	// ApproveTransfer executes a pending transfer. A transfer past its
+	// expiry is expired instead and ErrTransferExpired returned. If the
+	// transfer fails, for lack of funds or by a velocity limit, it stays
+	// pending.
+	ApproveTransfer(ctx context.Context, id string, reason string) (ApprovedTransfer, error)
+	// RejectTransfer refuses a pending transfer, releasing its hold
+	RejectTransfer(ctx context.Context, id string, reason string) (PendingTransfer, error)
+	// ExpireTransfers expires every pending transfer whose expiry is not
+	// after now, releasing their holds, and returns how many it expired
+	ExpireTransfers(ctx context.Context, now time.Time) (int, error)
+}
+
+// WithApprovals holds the transfers policy requires approval for
+func (s *Store) WithApprovals(policy ApprovalPolicy) *Store {
+	s.approvals = policy
+	return s
+}
+
+const pendingTransferColumns = `transfer_id::text, from_client_id, to_client_id, amount, currency,
+	COALESCE(idempotency_key, ''), status, hold_entry_id IS NOT NULL, requested_by, created_at, expires_at`
+
+func scanPendingTransfer(row pgx.Row) (PendingTransfer, error) {
+	var t PendingTransfer
+	err := row.Scan(&t.ID, &t.FromClientID, &t.ToClientID, &t.Amount, &t.Currency,
+		&t.IdempotencyKey, &t.Status, &t.Held, &t.RequestedBy, &t.CreatedAt, &t.ExpiresAt)
+	if err == pgx.ErrNoRows {
+		return PendingTransfer{}, ErrTransferNotFound
+	}
+	return t, err
+}
+
+func transferEvents(ctx context.Context, q querier, id string) ([]TransferEvent, error) {
+	rows, err := q.Query(ctx,
+		`SELECT action, actor, reason, created_at
+		FROM transfer_events WHERE transfer_id = $1 ORDER BY event_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []TransferEvent{}
+	for rows.Next() {
+		var e TransferEvent
+		if err := rows.Scan(&e.Action, &e.Actor, &e.Reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *Store) GetPendingTransfer(ctx context.Context, id string) (_ PendingTransfer, err error) {
+	ctx, span := startSpan(ctx, "Store.GetPendingTransfer",
+		attribute.String("transfer.id", id))
+	defer func() { endSpan(span, err) }()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return PendingTransfer{}, ErrTransferNotFound
+	}
+	t, err := scanPendingTransfer(s.db.QueryRow(ctx,
+		`SELECT `+pendingTransferColumns+` FROM pending_transfers WHERE transfer_id = $1`, id))
+	if err != nil {
+		return PendingTransfer{}, err
+	}
+	if t.Events, err = transferEvents(ctx, s.db, id); err != nil {
+		return PendingTransfer{}, err
+	}
+	return t, nil
+}
+
+func (s *Store) ListPendingTransfers(ctx context.Context, status string) (_ []PendingTransfer, err error) {
+	ctx, span := startSpan(ctx, "Store.ListPendingTransfers",
+		attribute.String("transfer.status", status))
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.Query(ctx,
+		`SELECT `+pendingTransferColumns+`
+		FROM pending_transfers WHERE $1 = '' OR status = $1
+		ORDER BY created_at, transfer_id`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []PendingTransfer{}
+	for rows.Next() {
+		t, err := scanPendingTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, rows.Err()
+}
+
+func (s *Store) ApproveTransfer(ctx context.Context, id string, reason string) (_ ApprovedTransfer, err error) {
+	ctx, span := startSpan(ctx, "Store.ApproveTransfer",
+		attribute.String("transfer.id", id))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return ApprovedTransfer{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	t, err := s.decide(ctx, tx, id)
+	if errors.Is(err, ErrTransferExpired) {
+		return ApprovedTransfer{}, s.commitExpiry(ctx, tx)
+	}
+	if err != nil {
+		return ApprovedTransfer{}, err
+	}
+
+	// Both clients are locked before the hold account
+	if _, err := lockClients(ctx, tx, t.FromClientID, t.ToClientID); err != nil {
+		return ApprovedTransfer{}, err
+	}
+	if t.Held {
+		if _, err := s.moveHold(ctx, tx, t.FromClientID, money.New(-t.Amount, t.Currency)); err != nil {
+			return ApprovedTransfer{}, err
+		}
+	}
+	fromBalance, toBalance, fee, err := s.transfer(ctx, tx, t.FromClientID, t.ToClientID, t.Amount, t.IdempotencyKey)
+	if err != nil {
+		return ApprovedTransfer{}, err
+	}
+	if err := s.decideTransfer(ctx, tx, &t, TransferApproved, Actor(ctx), reason); err != nil {
+		return ApprovedTransfer{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return ApprovedTransfer{}, err
+	}
+	s.metrics.observeTransfer(fee.Currency, t.Amount)
+	s.metrics.observeFee(fee.Currency, FeeTransfer, fee.Fee)
+	s.metrics.observeApproval(TransferApproved)
+	return ApprovedTransfer{PendingTransfer: t, FromNewBalance: fromBalance, ToNewBalance: toBalance}, nil
+}
+
+func (s *Store) RejectTransfer(ctx context.Context, id string, reason string) (_ PendingTransfer, err error) {
+	ctx, span := startSpan(ctx, "Store.RejectTransfer",
+		attribute.String("transfer.id", id))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return PendingTransfer{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	t, err := s.decide(ctx, tx, id)
+	if errors.Is(err, ErrTransferExpired) {
+		return PendingTransfer{}, s.commitExpiry(ctx, tx)
+	}
+	if err != nil {
+		return PendingTransfer{}, err
+	}
+	if t.Held {
+		if _, err := s.moveHold(ctx, tx, t.FromClientID, money.New(-t.Amount, t.Currency)); err != nil {
+			return PendingTransfer{}, err
+		}
+	}
+	if err := s.decideTransfer(ctx, tx, &t, TransferRejected, Actor(ctx), reason); err != nil {
+		return PendingTransfer{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return PendingTransfer{}, err
+	}
+	s.metrics.observeApproval(TransferRejected)
+	return t, nil
+}
+
+func (s *Store) ExpireTransfers(ctx context.Context, now time.Time) (_ int, err error) {
+	ctx, span := startSpan(ctx, "Store.ExpireTransfers")
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.Query(ctx,
+		`SELECT transfer_id::text FROM pending_transfers
+		WHERE status = 'pending' AND expires_at <= $1
+		ORDER BY expires_at, transfer_id`, now)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, err
+	}
+
+	// Each transfer is expired in a transaction of its own, so one that was
+	// decided meanwhile is skipped instead of failing the others
+	expired := 0
+	for _, id := range ids {
+		done, err := s.expireTransfer(ctx, id, now)
+		if err != nil {
+			return expired, err
+		}
+		if done {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (s *Store) expireTransfer(ctx context.Context, id string, now time.Time) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	t, err := lockPendingTransfer(ctx, tx, id)
+	if err != nil {
+		return false, err
+	}
+	if t.Status != TransferPending || t.ExpiresAt.After(now) {
+		return false, nil
+	}
+	if err := s.expire(ctx, tx, &t); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+	s.metrics.observeApproval(TransferExpired)
+	return true, nil
+}
+
+// requestApproval records a transfer that needs approval inside tx,
+// holding its amount when the policy says so. The transfer must be
+// possible as requested: both clients exist in one currency, and the
+// sender can cover the amount if it is held.
+func (s *Store) requestApproval(ctx context.Context, tx pgx.Tx, fromClientId, toClientId string, amount int64, idempotencyKey string) (PendingTransfer, error) {
+	balances, err := lockClients(ctx, tx, fromClientId, toClientId)
+	if err != nil {
+		return PendingTransfer{}, err
+	}
+	from, ok1 := balances[fromClientId]
+	to, ok2 := balances[toClientId]
+	if !ok1 || !ok2 {
+		return PendingTransfer{}, ErrClientNotFound
+	}
+	sent := money.New(amount, from.Currency)
+	newFrom, err := from.Sub(sent)
+	if err != nil {
+		return PendingTransfer{}, err
+	}
+	if _, err := to.Add(sent); err != nil {
+		return PendingTransfer{}, err
+	}
+
+	t := PendingTransfer{
+		FromClientID:   fromClientId,
+		ToClientID:     toClientId,
+		Amount:         amount,
+		Currency:       from.Currency,
+		IdempotencyKey: idempotencyKey,
+		Status:         TransferPending,
+		Held:           s.approvals.Hold,
+		RequestedBy:    Actor(ctx),
+	}
+	var holdEntryID *string
+	if t.Held {
+		if newFrom.IsNegative() {
+			s.metrics.observeInsufficientBalance("transfer")
+			s.logger.InfoContext(ctx, "transfer rejected for insufficient balance",
+				"from_client_id", fromClientId, "balance", from.Amount, "amount", amount)
+			return PendingTransfer{}, ErrInsufficientBalance
+		}
+		entryID, err := s.moveHold(ctx, tx, fromClientId, sent)
+		if err != nil {
+			return PendingTransfer{}, err
+		}
+		holdEntryID = &entryID
+	}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO pending_transfers (from_client_id, to_client_id, amount, currency, idempotency_key,
+			status, hold_entry_id, requested_by, expires_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, NOW() + make_interval(secs => $9))
+		RETURNING transfer_id::text, created_at, expires_at`,
+		t.FromClientID, t.ToClientID, t.Amount, t.Currency, t.IdempotencyKey,
+		t.Status, holdEntryID, t.RequestedBy, s.approvals.TTL.Seconds(),
+	).Scan(&t.ID, &t.CreatedAt, &t.ExpiresAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+		// The same key was requested concurrently
+		return PendingTransfer{}, ErrIdempotencyConflict
+	}
+	if err != nil {
+		return PendingTransfer{}, err
+	}
+	if err := s.recordTransferEvent(ctx, tx, &t, TransferRequested, t.RequestedBy, ""); err != nil {
+		return PendingTransfer{}, err
+	}
+	s.metrics.observeApproval(TransferPending)
+	s.logger.InfoContext(ctx, "transfer held for approval",
+		"transfer_id", t.ID, "from_client_id", fromClientId, "to_client_id", toClientId,
+		"amount", amount, "held", t.Held, "actor", t.RequestedBy)
+	return t, nil
+}
+
+// pendingTransferByKey returns the transfer held for approval under
+// idempotencyKey
+func (s *Store) pendingTransferByKey(ctx context.Context, tx pgx.Tx, idempotencyKey string) (PendingTransfer, error) {
+	t, err := scanPendingTransfer(tx.QueryRow(ctx,
+		`SELECT `+pendingTransferColumns+` FROM pending_transfers WHERE idempotency_key = $1`,
+		idempotencyKey))
+	if err != nil {
+		return PendingTransfer{}, err
+	}
+	t.Events, err = transferEvents(ctx, tx, t.ID)
+	return t, err
+}
+
+func lockPendingTransfer(ctx context.Context, tx pgx.Tx, id string) (PendingTransfer, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return PendingTransfer{}, ErrTransferNotFound
+	}
+	return scanPendingTransfer(tx.QueryRow(ctx,
+		`SELECT `+pendingTransferColumns+` FROM pending_transfers WHERE transfer_id = $1 FOR UPDATE`, id))
+}
+
+// decide locks transfer id so the principal in ctx can approve or reject
+// it. A transfer past its expiry is expired instead, and
+// ErrTransferExpired returned for the caller to commit with commitExpiry.
+func (s *Store) decide(ctx context.Context, tx pgx.Tx, id string) (PendingTransfer, error) {
+	if _, ok := PrincipalFromContext(ctx); !ok {
+		return PendingTransfer{}, ErrApproverRequired
+	}
+	t, err := lockPendingTransfer(ctx, tx, id)
+	if err != nil {
+		return PendingTransfer{}, err
+	}
+	if t.Status != TransferPending {
+		return PendingTransfer{}, ErrTransferNotPending
+	}
+	if !time.Now().Before(t.ExpiresAt) {
+		if err := s.expire(ctx, tx, &t); err != nil {
+			return PendingTransfer{}, err
+		}
+		return t, ErrTransferExpired
+	}
+	if Actor(ctx) == t.RequestedBy {
+		return PendingTransfer{}, ErrSelfApproval
+	}
+	return t, nil
+}
+
+func (s *Store) commitExpiry(ctx context.Context, tx pgx.Tx) error {
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	s.metrics.observeApproval(TransferExpired)
+	return ErrTransferExpired
+}
+
+// expire releases the hold of t and marks it expired
+func (s *Store) expire(ctx context.Context, tx pgx.Tx, t *PendingTransfer) error {
+	if t.Held {
+		if _, err := s.moveHold(ctx, tx, t.FromClientID, money.New(-t.Amount, t.Currency)); err != nil {
+			return err
+		}
+	}
+	return s.decideTransfer(ctx, tx, t, TransferExpired, SystemActor, "")
+}
+
+// decideTransfer moves t to status and records who did it
+func (s *Store) decideTransfer(ctx context.Context, tx pgx.Tx, t *PendingTransfer, status, actor, reason string) error {
+	if _, err := tx.Exec(ctx,
+		`UPDATE pending_transfers SET status = $2 WHERE transfer_id = $1`, t.ID, status); err != nil {
+		return err
+	}
+	t.Status = status
+	if err := s.recordTransferEvent(ctx, tx, t, status, actor, reason); err != nil {
+		return err
+	}
+	s.logger.InfoContext(ctx, "transfer "+status,
+		"transfer_id", t.ID, "from_client_id", t.FromClientID, "to_client_id", t.ToClientID,
+		"amount", t.Amount, "actor", actor, "reason", reason)
+	return nil
+}
+
+// recordTransferEvent appends an event to the audit trail of t and
+// reloads it
+func (s *Store) recordTransferEvent(ctx context.Context, tx pgx.Tx, t *PendingTransfer, action, actor, reason string) error {
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO transfer_events (transfer_id, action, actor, reason) VALUES ($1, $2, $3, $4)`,
+		t.ID, action, actor, reason); err != nil {
+		return err
+	}
+	events, err := transferEvents(ctx, tx, t.ID)
+	if err != nil {
+		return err
+	}
+	t.Events = events
+	return nil
+}
+
+// moveHold moves amount from the client to the approval hold account of
+// its currency, or back when amount is negative, and returns the client's
+// ledger entry
+func (s *Store) moveHold(ctx context.Context, tx pgx.Tx, clientID string, amount money.Money) (string, error) {
+	account := ApprovalHoldAccount(amount.Currency)
+	var client money.Money
+	err := tx.QueryRow(ctx,
+		`SELECT balance, currency FROM clients WHERE client_id = $1 FOR UPDATE`,
+		clientID).Scan(&client.Amount, &client.Currency)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO clients (client_id, balance, currency) VALUES ($1, 0, $2)
+		ON CONFLICT (client_id) DO NOTHING`, account, amount.Currency); err != nil {
+		return "", err
+	}
+	var hold money.Money
+	err = tx.QueryRow(ctx,
+		`SELECT balance, currency FROM clients WHERE client_id = $1 FOR UPDATE`,
+		account).Scan(&hold.Amount, &hold.Currency)
+	if err != nil {
+		return "", err
+	}
+	if client, err = client.Sub(amount); err != nil {
+		return "", err
+	}
+	if hold, err = hold.Add(amount); err != nil {
+		return "", err
+	}
+	if _, err = tx.Exec(ctx,
+		`UPDATE clients SET balance = $1 WHERE client_id = $2`, client.Amount, clientID); err != nil {
+		return "", err
+	}
+	if _, err = tx.Exec(ctx,
+		`UPDATE clients SET balance = $1 WHERE client_id = $2`, hold.Amount, account); err != nil {
+		return "", err
+	}
+
+	var entryID string
+	err = tx.QueryRow(ctx,
+		`INSERT INTO ledger_entries (entry_id, client_id, amount) VALUES (gen_random_uuid(), $1, $2)
+		RETURNING entry_id::text`, clientID, -amount.Amount).Scan(&entryID)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO ledger_entries (entry_id, client_id, amount) VALUES (gen_random_uuid(), $1, $2)`,
+		account, amount.Amount)
+	return entryID, err
+}
+
+// ApprovalExpiryJob expires the transfers nobody decided in time every
+// interval, starting right away. Replicas may each run the job.
+type ApprovalExpiryJob struct {
+	store    ApprovalStore
+	interval time.Duration
+	logger   *slog.Logger
+}
+
+func NewApprovalExpiryJob(store ApprovalStore, interval time.Duration) *ApprovalExpiryJob {
+	return &ApprovalExpiryJob{store: store, interval: interval, logger: slog.Default()}
+}
+
+func (j *ApprovalExpiryJob) WithLogger(logger *slog.Logger) *ApprovalExpiryJob {
+	j.logger = logger
+	return j
+}
+
+// Run runs the job until ctx is cancelled. A failed run is logged and
+// retried at the next interval.
+func (j *ApprovalExpiryJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		expired, err := j.store.ExpireTransfers(ctx, time.Now())
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			j.logger.Error("approval expiry failed", "err", err, "expired", expired)
+		case expired > 0:
+			j.logger.Info("pending transfers expired", "expired", expired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// TransferDecisionRequest is the body of POST /transfers/{id}/approve
+// and /reject. A rejection must give its reason.
+type TransferDecisionRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (d TransferDecisionRequest) validate(required bool) error {
+	var v validator
+	if strings.TrimSpace(d.Reason) == "" {
+		v.check(!required, "reason", "is required")
+	} else {
+		v.check(len(d.Reason) <= maxReasonLength, "reason", "must be at most %d bytes", maxReasonLength)
+	}
+	return v.err()
+}
+
+// PendingTransfersResponse lists transfers held for approval
+type PendingTransfersResponse struct {
+	Transfers []PendingTransfer `json:"transfers"`
+}
+
+func (h *Handler) approvalStore(w http.ResponseWriter, r *http.Request) (ApprovalStore, bool) {
+	store, ok := h.store.(ApprovalStore)
+	if !ok {
+		writeProblem(w, r, http.StatusNotImplemented, CodeNotImplemented, "transfer approvals are not supported by this store")
+	}
+	return store, ok
+}
+
+// writePendingTransfer answers 202 for a transfer held for approval,
+// pointing at where its decision can be followed
+func writePendingTransfer(w http.ResponseWriter, t PendingTransfer) {
+	w.Header().Set("Location", V1+"/transfers/"+t.ID)
+	writeJSON(w, http.StatusAccepted, t)
+}
+
+// listPendingTransfers serves GET /transfers, by default the ones still
+// awaiting a decision
+func (h *Handler) listPendingTransfers(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	store, ok := h.approvalStore(w, r)
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = TransferPending
+	case "all":
+		status = ""
+	case TransferPending, TransferApproved, TransferRejected, TransferExpired:
+	default:
+		writeInvalid(w, r, ValidationError{{Field: "status", Detail: "must be pending, approved, rejected, expired or all"}})
+		return
+	}
+	transfers, err := store.ListPendingTransfers(r.Context(), status)
+	if err != nil {
+		h.writeError(w, r, "list pending transfers failed", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, PendingTransfersResponse{Transfers: transfers})
+}
+
+// getPendingTransfer serves GET /transfers/{transfer_id}. Clients may
+// follow the transfers they send.
+func (h *Handler) getPendingTransfer(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.approvalStore(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("transfer_id")
+	t, err := store.GetPendingTransfer(r.Context(), id)
+	if err != nil {
+		h.writeError(w, r, "get pending transfer failed", err, "transfer_id", id)
+		return
+	}
+	if !authorizeClient(w, r, t.FromClientID) {
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// approveTransfer serves POST /transfers/{transfer_id}/approve
+func (h *Handler) approveTransfer(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	store, ok := h.approvalStore(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("transfer_id")
+	var req TransferDecisionRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if err := req.validate(false); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	t, err := store.ApproveTransfer(r.Context(), id, req.Reason)
+	if err != nil {
+		h.writeError(w, r, "approve transfer failed", err, "transfer_id", id, "actor", Actor(r.Context()))
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// rejectTransfer serves POST /transfers/{transfer_id}/reject
+func (h *Handler) rejectTransfer(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	store, ok := h.approvalStore(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("transfer_id")
+	var req TransferDecisionRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if err := req.validate(true); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	t, err := store.RejectTransfer(r.Context(), id, req.Reason)
+	if err != nil {
+		h.writeError(w, r, "reject transfer failed", err, "transfer_id", id, "actor", Actor(r.Context()))
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
@@ -0,0 +1,253 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/memstore"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// newApprovalStore holds transfers above 1000 for an hour, with client_001
+// holding 10000 and client_002 nothing
+func newApprovalStore(t *testing.T) *memstore.Store {
+	t.Helper()
+	store := memstore.New().WithApprovals(server.ApprovalPolicy{Threshold: 1000, Hold: true, TTL: time.Hour})
+	seed(t, store, "client_001", 10000)
+	seed(t, store, "client_002", 0)
+	return store
+}
+
+// requestTransfer has maker transfer amount from client_001 to client_002,
+// failing the test unless it is held
+func requestTransfer(t *testing.T, h http.Handler, maker *server.Principal, amount int64, key string) server.PendingTransfer {
+	t.Helper()
+	body := `{"from_client_id":"client_001","to_client_id":"client_002","amount":` +
+		strconv.FormatInt(amount, 10) + `,"idempotencyKey":"` + key + `"}`
+	res := serveAs(h, maker, http.MethodPost, server.V1+"/transfer", body)
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("transfer of %d: got %d, want %d: %s", amount, res.Code, http.StatusAccepted, res.Body)
+	}
+	return decodeJSON[server.PendingTransfer](t, res)
+}
+
+func TestHandler_TransferApproval(t *testing.T) {
+	store := newApprovalStore(t)
+	handler := newHandler(store)
+	maker := &server.Principal{ID: "key:maker", ClientIDs: []string{"client_001"}}
+	checker := &server.Principal{ID: "key:checker", ClientIDs: []string{server.AnyClient}, Admin: true}
+	other := &server.Principal{ID: "key:other", ClientIDs: []string{"client_002"}}
+
+	res := serveAs(handler, maker, http.MethodPost, server.V1+"/transfer",
+		`{"from_client_id":"client_001","to_client_id":"client_002","amount":5000,"idempotencyKey":"t1"}`)
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("transfer above the threshold: got %d, want %d: %s", res.Code, http.StatusAccepted, res.Body)
+	}
+	pending := decodeJSON[server.PendingTransfer](t, res)
+	if pending.Status != server.TransferPending || pending.RequestedBy != "key:maker" || pending.Amount != 5000 {
+		t.Errorf("unexpected transfer %+v", pending)
+	}
+	if got, want := res.Header().Get("Location"), server.V1+"/transfers/"+pending.ID; got != want {
+		t.Errorf("Location is %q, want %q", got, want)
+	}
+	if b := balance(t, store, "client_001"); b != 5000 {
+		t.Errorf("sender holds %d while the transfer is pending, want 5000", b)
+	}
+
+	res = serveAs(handler, maker, http.MethodGet, server.V1+"/transfers/"+pending.ID, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("get as maker: got %d: %s", res.Code, res.Body)
+	}
+	res = serveAs(handler, other, http.MethodGet, server.V1+"/transfers/"+pending.ID, "")
+	if res.Code != http.StatusForbidden {
+		t.Errorf("get as another client: got %d, want %d", res.Code, http.StatusForbidden)
+	}
+
+	res = serveAs(handler, maker, http.MethodGet, server.V1+"/transfers", "")
+	if res.Code != http.StatusForbidden {
+		t.Errorf("list as maker: got %d, want %d", res.Code, http.StatusForbidden)
+	}
+	res = serveAs(handler, checker, http.MethodGet, server.V1+"/transfers", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("list as checker: got %d: %s", res.Code, res.Body)
+	}
+	if got := decodeJSON[server.PendingTransfersResponse](t, res); len(got.Transfers) != 1 || got.Transfers[0].ID != pending.ID {
+		t.Errorf("unexpected transfers %+v", got)
+	}
+
+	res = serveAs(handler, maker, http.MethodPost, server.V1+"/transfers/"+pending.ID+"/approve", `{}`)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("approve as maker: got %d, want %d", res.Code, http.StatusForbidden)
+	}
+	res = serveAs(handler, checker, http.MethodPost, server.V1+"/transfers/"+pending.ID+"/reject", `{}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("reject without reason: got %d, want %d", res.Code, http.StatusBadRequest)
+	}
+	res = serveAs(handler, checker, http.MethodPost, server.V1+"/transfers/"+pending.ID+"/approve", `{"reason":"invoice 42"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("approve as checker: got %d: %s", res.Code, res.Body)
+	}
+	got := decodeJSON[server.ApprovedTransfer](t, res)
+	if got.Status != server.TransferApproved || got.FromNewBalance != 5000 || got.ToNewBalance != 5000 {
+		t.Errorf("unexpected approval %+v", got)
+	}
+	res = serveAs(handler, checker, http.MethodPost, server.V1+"/transfers/"+pending.ID+"/reject", `{"reason":"late"}`)
+	if res.Code != http.StatusConflict || !strings.Contains(res.Body.String(), server.CodeTransferNotPending) {
+		t.Errorf("reject after approval: got %d (%s), want %d", res.Code, res.Body, http.StatusConflict)
+	}
+
+	res = serveAs(handler, checker, http.MethodGet, server.V1+"/transfers?status=approved", "")
+	if got := decodeJSON[server.PendingTransfersResponse](t, res); len(got.Transfers) != 1 {
+		t.Errorf("approved transfers: got %+v", got)
+	}
+}
+
+// An admin key that requested a transfer cannot approve it itself
+func TestHandler_TransferSelfApproval(t *testing.T) {
+	handler := newHandler(newApprovalStore(t))
+	admin := &server.Principal{ID: "key:ops", ClientIDs: []string{server.AnyClient}, Admin: true}
+
+	pending := requestTransfer(t, handler, admin, 5000, "t1")
+	res := serveAs(handler, admin, http.MethodPost, server.V1+"/transfers/"+pending.ID+"/approve", `{}`)
+	if res.Code != http.StatusForbidden || !strings.Contains(res.Body.String(), server.CodeSelfApproval) {
+		t.Errorf("got %d (%s), want %d %s", res.Code, res.Body, http.StatusForbidden, server.CodeSelfApproval)
+	}
+}
+
+// Deciding a transfer needs an admin key even when authentication is off
+func TestHandler_TransferApprovalNeedsAdminKey(t *testing.T) {
+	store := newApprovalStore(t)
+	handler := newHandler(store)
+	maker := &server.Principal{ID: "key:maker", ClientIDs: []string{"client_001"}}
+	client := &server.Principal{ID: "key:other", ClientIDs: []string{server.AnyClient}}
+	pending := requestTransfer(t, handler, maker, 5000, "t1")
+
+	tests := []struct {
+		name       string
+		principal  *server.Principal
+		action     string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"approve without a key", nil, "approve", `{}`, http.StatusUnauthorized, server.CodeUnauthorized},
+		{"reject without a key", nil, "reject", `{"reason":"x"}`, http.StatusUnauthorized, server.CodeUnauthorized},
+		{"approve with a client key", client, "approve", `{}`, http.StatusForbidden, server.CodeForbidden},
+		{"reject with a client key", client, "reject", `{"reason":"x"}`, http.StatusForbidden, server.CodeForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serveAs(handler, tt.principal, http.MethodPost, server.V1+"/transfers/"+pending.ID+"/"+tt.action, tt.body)
+			if res.Code != tt.wantStatus {
+				t.Fatalf("got %d (%s), want %d", res.Code, res.Body, tt.wantStatus)
+			}
+			if p := decodeJSON[server.Problem](t, res); p.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", p.Code, tt.wantCode)
+			}
+		})
+	}
+	if got, err := store.GetPendingTransfer(context.Background(), pending.ID); err != nil || got.Status != server.TransferPending {
+		t.Errorf("transfer is %s (%v), want %s", got.Status, err, server.TransferPending)
+	}
+}
+
+// The key of a transfer that was turned down cannot request it again
+func TestHandler_TransferReplayAfterDecision(t *testing.T) {
+	store := newApprovalStore(t)
+	handler := newHandler(store)
+	maker := &server.Principal{ID: "key:maker", ClientIDs: []string{"client_001"}}
+	checker := &server.Principal{ID: "key:checker", ClientIDs: []string{server.AnyClient}, Admin: true}
+	body := `{"from_client_id":"client_001","to_client_id":"client_002","amount":5000,"idempotencyKey":"t1"}`
+
+	pending := requestTransfer(t, handler, maker, 5000, "t1")
+	if replayed := requestTransfer(t, handler, maker, 5000, "t1"); replayed.ID != pending.ID {
+		t.Errorf("replay while pending returned transfer %s, want %s", replayed.ID, pending.ID)
+	}
+	res := serveAs(handler, checker, http.MethodPost, server.V1+"/transfers/"+pending.ID+"/reject", `{"reason":"unknown payee"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("reject: got %d: %s", res.Code, res.Body)
+	}
+	res = serveAs(handler, maker, http.MethodPost, server.V1+"/transfer", body)
+	if res.Code != http.StatusConflict {
+		t.Fatalf("replay after rejection: got %d (%s), want %d", res.Code, res.Body, http.StatusConflict)
+	}
+	if p := decodeJSON[server.Problem](t, res); p.Code != server.CodeTransferNotPending {
+		t.Errorf("got code %q, want %q", p.Code, server.CodeTransferNotPending)
+	}
+	if b := balance(t, store, "client_001"); b != 10000 {
+		t.Errorf("sender holds %d after the replay, want 10000", b)
+	}
+}
+
+func TestHandler_TransferApprovalValidation(t *testing.T) {
+	handler := newHandler(newApprovalStore(t))
+	admin := &server.Principal{ID: "key:checker", ClientIDs: []string{server.AnyClient}, Admin: true}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"list unknown status", http.MethodGet, "/transfers?status=done", "", http.StatusBadRequest},
+		{"list all", http.MethodGet, "/transfers?status=all", "", http.StatusOK},
+		{"get unknown transfer", http.MethodGet, "/transfers/" + uuid.NewString(), "", http.StatusNotFound},
+		{"approve unknown transfer", http.MethodPost, "/transfers/" + uuid.NewString() + "/approve", `{}`, http.StatusNotFound},
+		{"approve without body", http.MethodPost, "/transfers/" + uuid.NewString() + "/approve", "", http.StatusUnsupportedMediaType},
+		{"reject unknown field", http.MethodPost, "/transfers/" + uuid.NewString() + "/reject", `{"reason":"x","note":"y"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serveAs(handler, admin, tt.method, server.V1+tt.path, tt.body)
+			if res.Code != tt.wantStatus {
+				t.Errorf("got %d, want %d: %s", res.Code, tt.wantStatus, res.Body)
+			}
+		})
+	}
+}
+
+func TestHandler_ApprovalResponsesConformToOpenAPI(t *testing.T) {
+	router := server.OpenAPIRouter(t)
+	handler := newHandler(newApprovalStore(t))
+	maker := &server.Principal{ID: "key:maker", ClientIDs: []string{"client_001"}}
+	admin := &server.Principal{ID: "key:ops", ClientIDs: []string{server.AnyClient}, Admin: true}
+	portal := &server.Principal{ID: "key:portal", ClientIDs: []string{"client_001"}}
+	approved := requestTransfer(t, handler, maker, 5000, "h1")
+	rejected := requestTransfer(t, handler, maker, 2000, "h2")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		as     *server.Principal
+		want   int
+	}{
+		{"transfer held for approval", http.MethodPost, "/transfer",
+			`{"from_client_id":"client_001","to_client_id":"client_002","amount":2500,"idempotencyKey":"h3"}`, maker, http.StatusAccepted},
+		{"list pending transfers", http.MethodGet, "/transfers", "", admin, http.StatusOK},
+		{"list transfers bad status", http.MethodGet, "/transfers?status=done", "", admin, http.StatusBadRequest},
+		{"pending transfer", http.MethodGet, "/transfers/" + approved.ID, "", portal, http.StatusOK},
+		{"unknown pending transfer", http.MethodGet, "/transfers/" + uuid.NewString(), "", admin, http.StatusNotFound},
+		{"approve transfer", http.MethodPost, "/transfers/" + approved.ID + "/approve", `{"reason":"invoice 42"}`, admin, http.StatusOK},
+		{"approve decided transfer", http.MethodPost, "/transfers/" + approved.ID + "/approve", `{}`, admin, http.StatusConflict},
+		{"approve without a key", http.MethodPost, "/transfers/" + rejected.ID + "/approve", `{}`, nil, http.StatusUnauthorized},
+		{"approve not admin", http.MethodPost, "/transfers/" + rejected.ID + "/approve", `{}`, portal, http.StatusForbidden},
+		{"reject without reason", http.MethodPost, "/transfers/" + rejected.ID + "/reject", `{}`, admin, http.StatusBadRequest},
+		{"reject transfer", http.MethodPost, "/transfers/" + rejected.ID + "/reject", `{"reason":"unknown payee"}`, admin, http.StatusOK},
+		{"replay rejected transfer", http.MethodPost, "/transfer",
+			`{"from_client_id":"client_001","to_client_id":"client_002","amount":2000,"idempotencyKey":"h2"}`, maker, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.ServeConforming(t, router, handler, request(tt.method, server.V1+tt.path, tt.body, tt.as), tt.want)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestApprovalPolicy_Requires(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ApprovalPolicy
+		amount int64
+		want   bool
+	}{
+		{"disabled", ApprovalPolicy{}, 1 << 40, false},
+		{"below", ApprovalPolicy{Threshold: 1000}, 999, false},
+		{"at", ApprovalPolicy{Threshold: 1000}, 1000, false},
+		{"above", ApprovalPolicy{Threshold: 1000}, 1001, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Requires(tt.amount); got != tt.want {
+				t.Errorf("Requires(%d) = %v, want %v", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransferDecisionRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		reason   string
+		required bool
+		wantErr  bool
+	}{
+		{"approval without reason", "", false, false},
+		{"approval with reason", "invoice 42", false, false},
+		{"rejection without reason", " ", true, true},
+		{"rejection with reason", "unknown payee", true, false},
+		{"reason too long", strings.Repeat("x", maxReasonLength+1), false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := TransferDecisionRequest{Reason: tt.reason}.validate(tt.required)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandler_TransferApprovalUnsupportedStore(t *testing.T) {
+	admin := &Principal{ID: "key:ops", ClientIDs: []string{AnyClient}, Admin: true}
+	res := serveAs(NewHandler(NewStubClient()), admin, http.MethodGet, V1+"/transfers", "")
+	if res.Code != http.StatusNotImplemented {
+		t.Errorf("got %d, want %d", res.Code, http.StatusNotImplemented)
+	}
+}
+
+// approvalJobStub signals each sweep and fails the first. The job calls
+// nothing else.
+type approvalJobStub struct {
+	ApprovalStore
+	runs  atomic.Int32
+	calls chan struct{}
+}
+
+func (s *approvalJobStub) ExpireTransfers(ctx context.Context, now time.Time) (int, error) {
+	select {
+	case s.calls <- struct{}{}:
+	default:
+	}
+	if s.runs.Add(1) == 1 {
+		return 0, errors.New("database is down")
+	}
+	return 1, nil
+}
+
+func TestApprovalExpiryJob_RunsUntilCancelled(t *testing.T) {
+	store := &approvalJobStub{calls: make(chan struct{}, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewApprovalExpiryJob(store, time.Millisecond).WithLogger(discardLogger()).Run(ctx)
+		close(done)
+	}()
+
+	// A failed sweep does not stop the job
+	for range 3 {
+		select {
+		case <-store.calls:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the job did not run")
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job did not stop")
+	}
+}
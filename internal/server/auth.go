@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/koki1610168/go-payment-ledger/internal/config"
+)
+
+// AnyClient in Principal.ClientIDs grants access to every client
+const AnyClient = "*"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// ID is stable for the caller, e.g. "cert:payments-gateway"
+	ID string
+	// ClientIDs lists the clients the caller may act on
+	ClientIDs []string
+	// Admin may change risk settings, such as velocity limits
+	Admin bool
+}
+
+func (p *Principal) CanAccess(clientID string) bool {
+	return slices.Contains(p.ClientIDs, AnyClient) || slices.Contains(p.ClientIDs, clientID)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// authorizeClient writes 403 and returns false when the request was
+// authenticated as a principal that may not act on clientID.
+// Unauthenticated requests are left to the transport configuration.
+func authorizeClient(w http.ResponseWriter, r *http.Request, clientID string) bool {
+	p, ok := PrincipalFromContext(r.Context())
+	if !ok || p.CanAccess(clientID) {
+		return true
+	}
+	writeProblem(w, r, http.StatusForbidden, CodeForbidden, "forbidden")
+	return false
+}
+
+// authorizeAdmin writes 401 or 403 and returns false unless the request
+// was authenticated as an admin. Unlike authorizeClient it never lets an
+// unauthenticated request through, even when no keys are configured.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	p, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ledger"`)
+		writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "an admin API key is required")
+		return false
+	}
+	if !p.Admin {
+		writeProblem(w, r, http.StatusForbidden, CodeForbidden, "forbidden")
+		return false
+	}
+	return true
+}
+
+// APIKeys authenticates requests carrying an API key
+type APIKeys struct {
+	byHash map[[sha256.Size]byte]*Principal
+}
+
+func NewAPIKeys(keys []config.APIKey) (*APIKeys, error) {
+	k := &APIKeys{byHash: make(map[[sha256.Size]byte]*Principal, len(keys))}
+	for _, key := range keys {
+		raw, err := hex.DecodeString(key.KeySHA256)
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("api key %s: key_sha256 is not a SHA-256 digest", key.Name)
+		}
+		var sum [sha256.Size]byte
+		copy(sum[:], raw)
+		k.byHash[sum] = &Principal{ID: "key:" + key.Name, ClientIDs: key.ClientIDs, Admin: key.Admin}
+	}
+	return k, nil
+}
+
+// Middleware reads the key from "Authorization: Bearer <key>" or
+// X-API-Key. A request without a key passes through unchanged; a request
+// with an unknown key gets 401.
+func (k *APIKeys) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			key = bearerToken(r.Header.Get("Authorization"))
+		}
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, ok := k.lookup(key)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ledger"`)
+			writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "invalid api key")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+func (k *APIKeys) lookup(key string) (*Principal, bool) {
+	p, ok := k.byHash[sha256.Sum256([]byte(key))]
+	return p, ok
+}
+
+func bearerToken(auth string) string {
+	scheme, token, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/koki1610168/go-payment-ledger/internal/config"
+)
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAPIKeys_Middleware(t *testing.T) {
+	keys, err := NewAPIKeys([]config.APIKey{
+		{Name: "portal", KeySHA256: hashKey("s3cret"), ClientIDs: []string{"client_001"}},
+	})
+	if err != nil {
+		t.Fatalf("new api keys: %v", err)
+	}
+
+	var got *Principal
+	handler := keys.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = PrincipalFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		wantStatus int
+		wantID     string
+	}{
+		{"bearer", "Authorization", "Bearer s3cret", http.StatusOK, "key:portal"},
+		{"x-api-key", "X-API-Key", "s3cret", http.StatusOK, "key:portal"},
+		{"unknown key", "X-API-Key", "wrong", http.StatusUnauthorized, ""},
+		{"no key", "", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodGet, "/clients/client_001/balance", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", res.Code, tt.wantStatus)
+			}
+			id := ""
+			if got != nil {
+				id = got.ID
+			}
+			if id != tt.wantID {
+				t.Errorf("got principal %q, want %q", id, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestNewAPIKeys_RejectsBadDigest(t *testing.T) {
+	if _, err := NewAPIKeys([]config.APIKey{{Name: "bad", KeySHA256: "abc"}}); err == nil {
+		t.Error("expected an error for a short digest")
+	}
+}
@@ -0,0 +1,41 @@
+package server_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+	"github.com/koki1610168/go-payment-ledger/internal/storetest"
+)
+
+// migratedDB connects to DATABASE_URL and migrates it, skipping the test
+// when it is not set
+func migratedDB(t *testing.T) *server.DB {
+	t.Helper()
+	if os.Getenv("DATABASE_URL") == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	db, err := server.NewDB(ctx)
+	if err != nil {
+		t.Fatalf("connect db: %v", err)
+	}
+	t.Cleanup(db.Close)
+	if err := server.Migrate(ctx, db.Pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func TestStore_Conformance(t *testing.T) {
+	store := server.NewStore(migratedDB(t).Pool)
+	storetest.Run(t, func(t *testing.T) storetest.Store { return store })
+}
+
+func TestStore_ConformanceApprovals(t *testing.T) {
+	db := migratedDB(t)
+	storetest.RunApprovals(t, func(t *testing.T, policy server.ApprovalPolicy) storetest.Store {
+		return server.NewStore(db.Pool).WithApprovals(policy)
+	})
+}
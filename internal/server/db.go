@@ -5,8 +5,9 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"time"
 	"github.com/jackc/pgx/v5/pgxpool"	
+
+	"github.com/koki1610168/go-payment-ledger/internal/config"
 )
 
 // I should not use the global db
@@ -17,29 +18,36 @@ type DB struct {
 }
 
 // Connecting to the existing database specified by DATABASE_URL
-// Using pgxpool.Pool for concurrent safe connections
+// using the default pool settings
 func NewDB(ctx context.Context) (*DB, error) {
-	dsn := os.Getenv("DATABASE_URL")
-	if dsn == "" {
+	cfg := config.Default().Database
+	cfg.URL = os.Getenv("DATABASE_URL")
+	return OpenDB(ctx, cfg)
+}
+
+// Using pgxpool.Pool for concurrent safe connections
+func OpenDB(ctx context.Context, dbCfg config.Database) (*DB, error) {
+	if dbCfg.URL == "" {
 		return nil, fmt.Errorf("DATABASE_URL is not set")
 	}
 
-	cfg, err := pgxpool.ParseConfig(dsn)
+	cfg, err := pgxpool.ParseConfig(dbCfg.URL)
 	if err != nil {
 		return nil, fmt.Errorf("parse DATABASE_URL: %w", err)
 	}
 
-	cfg.MaxConns = 10
-	cfg.MinConns = 1
-	cfg.MaxConnIdleTime = 5 * time.Second
-	cfg.MaxConnLifetime = 30 * time.Second
+	cfg.MaxConns = dbCfg.MaxConns
+	cfg.MinConns = dbCfg.MinConns
+	cfg.MaxConnIdleTime = dbCfg.MaxConnIdleTime
+	cfg.MaxConnLifetime = dbCfg.MaxConnLifetime
+	cfg.ConnConfig.Tracer = queryTracer{}
 
 	pool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("create pgx pool: %w", err)
 	}
 
-	pingCtx, cancel := context.WithTimeout(ctx, 3 * time.Second)
+	pingCtx, cancel := context.WithTimeout(ctx, dbCfg.ConnectTimeout)
 	defer cancel()
 	if err := pool.Ping(pingCtx); err != nil {
 		pool.Close()
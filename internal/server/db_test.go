@@ -6,6 +6,7 @@ import (
 )
 
 func TestDBConnection(t *testing.T) {
+	requireDatabase(t)
 	ctx := context.Background()
 	db, err := NewDB(ctx)
 	if err != nil {
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// MaxBodyBytes is the largest request body accepted. Larger bodies are
+// answered 413 without being read to the end.
+const MaxBodyBytes = 64 << 10
+
+// MaxAmount bounds the amount of a single request, in minor units. It
+// leaves room to add many of them to a balance without overflowing int64.
+const MaxAmount int64 = 1_000_000_000_000_000
+
+var idempotencyKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,128}$`)
+
+// FieldError is one invalid field of a request body
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// ValidationError lists every invalid field of a request, so callers can
+// fix them all at once. It is answered 400 with the fields in
+// Problem.Errors.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Detail
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validator collects field errors. Its zero value is ready to use.
+type validator struct {
+	errs ValidationError
+}
+
+func (v *validator) check(ok bool, field, format string, args ...any) {
+	if !ok {
+		v.errs = append(v.errs, FieldError{Field: field, Detail: fmt.Sprintf(format, args...)})
+	}
+}
+
+func (v *validator) clientID(field, id string) {
+	if id == "" {
+		v.check(false, field, "is required")
+		return
+	}
+	v.check(clientIDPattern.MatchString(id), field, "must be 1 to 64 letters, digits, '_', '.' or '-'")
+	v.check(!strings.HasPrefix(id, SystemAccountPrefix), field, "is reserved for system accounts")
+}
+
+func (v *validator) idempotencyKey(field, key string) {
+	if key == "" {
+		v.check(false, field, "is required")
+		return
+	}
+	v.check(idempotencyKeyPattern.MatchString(key), field, "must be 1 to 128 letters, digits, '_', '.', ':' or '-'")
+}
+
+func (v *validator) currency(field, currency string) {
+	if currency == "" {
+		v.check(false, field, "is required")
+		return
+	}
+	v.check(currencyPattern.MatchString(currency), field, "must be a three-letter ISO 4217 code")
+}
+
+// amount checks that amount is within ±MaxAmount and not zero. With
+// positive set it must also be above zero.
+func (v *validator) amount(field string, amount int64, positive bool) {
+	switch {
+	case positive && amount <= 0:
+		v.check(false, field, "must be positive")
+	case amount == 0:
+		v.check(false, field, "must not be zero")
+	default:
+		v.check(amount <= MaxAmount && amount >= -MaxAmount, field, "must be at most %d in absolute value", MaxAmount)
+	}
+}
+
+// feeAmount checks an amount of a fee schedule, which may be zero
+func (v *validator) feeAmount(field string, amount int64) {
+	v.check(amount >= 0 && amount <= MaxAmount, field, "must be between 0 and %d", MaxAmount)
+}
+
+// feeGroup checks the name of a fee group, which follows the rules of
+// client IDs
+func (v *validator) feeGroup(field, group string) {
+	v.check(clientIDPattern.MatchString(group), field, "must be 1 to 64 letters, digits, '_', '.' or '-'")
+}
+
+// err returns the collected errors, or nil when there are none
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// decodeBody decodes the JSON body of r into dst. The body must be sent
+// as application/json, fit in MaxBodyBytes, hold exactly one value and
+// name no fields dst does not have. On failure it answers with a problem
+// and returns false.
+func decodeBody(w http.ResponseWriter, r *http.Request, dst any) bool {
+	if !isJSON(r) {
+		writeProblem(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType,
+			"request body must be sent as application/json")
+		return false
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err == nil {
+		// Anything after the value, even a second value, is rejected
+		if _, err = dec.Token(); err == io.EOF {
+			return true
+		} else if err == nil {
+			err = errors.New("unexpected data after the JSON value")
+		}
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, CodeRequestTooLarge,
+			fmt.Sprintf("request body must be at most %d bytes", tooLarge.Limit))
+		return false
+	}
+	writeHTTPError(w, r, decodeError(err))
+	return false
+}
+
+// decodeError describes why a body could not be decoded, naming the field
+// when there is one
+func decodeError(err error) *HTTPError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return &HTTPError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: "request body is required"}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &HTTPError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: "request body is not valid JSON: unexpected end"}
+	case errors.As(err, &syntaxErr):
+		return &HTTPError{Status: http.StatusBadRequest, Code: CodeInvalidRequest,
+			Detail: fmt.Sprintf("request body is not valid JSON at offset %d", syntaxErr.Offset)}
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return ValidationError{{Field: typeErr.Field, Detail: "must be " + jsonType(typeErr.Type.Kind())}}.httpError()
+	}
+	// encoding/json reports unknown fields only by message
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return ValidationError{{Field: strings.Trim(field, `"`), Detail: "is not a known field"}}.httpError()
+	}
+	return &HTTPError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: "request body is not valid: " + err.Error()}
+}
+
+// jsonType names a Go kind the way a JSON client knows it
+func jsonType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
+
+// writeInvalid answers 400 for a request that failed validation, listing
+// the fields when err is a ValidationError
+func writeInvalid(w http.ResponseWriter, r *http.Request, err error) {
+	var invalid ValidationError
+	if errors.As(err, &invalid) {
+		writeHTTPError(w, r, invalid.httpError())
+		return
+	}
+	writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+}
+
+func (e ValidationError) httpError() *HTTPError {
+	return &HTTPError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: e.Error(), Errors: e}
+}
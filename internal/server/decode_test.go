@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler_StrictDecoding(t *testing.T) {
+	transfer := `{"from_client_id":"client_001","to_client_id":"client_002","amount":100,"idempotencyKey":"k-1"}`
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantCode    string
+		wantFields  []string
+	}{
+		{"valid", "/v1/transfer", "application/json", transfer, http.StatusOK, "", nil},
+		{"charset parameter", "/v1/transfer", "application/json; charset=utf-8", transfer, http.StatusOK, "", nil},
+		{"no content type", "/v1/transfer", "", transfer, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, nil},
+		{"form content type", "/v1/transfer", "application/x-www-form-urlencoded", transfer,
+			http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, nil},
+		{"too large", "/v1/transfer", "application/json",
+			`{"from_client_id":"` + strings.Repeat("a", MaxBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, nil},
+		{"empty", "/v1/transfer", "application/json", "", http.StatusBadRequest, CodeInvalidRequest, nil},
+		{"truncated", "/v1/transfer", "application/json", `{"amount":`, http.StatusBadRequest, CodeInvalidRequest, nil},
+		{"trailing garbage", "/v1/transfer", "application/json", transfer + "x", http.StatusBadRequest, CodeInvalidRequest, nil},
+		{"two values", "/v1/transfer", "application/json", transfer + transfer, http.StatusBadRequest, CodeInvalidRequest, nil},
+		{"unknown field", "/v1/transfer", "application/json",
+			`{"from_client_id":"client_001","to_client_id":"client_002","amount":100,"idempotencyKey":"k-1","memo":"x"}`,
+			http.StatusBadRequest, CodeInvalidRequest, []string{"memo"}},
+		{"wrong type", "/v1/transfer", "application/json",
+			`{"from_client_id":"client_001","to_client_id":"client_002","amount":"100","idempotencyKey":"k-1"}`,
+			http.StatusBadRequest, CodeInvalidRequest, []string{"amount"}},
+		{"every field invalid", "/v1/transfer", "application/json",
+			`{"from_client_id":"client 001","to_client_id":"","amount":0,"idempotencyKey":"` + strings.Repeat("k", 129) + `"}`,
+			http.StatusBadRequest, CodeInvalidRequest, []string{"from_client_id", "to_client_id", "amount", "idempotencyKey"}},
+		{"self transfer", "/v1/transfer", "application/json",
+			`{"from_client_id":"client_001","to_client_id":"client_001","amount":100,"idempotencyKey":"k-1"}`,
+			http.StatusBadRequest, CodeInvalidRequest, []string{"to_client_id"}},
+		{"transfer above maximum", "/v1/transfer", "application/json",
+			`{"from_client_id":"client_001","to_client_id":"client_002","amount":1000000000000001,"idempotencyKey":"k-1"}`,
+			http.StatusBadRequest, CodeInvalidRequest, []string{"amount"}},
+		{"zero payment", "/v1/payments", "application/json",
+			`{"clientID":"client_001","amount":0,"currency":"JPY","idempotencyKey":"k-1"}`,
+			http.StatusBadRequest, CodeInvalidRequest, []string{"amount"}},
+		{"payment below minimum", "/v1/payments", "application/json",
+			`{"clientID":"client_001","amount":-1000000000000001,"currency":"JPY","idempotencyKey":"k-1"}`,
+			http.StatusBadRequest, CodeInvalidRequest, []string{"amount"}},
+		{"payment bad currency and key", "/v1/payments", "application/json",
+			`{"clientID":"client_001","amount":100,"currency":"jpy","idempotencyKey":"a b"}`,
+			http.StatusBadRequest, CodeInvalidRequest, []string{"currency", "idempotencyKey"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStubClient()
+			store.SeedClient("client_001", 1000, "JPY")
+			store.SeedClient("client_002", 0, "JPY")
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			res := httptest.NewRecorder()
+			NewHandler(store).ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("got %d (%s), want %d", res.Code, res.Body, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK {
+				return
+			}
+			p := decodeJSON[Problem](t, res)
+			if p.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", p.Code, tt.wantCode)
+			}
+			var fields []string
+			for _, fe := range p.Errors {
+				fields = append(fields, fe.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("got invalid fields %v, want %v", fields, tt.wantFields)
+			}
+			// Nothing may be posted for a rejected request
+			if b := store.balances["client_001"]; b != 1000 {
+				t.Errorf("client_001 balance changed to %d", b)
+			}
+		})
+	}
+}
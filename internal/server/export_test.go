@@ -0,0 +1,8 @@
+package server
+
+// Helpers for the handler tests in package server_test, which run against
+// memstore and so cannot be in package server
+var (
+	OpenAPIRouter   = openAPIRouter
+	ServeConforming = serveConforming
+)
@@ -0,0 +1,583 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+)
+
+// Operations a fee schedule applies to. They match the operation names
+// of velocity checks and metrics.
+const (
+	FeePayment  = "payment"
+	FeeTransfer = "transfer"
+)
+
+// Fee schedule kinds
+const (
+	// FeeFixed charges Fixed whatever the amount
+	FeeFixed = "fixed"
+	// FeePercentage charges BasisPoints of the amount, plus Fixed
+	FeePercentage = "percentage"
+	// FeeTiered charges like FeePercentage with the Fixed and
+	// BasisPoints of the first tier the amount fits in
+	FeeTiered = "tiered"
+)
+
+// SystemAccountPrefix starts the IDs of accounts the ledger keeps for
+// itself, such as the fee revenue account of each currency. Clients
+// cannot be created under it. Stores create these house accounts on
+// first use, and lock one only after the client rows of the same
+// transaction, so two transactions never wait on each other in opposite
+// order.
+const SystemAccountPrefix = "system."
+
+// FeeRevenueAccount is the house account that collects the fees charged
+// in currency
+func FeeRevenueAccount(currency string) string {
+	return SystemAccountPrefix + "fee_revenue." + currency
+}
+
+// Most tiers a schedule may have
+const maxFeeTiers = 20
+
+var ErrFeeScheduleNotFound = errors.New("fee schedule not found")
+var ErrFeeScheduleExists = errors.New("a fee schedule for this operation already exists")
+
+// FeeSchedule prices an operation for one client, or for every client of
+// a fee group. A client's own schedule takes precedence over its group's.
+//
+// The fee is computed on the absolute amount and rounded to the nearest
+// minor unit, then raised to Min and capped at Max when they are set.
+type FeeSchedule struct {
+	ID string `json:"id"`
+	// Exactly one of ClientID and Group is set
+	ClientID    string    `json:"client_id,omitempty"`
+	Group       string    `json:"group,omitempty"`
+	Operation   string    `json:"operation"`
+	Kind        string    `json:"kind"`
+	Fixed       int64     `json:"fixed"`
+	BasisPoints int64     `json:"basis_points"`
+	Tiers       []FeeTier `json:"tiers,omitempty"`
+	Min         int64     `json:"min"`
+	Max         int64     `json:"max"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// FeeTier prices the amounts up to UpTo, and above the previous tier's.
+// The last tier has no UpTo and takes every larger amount.
+type FeeTier struct {
+	UpTo        int64 `json:"up_to,omitempty"`
+	Fixed       int64 `json:"fixed"`
+	BasisPoints int64 `json:"basis_points"`
+}
+
+// Validate reports every problem with f before it is stored
+func (f FeeSchedule) Validate() error {
+	var v validator
+	switch {
+	case f.ClientID == "" && f.Group == "":
+		v.check(false, "client_id", "is required unless group is set")
+	case f.ClientID != "" && f.Group != "":
+		v.check(false, "group", "must not be set together with client_id")
+	case f.ClientID != "":
+		v.clientID("client_id", f.ClientID)
+	default:
+		v.feeGroup("group", f.Group)
+	}
+	v.check(f.Operation == FeePayment || f.Operation == FeeTransfer, "operation", "must be payment or transfer")
+
+	switch f.Kind {
+	case FeeFixed:
+		v.check(f.Fixed > 0, "fixed", "must be positive")
+		v.check(f.BasisPoints == 0, "basis_points", "must not be set for a fixed fee")
+	case FeePercentage:
+		v.check(f.BasisPoints > 0, "basis_points", "must be positive")
+	case FeeTiered:
+		v.check(f.Fixed == 0, "fixed", "must be set on the tiers of a tiered fee")
+		v.check(f.BasisPoints == 0, "basis_points", "must be set on the tiers of a tiered fee")
+	default:
+		v.check(false, "kind", "must be fixed, percentage or tiered")
+	}
+	if f.Kind != FeeTiered {
+		v.check(len(f.Tiers) == 0, "tiers", "are only allowed for a tiered fee")
+	}
+	v.feeAmount("fixed", f.Fixed)
+	v.check(f.BasisPoints >= 0 && f.BasisPoints <= 10000, "basis_points", "must be between 0 and 10000")
+
+	if f.Kind == FeeTiered {
+		v.check(len(f.Tiers) > 0 && len(f.Tiers) <= maxFeeTiers, "tiers", "must have 1 to %d tiers", maxFeeTiers)
+		var prev int64
+		for i, t := range f.Tiers {
+			field := "tiers[" + strconv.Itoa(i) + "]"
+			if i == len(f.Tiers)-1 {
+				v.check(t.UpTo == 0, field+".up_to", "must not be set on the last tier")
+			} else {
+				v.check(t.UpTo > prev, field+".up_to", "must be above the previous tier's")
+				prev = t.UpTo
+			}
+			v.feeAmount(field+".fixed", t.Fixed)
+			v.check(t.BasisPoints >= 0 && t.BasisPoints <= 10000, field+".basis_points", "must be between 0 and 10000")
+		}
+	}
+
+	v.feeAmount("min", f.Min)
+	v.feeAmount("max", f.Max)
+	v.check(f.Max == 0 || f.Max >= f.Min, "max", "must not be below min")
+	return v.err()
+}
+
+// Fee is what f charges for amount, in the same minor units
+func (f FeeSchedule) Fee(amount int64) (int64, error) {
+	base := money.New(amount, "")
+	if amount < 0 {
+		var err error
+		if base, err = base.Neg(); err != nil {
+			return 0, err
+		}
+	}
+
+	fixed, bps := f.Fixed, f.BasisPoints
+	if f.Kind == FeeTiered {
+		for _, t := range f.Tiers {
+			fixed, bps = t.Fixed, t.BasisPoints
+			if t.UpTo != 0 && base.Amount <= t.UpTo {
+				break
+			}
+		}
+	}
+
+	fee, err := base.Mul(bps, 10000)
+	if err != nil {
+		return 0, err
+	}
+	if fee, err = fee.Add(money.New(fixed, "")); err != nil {
+		return 0, err
+	}
+	if fee.Amount < f.Min {
+		fee.Amount = f.Min
+	}
+	if f.Max > 0 && fee.Amount > f.Max {
+		fee.Amount = f.Max
+	}
+	return fee.Amount, nil
+}
+
+// FeeQuote is the fee an operation would be charged. Payments move
+// Amount - Fee onto the client's balance; transfers take Amount + Fee
+// from the sender.
+type FeeQuote struct {
+	ClientID  string `json:"client_id"`
+	Operation string `json:"operation"`
+	Amount    int64  `json:"amount"`
+	Fee       int64  `json:"fee"`
+	Currency  string `json:"currency"`
+	// ScheduleID is the schedule the fee comes from, empty when no
+	// schedule applies and the fee is zero
+	ScheduleID string `json:"schedule_id,omitempty"`
+}
+
+// NewFeeQuote prices amount with schedule, which is nil when the client
+// has none for the operation. System accounts are never charged.
+func NewFeeQuote(clientID, operation string, amount int64, currency string, schedule *FeeSchedule) (FeeQuote, error) {
+	q := FeeQuote{ClientID: clientID, Operation: operation, Amount: amount, Currency: currency}
+	if schedule == nil || strings.HasPrefix(clientID, SystemAccountPrefix) {
+		return q, nil
+	}
+	fee, err := schedule.Fee(amount)
+	if err != nil {
+		return FeeQuote{}, err
+	}
+	q.Fee, q.ScheduleID = fee, schedule.ID
+	return q, nil
+}
+
+// FeeStore is implemented by stores that charge fees. Their
+// CreatePayment and Transfer charge the fee of the paying client in the
+// same transaction: it is debited from the client and credited to
+// FeeRevenueAccount as two entries of their own, linked to the entry of
+// the operation.
+type FeeStore interface {
+	ListFeeSchedules(ctx context.Context) ([]FeeSchedule, error)
+	CreateFeeSchedule(ctx context.Context, schedule FeeSchedule) (FeeSchedule, error)
+	DeleteFeeSchedule(ctx context.Context, scheduleID string) error
+	// SetFeeGroup puts the client in a fee group, or in none when group
+	// is empty
+	SetFeeGroup(ctx context.Context, clientID string, group string) error
+	QuoteFee(ctx context.Context, clientID string, operation string, amount int64) (FeeQuote, error)
+}
+
+const feeScheduleColumns = `schedule_id::text, COALESCE(client_id, ''), COALESCE(fee_group, ''), operation, kind,
+	fixed, basis_points, tiers, min_fee, max_fee, created_at`
+
+func scanFeeSchedule(row pgx.Row) (FeeSchedule, error) {
+	var f FeeSchedule
+	var tiers string
+	err := row.Scan(&f.ID, &f.ClientID, &f.Group, &f.Operation, &f.Kind,
+		&f.Fixed, &f.BasisPoints, &tiers, &f.Min, &f.Max, &f.CreatedAt)
+	if err != nil {
+		return FeeSchedule{}, err
+	}
+	return f, json.Unmarshal([]byte(tiers), &f.Tiers)
+}
+
+func (s *Store) ListFeeSchedules(ctx context.Context) (_ []FeeSchedule, err error) {
+	ctx, span := startSpan(ctx, "Store.ListFeeSchedules")
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.Query(ctx,
+		`SELECT `+feeScheduleColumns+` FROM fee_schedules ORDER BY created_at, schedule_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []FeeSchedule{}
+	for rows.Next() {
+		f, err := scanFeeSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, f)
+	}
+	return schedules, rows.Err()
+}
+
+func (s *Store) CreateFeeSchedule(ctx context.Context, schedule FeeSchedule) (_ FeeSchedule, err error) {
+	ctx, span := startSpan(ctx, "Store.CreateFeeSchedule",
+		attribute.String("client.id", schedule.ClientID))
+	defer func() { endSpan(span, err) }()
+
+	if err := schedule.Validate(); err != nil {
+		return FeeSchedule{}, err
+	}
+	tiers, err := json.Marshal(schedule.Tiers)
+	if err != nil {
+		return FeeSchedule{}, err
+	}
+
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO fee_schedules (client_id, fee_group, operation, kind, fixed, basis_points, tiers, min_fee, max_fee)
+		VALUES (NULLIF($1, ''), NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9)
+		RETURNING schedule_id::text, created_at`,
+		schedule.ClientID, schedule.Group, schedule.Operation, schedule.Kind,
+		schedule.Fixed, schedule.BasisPoints, string(tiers), schedule.Min, schedule.Max,
+	).Scan(&schedule.ID, &schedule.CreatedAt)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23503": // foreign_key_violation
+			return FeeSchedule{}, ErrClientNotFound
+		case "23505": // unique_violation
+			return FeeSchedule{}, ErrFeeScheduleExists
+		}
+	}
+	if err != nil {
+		return FeeSchedule{}, err
+	}
+	return schedule, nil
+}
+
+func (s *Store) DeleteFeeSchedule(ctx context.Context, scheduleID string) (err error) {
+	ctx, span := startSpan(ctx, "Store.DeleteFeeSchedule")
+	defer func() { endSpan(span, err) }()
+
+	id, err := uuid.Parse(scheduleID)
+	if err != nil {
+		return ErrFeeScheduleNotFound
+	}
+	tag, err := s.db.Exec(ctx, `DELETE FROM fee_schedules WHERE schedule_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrFeeScheduleNotFound
+	}
+	return nil
+}
+
+func (s *Store) SetFeeGroup(ctx context.Context, clientID string, group string) (err error) {
+	ctx, span := startSpan(ctx, "Store.SetFeeGroup",
+		attribute.String("client.id", clientID))
+	defer func() { endSpan(span, err) }()
+
+	tag, err := s.db.Exec(ctx,
+		`UPDATE clients SET fee_group = NULLIF($1, '') WHERE client_id = $2`, group, clientID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
+
+func (s *Store) QuoteFee(ctx context.Context, clientID string, operation string, amount int64) (_ FeeQuote, err error) {
+	ctx, span := startSpan(ctx, "Store.QuoteFee",
+		attribute.String("client.id", clientID))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return FeeQuote{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var currency string
+	err = tx.QueryRow(ctx, `SELECT currency FROM clients WHERE client_id = $1`, clientID).Scan(&currency)
+	if err == pgx.ErrNoRows {
+		return FeeQuote{}, ErrClientNotFound
+	}
+	if err != nil {
+		return FeeQuote{}, err
+	}
+	return s.quoteFee(ctx, tx, clientID, operation, amount, currency)
+}
+
+// quoteFee prices an operation with the client's own schedule, or its
+// group's when it has none
+func (s *Store) quoteFee(ctx context.Context, tx pgx.Tx, clientID, operation string, amount int64, currency string) (FeeQuote, error) {
+	schedule, err := scanFeeSchedule(tx.QueryRow(ctx,
+		`SELECT `+feeScheduleColumns+`
+		FROM fee_schedules
+		WHERE operation = $2
+			AND (client_id = $1 OR fee_group = (SELECT fee_group FROM clients WHERE client_id = $1))
+		ORDER BY client_id NULLS LAST
+		LIMIT 1`, clientID, operation))
+	if err == pgx.ErrNoRows {
+		return NewFeeQuote(clientID, operation, amount, currency, nil)
+	}
+	if err != nil {
+		return FeeQuote{}, err
+	}
+	return NewFeeQuote(clientID, operation, amount, currency, &schedule)
+}
+
+// postFee credits q.Fee to the fee revenue account and records the debit
+// of the client, whose balance the caller has already lowered by it. Both
+// entries are linked to entryID, the entry of the operation charged for.
+func (s *Store) postFee(ctx context.Context, tx pgx.Tx, q FeeQuote, entryID string) error {
+	account := FeeRevenueAccount(q.Currency)
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO clients (client_id, balance, currency) VALUES ($1, 0, $2)
+		ON CONFLICT (client_id) DO NOTHING`, account, q.Currency); err != nil {
+		return err
+	}
+	var revenue money.Money
+	err := tx.QueryRow(ctx,
+		`SELECT balance, currency FROM clients WHERE client_id = $1 FOR UPDATE`,
+		account).Scan(&revenue.Amount, &revenue.Currency)
+	if err != nil {
+		return err
+	}
+	if revenue, err = revenue.Add(money.New(q.Fee, q.Currency)); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx,
+		`UPDATE clients SET balance = $1 WHERE client_id = $2`, revenue.Amount, account); err != nil {
+		return err
+	}
+
+	var debitID, creditID string
+	err = tx.QueryRow(ctx,
+		`INSERT INTO ledger_entries (entry_id, client_id, amount) VALUES (gen_random_uuid(), $1, $2)
+		RETURNING entry_id::text`, q.ClientID, -q.Fee).Scan(&debitID)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO ledger_entries (entry_id, client_id, amount) VALUES (gen_random_uuid(), $1, $2)
+		RETURNING entry_id::text`, account, q.Fee).Scan(&creditID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO fee_charges (entry_id, debit_entry_id, credit_entry_id, schedule_id, client_id, operation, amount)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		entryID, debitID, creditID, q.ScheduleID, q.ClientID, q.Operation, q.Fee)
+	return err
+}
+
+// FeeScheduleRequest is the body of POST /fee-schedules
+type FeeScheduleRequest struct {
+	ClientID    string    `json:"client_id"`
+	Group       string    `json:"group"`
+	Operation   string    `json:"operation"`
+	Kind        string    `json:"kind"`
+	Fixed       int64     `json:"fixed"`
+	BasisPoints int64     `json:"basis_points"`
+	Tiers       []FeeTier `json:"tiers"`
+	Min         int64     `json:"min"`
+	Max         int64     `json:"max"`
+}
+
+// FeeSchedulesResponse lists every fee schedule
+type FeeSchedulesResponse struct {
+	Schedules []FeeSchedule `json:"schedules"`
+}
+
+// FeeGroupRequest is the body of PUT /clients/{id}/fee-group
+type FeeGroupRequest struct {
+	Group string `json:"group"`
+}
+
+func (h *Handler) feeStore(w http.ResponseWriter, r *http.Request) (FeeStore, bool) {
+	store, ok := h.store.(FeeStore)
+	if !ok {
+		writeProblem(w, r, http.StatusNotImplemented, CodeNotImplemented, "fees are not supported by this store")
+	}
+	return store, ok
+}
+
+// listFeeSchedules serves GET /fee-schedules
+func (h *Handler) listFeeSchedules(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	store, ok := h.feeStore(w, r)
+	if !ok {
+		return
+	}
+	schedules, err := store.ListFeeSchedules(r.Context())
+	if err != nil {
+		h.writeError(w, r, "list fee schedules failed", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, FeeSchedulesResponse{Schedules: schedules})
+}
+
+// createFeeSchedule serves POST /fee-schedules
+func (h *Handler) createFeeSchedule(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	store, ok := h.feeStore(w, r)
+	if !ok {
+		return
+	}
+	var req FeeScheduleRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	schedule := FeeSchedule{
+		ClientID:    req.ClientID,
+		Group:       req.Group,
+		Operation:   req.Operation,
+		Kind:        req.Kind,
+		Fixed:       req.Fixed,
+		BasisPoints: req.BasisPoints,
+		Tiers:       req.Tiers,
+		Min:         req.Min,
+		Max:         req.Max,
+	}
+	if err := schedule.Validate(); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	created, err := store.CreateFeeSchedule(r.Context(), schedule)
+	if err != nil {
+		h.writeError(w, r, "create fee schedule failed", err, "client_id", req.ClientID, "group", req.Group)
+		return
+	}
+	h.logger.InfoContext(r.Context(), "fee schedule created",
+		"schedule_id", created.ID, "client_id", created.ClientID, "group", created.Group,
+		"operation", created.Operation, "kind", created.Kind, "actor", Actor(r.Context()))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// deleteFeeSchedule serves DELETE /fee-schedules/{schedule_id}
+func (h *Handler) deleteFeeSchedule(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	store, ok := h.feeStore(w, r)
+	if !ok {
+		return
+	}
+	scheduleID := r.PathValue("schedule_id")
+	if err := store.DeleteFeeSchedule(r.Context(), scheduleID); err != nil {
+		h.writeError(w, r, "delete fee schedule failed", err, "schedule_id", scheduleID)
+		return
+	}
+	h.logger.InfoContext(r.Context(), "fee schedule deleted",
+		"schedule_id", scheduleID, "actor", Actor(r.Context()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setFeeGroup serves PUT /clients/{id}/fee-group
+func (h *Handler) setFeeGroup(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	store, ok := h.feeStore(w, r)
+	if !ok {
+		return
+	}
+	clientID := r.PathValue("id")
+	var req FeeGroupRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Group != "" {
+		var v validator
+		v.feeGroup("group", req.Group)
+		if err := v.err(); err != nil {
+			writeInvalid(w, r, err)
+			return
+		}
+	}
+	if err := store.SetFeeGroup(r.Context(), clientID, req.Group); err != nil {
+		h.writeError(w, r, "set fee group failed", err, "client_id", clientID)
+		return
+	}
+	h.logger.InfoContext(r.Context(), "fee group set",
+		"client_id", clientID, "group", req.Group, "actor", Actor(r.Context()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// quoteFee serves GET /clients/{id}/fee-quote?operation=transfer&amount=1000.
+// Clients may quote their own fees.
+func (h *Handler) quoteFee(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.feeStore(w, r)
+	if !ok {
+		return
+	}
+	clientID := r.PathValue("id")
+	if !authorizeClient(w, r, clientID) {
+		return
+	}
+
+	query := r.URL.Query()
+	operation := query.Get("operation")
+	var v validator
+	v.check(operation == FeePayment || operation == FeeTransfer, "operation", "must be payment or transfer")
+	amount, err := strconv.ParseInt(query.Get("amount"), 10, 64)
+	if err != nil {
+		v.check(false, "amount", "must be an integer")
+	} else {
+		v.amount("amount", amount, operation == FeeTransfer)
+	}
+	if err := v.err(); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	quote, err := store.QuoteFee(r.Context(), clientID, operation, amount)
+	if err != nil {
+		h.writeError(w, r, "quote fee failed", err, "client_id", clientID)
+		return
+	}
+	writeJSON(w, http.StatusOK, quote)
+}
@@ -0,0 +1,201 @@
+package server_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/memstore"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+func TestHandler_FeeSchedules(t *testing.T) {
+	store := memstore.New()
+	seed(t, store, "client_001", 10000)
+	handler := newHandler(store)
+	admin := &server.Principal{ID: "key:finance", ClientIDs: []string{server.AnyClient}, Admin: true}
+	owner := &server.Principal{ID: "key:portal", ClientIDs: []string{"client_001"}}
+	body := `{"client_id":"client_001","operation":"transfer","kind":"percentage","basis_points":100,"fixed":5,"max":50}`
+
+	res := serveAs(handler, owner, http.MethodPost, server.V1+"/fee-schedules", body)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("create as owner: got %d, want %d", res.Code, http.StatusForbidden)
+	}
+	res = serveAs(handler, admin, http.MethodPost, server.V1+"/fee-schedules", body)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("create as admin: got %d, want %d: %s", res.Code, http.StatusCreated, res.Body)
+	}
+	created := decodeJSON[server.FeeSchedule](t, res)
+	if created.ID == "" || created.Kind != server.FeePercentage || created.Max != 50 {
+		t.Errorf("unexpected schedule %+v", created)
+	}
+	res = serveAs(handler, admin, http.MethodPost, server.V1+"/fee-schedules", body)
+	if res.Code != http.StatusConflict {
+		t.Errorf("create again: got %d, want %d", res.Code, http.StatusConflict)
+	}
+
+	res = serveAs(handler, owner, http.MethodGet, server.V1+"/clients/client_001/fee-quote?operation=transfer&amount=1000", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("quote as owner: got %d: %s", res.Code, res.Body)
+	}
+	if q := decodeJSON[server.FeeQuote](t, res); q.Fee != 15 || q.ScheduleID != created.ID || q.Currency != "JPY" {
+		t.Errorf("unexpected quote %+v", q)
+	}
+
+	res = serveAs(handler, admin, http.MethodGet, server.V1+"/fee-schedules", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("list: got %d", res.Code)
+	}
+	if got := decodeJSON[server.FeeSchedulesResponse](t, res); len(got.Schedules) != 1 || got.Schedules[0].ID != created.ID {
+		t.Errorf("unexpected schedules %+v", got)
+	}
+
+	res = serveAs(handler, admin, http.MethodDelete, server.V1+"/fee-schedules/"+created.ID, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("delete: got %d, want %d", res.Code, http.StatusNoContent)
+	}
+	res = serveAs(handler, admin, http.MethodDelete, server.V1+"/fee-schedules/"+created.ID, "")
+	if res.Code != http.StatusNotFound || !strings.Contains(res.Body.String(), server.CodeFeeScheduleNotFound) {
+		t.Errorf("delete again: got %d (%s), want %d", res.Code, res.Body, http.StatusNotFound)
+	}
+
+	// Without its own schedule, a client pays its group's
+	res = serveAs(handler, admin, http.MethodPost, server.V1+"/fee-schedules",
+		`{"group":"gold","operation":"transfer","kind":"fixed","fixed":7}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("create group schedule: got %d: %s", res.Code, res.Body)
+	}
+	gold := decodeJSON[server.FeeSchedule](t, res)
+	res = serveAs(handler, admin, http.MethodPut, server.V1+"/clients/client_001/fee-group", `{"group":"gold"}`)
+	if res.Code != http.StatusNoContent {
+		t.Errorf("set group: got %d, want %d: %s", res.Code, http.StatusNoContent, res.Body)
+	}
+	res = serveAs(handler, owner, http.MethodGet, server.V1+"/clients/client_001/fee-quote?operation=transfer&amount=1000", "")
+	if q := decodeJSON[server.FeeQuote](t, res); q.Fee != 7 || q.ScheduleID != gold.ID {
+		t.Errorf("quote in group gold: got %+v", q)
+	}
+}
+
+func TestHandler_FeeValidation(t *testing.T) {
+	store := memstore.New()
+	seed(t, store, "client_001", 10000)
+	handler := newHandler(store)
+	admin := &server.Principal{ID: "key:finance", ClientIDs: []string{server.AnyClient}, Admin: true}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"quote without operation", http.MethodGet, "/clients/client_001/fee-quote?amount=100", "", http.StatusBadRequest},
+		{"quote without amount", http.MethodGet, "/clients/client_001/fee-quote?operation=payment", "", http.StatusBadRequest},
+		{"quote negative transfer", http.MethodGet, "/clients/client_001/fee-quote?operation=transfer&amount=-1", "", http.StatusBadRequest},
+		{"quote unknown client", http.MethodGet, "/clients/client_404/fee-quote?operation=payment&amount=1", "", http.StatusNotFound},
+		{"schedule without kind", http.MethodPost, "/fee-schedules", `{"client_id":"client_001","operation":"payment"}`, http.StatusBadRequest},
+		{"schedule unknown client", http.MethodPost, "/fee-schedules",
+			`{"client_id":"client_404","operation":"payment","kind":"fixed","fixed":1}`, http.StatusNotFound},
+		{"invalid group", http.MethodPut, "/clients/client_001/fee-group", `{"group":"no spaces"}`, http.StatusBadRequest},
+		{"group of unknown client", http.MethodPut, "/clients/client_404/fee-group", `{"group":"gold"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serveAs(handler, admin, tt.method, server.V1+tt.path, tt.body)
+			if res.Code != tt.wantStatus {
+				t.Errorf("got %d, want %d: %s", res.Code, tt.wantStatus, res.Body)
+			}
+		})
+	}
+}
+
+// Without an admin key a caller could zero its own fees or move itself
+// into a cheaper group
+func TestHandler_FeeSchedulesNeedAdminKey(t *testing.T) {
+	store := memstore.New()
+	seed(t, store, "client_001", 10000)
+	schedule, err := store.CreateFeeSchedule(t.Context(), server.FeeSchedule{ClientID: "client_001",
+		Operation: server.FeePayment, Kind: server.FeeFixed, Fixed: 10})
+	if err != nil {
+		t.Fatalf("create fee schedule: %v", err)
+	}
+	handler := newHandler(store)
+	portal := &server.Principal{ID: "key:portal", ClientIDs: []string{"client_001"}}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"list schedules", http.MethodGet, "/fee-schedules", ""},
+		{"create schedule", http.MethodPost, "/fee-schedules", `{"client_id":"client_001","operation":"payment","kind":"fixed","fixed":0}`},
+		{"delete schedule", http.MethodDelete, "/fee-schedules/" + schedule.ID, ""},
+		{"set group", http.MethodPut, "/clients/client_001/fee-group", `{"group":"gold"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := serveAs(handler, nil, tt.method, server.V1+tt.path, tt.body); res.Code != http.StatusUnauthorized {
+				t.Errorf("without a key: got %d, want %d", res.Code, http.StatusUnauthorized)
+			}
+			if res := serveAs(handler, portal, tt.method, server.V1+tt.path, tt.body); res.Code != http.StatusForbidden {
+				t.Errorf("with a client key: got %d, want %d", res.Code, http.StatusForbidden)
+			}
+		})
+	}
+	if q, err := store.QuoteFee(t.Context(), "client_001", server.FeePayment, 1000); err != nil || q.Fee != 10 {
+		t.Errorf("quote after refused changes: got %+v, %v, want a fee of 10", q, err)
+	}
+}
+
+func TestHandler_FeeRoutesAreVersionedOnly(t *testing.T) {
+	res := serveAs(newHandler(memstore.New()), nil, http.MethodGet, "/fee-schedules", "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("got %d, want %d", res.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandler_FeeResponsesConformToOpenAPI(t *testing.T) {
+	router := server.OpenAPIRouter(t)
+	store := memstore.New()
+	seed(t, store, "client_001", 10000)
+	seed(t, store, "client_002", 0)
+	schedule, err := store.CreateFeeSchedule(t.Context(), server.FeeSchedule{ClientID: "client_001",
+		Operation: server.FeeTransfer, Kind: server.FeeTiered, Tiers: []server.FeeTier{{UpTo: 1000, Fixed: 10}, {BasisPoints: 100}}})
+	if err != nil {
+		t.Fatalf("create fee schedule: %v", err)
+	}
+	handler := newHandler(store)
+	admin := &server.Principal{ID: "key:ops", ClientIDs: []string{server.AnyClient}, Admin: true}
+	portal := &server.Principal{ID: "key:portal", ClientIDs: []string{"client_001"}}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		as     *server.Principal
+		want   int
+	}{
+		{"quote fee", http.MethodGet, "/clients/client_001/fee-quote?operation=transfer&amount=5000", "", portal, http.StatusOK},
+		{"quote fee without schedule", http.MethodGet, "/clients/client_002/fee-quote?operation=payment&amount=-5000", "", nil, http.StatusOK},
+		{"quote fee bad operation", http.MethodGet, "/clients/client_001/fee-quote?operation=refund&amount=1", "", nil, http.StatusBadRequest},
+		{"list fee schedules", http.MethodGet, "/fee-schedules", "", admin, http.StatusOK},
+		{"create fee schedule", http.MethodPost, "/fee-schedules",
+			`{"group":"gold","operation":"payment","kind":"percentage","basis_points":50,"max":500}`, admin, http.StatusCreated},
+		{"create existing fee schedule", http.MethodPost, "/fee-schedules",
+			`{"group":"gold","operation":"payment","kind":"fixed","fixed":5}`, admin, http.StatusConflict},
+		{"create fee schedule not admin", http.MethodPost, "/fee-schedules",
+			`{"group":"gold","operation":"transfer","kind":"fixed","fixed":5}`, portal, http.StatusForbidden},
+		{"set fee group", http.MethodPut, "/clients/client_001/fee-group", `{"group":"gold"}`, admin, http.StatusNoContent},
+		{"delete fee schedule", http.MethodDelete, "/fee-schedules/" + schedule.ID, "", admin, http.StatusNoContent},
+		{"delete unknown fee schedule", http.MethodDelete, "/fee-schedules/" + uuid.NewString(), "", admin, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.ServeConforming(t, router, handler, request(tt.method, server.V1+tt.path, tt.body, tt.as), tt.want)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestFeeSchedule_Fee(t *testing.T) {
+	tiered := FeeSchedule{Kind: FeeTiered, Tiers: []FeeTier{
+		{UpTo: 1000, Fixed: 10},
+		{UpTo: 100000, BasisPoints: 150},
+		{Fixed: 500, BasisPoints: 50},
+	}}
+
+	tests := []struct {
+		name     string
+		schedule FeeSchedule
+		amount   int64
+		want     int64
+	}{
+		{"fixed", FeeSchedule{Kind: FeeFixed, Fixed: 30}, 123456, 30},
+		{"percentage", FeeSchedule{Kind: FeePercentage, BasisPoints: 250}, 1000, 25},
+		{"percentage rounds half away from zero", FeeSchedule{Kind: FeePercentage, BasisPoints: 250}, 1010, 25},
+		{"percentage of a withdrawal", FeeSchedule{Kind: FeePercentage, BasisPoints: 100}, -5000, 50},
+		{"percentage plus fixed", FeeSchedule{Kind: FeePercentage, BasisPoints: 100, Fixed: 20}, 5000, 70},
+		{"capped", FeeSchedule{Kind: FeePercentage, BasisPoints: 100, Max: 300}, 1000000, 300},
+		{"minimum", FeeSchedule{Kind: FeePercentage, BasisPoints: 100, Min: 50}, 100, 50},
+		{"first tier", tiered, 1000, 10},
+		{"middle tier", tiered, 1001, 15},
+		{"last tier", tiered, 1000000, 5500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.schedule.Fee(tt.amount)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("fee of %d is %d, want %d", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeeSchedule_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		schedule  FeeSchedule
+		wantField string
+	}{
+		{"valid fixed", FeeSchedule{ClientID: "client_001", Operation: FeePayment, Kind: FeeFixed, Fixed: 1}, ""},
+		{"valid tiered group", FeeSchedule{Group: "gold", Operation: FeeTransfer, Kind: FeeTiered,
+			Tiers: []FeeTier{{UpTo: 100, Fixed: 1}, {BasisPoints: 10}}}, ""},
+		{"no owner", FeeSchedule{Operation: FeePayment, Kind: FeeFixed, Fixed: 1}, "client_id"},
+		{"two owners", FeeSchedule{ClientID: "client_001", Group: "gold", Operation: FeePayment, Kind: FeeFixed, Fixed: 1}, "group"},
+		{"unknown operation", FeeSchedule{ClientID: "client_001", Operation: "refund", Kind: FeeFixed, Fixed: 1}, "operation"},
+		{"unknown kind", FeeSchedule{ClientID: "client_001", Operation: FeePayment, Kind: "flat"}, "kind"},
+		{"percentage above 100%", FeeSchedule{ClientID: "client_001", Operation: FeePayment, Kind: FeePercentage, BasisPoints: 10001}, "basis_points"},
+		{"max below min", FeeSchedule{ClientID: "client_001", Operation: FeePayment, Kind: FeePercentage, BasisPoints: 1, Min: 10, Max: 5}, "max"},
+		{"tiers on a fixed fee", FeeSchedule{ClientID: "client_001", Operation: FeePayment, Kind: FeeFixed, Fixed: 1,
+			Tiers: []FeeTier{{Fixed: 1}}}, "tiers"},
+		{"tiers out of order", FeeSchedule{ClientID: "client_001", Operation: FeePayment, Kind: FeeTiered,
+			Tiers: []FeeTier{{UpTo: 100, Fixed: 1}, {UpTo: 50, Fixed: 2}, {Fixed: 3}}}, "tiers[1].up_to"},
+		{"fixed on a tiered fee", FeeSchedule{ClientID: "client_001", Operation: FeePayment, Kind: FeeTiered, Fixed: 1,
+			Tiers: []FeeTier{{Fixed: 1}}}, "fixed"},
+		{"basis points on a tiered fee", FeeSchedule{ClientID: "client_001", Operation: FeePayment, Kind: FeeTiered, BasisPoints: 1,
+			Tiers: []FeeTier{{Fixed: 1}}}, "basis_points"},
+		{"tier above 100%", FeeSchedule{ClientID: "client_001", Operation: FeePayment, Kind: FeeTiered,
+			Tiers: []FeeTier{{UpTo: 100, Fixed: 1}, {BasisPoints: 10001}}}, "tiers[1].basis_points"},
+		{"bounded last tier", FeeSchedule{ClientID: "client_001", Operation: FeePayment, Kind: FeeTiered,
+			Tiers: []FeeTier{{UpTo: 100, Fixed: 1}}}, "tiers[0].up_to"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.schedule.Validate()
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var invalid ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("got %v, want a ValidationError", err)
+			}
+			for _, f := range invalid {
+				if f.Field == tt.wantField {
+					return
+				}
+			}
+			t.Errorf("%v does not report %s", err, tt.wantField)
+		})
+	}
+}
+
+func TestNewFeeQuote_SystemAccountsAreNotCharged(t *testing.T) {
+	schedule := &FeeSchedule{ID: "s1", Kind: FeeFixed, Fixed: 10}
+	q, err := NewFeeQuote(FeeRevenueAccount("JPY"), FeeTransfer, 100, "JPY", schedule)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q.Fee != 0 || q.ScheduleID != "" {
+		t.Errorf("system account was quoted %+v", q)
+	}
+}
+
+func TestHandler_FeesUnsupportedStore(t *testing.T) {
+	admin := &Principal{ID: "key:ops", ClientIDs: []string{AnyClient}, Admin: true}
+	res := serveAs(NewHandler(NewStubClient()), admin, http.MethodGet, V1+"/fee-schedules", "")
+	if res.Code != http.StatusNotImplemented {
+		t.Errorf("got %d, want %d", res.Code, http.StatusNotImplemented)
+	}
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	ledgerv1 "github.com/koki1610168/go-payment-ledger/api/ledger/v1"
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+)
+
+// GRPCService implements ledgerv1.LedgerServiceServer on top of the same
+// ClientStore as the HTTP handler
+type GRPCService struct {
+	ledgerv1.UnimplementedLedgerServiceServer
+	store  ClientStore
+	logger *slog.Logger
+}
+
+func NewGRPCService(store ClientStore) *GRPCService {
+	return &GRPCService{store: store, logger: slog.Default()}
+}
+
+// WithLogger sets the logger used to report failed store calls
+func (s *GRPCService) WithLogger(logger *slog.Logger) *GRPCService {
+	s.logger = logger
+	return s
+}
+
+func (s *GRPCService) GetBalance(ctx context.Context, req *ledgerv1.GetBalanceRequest) (*ledgerv1.GetBalanceResponse, error) {
+	clientID := req.GetClientId()
+	if clientID == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	if err := authorizeClientRPC(ctx, clientID); err != nil {
+		return nil, err
+	}
+
+	balance, currency, err := s.store.GetBalance(ctx, clientID)
+	if err != nil {
+		return nil, s.statusError(ctx, "get balance failed", err, "client_id", clientID)
+	}
+	return &ledgerv1.GetBalanceResponse{ClientId: clientID, Balance: balance, Currency: currency}, nil
+}
+
+func (s *GRPCService) ListLedgerEntries(req *ledgerv1.ListLedgerEntriesRequest, stream grpc.ServerStreamingServer[ledgerv1.ListLedgerEntriesResponse]) error {
+	ctx := stream.Context()
+	clientID := req.GetClientId()
+	if clientID == "" {
+		return status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	if err := authorizeClientRPC(ctx, clientID); err != nil {
+		return err
+	}
+
+	entries, err := s.store.GetLedger(ctx, clientID)
+	if err != nil {
+		return s.statusError(ctx, "get ledger failed", err, "client_id", clientID)
+	}
+	for _, e := range entries {
+		err := stream.Send(&ledgerv1.ListLedgerEntriesResponse{Entry: &ledgerv1.LedgerEntry{
+			EntryId:        e.EntryId.String(),
+			ClientId:       e.ClientId,
+			Amount:         e.Amount,
+			CreatedAt:      timestamppb.New(e.CreatedAt),
+			IdempotencyKey: e.IdempotencyKey.String,
+		}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *GRPCService) CreatePayment(ctx context.Context, req *ledgerv1.CreatePaymentRequest) (*ledgerv1.CreatePaymentResponse, error) {
+	var v validator
+	v.clientID("client_id", req.GetClientId())
+	v.amount("amount", req.GetAmount(), false)
+	v.currency("currency", req.GetCurrency())
+	v.idempotencyKey("idempotency_key", req.GetIdempotencyKey())
+	if err := v.err(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := authorizeClientRPC(ctx, req.GetClientId()); err != nil {
+		return nil, err
+	}
+
+	currency, err := paymentCurrency(ctx, s.store, req.GetClientId(), req.GetCurrency())
+	if err != nil {
+		return nil, s.statusError(ctx, "create payment failed", err,
+			"client_id", req.GetClientId(), "amount", req.GetAmount())
+	}
+	balance, err := s.store.CreatePayment(ctx, req.GetClientId(), req.GetAmount(), req.GetIdempotencyKey())
+	if err != nil {
+		return nil, s.statusError(ctx, "create payment failed", err,
+			"client_id", req.GetClientId(), "amount", req.GetAmount())
+	}
+	return &ledgerv1.CreatePaymentResponse{
+		ClientId: req.GetClientId(),
+		Balance:  balance,
+		Currency: currency,
+	}, nil
+}
+
+func (s *GRPCService) Transfer(ctx context.Context, req *ledgerv1.TransferRequest) (*ledgerv1.TransferResponse, error) {
+	var v validator
+	v.clientID("from_client_id", req.GetFromClientId())
+	v.clientID("to_client_id", req.GetToClientId())
+	if req.GetFromClientId() != "" {
+		v.check(req.GetToClientId() != req.GetFromClientId(), "to_client_id", "must differ from from_client_id")
+	}
+	v.amount("amount", req.GetAmount(), true)
+	v.idempotencyKey("idempotency_key", req.GetIdempotencyKey())
+	if err := v.err(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// Only the paying side needs to belong to the caller
+	if err := authorizeClientRPC(ctx, req.GetFromClientId()); err != nil {
+		return nil, err
+	}
+
+	fromBalance, toBalance, err := s.store.Transfer(ctx,
+		req.GetFromClientId(), req.GetToClientId(), req.GetAmount(), req.GetIdempotencyKey())
+	if err != nil {
+		return nil, s.statusError(ctx, "transfer failed", err,
+			"from_client_id", req.GetFromClientId(), "to_client_id", req.GetToClientId(), "amount", req.GetAmount())
+	}
+	return &ledgerv1.TransferResponse{
+		FromClientId:   req.GetFromClientId(),
+		ToClientId:     req.GetToClientId(),
+		Amount:         req.GetAmount(),
+		FromNewBalance: fromBalance,
+		ToNewBalance:   toBalance,
+	}, nil
+}
+
+// statusError maps store errors to gRPC codes. Only unexpected errors are
+// logged; their details stay out of the response.
+func (s *GRPCService) statusError(ctx context.Context, msg string, err error, attrs ...any) error {
+	switch {
+	case errors.Is(err, ErrClientNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrInsufficientBalance):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, money.ErrOverflow):
+		return status.Error(codes.OutOfRange, "the resulting balance is out of range")
+	case errors.Is(err, money.ErrCurrencyMismatch):
+		return status.Error(codes.FailedPrecondition, "the currencies do not match")
+	case errors.Is(err, ErrSelfTransfer):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrVelocityLimitExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, ErrApprovalRequired):
+		// The message names the transfer, which an admin approves over HTTP
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrTransferRejected), errors.Is(err, ErrTransferExpired):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrIdempotencyConflict):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	s.logger.WarnContext(ctx, msg, append(attrs, "err", err)...)
+	return status.Error(codes.Internal, "internal error")
+}
+
+// authorizeClientRPC is the gRPC counterpart of authorizeClient
+func authorizeClientRPC(ctx context.Context, clientID string) error {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok || p.CanAccess(clientID) {
+		return nil
+	}
+	return status.Error(codes.PermissionDenied, "forbidden")
+}
+
+type GRPCOptions struct {
+	// TLSConfig serves the API over TLS when set
+	TLSConfig *tls.Config
+	// APIKeys authenticates calls carrying an "authorization: Bearer" or
+	// "x-api-key" metadata entry
+	APIKeys *APIKeys
+	// ClientSubjects maps verified client certificates to principals, as
+	// ClientCertAuth does for HTTP. Nil ignores client certificates.
+	ClientSubjects map[string][]string
+	Logger         *slog.Logger
+}
+
+// NewGRPCServer builds a server with LedgerService, the standard health
+// service and reflection registered. The returned health server reports
+// SERVING until the caller changes it, e.g. when draining.
+func NewGRPCServer(service *GRPCService, opts GRPCOptions) (*grpc.Server, *health.Server) {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	auth := &grpcAuth{keys: opts.APIKeys, subjects: opts.ClientSubjects}
+	logged := &grpcLogger{logger: opts.Logger}
+
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(logged.unary, auth.unary),
+		grpc.ChainStreamInterceptor(logged.stream, auth.stream),
+	}
+	if opts.TLSConfig != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(opts.TLSConfig)))
+	}
+
+	srv := grpc.NewServer(serverOpts...)
+	ledgerv1.RegisterLedgerServiceServer(srv, service)
+
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus(ledgerv1.LedgerService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, healthSrv)
+
+	reflection.Register(srv)
+	return srv, healthSrv
+}
+
+// ServeGRPC runs srv on ln until ctx is cancelled, then drains it the way
+// Serve drains the HTTP server: OnDrain, DrainDelay, and a graceful stop
+// bounded by ShutdownTimeout after which open streams are cut.
+func ServeGRPC(ctx context.Context, srv *grpc.Server, ln net.Listener, opts ServeOptions) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	if opts.OnDrain != nil {
+		opts.OnDrain()
+	}
+	if opts.DrainDelay > 0 {
+		time.Sleep(opts.DrainDelay)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(opts.ShutdownTimeout):
+		srv.Stop()
+		<-stopped
+		return fmt.Errorf("shutdown grpc server: %w", context.DeadlineExceeded)
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// grpcAuth attaches a Principal to the context like APIKeys.Middleware
+// and ClientCertAuth do for HTTP. A key takes precedence over a
+// certificate; calls with neither pass through unchanged.
+type grpcAuth struct {
+	keys     *APIKeys
+	subjects map[string][]string
+}
+
+func (a *grpcAuth) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	key := first(md.Get("x-api-key"))
+	if key == "" {
+		key = bearerToken(first(md.Get("authorization")))
+	}
+	if key != "" {
+		if a.keys == nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid api key")
+		}
+		p, ok := a.keys.lookup(key)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "invalid api key")
+		}
+		return WithPrincipal(ctx, p), nil
+	}
+
+	if a.subjects == nil {
+		return ctx, nil
+	}
+	pr, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx, nil
+	}
+	info, ok := pr.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ctx, nil
+	}
+	p, err := certPrincipal(a.subjects, &info.State)
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if p == nil {
+		return ctx, nil
+	}
+	return WithPrincipal(ctx, p), nil
+}
+
+func (a *grpcAuth) unary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *grpcAuth) stream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// contextStream replaces the context of a server stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcLogger writes one access log line per call, like AccessLog
+type grpcLogger struct {
+	logger *slog.Logger
+}
+
+func (l *grpcLogger) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	l.log(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func (l *grpcLogger) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	l.log(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+func (l *grpcLogger) log(ctx context.Context, method string, start time.Time, err error) {
+	l.logger.LogAttrs(ctx, slog.LevelInfo, "rpc",
+		slog.String("method", method),
+		slog.String("code", status.Code(err).String()),
+		slog.Duration("duration", time.Since(start)),
+	)
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	ledgerv1 "github.com/koki1610168/go-payment-ledger/api/ledger/v1"
+	"github.com/koki1610168/go-payment-ledger/internal/config"
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+)
+
+// grpcStub serves a fixed ledger and fails every call with err when set
+type grpcStub struct {
+	*StubStore
+	entries []Ledger
+	err     error
+}
+
+func (s *grpcStub) GetBalance(ctx context.Context, clientID string) (int64, string, error) {
+	if s.err != nil {
+		return 0, "", s.err
+	}
+	return s.StubStore.GetBalance(ctx, clientID)
+}
+
+func (s *grpcStub) GetLedger(ctx context.Context, clientID string) ([]Ledger, error) {
+	return s.entries, s.err
+}
+
+func (s *grpcStub) CreatePayment(ctx context.Context, clientID string, amount int64, key string) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.StubStore.CreatePayment(ctx, clientID, amount, key)
+}
+
+func (s *grpcStub) Transfer(ctx context.Context, from, to string, amount int64, key string) (int64, int64, error) {
+	if s.err != nil {
+		return 0, 0, s.err
+	}
+	return 900, 1100, nil
+}
+
+// dialGRPC serves store over an in-memory listener and returns a client
+func dialGRPC(t *testing.T, store ClientStore, opts GRPCOptions) (ledgerv1.LedgerServiceClient, *grpc.ClientConn) {
+	t.Helper()
+	ln := bufconn.Listen(1 << 20)
+	srv, _ := NewGRPCServer(NewGRPCService(store), opts)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return ledgerv1.NewLedgerServiceClient(conn), conn
+}
+
+func TestGRPC_BalanceAndPayment(t *testing.T) {
+	stub := &grpcStub{StubStore: NewStubClient()}
+	stub.SeedClient("client_001", 1000, "JPY")
+	client, _ := dialGRPC(t, stub, GRPCOptions{})
+	ctx := context.Background()
+
+	bal, err := client.GetBalance(ctx, &ledgerv1.GetBalanceRequest{ClientId: "client_001"})
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+	if bal.GetBalance() != 1000 || bal.GetCurrency() != "JPY" {
+		t.Errorf("got balance %d %s, want 1000 JPY", bal.GetBalance(), bal.GetCurrency())
+	}
+
+	pay, err := client.CreatePayment(ctx, &ledgerv1.CreatePaymentRequest{
+		ClientId: "client_001", Amount: 250, Currency: "JPY", IdempotencyKey: "k1",
+	})
+	if err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+	if pay.GetBalance() != 1250 || pay.GetCurrency() != "JPY" {
+		t.Errorf("got balance %d %s after payment, want 1250 JPY", pay.GetBalance(), pay.GetCurrency())
+	}
+	_, err = client.CreatePayment(ctx, &ledgerv1.CreatePaymentRequest{
+		ClientId: "client_001", Amount: 150, Currency: "USD", IdempotencyKey: "k3",
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("payment in USD to a JPY client: got %v, want FailedPrecondition", err)
+	}
+
+	tr, err := client.Transfer(ctx, &ledgerv1.TransferRequest{
+		FromClientId: "client_001", ToClientId: "client_002", Amount: 100, IdempotencyKey: "k2",
+	})
+	if err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+	if tr.GetFromNewBalance() != 900 || tr.GetToNewBalance() != 1100 {
+		t.Errorf("got balances %d/%d, want 900/1100", tr.GetFromNewBalance(), tr.GetToNewBalance())
+	}
+}
+
+func TestGRPC_StatusCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{"not found", ErrClientNotFound, codes.NotFound},
+		{"insufficient balance", ErrInsufficientBalance, codes.FailedPrecondition},
+		{"balance out of range", money.ErrOverflow, codes.OutOfRange},
+		{"currency mismatch", money.ErrCurrencyMismatch, codes.FailedPrecondition},
+		{"awaiting approval", &ApprovalRequiredError{Transfer: PendingTransfer{ID: "t1", Status: TransferPending}}, codes.FailedPrecondition},
+		{"idempotency conflict", ErrIdempotencyConflict, codes.AlreadyExists},
+		{"velocity limit", &VelocityLimitError{Limit: VelocityLimit{Kind: VelocityCount, Max: 1}}, codes.ResourceExhausted},
+		{"deadline", context.DeadlineExceeded, codes.DeadlineExceeded},
+		{"unexpected", errors.New("connection reset"), codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &grpcStub{StubStore: NewStubClient(), err: tt.err}
+			client, _ := dialGRPC(t, stub, GRPCOptions{})
+
+			_, err := client.Transfer(context.Background(), &ledgerv1.TransferRequest{
+				FromClientId: "client_001", ToClientId: "client_002", Amount: 100, IdempotencyKey: "k",
+			})
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if tt.want == codes.Internal && status.Convert(err).Message() != "internal error" {
+				t.Errorf("internal error leaked details: %q", status.Convert(err).Message())
+			}
+		})
+	}
+}
+
+// The RPCs check their requests like the HTTP handlers do
+func TestGRPC_InvalidArgument(t *testing.T) {
+	client, _ := dialGRPC(t, &grpcStub{StubStore: NewStubClient()}, GRPCOptions{})
+	ctx := context.Background()
+	fees := FeeRevenueAccount("JPY")
+	tooLong := strings.Repeat("k", 129)
+
+	payments := []struct {
+		name  string
+		req   *ledgerv1.CreatePaymentRequest
+		field string
+	}{
+		{"zero amount", &ledgerv1.CreatePaymentRequest{ClientId: "client_001", Amount: 0, Currency: "JPY", IdempotencyKey: "k"}, "amount"},
+		{"amount above max", &ledgerv1.CreatePaymentRequest{ClientId: "client_001", Amount: MaxAmount + 1, Currency: "JPY", IdempotencyKey: "k"}, "amount"},
+		{"withdrawal above max", &ledgerv1.CreatePaymentRequest{ClientId: "client_001", Amount: -MaxAmount - 1, Currency: "JPY", IdempotencyKey: "k"}, "amount"},
+		{"missing idempotency key", &ledgerv1.CreatePaymentRequest{ClientId: "client_001", Amount: 1, Currency: "JPY"}, "idempotency_key"},
+		{"malformed idempotency key", &ledgerv1.CreatePaymentRequest{ClientId: "client_001", Amount: 1, Currency: "JPY", IdempotencyKey: "a key"}, "idempotency_key"},
+		{"idempotency key too long", &ledgerv1.CreatePaymentRequest{ClientId: "client_001", Amount: 1, Currency: "JPY", IdempotencyKey: tooLong}, "idempotency_key"},
+		{"missing client", &ledgerv1.CreatePaymentRequest{Amount: 1, Currency: "JPY", IdempotencyKey: "k"}, "client_id"},
+		{"malformed client", &ledgerv1.CreatePaymentRequest{ClientId: "a/b", Amount: 1, Currency: "JPY", IdempotencyKey: "k"}, "client_id"},
+		{"system account", &ledgerv1.CreatePaymentRequest{ClientId: fees, Amount: 1, Currency: "JPY", IdempotencyKey: "k"}, "client_id"},
+		{"malformed currency", &ledgerv1.CreatePaymentRequest{ClientId: "client_001", Amount: 1, Currency: "yen", IdempotencyKey: "k"}, "currency"},
+	}
+	for _, tt := range payments {
+		t.Run("payment/"+tt.name, func(t *testing.T) {
+			_, err := client.CreatePayment(ctx, tt.req)
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("got %v, want InvalidArgument", err)
+			}
+			if msg := status.Convert(err).Message(); !strings.Contains(msg, tt.field+":") {
+				t.Errorf("message %q does not name %s", msg, tt.field)
+			}
+		})
+	}
+
+	transfers := []struct {
+		name  string
+		req   *ledgerv1.TransferRequest
+		field string
+	}{
+		{"negative amount", &ledgerv1.TransferRequest{FromClientId: "client_001", ToClientId: "client_002", Amount: -5, IdempotencyKey: "k"}, "amount"},
+		{"amount above max", &ledgerv1.TransferRequest{FromClientId: "client_001", ToClientId: "client_002", Amount: MaxAmount + 1, IdempotencyKey: "k"}, "amount"},
+		{"malformed idempotency key", &ledgerv1.TransferRequest{FromClientId: "client_001", ToClientId: "client_002", Amount: 5, IdempotencyKey: "k/1"}, "idempotency_key"},
+		{"malformed sender", &ledgerv1.TransferRequest{FromClientId: "a b", ToClientId: "client_002", Amount: 5, IdempotencyKey: "k"}, "from_client_id"},
+		{"malformed recipient", &ledgerv1.TransferRequest{FromClientId: "client_001", ToClientId: "a b", Amount: 5, IdempotencyKey: "k"}, "to_client_id"},
+		{"same client", &ledgerv1.TransferRequest{FromClientId: "client_001", ToClientId: "client_001", Amount: 5, IdempotencyKey: "k"}, "to_client_id"},
+		{"from a system account", &ledgerv1.TransferRequest{FromClientId: fees, ToClientId: "client_001", Amount: 5, IdempotencyKey: "k"}, "from_client_id"},
+		{"to a system account", &ledgerv1.TransferRequest{FromClientId: "client_001", ToClientId: fees, Amount: 5, IdempotencyKey: "k"}, "to_client_id"},
+	}
+	for _, tt := range transfers {
+		t.Run("transfer/"+tt.name, func(t *testing.T) {
+			_, err := client.Transfer(ctx, tt.req)
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("got %v, want InvalidArgument", err)
+			}
+			if msg := status.Convert(err).Message(); !strings.Contains(msg, tt.field+":") {
+				t.Errorf("message %q does not name %s", msg, tt.field)
+			}
+		})
+	}
+}
+
+func TestGRPC_ListLedgerEntriesStreams(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	stub := &grpcStub{StubStore: NewStubClient(), entries: []Ledger{
+		{EntryId: uuid.New(), ClientId: "client_001", Amount: 500, CreatedAt: created,
+			IdempotencyKey: sql.NullString{String: "k1", Valid: true}},
+		{EntryId: uuid.New(), ClientId: "client_001", Amount: -200, CreatedAt: created.Add(time.Minute)},
+	}}
+	client, _ := dialGRPC(t, stub, GRPCOptions{})
+
+	stream, err := client.ListLedgerEntries(context.Background(), &ledgerv1.ListLedgerEntriesRequest{ClientId: "client_001"})
+	if err != nil {
+		t.Fatalf("list ledger entries: %v", err)
+	}
+	var got []*ledgerv1.LedgerEntry
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("recv: %v", err)
+		}
+		got = append(got, res.GetEntry())
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	if got[0].GetAmount() != 500 || got[0].GetIdempotencyKey() != "k1" || !got[0].GetCreatedAt().AsTime().Equal(created) {
+		t.Errorf("first entry = %v", got[0])
+	}
+	if got[1].GetEntryId() != stub.entries[1].EntryId.String() {
+		t.Errorf("second entry id = %s, want %s", got[1].GetEntryId(), stub.entries[1].EntryId)
+	}
+}
+
+func TestGRPC_APIKeyAuth(t *testing.T) {
+	keys, err := NewAPIKeys([]config.APIKey{
+		{Name: "portal", KeySHA256: hashKey("s3cret"), ClientIDs: []string{"client_001"}},
+	})
+	if err != nil {
+		t.Fatalf("new api keys: %v", err)
+	}
+	stub := &grpcStub{StubStore: NewStubClient(), entries: []Ledger{}}
+	stub.SeedClient("client_001", 1000, "JPY")
+	stub.SeedClient("client_002", 1000, "JPY")
+	client, _ := dialGRPC(t, stub, GRPCOptions{APIKeys: keys})
+
+	withKey := func(md ...string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), md...)
+	}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		clientID string
+		want     codes.Code
+	}{
+		{"bearer own client", withKey("authorization", "Bearer s3cret"), "client_001", codes.OK},
+		{"x-api-key own client", withKey("x-api-key", "s3cret"), "client_001", codes.OK},
+		{"other client", withKey("authorization", "Bearer s3cret"), "client_002", codes.PermissionDenied},
+		{"unknown key", withKey("authorization", "Bearer nope"), "client_001", codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.GetBalance(tt.ctx, &ledgerv1.GetBalanceRequest{ClientId: tt.clientID})
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("GetBalance: got %v, want %v", got, tt.want)
+			}
+
+			// Streams go through the same interceptor chain
+			stream, err := client.ListLedgerEntries(tt.ctx, &ledgerv1.ListLedgerEntriesRequest{ClientId: tt.clientID})
+			if err == nil {
+				_, err = stream.Recv()
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("ListLedgerEntries: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGRPC_HealthAndGracefulStop(t *testing.T) {
+	ln := bufconn.Listen(1 << 20)
+	srv, healthSrv := NewGRPCServer(NewGRPCService(&grpcStub{StubStore: NewStubClient()}), GRPCOptions{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- ServeGRPC(ctx, srv, ln, ServeOptions{
+			OnDrain:         healthSrv.Shutdown,
+			ShutdownTimeout: 5 * time.Second,
+		})
+	}()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	res, err := healthpb.NewHealthClient(conn).Check(context.Background(),
+		&healthpb.HealthCheckRequest{Service: ledgerv1.LedgerService_ServiceDesc.ServiceName})
+	if err != nil {
+		t.Fatalf("health check: %v", err)
+	}
+	if res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("got status %v, want SERVING", res.GetStatus())
+	}
+
+	cancel()
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			t.Errorf("ServeGRPC: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeGRPC did not return after cancel")
+	}
+}
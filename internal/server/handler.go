@@ -2,10 +2,13 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
-	"strings"
 	"encoding/json"
-	"fmt"
+	"log/slog"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
 )
 
 // ----------------------------------------------
@@ -21,6 +24,8 @@ type PaymentResponse struct {
 	ClientID string
 	Balance int64
 	Currency string
+	// Money is the balance again, as a decimal string in major units
+	Money money.Money `json:"money"`
 }
 // ----------------------------------------------
 
@@ -40,6 +45,17 @@ type TransferResponse struct {
 	Amount int64 `json:"amount"`
 	FromNewBalance int64 `json:"from_new_balance"`
 	ToNewBalance int64 `json:"to_new_balance"`
+	// Currency is held by both clients, and the amounts are in it
+	Currency string `json:"currency"`
+	// Money repeats the amounts as decimal strings in major units
+	Money TransferMoney `json:"money"`
+}
+
+// TransferMoney holds the amounts of a TransferResponse as Money
+type TransferMoney struct {
+	Amount money.Money `json:"amount"`
+	FromNewBalance money.Money `json:"from_new_balance"`
+	ToNewBalance money.Money `json:"to_new_balance"`
 }
 // ----------------------------------------------
 
@@ -48,6 +64,8 @@ type TransferResponse struct {
 type LedgerResponse struct {
 	ClientID string `json:"client_id"`
 	Entries []Ledger `json:"ledger_entires"`
+	// NextCursor is set on paged responses when more entries follow
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 
@@ -61,60 +79,51 @@ type ClientStore interface {
 type Handler struct {
 	store ClientStore
 	mux *http.ServeMux
+	logger *slog.Logger
 }
 
 func NewHandler(store ClientStore) *Handler{
-	h := &Handler{store: store}
+	h := &Handler{store: store, logger: slog.Default()}
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/payments", h.postPayments)
-	mux.HandleFunc("/clients/", h.clientsRouter)
-	mux.HandleFunc("/transfer", h.transferMoney)
+	register(mux, V1, h.v1Routes())
+	registerUnversioned(mux, V1, h.legacyRoutes())
 
 	h.mux = mux
 	return h
 }
 
-func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.mux.ServeHTTP(w, r)
+// WithLogger sets the logger used to report failed store calls
+func (h *Handler) WithLogger(logger *slog.Logger) *Handler {
+	h.logger = logger
+	return h
 }
 
-func (h *Handler) clientsRouter(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, pattern := h.mux.Handler(r)
+	if pattern != "" {
+		setMatchedRoute(r, pattern)
+		h.mux.ServeHTTP(w, r)
 		return
 	}
-	rest := strings.TrimPrefix(r.URL.Path, "/clients/")
-	endpoint := strings.Split(strings.Trim(rest, "/"), "/")
 
-	if len(endpoint) != 2 {
-		http.Error(w, "bad request", http.StatusBadRequest)
-	}
-
-	switch endpoint[1] {
-	case "balance":
-		h.getBalance(w, r, endpoint[0])
-	case "ledger":
-		h.getLedger(w, r, endpoint[0])
-	default:
-		http.Error(w, "the endpoint not found", http.StatusBadGateway)
+	// ServeMux answers unknown paths and methods in plain text. Answer
+	// with a problem instead, keeping its status and Allow header, and
+	// leave r.Pattern empty for the metrics.
+	probe := &statusProbe{header: http.Header{}}
+	handler.ServeHTTP(probe, r)
+	if probe.status == http.StatusMethodNotAllowed {
+		w.Header()["Allow"] = probe.header["Allow"]
+		writeMethodNotAllowed(w, r)
+		return
 	}
-
-
+	writeNotFound(w, r)
 }
 
-
-
-
 func (h *Handler) postPayments(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
 	var paymentReq PaymentRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&paymentReq); err != nil {
-		http.Error(w, "failed to load request", http.StatusBadRequest)
+	if !decodeBody(w, r, &paymentReq) {
 		return
 	}
 
@@ -123,24 +132,28 @@ func (h *Handler) postPayments(w http.ResponseWriter, r *http.Request) {
 	currency := paymentReq.Currency
 	idempotencyKey := paymentReq.IdempotencyKey
 
-	if client_id == "" {
-		http.Error(w, "client_id is required", http.StatusBadRequest)
+	var v validator
+	v.clientID("clientID", client_id)
+	v.amount("amount", amount, false)
+	v.currency("currency", currency)
+	v.idempotencyKey("idempotencyKey", idempotencyKey)
+	if err := v.err(); err != nil {
+		writeInvalid(w, r, err)
 		return
 	}
 
-	if currency == "" {
-		http.Error(w, "currency is required", http.StatusBadRequest)
+	if !authorizeClient(w, r, client_id) {
 		return
 	}
 
-	if idempotencyKey == "" {
-		http.Error(w, "idempotencyKey is required", http.StatusBadRequest)
+	currency, err := paymentCurrency(r.Context(), h.store, client_id, currency)
+	if err != nil {
+		h.writeError(w, r, "create payment failed", err, "client_id", client_id, "amount", amount)
 		return
 	}
-
 	newBalance, err := h.store.CreatePayment(r.Context(), client_id, amount, idempotencyKey)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to initiate payment because of %v", err), http.StatusBadRequest)	
+		h.writeError(w, r, "create payment failed", err, "client_id", client_id, "amount", amount)
 		return
 	}
 
@@ -149,23 +162,36 @@ func (h *Handler) postPayments(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (h *Handler) getBalance(w http.ResponseWriter, r *http.Request, client_id string) {
+func (h *Handler) getBalance(w http.ResponseWriter, r *http.Request) {
 	// We want to call GetBalance
+	client_id := r.PathValue("id")
+	if !authorizeClient(w, r, client_id) {
+		return
+	}
 
 	balance, currency, err := h.store.GetBalance(r.Context(), client_id)
 
 	if err != nil {
-		http.Error(w, "failed to get balance", http.StatusNotFound)
+		h.writeError(w, r, "get balance failed", err, "client_id", client_id)
 		return
 	}
 
 	encodePaymentResponseToJSON(w, client_id, balance, currency)
 }
 
-func (h *Handler) getLedger(w http.ResponseWriter, r *http.Request, client_id string) {
+func (h *Handler) getLedger(w http.ResponseWriter, r *http.Request) {
+	client_id := r.PathValue("id")
+	if !authorizeClient(w, r, client_id) {
+		return
+	}
+	if q := r.URL.Query(); q.Has("limit") || q.Has("cursor") {
+		h.getLedgerPage(w, r, client_id)
+		return
+	}
 	ledger_entries, err := h.store.GetLedger(r.Context(), client_id)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to fetch ledger, %v", err), http.StatusNotFound)
+		h.writeError(w, r, "get ledger failed", err, "client_id", client_id)
+		return
 	}
 
 	encodeLedgerToJSON(w, client_id, ledger_entries)
@@ -175,14 +201,9 @@ func (h *Handler) getLedger(w http.ResponseWriter, r *http.Request, client_id st
 
 
 func (h *Handler) transferMoney(w http.ResponseWriter, r *http.Request) { 
-	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
 	var transferReq TransferRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&transferReq); err != nil {
-		http.Error(w, "failed to load request", http.StatusBadRequest)
+	if !decodeBody(w, r, &transferReq) {
 		return
 	}
 
@@ -191,45 +212,80 @@ func (h *Handler) transferMoney(w http.ResponseWriter, r *http.Request) {
 	amount := transferReq.Amount
 	idempotencyKey := transferReq.IdempotencyKey
 
-	if from_client_id == "" {
-		http.Error(w, "from_client_id is required", http.StatusBadRequest)
-		return
+	var v validator
+	v.clientID("from_client_id", from_client_id)
+	v.clientID("to_client_id", to_client_id)
+	if from_client_id != "" {
+		v.check(to_client_id != from_client_id, "to_client_id", "must differ from from_client_id")
 	}
-	if to_client_id == "" {
-		http.Error(w, "to_client_id is required", http.StatusBadRequest)
+	v.amount("amount", amount, true)
+	v.idempotencyKey("idempotencyKey", idempotencyKey)
+	if err := v.err(); err != nil {
+		writeInvalid(w, r, err)
 		return
 	}
-	if amount <= 0 {
-		http.Error(w, "amount must be positive", http.StatusBadRequest)
-	}
 
-	if idempotencyKey == "" {
-		http.Error(w, "idempotencyKey is required", http.StatusBadRequest)
+	// Only the paying side needs to belong to the caller
+	if !authorizeClient(w, r, from_client_id) {
 		return
 	}
 	
 	from_new_balance, to_new_balance, err := h.store.Transfer(r.Context(), from_client_id, to_client_id, amount, idempotencyKey)
+	var approvalErr *ApprovalRequiredError
+	if errors.As(err, &approvalErr) {
+		// Accepted, but nothing moves until someone else approves it
+		writePendingTransfer(w, approvalErr.Transfer)
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to transfer, %v", err), http.StatusNotFound)
+		h.writeError(w, r, "transfer failed", err,
+			"from_client_id", from_client_id, "to_client_id", to_client_id, "amount", amount)
 		return
 	}
 
-	encodeTransferResponseToJSON(w, from_client_id, to_client_id, amount, from_new_balance, to_new_balance)
+	// Both clients hold the currency of the sender, or the transfer
+	// would have failed
+	_, currency, err := h.store.GetBalance(r.Context(), from_client_id)
+	if err != nil {
+		h.writeError(w, r, "transfer failed", err,
+			"from_client_id", from_client_id, "to_client_id", to_client_id, "amount", amount)
+		return
+	}
+
+	encodeTransferResponseToJSON(w, from_client_id, to_client_id, currency, amount, from_new_balance, to_new_balance)
 
 
 }
 
+// paymentCurrency returns the currency clientID holds, failing with
+// money.ErrCurrencyMismatch when a payment in currency cannot be posted to
+// it. A client's currency never changes, so it can be checked before the
+// payment rather than inside it.
+func paymentCurrency(ctx context.Context, store ClientStore, clientID, currency string) (string, error) {
+	_, held, err := store.GetBalance(ctx, clientID)
+	if err != nil {
+		return "", err
+	}
+	if held != currency {
+		return "", fmt.Errorf("%w: a payment in %s to a client holding %s", money.ErrCurrencyMismatch, currency, held)
+	}
+	return held, nil
+}
+
 func encodePaymentResponseToJSON(w http.ResponseWriter, client_id string, balance int64, currency string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(PaymentResponse{client_id, balance, currency})
+	json.NewEncoder(w).Encode(PaymentResponse{client_id, balance, currency, money.New(balance, currency)})
 }
 
 func encodeTransferResponseToJSON(w http.ResponseWriter, from_client_id string, to_client_id string, 
-	amount int64, from_new_balance int64, to_new_balance int64) {
+	currency string, amount int64, from_new_balance int64, to_new_balance int64) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(TransferResponse{from_client_id, to_client_id, amount, from_new_balance, to_new_balance})
+	json.NewEncoder(w).Encode(TransferResponse{from_client_id, to_client_id, amount, from_new_balance, to_new_balance,
+		currency, TransferMoney{
+			money.New(amount, currency), money.New(from_new_balance, currency), money.New(to_new_balance, currency),
+		}})
 }
 
 
@@ -238,3 +294,9 @@ func encodeLedgerToJSON(w http.ResponseWriter, clientId string, ledger_entries [
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(LedgerResponse{ClientID: clientId, Entries: ledger_entries})
 }
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
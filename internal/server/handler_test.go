@@ -7,11 +7,11 @@ import (
 	"context"
 	"encoding/json"
 	"reflect"
-	"errors"
 	"bytes"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
 )
 
-var ErrorNotFound = errors.New("error not found")
 
 type StubStore struct {
 	balances map[string]int64
@@ -35,7 +35,7 @@ func (s *StubStore) SeedClient(clientId string, balance int64, currency string)
 func (s *StubStore) GetBalance(ctx context.Context, clientId string) (int64, string, error) {
 	b, ok := s.balances[clientId]
 	if !ok {
-		return 0, "", ErrorNotFound
+		return 0, "", ErrClientNotFound
 	}
 	return b, s.currencies[clientId], nil
 
@@ -44,7 +44,7 @@ func (s *StubStore) GetBalance(ctx context.Context, clientId string) (int64, str
 func (s *StubStore) CreatePayment(ctx context.Context, clientId string, amount int64, idempotencyKey string) (int64, error) {
 	_, ok := s.balances[clientId]
 	if !ok {
-		return 0, ErrorNotFound
+		return 0, ErrClientNotFound
 	}
 
 	if idempotencyKey != "" {
@@ -89,6 +89,7 @@ func TestHandler(t *testing.T) {
 			ClientID: "client_001",
 			Balance: 10000,
 			Currency: "JPY",
+			Money: money.New(10000, "JPY"),
 		}
 
 		balanceClient := decodePaymentResponseJSON(t, response)
@@ -119,6 +120,7 @@ func TestHandler(t *testing.T) {
 		}
 
 		request, _ := http.NewRequest(http.MethodPost, "/payments", &buf)
+		request.Header.Set("Content-Type", "application/json")
 		response := httptest.NewRecorder()
 
 		handler.mux.ServeHTTP(response, request)
@@ -128,6 +130,24 @@ func TestHandler(t *testing.T) {
 
 		assertEqualBalance(t, afterBalance, want)
 	})
+
+	t.Run("payment in another currency than the client's is rejected", func(t *testing.T) {
+		store := NewStubClient()
+		store.SeedClient("client_001", 10000, "JPY")
+		handler := NewHandler(store).WithLogger(discardLogger())
+
+		res := serveAs(handler, nil, http.MethodPost, V1+"/payments",
+			`{"clientID":"client_001","amount":150,"currency":"USD","idempotencyKey":"usd-1"}`)
+		if res.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("got %d (%s), want %d", res.Code, res.Body, http.StatusUnprocessableEntity)
+		}
+		if p := decodeJSON[Problem](t, res); p.Code != CodeCurrencyMismatch {
+			t.Errorf("got code %q, want %q", p.Code, CodeCurrencyMismatch)
+		}
+		if store.balances["client_001"] != 10000 {
+			t.Errorf("balance is %d after a rejected payment, want 10000", store.balances["client_001"])
+		}
+	})
 }
 
 func TestDoulbeCharge(t *testing.T) {
@@ -149,6 +169,7 @@ func TestDoulbeCharge(t *testing.T) {
             "idempotencyKey": idempotencyKey,
         })
         req, _ := http.NewRequest(http.MethodPost, "/payments", &buf)
+        req.Header.Set("Content-Type", "application/json")
         res := httptest.NewRecorder()
         handler.mux.ServeHTTP(res, req)
         return decodePaymentResponseJSON(t, res).Balance
@@ -182,6 +203,7 @@ func TestTransfer(t *testing.T) {
         })
 
         req, _ := http.NewRequest(http.MethodPost, "/transfer", &buf)
+        req.Header.Set("Content-Type", "application/json")
         res := httptest.NewRecorder()
         handler.mux.ServeHTTP(res, req)
 
@@ -213,6 +235,7 @@ func TestClientsLedger(t *testing.T) {
 			"idempotencyKey": idempotencyKey,
 		})
 		req, _ := http.NewRequest(http.MethodPost, "/payments", &buf)
+		req.Header.Set("Content-Type", "application/json")
 		res := httptest.NewRecorder()
 		handler.mux.ServeHTTP(res, req)
 		return decodePaymentResponseJSON(t, res).Balance
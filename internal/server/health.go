@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Version is stamped at build time with
+// -ldflags "-X github.com/koki1610168/go-payment-ledger/internal/server.Version=v1.2.3"
+var Version = "dev"
+
+// Each readiness check gets its own short deadline so a hung database
+// cannot make the probe itself time out
+const healthCheckTimeout = 2 * time.Second
+
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// Health serves the liveness, readiness and status probes
+type Health struct {
+	started  time.Time
+	pool     *pgxpool.Pool
+	checks   []healthCheck
+	draining atomic.Bool
+}
+
+// NewHealth registers the database and migration checks when pool is not
+// nil. More checks can be added with AddCheck.
+func NewHealth(pool *pgxpool.Pool) *Health {
+	h := &Health{started: time.Now(), pool: pool}
+	if pool != nil {
+		h.AddCheck("database", pool.Ping)
+		h.AddCheck("migrations", func(ctx context.Context) error {
+			pending, err := PendingMigrations(ctx, pool)
+			if err != nil {
+				return err
+			}
+			if len(pending) > 0 {
+				return fmt.Errorf("pending: %s", strings.Join(pending, ", "))
+			}
+			return nil
+		})
+	}
+	return h
+}
+
+func (h *Health) AddCheck(name string, check func(ctx context.Context) error) {
+	h.checks = append(h.checks, healthCheck{name: name, check: check})
+}
+
+// SetDraining makes /readyz fail so the load balancer stops sending
+// traffic while in-flight requests finish
+func (h *Health) SetDraining() {
+	h.draining.Store(true)
+}
+
+// Register mounts the probes on mux. They belong outside the rate
+// limiter so orchestrator probes never consume client tokens.
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", h.healthz)
+	mux.HandleFunc("GET /readyz", h.readyz)
+	mux.HandleFunc("GET /status", h.status)
+}
+
+type ReadinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+type StatusResponse struct {
+	Status        string            `json:"status"`
+	Version       string            `json:"version"`
+	Revision      string            `json:"revision,omitempty"`
+	GoVersion     string            `json:"go_version"`
+	StartedAt     time.Time         `json:"started_at"`
+	UptimeSeconds int64             `json:"uptime_seconds"`
+	Draining      bool              `json:"draining"`
+	Checks        map[string]string `json:"checks"`
+	Pool          *PoolStatus       `json:"pool,omitempty"`
+}
+
+type PoolStatus struct {
+	AcquiredConns        int32   `json:"acquired_conns"`
+	IdleConns            int32   `json:"idle_conns"`
+	TotalConns           int32   `json:"total_conns"`
+	MaxConns             int32   `json:"max_conns"`
+	AcquireCount         int64   `json:"acquire_count"`
+	EmptyAcquireCount    int64   `json:"empty_acquire_count"`
+	EmptyAcquireWaitSecs float64 `json:"empty_acquire_wait_seconds"`
+}
+
+func (h *Health) healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (h *Health) readyz(w http.ResponseWriter, r *http.Request) {
+	ready, checks := h.runChecks(r.Context())
+	res := ReadinessResponse{Status: "ready", Checks: checks}
+	code := http.StatusOK
+	if !ready {
+		res.Status = "not_ready"
+		code = http.StatusServiceUnavailable
+	}
+	writeHealthJSON(w, code, res)
+}
+
+func (h *Health) status(w http.ResponseWriter, r *http.Request) {
+	ready, checks := h.runChecks(r.Context())
+	res := StatusResponse{
+		Status:        "ready",
+		Version:       Version,
+		GoVersion:     runtime.Version(),
+		StartedAt:     h.started.UTC(),
+		UptimeSeconds: int64(time.Since(h.started).Seconds()),
+		Draining:      h.draining.Load(),
+		Checks:        checks,
+	}
+	if !ready {
+		res.Status = "not_ready"
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				res.Revision = s.Value
+			}
+		}
+	}
+	if h.pool != nil {
+		s := h.pool.Stat()
+		res.Pool = &PoolStatus{
+			AcquiredConns:        s.AcquiredConns(),
+			IdleConns:            s.IdleConns(),
+			TotalConns:           s.TotalConns(),
+			MaxConns:             s.MaxConns(),
+			AcquireCount:         s.AcquireCount(),
+			EmptyAcquireCount:    s.EmptyAcquireCount(),
+			EmptyAcquireWaitSecs: s.EmptyAcquireWaitTime().Seconds(),
+		}
+	}
+	writeHealthJSON(w, http.StatusOK, res)
+}
+
+func (h *Health) runChecks(ctx context.Context) (bool, map[string]string) {
+	ready := true
+	results := make(map[string]string, len(h.checks)+1)
+
+	if h.draining.Load() {
+		ready = false
+		results["draining"] = "server is shutting down"
+	}
+
+	for _, c := range h.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		err := c.check(checkCtx)
+		cancel()
+		if err != nil {
+			ready = false
+			results[c.name] = err.Error()
+			continue
+		}
+		results[c.name] = "ok"
+	}
+	return ready, results
+}
+
+func writeHealthJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
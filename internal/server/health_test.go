@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveHealth(t *testing.T, h *Health, path string) (*httptest.ResponseRecorder, ReadinessResponse) {
+	t.Helper()
+	mux := http.NewServeMux()
+	h.Register(mux)
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var body ReadinessResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+	return res, body
+}
+
+func TestHealth_LivenessIgnoresChecks(t *testing.T) {
+	h := NewHealth(nil)
+	h.AddCheck("database", func(ctx context.Context) error { return errors.New("down") })
+
+	res, _ := serveHealth(t, h, "/healthz")
+	if res.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", res.Code, http.StatusOK)
+	}
+}
+
+func TestHealth_ReadinessReportsFailingChecks(t *testing.T) {
+	h := NewHealth(nil)
+	h.AddCheck("database", func(ctx context.Context) error { return nil })
+	h.AddCheck("migrations", func(ctx context.Context) error { return errors.New("pending: 0002_fees") })
+
+	res, body := serveHealth(t, h, "/readyz")
+	if res.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", res.Code, http.StatusServiceUnavailable)
+	}
+	if body.Checks["database"] != "ok" {
+		t.Errorf("got database check %q, want ok", body.Checks["database"])
+	}
+	if body.Checks["migrations"] != "pending: 0002_fees" {
+		t.Errorf("got migrations check %q", body.Checks["migrations"])
+	}
+}
+
+func TestHealth_NotReadyWhileDraining(t *testing.T) {
+	h := NewHealth(nil)
+
+	res, _ := serveHealth(t, h, "/readyz")
+	if res.Code != http.StatusOK {
+		t.Fatalf("got status %d before draining, want %d", res.Code, http.StatusOK)
+	}
+
+	h.SetDraining()
+	res, body := serveHealth(t, h, "/readyz")
+	if res.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d while draining, want %d", res.Code, http.StatusServiceUnavailable)
+	}
+	if _, ok := body.Checks["draining"]; !ok {
+		t.Error("expected a draining entry in checks")
+	}
+}
+
+func TestHealth_StatusIncludesBuildInfo(t *testing.T) {
+	h := NewHealth(nil)
+	mux := http.NewServeMux()
+	h.Register(mux)
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	var body StatusResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("decode status: %v", err)
+	}
+	if body.Version != Version || body.GoVersion == "" || body.StartedAt.IsZero() {
+		t.Errorf("incomplete status response: %+v", body)
+	}
+}
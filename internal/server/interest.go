@@ -0,0 +1,648 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+)
+
+// Day-count conventions, which set how much of an annual rate one day
+// earns
+const (
+	// DayCountActual365 counts each day as 1/365 of a year
+	DayCountActual365 = "act/365"
+	// DayCountActual360 counts each day as 1/360 of a year
+	DayCountActual360 = "act/360"
+	// DayCountActualActual counts each day as 1/365, or 1/366 in leap
+	// years
+	DayCountActualActual = "act/act"
+	// DayCount30360 counts every month as 30 days of a 360-day year: the
+	// 31st earns nothing and the last day of February earns for the days
+	// it is short of 30
+	DayCount30360 = "30/360"
+)
+
+// InterestScale is how many units of accrued interest make one minor
+// unit. Accruals are kept in millionths so that rounding happens only
+// when they are posted, and what is rounded off is carried forward.
+const InterestScale = 1_000_000
+
+// InterestExpenseAccount is the house account that pays the interest
+// earned on positive balances in currency
+func InterestExpenseAccount(currency string) string {
+	return SystemAccountPrefix + "interest_expense." + currency
+}
+
+// InterestIncomeAccount is the house account that collects overdraft
+// interest in currency
+func InterestIncomeAccount(currency string) string {
+	return SystemAccountPrefix + "interest_income." + currency
+}
+
+var ErrInterestRateNotFound = errors.New("interest rate not found")
+
+// InterestRate sets the annual rates a client's balance earns or pays.
+// Accrual starts on the UTC day the rate was first set. A changed rate
+// applies to the days not yet accrued; a zero rate stops accrual.
+type InterestRate struct {
+	ClientID string `json:"client_id"`
+	// CreditBasisPoints is earned on positive balances
+	CreditBasisPoints int64 `json:"credit_basis_points"`
+	// OverdraftBasisPoints is charged on negative balances
+	OverdraftBasisPoints int64     `json:"overdraft_basis_points"`
+	DayCount             string    `json:"day_count"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
+}
+
+// Validate reports every problem with r before it is stored
+func (r InterestRate) Validate() error {
+	var v validator
+	v.clientID("client_id", r.ClientID)
+	v.check(r.CreditBasisPoints >= 0 && r.CreditBasisPoints <= 10000, "credit_basis_points", "must be between 0 and 10000")
+	v.check(r.OverdraftBasisPoints >= 0 && r.OverdraftBasisPoints <= 10000, "overdraft_basis_points", "must be between 0 and 10000")
+	switch r.DayCount {
+	case DayCountActual365, DayCountActual360, DayCountActualActual, DayCount30360:
+	default:
+		v.check(false, "day_count", "must be act/365, act/360, act/act or 30/360")
+	}
+	return v.err()
+}
+
+// Accrue is the interest balance earns on day, in 1/InterestScale of the
+// minor unit, rounded to the nearest. It is negative for overdraft
+// interest.
+func (r InterestRate) Accrue(day time.Time, balance int64) (int64, error) {
+	bps := r.CreditBasisPoints
+	if balance < 0 {
+		bps = r.OverdraftBasisPoints
+	}
+	weight, basis := dayCount(r.DayCount, day)
+	accrued, err := money.New(balance, "").Mul(bps*weight*(InterestScale/10000), basis)
+	if err != nil {
+		return 0, err
+	}
+	return accrued.Amount, nil
+}
+
+// dayCount returns how many days of a basis-day year day counts for
+func dayCount(convention string, day time.Time) (weight, basis int64) {
+	switch convention {
+	case DayCountActual360:
+		return 1, 360
+	case DayCountActualActual:
+		if y := day.Year(); y%4 == 0 && (y%100 != 0 || y%400 == 0) {
+			return 1, 366
+		}
+		return 1, 365
+	case DayCount30360:
+		last := InterestPeriod(day).AddDate(0, 1, -1).Day()
+		switch {
+		case day.Day() > 30:
+			return 0, 360
+		case day.Day() == last && last < 30:
+			return int64(31 - last), 360
+		}
+		return 1, 360
+	}
+	return 1, 365
+}
+
+// InterestDay is the UTC day t falls on. A day is accrued once it has
+// ended, on its end-of-day balance.
+func InterestDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// InterestPeriod is the first day of the month day falls in. Interest is
+// capitalized once per month, after the month's last day is accrued.
+func InterestPeriod(day time.Time) time.Time {
+	y, m, _ := day.UTC().Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// InterestPosting is the capitalization of one client's interest for one
+// month. Each client has at most one per month, which keeps reruns of the
+// job from posting a month twice.
+type InterestPosting struct {
+	ClientID string `json:"client_id"`
+	// Period is the month, as YYYY-MM
+	Period   string `json:"period"`
+	Currency string `json:"currency"`
+	// Accrued is the interest of the month plus the residual carried from
+	// the month before, in 1/InterestScale of the minor unit
+	Accrued int64 `json:"accrued"`
+	// Amount is Accrued in minor units, rounded toward zero. It is
+	// credited to the client from InterestExpenseAccount, or debited to
+	// InterestIncomeAccount when negative.
+	Amount int64 `json:"amount"`
+	// Residual is what rounding left of Accrued, carried to the next month
+	Residual int64 `json:"residual"`
+	// EntryID is the client's ledger entry, empty when Amount is zero
+	EntryID   string    `json:"entry_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewInterestPosting capitalizes the interest accrued over period, plus
+// the residual carried from the period before
+func NewInterestPosting(clientID, currency string, period time.Time, carried, accrued int64) (InterestPosting, error) {
+	total, err := money.New(carried, "").Add(money.New(accrued, ""))
+	if err != nil {
+		return InterestPosting{}, err
+	}
+	return InterestPosting{
+		ClientID: clientID,
+		Period:   period.Format("2006-01"),
+		Currency: currency,
+		Accrued:  total.Amount,
+		Amount:   total.Amount / InterestScale,
+		Residual: total.Amount % InterestScale,
+	}, nil
+}
+
+// Account is the house account on the other side of the posting
+func (p InterestPosting) Account() string {
+	if p.Amount < 0 {
+		return InterestIncomeAccount(p.Currency)
+	}
+	return InterestExpenseAccount(p.Currency)
+}
+
+// InterestRun reports what one run of the interest job did
+type InterestRun struct {
+	AsOf time.Time `json:"as_of"`
+	// ClientsAccrued is how many clients had days accrued, DaysAccrued how
+	// many days in all
+	ClientsAccrued int               `json:"clients_accrued"`
+	DaysAccrued    int               `json:"days_accrued"`
+	Postings       []InterestPosting `json:"postings"`
+	// Failed lists the clients whose accrual failed. Nothing was written
+	// for them, and the next run retries them.
+	Failed []string `json:"failed"`
+}
+
+// InterestStore is implemented by stores that accrue interest on client
+// balances: paid from InterestExpenseAccount on positive balances and
+// collected into InterestIncomeAccount on overdrafts.
+type InterestStore interface {
+	GetInterestRate(ctx context.Context, clientID string) (InterestRate, error)
+	// SetInterestRate creates or replaces the client's rate
+	SetInterestRate(ctx context.Context, rate InterestRate) (InterestRate, error)
+	// ListInterestPostings returns the client's postings, oldest first
+	ListInterestPostings(ctx context.Context, clientID string) ([]InterestPosting, error)
+	// RunInterest accrues every day that ended by asOf and was not
+	// accrued yet, for every client with a rate, and capitalizes every
+	// month whose last day it accrued. Each client is accrued in one
+	// transaction holding its lock, so runs may be repeated and may
+	// overlap. Days are accrued once, so asOf must not be in the future.
+	RunInterest(ctx context.Context, asOf time.Time) (InterestRun, error)
+}
+
+const interestRateColumns = `client_id, credit_basis_points, overdraft_basis_points, day_count, created_at, updated_at`
+
+func scanInterestRate(row pgx.Row) (InterestRate, error) {
+	var r InterestRate
+	err := row.Scan(&r.ClientID, &r.CreditBasisPoints, &r.OverdraftBasisPoints, &r.DayCount, &r.CreatedAt, &r.UpdatedAt)
+	return r, err
+}
+
+func (s *Store) GetInterestRate(ctx context.Context, clientID string) (_ InterestRate, err error) {
+	ctx, span := startSpan(ctx, "Store.GetInterestRate",
+		attribute.String("client.id", clientID))
+	defer func() { endSpan(span, err) }()
+
+	rate, err := scanInterestRate(s.db.QueryRow(ctx,
+		`SELECT `+interestRateColumns+` FROM interest_rates WHERE client_id = $1`, clientID))
+	if err != pgx.ErrNoRows {
+		return rate, err
+	}
+	var exists bool
+	if err := s.db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM clients WHERE client_id = $1)`, clientID).Scan(&exists); err != nil {
+		return InterestRate{}, err
+	}
+	if !exists {
+		return InterestRate{}, ErrClientNotFound
+	}
+	return InterestRate{}, ErrInterestRateNotFound
+}
+
+func (s *Store) SetInterestRate(ctx context.Context, rate InterestRate) (_ InterestRate, err error) {
+	ctx, span := startSpan(ctx, "Store.SetInterestRate",
+		attribute.String("client.id", rate.ClientID))
+	defer func() { endSpan(span, err) }()
+
+	if err := rate.Validate(); err != nil {
+		return InterestRate{}, err
+	}
+	rate, err = scanInterestRate(s.db.QueryRow(ctx,
+		`INSERT INTO interest_rates (client_id, credit_basis_points, overdraft_basis_points, day_count)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (client_id) DO UPDATE SET
+			credit_basis_points = EXCLUDED.credit_basis_points,
+			overdraft_basis_points = EXCLUDED.overdraft_basis_points,
+			day_count = EXCLUDED.day_count,
+			updated_at = NOW()
+		RETURNING `+interestRateColumns,
+		rate.ClientID, rate.CreditBasisPoints, rate.OverdraftBasisPoints, rate.DayCount))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return InterestRate{}, ErrClientNotFound
+	}
+	return rate, err
+}
+
+func (s *Store) ListInterestPostings(ctx context.Context, clientID string) (_ []InterestPosting, err error) {
+	ctx, span := startSpan(ctx, "Store.ListInterestPostings",
+		attribute.String("client.id", clientID))
+	defer func() { endSpan(span, err) }()
+
+	var exists bool
+	if err := s.db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM clients WHERE client_id = $1)`, clientID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrClientNotFound
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT p.client_id, p.period, c.currency, p.accrued, p.amount, p.residual,
+			COALESCE(p.entry_id::text, ''), p.created_at
+		FROM interest_postings p
+		JOIN clients c ON c.client_id = p.client_id
+		WHERE p.client_id = $1
+		ORDER BY p.period`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	postings := []InterestPosting{}
+	for rows.Next() {
+		var p InterestPosting
+		var period time.Time
+		if err := rows.Scan(&p.ClientID, &period, &p.Currency, &p.Accrued, &p.Amount, &p.Residual,
+			&p.EntryID, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		p.Period = period.Format("2006-01")
+		postings = append(postings, p)
+	}
+	return postings, rows.Err()
+}
+
+func (s *Store) RunInterest(ctx context.Context, asOf time.Time) (_ InterestRun, err error) {
+	ctx, span := startSpan(ctx, "Store.RunInterest")
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.Query(ctx, `SELECT client_id FROM interest_rates ORDER BY client_id`)
+	if err != nil {
+		return InterestRun{}, err
+	}
+	clientIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return InterestRun{}, err
+	}
+
+	run := InterestRun{AsOf: asOf, Postings: []InterestPosting{}, Failed: []string{}}
+	for _, clientID := range clientIDs {
+		days, postings, err := s.accrueInterest(ctx, clientID, asOf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return InterestRun{}, ctx.Err()
+			}
+			s.logger.ErrorContext(ctx, "interest accrual failed", "client_id", clientID, "err", err)
+			run.Failed = append(run.Failed, clientID)
+			continue
+		}
+		if days > 0 {
+			run.ClientsAccrued++
+			run.DaysAccrued += days
+		}
+		run.Postings = append(run.Postings, postings...)
+	}
+	return run, nil
+}
+
+// accrueInterest accrues the client's days up to asOf, and capitalizes
+// each month as soon as its last day is accrued
+func (s *Store) accrueInterest(ctx context.Context, clientID string, asOf time.Time) (int, []InterestPosting, error) {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var balance money.Money
+	err = tx.QueryRow(ctx,
+		`SELECT balance, currency FROM clients WHERE client_id = $1 FOR UPDATE`,
+		clientID).Scan(&balance.Amount, &balance.Currency)
+	if err != nil {
+		return 0, nil, err
+	}
+	rate, err := scanInterestRate(tx.QueryRow(ctx,
+		`SELECT `+interestRateColumns+` FROM interest_rates WHERE client_id = $1`, clientID))
+	if err != nil {
+		return 0, nil, err
+	}
+	var last *time.Time
+	err = tx.QueryRow(ctx,
+		`SELECT MAX(accrual_date) FROM interest_accruals WHERE client_id = $1`, clientID).Scan(&last)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	day := InterestDay(rate.CreatedAt)
+	if last != nil {
+		day = last.AddDate(0, 0, 1)
+	}
+	days, postings := 0, []InterestPosting{}
+	for end := InterestDay(asOf); day.Before(end); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		// Capitalized interest counts from the end of its month, whenever
+		// it was posted
+		var eod int64
+		err := tx.QueryRow(ctx,
+			`SELECT
+				(SELECT COALESCE(SUM(e.amount), 0)::bigint FROM ledger_entries e
+				WHERE e.client_id = $1 AND e.created_at < $2
+					AND NOT EXISTS (SELECT 1 FROM interest_postings p WHERE p.entry_id = e.entry_id))
+				+ (SELECT COALESCE(SUM(amount), 0)::bigint FROM interest_postings
+				WHERE client_id = $1 AND period_end < $2)`,
+			clientID, next).Scan(&eod)
+		if err != nil {
+			return 0, nil, err
+		}
+		accrued, err := rate.Accrue(day, eod)
+		if err != nil {
+			return 0, nil, err
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO interest_accruals (client_id, accrual_date, balance, credit_basis_points,
+				overdraft_basis_points, day_count, accrued)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			clientID, day, eod, rate.CreditBasisPoints, rate.OverdraftBasisPoints, rate.DayCount, accrued); err != nil {
+			return 0, nil, err
+		}
+		days++
+
+		if next.Day() != 1 {
+			continue
+		}
+		p, err := s.capitalizeInterest(ctx, tx, &balance, clientID, InterestPeriod(day))
+		if err != nil {
+			return 0, nil, err
+		}
+		postings = append(postings, p)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, nil, err
+	}
+	for _, p := range postings {
+		s.logger.InfoContext(ctx, "interest capitalized",
+			"client_id", p.ClientID, "period", p.Period, "amount", p.Amount, "currency", p.Currency)
+	}
+	return days, postings, nil
+}
+
+// capitalizeInterest posts the interest accrued over period against the
+// house account, and moves balance, the client's locked balance, along.
+func (s *Store) capitalizeInterest(ctx context.Context, tx pgx.Tx, balance *money.Money, clientID string, period time.Time) (InterestPosting, error) {
+	var carried, accrued int64
+	err := tx.QueryRow(ctx,
+		`SELECT
+			COALESCE((SELECT residual FROM interest_postings WHERE client_id = $1 ORDER BY period DESC LIMIT 1), 0),
+			(SELECT COALESCE(SUM(accrued), 0)::bigint FROM interest_accruals
+			WHERE client_id = $1 AND accrual_date >= $2 AND accrual_date < $3)`,
+		clientID, period, period.AddDate(0, 1, 0)).Scan(&carried, &accrued)
+	if err != nil {
+		return InterestPosting{}, err
+	}
+	p, err := NewInterestPosting(clientID, balance.Currency, period, carried, accrued)
+	if err != nil {
+		return InterestPosting{}, err
+	}
+
+	var entryID, counterID *string
+	if p.Amount != 0 {
+		next, err := balance.Add(money.New(p.Amount, balance.Currency))
+		if err != nil {
+			return InterestPosting{}, err
+		}
+		account := p.Account()
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO clients (client_id, balance, currency) VALUES ($1, 0, $2)
+			ON CONFLICT (client_id) DO NOTHING`, account, p.Currency); err != nil {
+			return InterestPosting{}, err
+		}
+		var house money.Money
+		err = tx.QueryRow(ctx,
+			`SELECT balance, currency FROM clients WHERE client_id = $1 FOR UPDATE`,
+			account).Scan(&house.Amount, &house.Currency)
+		if err != nil {
+			return InterestPosting{}, err
+		}
+		if house, err = house.Sub(money.New(p.Amount, p.Currency)); err != nil {
+			return InterestPosting{}, err
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE clients SET balance = $1 WHERE client_id = $2`, next.Amount, clientID); err != nil {
+			return InterestPosting{}, err
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE clients SET balance = $1 WHERE client_id = $2`, house.Amount, account); err != nil {
+			return InterestPosting{}, err
+		}
+		*balance = next
+
+		err = tx.QueryRow(ctx,
+			`INSERT INTO ledger_entries (entry_id, client_id, amount) VALUES (gen_random_uuid(), $1, $2)
+			RETURNING entry_id::text`, clientID, p.Amount).Scan(&entryID)
+		if err != nil {
+			return InterestPosting{}, err
+		}
+		err = tx.QueryRow(ctx,
+			`INSERT INTO ledger_entries (entry_id, client_id, amount) VALUES (gen_random_uuid(), $1, $2)
+			RETURNING entry_id::text`, account, -p.Amount).Scan(&counterID)
+		if err != nil {
+			return InterestPosting{}, err
+		}
+		p.EntryID = *entryID
+	}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO interest_postings (client_id, period, period_end, accrued, amount, residual,
+			entry_id, counter_entry_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING created_at`,
+		clientID, period, period.AddDate(0, 1, 0), p.Accrued, p.Amount, p.Residual,
+		entryID, counterID).Scan(&p.CreatedAt)
+	return p, err
+}
+
+// InterestJob runs the interest accrual every interval, starting right
+// away. Runs are safe to repeat, and replicas may each run the job.
+type InterestJob struct {
+	store    InterestStore
+	interval time.Duration
+	logger   *slog.Logger
+}
+
+func NewInterestJob(store InterestStore, interval time.Duration) *InterestJob {
+	return &InterestJob{store: store, interval: interval, logger: slog.Default()}
+}
+
+func (j *InterestJob) WithLogger(logger *slog.Logger) *InterestJob {
+	j.logger = logger
+	return j
+}
+
+// Run runs the job until ctx is cancelled. A failed run is logged and
+// retried at the next interval.
+func (j *InterestJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		run, err := j.store.RunInterest(ctx, time.Now())
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			j.logger.Error("interest run failed", "err", err)
+		case run.DaysAccrued > 0 || len(run.Failed) > 0:
+			j.logger.Info("interest run finished", "clients", run.ClientsAccrued, "days", run.DaysAccrued,
+				"postings", len(run.Postings), "failed", len(run.Failed))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// InterestRateRequest is the body of PUT /clients/{id}/interest-rate
+type InterestRateRequest struct {
+	CreditBasisPoints    int64  `json:"credit_basis_points"`
+	OverdraftBasisPoints int64  `json:"overdraft_basis_points"`
+	DayCount             string `json:"day_count"`
+}
+
+// InterestPostingsResponse lists a client's interest postings
+type InterestPostingsResponse struct {
+	ClientID string            `json:"client_id"`
+	Postings []InterestPosting `json:"postings"`
+}
+
+func (h *Handler) interestStore(w http.ResponseWriter, r *http.Request) (InterestStore, bool) {
+	store, ok := h.store.(InterestStore)
+	if !ok {
+		writeProblem(w, r, http.StatusNotImplemented, CodeNotImplemented, "interest is not supported by this store")
+	}
+	return store, ok
+}
+
+// getInterestRate serves GET /clients/{id}/interest-rate
+func (h *Handler) getInterestRate(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.interestStore(w, r)
+	if !ok {
+		return
+	}
+	clientID := r.PathValue("id")
+	if !authorizeClient(w, r, clientID) {
+		return
+	}
+	rate, err := store.GetInterestRate(r.Context(), clientID)
+	if err != nil {
+		h.writeError(w, r, "get interest rate failed", err, "client_id", clientID)
+		return
+	}
+	writeJSON(w, http.StatusOK, rate)
+}
+
+// setInterestRate serves PUT /clients/{id}/interest-rate
+func (h *Handler) setInterestRate(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	store, ok := h.interestStore(w, r)
+	if !ok {
+		return
+	}
+	clientID := r.PathValue("id")
+	var req InterestRateRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	rate := InterestRate{
+		ClientID:             clientID,
+		CreditBasisPoints:    req.CreditBasisPoints,
+		OverdraftBasisPoints: req.OverdraftBasisPoints,
+		DayCount:             req.DayCount,
+	}
+	if err := rate.Validate(); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	rate, err := store.SetInterestRate(r.Context(), rate)
+	if err != nil {
+		h.writeError(w, r, "set interest rate failed", err, "client_id", clientID)
+		return
+	}
+	h.logger.InfoContext(r.Context(), "interest rate set",
+		"client_id", clientID, "credit_basis_points", rate.CreditBasisPoints,
+		"overdraft_basis_points", rate.OverdraftBasisPoints, "day_count", rate.DayCount,
+		"actor", Actor(r.Context()))
+	writeJSON(w, http.StatusOK, rate)
+}
+
+// listInterestPostings serves GET /clients/{id}/interest-postings
+func (h *Handler) listInterestPostings(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.interestStore(w, r)
+	if !ok {
+		return
+	}
+	clientID := r.PathValue("id")
+	if !authorizeClient(w, r, clientID) {
+		return
+	}
+	postings, err := store.ListInterestPostings(r.Context(), clientID)
+	if err != nil {
+		h.writeError(w, r, "list interest postings failed", err, "client_id", clientID)
+		return
+	}
+	writeJSON(w, http.StatusOK, InterestPostingsResponse{ClientID: clientID, Postings: postings})
+}
+
+// runInterest serves POST /interest-runs, which runs the interest job
+// now instead of waiting for its next interval
+func (h *Handler) runInterest(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	store, ok := h.interestStore(w, r)
+	if !ok {
+		return
+	}
+	run, err := store.RunInterest(r.Context(), time.Now())
+	if err != nil {
+		h.writeError(w, r, "interest run failed", err)
+		return
+	}
+	h.logger.InfoContext(r.Context(), "interest run finished",
+		"clients", run.ClientsAccrued, "days", run.DaysAccrued, "postings", len(run.Postings),
+		"failed", len(run.Failed), "actor", Actor(r.Context()))
+	writeJSON(w, http.StatusOK, run)
+}
@@ -0,0 +1,191 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koki1610168/go-payment-ledger/internal/memstore"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+func TestHandler_InterestRates(t *testing.T) {
+	store := memstore.New()
+	seed(t, store, "client_001", 10000)
+	handler := newHandler(store)
+	admin := &server.Principal{ID: "key:finance", ClientIDs: []string{server.AnyClient}, Admin: true}
+	owner := &server.Principal{ID: "key:portal", ClientIDs: []string{"client_001"}}
+	body := `{"credit_basis_points":150,"overdraft_basis_points":1800,"day_count":"act/360"}`
+
+	res := serveAs(handler, owner, http.MethodGet, server.V1+"/clients/client_001/interest-rate", "")
+	if res.Code != http.StatusNotFound || !strings.Contains(res.Body.String(), server.CodeInterestRateNotFound) {
+		t.Errorf("get before set: got %d (%s), want %d", res.Code, res.Body, http.StatusNotFound)
+	}
+	res = serveAs(handler, owner, http.MethodPut, server.V1+"/clients/client_001/interest-rate", body)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("set as owner: got %d, want %d", res.Code, http.StatusForbidden)
+	}
+	res = serveAs(handler, admin, http.MethodPut, server.V1+"/clients/client_001/interest-rate", body)
+	if res.Code != http.StatusOK {
+		t.Fatalf("set as admin: got %d: %s", res.Code, res.Body)
+	}
+	got := decodeJSON[server.InterestRate](t, res)
+	if got.ClientID != "client_001" || got.OverdraftBasisPoints != 1800 || got.DayCount != server.DayCountActual360 {
+		t.Errorf("unexpected rate %+v", got)
+	}
+	res = serveAs(handler, owner, http.MethodGet, server.V1+"/clients/client_001/interest-rate", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("get as owner: got %d: %s", res.Code, res.Body)
+	}
+	if got := decodeJSON[server.InterestRate](t, res); got.CreditBasisPoints != 150 {
+		t.Errorf("unexpected rate %+v", got)
+	}
+
+	res = serveAs(handler, owner, http.MethodPost, server.V1+"/interest-runs", "")
+	if res.Code != http.StatusForbidden {
+		t.Errorf("run as owner: got %d, want %d", res.Code, http.StatusForbidden)
+	}
+	res = serveAs(handler, admin, http.MethodPost, server.V1+"/interest-runs", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("run as admin: got %d: %s", res.Code, res.Body)
+	}
+	// The rate was set today, which has not ended yet
+	if run := decodeJSON[server.InterestRun](t, res); run.AsOf.IsZero() || run.DaysAccrued != 0 || len(run.Failed) != 0 {
+		t.Errorf("unexpected run %+v", run)
+	}
+
+	// Two months on, at least one month has been capitalized
+	if _, err := store.RunInterest(context.Background(), got.CreatedAt.AddDate(0, 2, 0)); err != nil {
+		t.Fatalf("run interest: %v", err)
+	}
+	res = serveAs(handler, owner, http.MethodGet, server.V1+"/clients/client_001/interest-postings", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("list postings: got %d: %s", res.Code, res.Body)
+	}
+	postings := decodeJSON[server.InterestPostingsResponse](t, res)
+	if postings.ClientID != "client_001" || len(postings.Postings) == 0 {
+		t.Fatalf("unexpected postings %+v", postings)
+	}
+	if p := postings.Postings[0]; p.Amount <= 0 || p.Currency != "JPY" {
+		t.Errorf("unexpected posting %+v", p)
+	}
+}
+
+func TestHandler_InterestValidation(t *testing.T) {
+	store := memstore.New()
+	seed(t, store, "client_001", 10000)
+	handler := newHandler(store)
+	admin := &server.Principal{ID: "key:finance", ClientIDs: []string{server.AnyClient}, Admin: true}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"rate of unknown client", http.MethodGet, "/clients/client_404/interest-rate", "", http.StatusNotFound},
+		{"postings of unknown client", http.MethodGet, "/clients/client_404/interest-postings", "", http.StatusNotFound},
+		{"set without day count", http.MethodPut, "/clients/client_001/interest-rate", `{"credit_basis_points":100}`, http.StatusBadRequest},
+		{"set negative rate", http.MethodPut, "/clients/client_001/interest-rate",
+			`{"credit_basis_points":-5,"day_count":"act/365"}`, http.StatusBadRequest},
+		{"set for system account", http.MethodPut, "/clients/" + server.InterestExpenseAccount("JPY") + "/interest-rate",
+			`{"day_count":"act/365"}`, http.StatusBadRequest},
+		{"set for unknown client", http.MethodPut, "/clients/client_404/interest-rate",
+			`{"credit_basis_points":100,"day_count":"act/365"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serveAs(handler, admin, tt.method, server.V1+tt.path, tt.body)
+			if res.Code != tt.wantStatus {
+				t.Errorf("got %d, want %d: %s", res.Code, tt.wantStatus, res.Body)
+			}
+		})
+	}
+}
+
+// Without an admin key a caller could give itself any rate and accrue
+// interest out of nothing
+func TestHandler_InterestNeedsAdminKey(t *testing.T) {
+	store := memstore.New()
+	seed(t, store, "client_001", 10000)
+	handler := newHandler(store)
+	portal := &server.Principal{ID: "key:portal", ClientIDs: []string{"client_001"}}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"set rate", http.MethodPut, "/clients/client_001/interest-rate", `{"credit_basis_points":100000,"day_count":"act/365"}`},
+		{"run", http.MethodPost, "/interest-runs", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := serveAs(handler, nil, tt.method, server.V1+tt.path, tt.body); res.Code != http.StatusUnauthorized {
+				t.Errorf("without a key: got %d, want %d", res.Code, http.StatusUnauthorized)
+			}
+			if res := serveAs(handler, portal, tt.method, server.V1+tt.path, tt.body); res.Code != http.StatusForbidden {
+				t.Errorf("with a client key: got %d, want %d", res.Code, http.StatusForbidden)
+			}
+		})
+	}
+	if _, err := store.GetInterestRate(t.Context(), "client_001"); err == nil {
+		t.Error("a rate was set without an admin key")
+	}
+}
+
+func TestHandler_InterestResponsesConformToOpenAPI(t *testing.T) {
+	router := server.OpenAPIRouter(t)
+	store := memstore.New()
+	seed(t, store, "client_001", 10000)
+	handler := newHandler(store)
+	admin := &server.Principal{ID: "key:ops", ClientIDs: []string{server.AnyClient}, Admin: true}
+	portal := &server.Principal{ID: "key:portal", ClientIDs: []string{"client_001"}}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		as     *server.Principal
+		want   int
+	}{
+		{"interest rate not set", http.MethodGet, "/clients/client_001/interest-rate", "", portal, http.StatusNotFound},
+		{"set interest rate", http.MethodPut, "/clients/client_001/interest-rate",
+			`{"credit_basis_points":150,"overdraft_basis_points":1800,"day_count":"act/365"}`, admin, http.StatusOK},
+		{"set interest rate bad day count", http.MethodPut, "/clients/client_001/interest-rate",
+			`{"credit_basis_points":150,"day_count":"act/364"}`, admin, http.StatusBadRequest},
+		{"set interest rate not admin", http.MethodPut, "/clients/client_001/interest-rate",
+			`{"credit_basis_points":150,"day_count":"act/365"}`, portal, http.StatusForbidden},
+		{"interest rate", http.MethodGet, "/clients/client_001/interest-rate", "", portal, http.StatusOK},
+		{"run interest", http.MethodPost, "/interest-runs", "", admin, http.StatusOK},
+		{"run interest not admin", http.MethodPost, "/interest-runs", "", portal, http.StatusForbidden},
+		{"interest postings", http.MethodGet, "/clients/client_001/interest-postings", "", portal, http.StatusOK},
+		{"interest postings unknown client", http.MethodGet, "/clients/nobody/interest-postings", "", nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.ServeConforming(t, router, handler, request(tt.method, server.V1+tt.path, tt.body, tt.as), tt.want)
+		})
+	}
+}
+
+// Postings conform to the spec once interest has been capitalized
+func TestHandler_InterestPostingsConformToOpenAPI(t *testing.T) {
+	store := memstore.New()
+	seed(t, store, "client_001", 10000)
+	rate, err := store.SetInterestRate(context.Background(), server.InterestRate{ClientID: "client_001",
+		CreditBasisPoints: 150, DayCount: server.DayCountActual365})
+	if err != nil {
+		t.Fatalf("set interest rate: %v", err)
+	}
+	if _, err := store.RunInterest(context.Background(), rate.CreatedAt.Add(62*24*time.Hour)); err != nil {
+		t.Fatalf("run interest: %v", err)
+	}
+	req := request(http.MethodGet, server.V1+"/clients/client_001/interest-postings", "", nil)
+	server.ServeConforming(t, server.OpenAPIRouter(t), newHandler(store), req, http.StatusOK)
+}
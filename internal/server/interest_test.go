@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInterestRate_Accrue(t *testing.T) {
+	day := time.Date(2025, time.March, 10, 0, 0, 0, 0, time.UTC)
+	rate := InterestRate{CreditBasisPoints: 365, OverdraftBasisPoints: 1825, DayCount: DayCountActual365}
+
+	tests := []struct {
+		name    string
+		rate    InterestRate
+		day     time.Time
+		balance int64
+		want    int64
+	}{
+		{"credit", rate, day, 100000, 10 * InterestScale},
+		{"fraction of a minor unit", rate, day, 1, 100},
+		{"overdraft", rate, day, -100000, -50 * InterestScale},
+		{"zero balance", rate, day, 0, 0},
+		{"act/360", InterestRate{CreditBasisPoints: 360, DayCount: DayCountActual360}, day, 100000, 10 * InterestScale},
+		{"act/act in a leap year", InterestRate{CreditBasisPoints: 366, DayCount: DayCountActualActual},
+			time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC), 100000, 10 * InterestScale},
+		{"act/act in a common year", InterestRate{CreditBasisPoints: 365, DayCount: DayCountActualActual}, day, 100000, 10 * InterestScale},
+		{"30/360 on the 31st", InterestRate{CreditBasisPoints: 360, DayCount: DayCount30360},
+			time.Date(2025, time.March, 31, 0, 0, 0, 0, time.UTC), 100000, 0},
+		{"30/360 at the end of February", InterestRate{CreditBasisPoints: 360, DayCount: DayCount30360},
+			time.Date(2025, time.February, 28, 0, 0, 0, 0, time.UTC), 100000, 30 * InterestScale},
+		{"30/360 at the end of a leap February", InterestRate{CreditBasisPoints: 360, DayCount: DayCount30360},
+			time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC), 100000, 20 * InterestScale},
+		{"30/360 on the 30th", InterestRate{CreditBasisPoints: 360, DayCount: DayCount30360},
+			time.Date(2025, time.April, 30, 0, 0, 0, 0, time.UTC), 100000, 10 * InterestScale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rate.Accrue(tt.day, tt.balance)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("accrued %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// Every month of a 30/360 year earns the same, whatever its length
+func TestInterestRate_Accrue30360Months(t *testing.T) {
+	rate := InterestRate{CreditBasisPoints: 360, DayCount: DayCount30360}
+	for _, year := range []int{2024, 2025} {
+		for m := time.January; m <= time.December; m++ {
+			var sum int64
+			for day := time.Date(year, m, 1, 0, 0, 0, 0, time.UTC); day.Month() == m; day = day.AddDate(0, 0, 1) {
+				a, err := rate.Accrue(day, 100000)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sum += a
+			}
+			if sum != 300*InterestScale {
+				t.Errorf("%s %d accrued %d, want %d", m, year, sum, 300*InterestScale)
+			}
+		}
+	}
+}
+
+func TestNewInterestPosting(t *testing.T) {
+	period := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		carried, accrued int64
+		wantAmount       int64
+		wantResidual     int64
+		wantAccount      string
+	}{
+		{"whole units", 0, 3 * InterestScale, 3, 0, InterestExpenseAccount("JPY")},
+		{"residual carried forward", 0, 3*InterestScale + 250, 3, 250, InterestExpenseAccount("JPY")},
+		{"residual carried in", InterestScale - 100, 100, 1, 0, InterestExpenseAccount("JPY")},
+		{"less than a unit", 0, InterestScale - 1, 0, InterestScale - 1, InterestExpenseAccount("JPY")},
+		{"overdraft rounds toward zero", 0, -2*InterestScale - 7, -2, -7, InterestIncomeAccount("JPY")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewInterestPosting("client_001", "JPY", period, tt.carried, tt.accrued)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if p.Amount != tt.wantAmount || p.Residual != tt.wantResidual || p.Period != "2025-01" {
+				t.Errorf("got %+v, want an amount of %d and a residual of %d", p, tt.wantAmount, tt.wantResidual)
+			}
+			if p.Accrued != tt.carried+tt.accrued {
+				t.Errorf("accrued is %d, want %d", p.Accrued, tt.carried+tt.accrued)
+			}
+			if got := p.Account(); got != tt.wantAccount {
+				t.Errorf("account is %s, want %s", got, tt.wantAccount)
+			}
+		})
+	}
+}
+
+func TestInterestRate_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		rate      InterestRate
+		wantField string
+	}{
+		{"valid", InterestRate{ClientID: "client_001", CreditBasisPoints: 150, OverdraftBasisPoints: 1800, DayCount: DayCount30360}, ""},
+		{"zero rates", InterestRate{ClientID: "client_001", DayCount: DayCountActual365}, ""},
+		{"system account", InterestRate{ClientID: InterestExpenseAccount("JPY"), DayCount: DayCountActual365}, "client_id"},
+		{"negative credit", InterestRate{ClientID: "client_001", CreditBasisPoints: -1, DayCount: DayCountActual365}, "credit_basis_points"},
+		{"overdraft above 100%", InterestRate{ClientID: "client_001", OverdraftBasisPoints: 10001, DayCount: DayCountActual365}, "overdraft_basis_points"},
+		{"no day count", InterestRate{ClientID: "client_001"}, "day_count"},
+		{"unknown day count", InterestRate{ClientID: "client_001", DayCount: "act/364"}, "day_count"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rate.Validate()
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var invalid ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("got %v, want a ValidationError", err)
+			}
+			for _, f := range invalid {
+				if f.Field == tt.wantField {
+					return
+				}
+			}
+			t.Errorf("%v does not report %s", err, tt.wantField)
+		})
+	}
+}
+
+func TestHandler_InterestUnsupportedStore(t *testing.T) {
+	res := serveAs(NewHandler(NewStubClient()), nil, http.MethodGet, V1+"/clients/client_001/interest-rate", "")
+	if res.Code != http.StatusNotImplemented {
+		t.Errorf("got %d, want %d", res.Code, http.StatusNotImplemented)
+	}
+}
+
+// interestJobStub signals each run and fails the first. The job calls
+// nothing else.
+type interestJobStub struct {
+	InterestStore
+	runs  atomic.Int32
+	calls chan struct{}
+}
+
+func (s *interestJobStub) RunInterest(ctx context.Context, asOf time.Time) (InterestRun, error) {
+	select {
+	case s.calls <- struct{}{}:
+	default:
+	}
+	if s.runs.Add(1) == 1 {
+		return InterestRun{}, errors.New("database is down")
+	}
+	return InterestRun{AsOf: asOf}, nil
+}
+
+func TestInterestJob_RunsUntilCancelled(t *testing.T) {
+	store := &interestJobStub{calls: make(chan struct{}, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewInterestJob(store, time.Millisecond).WithLogger(discardLogger()).Run(ctx)
+		close(done)
+	}()
+
+	// A failed run does not stop the job
+	for range 3 {
+		select {
+		case <-store.calls:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the job did not run")
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job did not stop")
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/koki1610168/go-payment-ledger/internal/config"
+)
+
+// Every phase of a request is bounded so a slow or malicious client cannot
+// hold a connection open forever
+func NewHTTPServer(cfg config.Server, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+type ServeOptions struct {
+	// OnDrain runs as soon as ctx is cancelled, before the listener closes
+	OnDrain func()
+	// DrainDelay keeps accepting requests after OnDrain so load balancers
+	// have time to notice the failing readiness probe
+	DrainDelay time.Duration
+	// ShutdownTimeout bounds how long in-flight requests may take to finish
+	ShutdownTimeout time.Duration
+}
+
+// Serve runs srv on ln, over TLS when srv.TLSConfig is set, until ctx is cancelled, then stops accepting
+// connections and waits for in-flight requests within ShutdownTimeout.
+// Request contexts are not derived from ctx, so a shutdown signal does not
+// abort transactions that are already running.
+func Serve(ctx context.Context, srv *http.Server, ln net.Listener, opts ServeOptions) error {
+	errCh := make(chan error, 1)
+	go func() {
+		// Certificates come from srv.TLSConfig, hence the empty file names
+		if srv.TLSConfig != nil {
+			errCh <- srv.ServeTLS(ln, "", "")
+			return
+		}
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	if opts.OnDrain != nil {
+		opts.OnDrain()
+	}
+	if opts.DrainDelay > 0 {
+		time.Sleep(opts.DrainDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("shutdown http server: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Workers tracks background goroutines so shutdown can wait for them
+type Workers struct {
+	wg sync.WaitGroup
+}
+
+// Go runs fn in a goroutine. fn must return once ctx is cancelled.
+func (w *Workers) Go(ctx context.Context, fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(ctx)
+	}()
+}
+
+// Wait blocks until every worker has returned or ctx is done
+func (w *Workers) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background workers did not stop: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/koki1610168/go-payment-ledger/internal/config"
+)
+
+func TestServe_DrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := NewHTTPServer(config.Default().Server, handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var drained atomic.Bool
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- Serve(ctx, srv, ln, ServeOptions{
+			OnDrain:         func() { drained.Store(true) },
+			ShutdownTimeout: 5 * time.Second,
+		})
+	}()
+
+	body := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		body <- string(b)
+	}()
+
+	<-started
+	cancel()
+
+	// Shutdown must wait for the request that is still running
+	select {
+	case err := <-serveErr:
+		t.Fatalf("Serve returned before the in-flight request finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if got := <-body; got != "done" {
+		t.Errorf("got body %q, want %q", got, "done")
+	}
+	if err := <-serveErr; err != nil {
+		t.Errorf("Serve returned %v, want nil", err)
+	}
+	if !drained.Load() {
+		t.Error("expected OnDrain to be called")
+	}
+}
+
+func TestServe_ShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(2 * time.Second)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := NewHTTPServer(config.Default().Server, handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- Serve(ctx, srv, ln, ServeOptions{ShutdownTimeout: 50 * time.Millisecond})
+	}()
+
+	go http.Get("http://" + ln.Addr().String())
+	<-started
+	cancel()
+
+	if err := <-serveErr; err == nil {
+		t.Error("expected an error when in-flight requests outlive the timeout")
+	}
+}
+
+func TestWorkers_WaitForReturn(t *testing.T) {
+	var w Workers
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var stopped atomic.Bool
+	w.Go(ctx, func(ctx context.Context) {
+		<-ctx.Done()
+		stopped.Store(true)
+	})
+
+	cancel()
+	if err := w.Wait(context.Background()); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if !stopped.Load() {
+		t.Error("expected worker to have stopped")
+	}
+}
+
+func TestWorkers_WaitTimesOut(t *testing.T) {
+	var w Workers
+	block := make(chan struct{})
+	defer close(block)
+	w.Go(context.Background(), func(ctx context.Context) { <-block })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := w.Wait(ctx); err == nil {
+		t.Error("expected a timeout error")
+	}
+}
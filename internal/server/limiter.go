@@ -0,0 +1,162 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limit describes a token bucket: Rate tokens per second refill a bucket
+// holding at most Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Decision is the outcome of spending tokens from a bucket
+type Decision struct {
+	Allowed bool
+	// Whole tokens left after this request
+	Remaining int
+	// Time until the bucket is full again
+	ResetAfter time.Duration
+	// Time until the request could succeed, zero when it was allowed
+	RetryAfter time.Duration
+}
+
+// LimiterBackend holds bucket state. Take spends cost tokens from the
+// bucket under key atomically, so concurrent callers (and replicas, for
+// shared backends) cannot overspend it.
+type LimiterBackend interface {
+	Take(ctx context.Context, key string, limit Limit, cost int) (Decision, error)
+}
+
+// decide builds a Decision from the tokens left in a bucket
+func decide(limit Limit, tokens float64, cost int, allowed bool) Decision {
+	d := Decision{
+		Allowed:    allowed,
+		Remaining:  max(int(math.Floor(tokens)), 0),
+		ResetAfter: refillTime(float64(limit.Burst)-tokens, limit.Rate),
+	}
+	if !allowed {
+		d.RetryAfter = refillTime(float64(cost)-tokens, limit.Rate)
+	}
+	return d
+}
+
+func refillTime(missing, rate float64) time.Duration {
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / rate * float64(time.Second))
+}
+
+type limiterEntry struct {
+	key      string
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// MemoryBackend keeps buckets in process memory, so every replica
+// enforces its own limit.
+// Buckets live in an LRU list: idle ones are evicted by Run and the least
+// recently used one is dropped when the backend is full, so memory stays
+// bounded no matter how many callers show up.
+type MemoryBackend struct {
+	clients    map[string]*list.Element
+	lru        *list.List
+	mu         sync.Mutex
+	idleTTL    time.Duration
+	maxEntries int
+	metrics    *Metrics
+	now        func() time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		clients:    make(map[string]*list.Element),
+		lru:        list.New(),
+		idleTTL:    DefaultLimiterIdleTTL,
+		maxEntries: DefaultLimiterMaxEntries,
+		now:        time.Now,
+	}
+}
+
+func (m *MemoryBackend) Take(_ context.Context, key string, limit Limit, cost int) (Decision, error) {
+	lim := m.limiter(key, limit)
+	now := m.now()
+	allowed := lim.AllowN(now, cost)
+	return decide(limit, lim.TokensAt(now), cost, allowed), nil
+}
+
+func (m *MemoryBackend) limiter(key string, limit Limit) *rate.Limiter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if el, exists := m.clients[key]; exists {
+		entry := el.Value.(*limiterEntry)
+		entry.lastSeen = now
+		m.lru.MoveToFront(el)
+		return entry.limiter
+	}
+
+	m.evictIdleLocked(now)
+	for m.maxEntries > 0 && m.lru.Len() >= m.maxEntries {
+		m.removeLocked(m.lru.Back())
+	}
+
+	entry := &limiterEntry{key: key, limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst), lastSeen: now}
+	m.clients[key] = m.lru.PushFront(entry)
+	m.metrics.observeLimiterKeys(m.lru.Len())
+	return entry.limiter
+}
+
+// The list is ordered by lastSeen, so idle entries are all at the back
+func (m *MemoryBackend) evictIdleLocked(now time.Time) {
+	for el := m.lru.Back(); el != nil; el = m.lru.Back() {
+		if now.Sub(el.Value.(*limiterEntry).lastSeen) < m.idleTTL {
+			return
+		}
+		m.removeLocked(el)
+	}
+}
+
+func (m *MemoryBackend) removeLocked(el *list.Element) {
+	delete(m.clients, el.Value.(*limiterEntry).key)
+	m.lru.Remove(el)
+}
+
+// Len reports how many buckets are currently held
+func (m *MemoryBackend) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+// Run evicts idle buckets in the background until ctx is cancelled
+func (m *MemoryBackend) Run(ctx context.Context) {
+	interval := m.idleTTL / 2
+	if interval <= 0 || interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.mu.Lock()
+			m.evictIdleLocked(m.now())
+			n := m.lru.Len()
+			m.mu.Unlock()
+			m.metrics.observeLimiterKeys(n)
+		}
+	}
+}
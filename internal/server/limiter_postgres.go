@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresBackend keeps buckets in the rate_limits table so every replica
+// draws from the same limit. It implements GCRA: each bucket is a single
+// timestamp, the theoretical arrival time (TAT), and a request of cost n
+// advances it by n emission intervals (1/rate) provided it stays within
+// burst intervals of now. The check and the update are one statement, and
+// the database clock is used throughout, so replicas with skewed clocks
+// still agree.
+type PostgresBackend struct {
+	pool     *pgxpool.Pool
+	interval time.Duration
+	logger   *slog.Logger
+}
+
+func NewPostgresBackend(pool *pgxpool.Pool) *PostgresBackend {
+	return &PostgresBackend{pool: pool, interval: time.Minute, logger: slog.Default()}
+}
+
+// WithLogger sets the logger used to report failed sweeps
+func (p *PostgresBackend) WithLogger(logger *slog.Logger) *PostgresBackend {
+	p.logger = logger
+	return p
+}
+
+func (p *PostgresBackend) Take(ctx context.Context, key string, limit Limit, cost int) (Decision, error) {
+	emission := 1 / limit.Rate
+	increment := emission * float64(cost)
+	tolerance := emission * float64(limit.Burst)
+
+	// ahead is how far the new TAT is past now, in seconds
+	var ahead float64
+	err := p.pool.QueryRow(ctx,
+		`INSERT INTO rate_limits AS r (key, tat)
+		VALUES ($1, now() + make_interval(secs => $2))
+		ON CONFLICT (key) DO UPDATE
+		SET tat = greatest(r.tat, now()) + make_interval(secs => $2)
+		WHERE greatest(r.tat, now()) + make_interval(secs => $2) <= now() + make_interval(secs => $3)
+		RETURNING extract(epoch FROM r.tat - now())::float8`,
+		key, increment, tolerance,
+	).Scan(&ahead)
+	if err == nil {
+		return gcraDecision(limit, ahead, emission, tolerance, cost, true), nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return Decision{}, fmt.Errorf("take rate limit tokens: %w", err)
+	}
+
+	// Not enough tokens: the row was left untouched, read where it stands
+	err = p.pool.QueryRow(ctx,
+		`SELECT extract(epoch FROM greatest(tat, now()) - now())::float8
+		FROM rate_limits WHERE key = $1`,
+		key,
+	).Scan(&ahead)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return Decision{}, fmt.Errorf("read rate limit bucket: %w", err)
+	}
+	return gcraDecision(limit, ahead, emission, tolerance, cost, false), nil
+}
+
+func gcraDecision(limit Limit, ahead, emission, tolerance float64, cost int, allowed bool) Decision {
+	// Timestamps have microsecond precision; round away the float noise so
+	// a full token does not read as 0.999999
+	tokens := math.Round((tolerance-ahead)/emission*1e6) / 1e6
+	return decide(limit, tokens, cost, allowed)
+}
+
+// Run deletes full buckets until ctx is cancelled. A row whose TAT has
+// passed holds no state, so removing it changes no decision.
+func (p *PostgresBackend) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := p.pool.Exec(ctx, `DELETE FROM rate_limits WHERE tat < now()`); err != nil && ctx.Err() == nil {
+				p.logger.Warn("sweep rate limit buckets", "err", err)
+			}
+		}
+	}
+}
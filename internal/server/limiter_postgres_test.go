@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestPostgresBackend(t *testing.T) *PostgresBackend {
+	t.Helper()
+	ctx, db, _ := newTestStore(t)
+	if err := Migrate(ctx, db.Pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return NewPostgresBackend(db.Pool)
+}
+
+func TestPostgresBackend(t *testing.T) {
+	testLimiterBackend(t, newTestPostgresBackend(t))
+}
+
+func TestPostgresBackend_SharedAcrossReplicas(t *testing.T) {
+	// Two limiters over one table behave as one
+	backend := newTestPostgresBackend(t)
+	first := NewRateLimiter(0.001, 2).WithBackend(backend)
+	second := NewRateLimiter(0.001, 2).WithBackend(NewPostgresBackend(backend.pool))
+	p := &Principal{ID: "key:" + uniqueKey(t)}
+
+	codes := []int{
+		serveLimited(first.Middleware(dummyHandler()), p).Code,
+		serveLimited(second.Middleware(dummyHandler()), p).Code,
+		serveLimited(first.Middleware(dummyHandler()), p).Code,
+	}
+	want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Errorf("request %d: got %d, want %d", i+1, codes[i], want[i])
+		}
+	}
+}
+
+func TestPostgresBackend_RunSweepsFullBuckets(t *testing.T) {
+	backend := newTestPostgresBackend(t)
+	backend.interval = 10 * time.Millisecond
+	key := uniqueKey(t)
+
+	// 1000 tokens per second refills within a millisecond
+	if _, err := backend.Take(context.Background(), key, Limit{Rate: 1000, Burst: 1}, 1); err != nil {
+		t.Fatalf("take: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	go backend.Run(ctx)
+
+	for ctx.Err() == nil {
+		var n int
+		backend.pool.QueryRow(ctx, `SELECT count(*) FROM rate_limits WHERE key = $1`, key).Scan(&n)
+		if n == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("full bucket was not swept")
+}
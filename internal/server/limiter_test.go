@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func uniqueKey(t *testing.T) string {
+	t.Helper()
+	key, err := NewIdempotencyKey(t)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return key
+}
+
+func serveLimited(handler http.Handler, p *Principal) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if p != nil {
+		req = req.WithContext(WithPrincipal(req.Context(), p))
+	}
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	return res
+}
+
+// testLimiterBackend checks the behaviour every LimiterBackend must share.
+// Keys are made unique per run so a shared backend can be reused.
+func testLimiterBackend(t *testing.T, backend LimiterBackend) {
+	ctx := context.Background()
+	prefix := uniqueKey(t) + "|"
+
+	t.Run("spends the burst then rejects", func(t *testing.T) {
+		key := prefix + "burst"
+		limit := Limit{Rate: 1, Burst: 3}
+		for i := 0; i < 3; i++ {
+			d, err := backend.Take(ctx, key, limit, 1)
+			if err != nil {
+				t.Fatalf("take %d: %v", i+1, err)
+			}
+			if !d.Allowed {
+				t.Fatalf("take %d: rejected within burst", i+1)
+			}
+			if d.Remaining != 2-i {
+				t.Errorf("take %d: remaining %d, want %d", i+1, d.Remaining, 2-i)
+			}
+		}
+
+		d, err := backend.Take(ctx, key, limit, 1)
+		if err != nil {
+			t.Fatalf("take: %v", err)
+		}
+		if d.Allowed {
+			t.Fatal("expected the request past the burst to be rejected")
+		}
+		if d.RetryAfter <= 0 || d.RetryAfter > time.Second {
+			t.Errorf("RetryAfter = %v, want within one emission interval", d.RetryAfter)
+		}
+		if d.ResetAfter <= 2*time.Second || d.ResetAfter > 3*time.Second {
+			t.Errorf("ResetAfter = %v, want about 3s", d.ResetAfter)
+		}
+	})
+
+	t.Run("cost takes several tokens", func(t *testing.T) {
+		key := prefix + "cost"
+		limit := Limit{Rate: 1, Burst: 10}
+		d, err := backend.Take(ctx, key, limit, 7)
+		if err != nil || !d.Allowed || d.Remaining != 3 {
+			t.Fatalf("got %+v, %v; want allowed with 3 remaining", d, err)
+		}
+		d, err = backend.Take(ctx, key, limit, 5)
+		if err != nil || d.Allowed {
+			t.Fatalf("got %+v, %v; want rejected", d, err)
+		}
+		// 2 tokens missing at 1 per second
+		if d.RetryAfter < time.Second || d.RetryAfter > 2*time.Second {
+			t.Errorf("RetryAfter = %v, want about 2s", d.RetryAfter)
+		}
+		// A rejected request spends nothing
+		d, err = backend.Take(ctx, key, limit, 3)
+		if err != nil || !d.Allowed {
+			t.Fatalf("got %+v, %v; want allowed", d, err)
+		}
+	})
+
+	t.Run("keys are independent", func(t *testing.T) {
+		limit := Limit{Rate: 1, Burst: 1}
+		backend.Take(ctx, prefix+"a", limit, 1)
+		d, err := backend.Take(ctx, prefix+"b", limit, 1)
+		if err != nil || !d.Allowed {
+			t.Fatalf("got %+v, %v; want allowed", d, err)
+		}
+	})
+
+	t.Run("concurrent takes never overspend", func(t *testing.T) {
+		key := prefix + "concurrent"
+		limit := Limit{Rate: 0.001, Burst: 20}
+		var allowed atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d, err := backend.Take(ctx, key, limit, 1)
+				if err != nil {
+					t.Errorf("take: %v", err)
+					return
+				}
+				if d.Allowed {
+					allowed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if got := allowed.Load(); got != 20 {
+			t.Errorf("%d requests allowed, want 20", got)
+		}
+	})
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testLimiterBackend(t, NewMemoryBackend())
+}
+
+type failingBackend struct{}
+
+func (failingBackend) Take(context.Context, string, Limit, int) (Decision, error) {
+	return Decision{}, context.DeadlineExceeded
+}
+
+func TestRateLimiter_FailsOpenWhenBackendErrors(t *testing.T) {
+	limiter := NewRateLimiter(1, 1).WithBackend(failingBackend{}).WithLogger(discardLogger())
+	handler := limiter.Middleware(dummyHandler())
+
+	for i := 0; i < 3; i++ {
+		res := serveLimited(handler, nil)
+		if res.Code != http.StatusOK {
+			t.Errorf("request %d: got %d, want %d", i+1, res.Code, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID_EchoesValidHeader(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	if seen != "abc-123" {
+		t.Errorf("got request id %q in context, want %q", seen, "abc-123")
+	}
+	if got := res.Header().Get(RequestIDHeader); got != "abc-123" {
+		t.Errorf("got response header %q, want %q", got, "abc-123")
+	}
+}
+
+func TestRequestID_ReplacesInvalidHeader(t *testing.T) {
+	handler := RequestID(dummyHandler())
+
+	for _, id := range []string{"", "has spaces", "line\nbreak", strings.Repeat("a", 129)} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set(RequestIDHeader, id)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		got := res.Header().Get(RequestIDHeader)
+		if got == "" || got == id {
+			t.Errorf("request id %q: got %q, want a generated id", id, got)
+		}
+	}
+}
+
+func TestAccessLog_IncludesRequestIDAndStatus(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "info", "json")
+	if err != nil {
+		t.Fatalf("new logger: %v", err)
+	}
+
+	store := NewStubClient()
+	handler := RequestID(AccessLog(logger, NewHandler(store).WithLogger(logger)))
+
+	req := httptest.NewRequest(http.MethodGet, "/clients/missing/balance", nil)
+	req.Header.Set(RequestIDHeader, "req-42")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2:\n%s", len(lines), buf.String())
+	}
+
+	var access map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &access); err != nil {
+		t.Fatalf("decode access log: %v", err)
+	}
+	if access["request_id"] != "req-42" {
+		t.Errorf("got request_id %v, want req-42", access["request_id"])
+	}
+	if access["status"] != float64(http.StatusNotFound) {
+		t.Errorf("got status %v, want %d", access["status"], http.StatusNotFound)
+	}
+	if access["route"] != "/clients/{id}/balance" {
+		t.Errorf("got route %v, want /clients/{id}/balance", access["route"])
+	}
+	if !strings.Contains(lines[0], `"request_id":"req-42"`) {
+		t.Errorf("handler log is missing the request id: %s", lines[0])
+	}
+}
+
+func TestNewLogger_RejectsBadConfig(t *testing.T) {
+	if _, err := NewLogger(&bytes.Buffer{}, "loud", "json"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	if _, err := NewLogger(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,88 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/koki1610168/go-payment-ledger/internal/memstore"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// The handler tests in package server_test run against memstore, which
+// the storetest suite holds to the semantics of the PostgreSQL store.
+
+// seed creates clientID in JPY holding balance
+func seed(t *testing.T, store *memstore.Store, clientID string, balance int64) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := store.CreateClient(ctx, clientID, "JPY"); err != nil {
+		t.Fatalf("create %s: %v", clientID, err)
+	}
+	if balance == 0 {
+		return
+	}
+	_, err := store.PostAdjustment(ctx, server.Adjustment{
+		ClientID: clientID, Amount: balance, Reason: "seed", IdempotencyKey: "seed-" + clientID,
+	})
+	if err != nil {
+		t.Fatalf("fund %s: %v", clientID, err)
+	}
+}
+
+// balance returns the balance of clientID in store
+func balance(t *testing.T, store *memstore.Store, clientID string) int64 {
+	t.Helper()
+	b, _, err := store.GetBalance(context.Background(), clientID)
+	if err != nil {
+		t.Fatalf("balance of %s: %v", clientID, err)
+	}
+	return b
+}
+
+func newHandler(store server.ClientStore) *server.Handler {
+	return server.NewHandler(store).WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// request is a request to path as p, or without a principal when p is nil
+func request(method, path, body string, p *server.Principal) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p != nil {
+		req = req.WithContext(server.WithPrincipal(req.Context(), p))
+	}
+	return req
+}
+
+func serveAs(h http.Handler, p *server.Principal, method, path, body string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, request(method, path, body, p))
+	return res
+}
+
+func decodeJSON[T any](t testing.TB, res *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return v
+}
+
+// hasField reports whether the problem in res lists an error for field
+func hasField(t testing.TB, res *httptest.ResponseRecorder, field string) bool {
+	t.Helper()
+	for _, fe := range decodeJSON[server.Problem](t, res).Errors {
+		if fe.Field == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds every Prometheus collector exposed by the service.
+// A nil *Metrics is valid and records nothing, so Store, Handler and
+// RateLimiter work without it.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	rateLimited prometheus.Counter
+	limiterKeys prometheus.Gauge
+
+	payments            *prometheus.CounterVec
+	paymentAmount       *prometheus.CounterVec
+	transfers           *prometheus.CounterVec
+	transferAmount      *prometheus.CounterVec
+	insufficientBalance *prometheus.CounterVec
+	velocityRejected    *prometheus.CounterVec
+	feeAmount           *prometheus.CounterVec
+	approvals           *prometheus.CounterVec
+}
+
+// Uses its own registry instead of the global one so tests can create
+// as many Metrics as they like
+func NewMetrics() *Metrics {
+	reg := prometheus.NewRegistry()
+
+	m := &Metrics{
+		registry: reg,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ledger_http_requests_total",
+			Help: "HTTP requests handled, by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ledger_http_request_duration_seconds",
+			Help:    "HTTP request latency, by route, method and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ledger_rate_limited_requests_total",
+			Help: "Requests rejected by the rate limiter.",
+		}),
+		limiterKeys: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ledger_rate_limiter_keys",
+			Help: "Client buckets currently held by the rate limiter.",
+		}),
+		payments: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ledger_payments_total",
+			Help: "Payments committed, by currency.",
+		}, []string{"currency"}),
+		paymentAmount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ledger_payment_amount_total",
+			Help: "Absolute payment volume in minor units, by currency and direction.",
+		}, []string{"currency", "direction"}),
+		transfers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ledger_transfers_total",
+			Help: "Transfers committed, by currency.",
+		}, []string{"currency"}),
+		transferAmount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ledger_transfer_amount_total",
+			Help: "Transfer volume in minor units, by currency.",
+		}, []string{"currency"}),
+		insufficientBalance: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ledger_insufficient_balance_total",
+			Help: "Operations rejected because of insufficient balance, by operation.",
+		}, []string{"operation"}),
+		velocityRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ledger_velocity_limit_rejections_total",
+			Help: "Debits rejected by a velocity limit, by operation and limit kind.",
+		}, []string{"operation", "kind"}),
+		feeAmount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ledger_fee_amount_total",
+			Help: "Fees charged in minor units, by currency and operation.",
+		}, []string{"currency", "operation"}),
+		approvals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ledger_transfer_approvals_total",
+			Help: "Transfers held for approval and what became of them, by status.",
+		}, []string{"status"}),
+	}
+
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.duration,
+		m.rateLimited,
+		m.limiterKeys,
+		m.payments,
+		m.paymentAmount,
+		m.transfers,
+		m.transferAmount,
+		m.insufficientBalance,
+		m.velocityRejected,
+		m.feeAmount,
+		m.approvals,
+	)
+
+	return m
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// RegisterPool exports pgxpool statistics on every scrape
+func (m *Metrics) RegisterPool(pool *pgxpool.Pool) {
+	m.registry.MustRegister(newPoolCollector(pool))
+}
+
+// Middleware records request count and latency.
+// The route label is the path of the route that matched, so unknown paths
+// cannot blow up the label cardinality.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		route := routeOf(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(rec.status)
+		m.requests.WithLabelValues(route, r.Method, status).Inc()
+		m.duration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+func (m *Metrics) observeRateLimited() {
+	if m == nil {
+		return
+	}
+	m.rateLimited.Inc()
+}
+
+func (m *Metrics) observeLimiterKeys(n int) {
+	if m == nil {
+		return
+	}
+	m.limiterKeys.Set(float64(n))
+}
+
+func (m *Metrics) observePayment(currency string, amount int64) {
+	if m == nil {
+		return
+	}
+	direction := "credit"
+	if amount < 0 {
+		direction = "debit"
+		amount = -amount
+	}
+	m.payments.WithLabelValues(currency).Inc()
+	m.paymentAmount.WithLabelValues(currency, direction).Add(float64(amount))
+}
+
+func (m *Metrics) observeTransfer(currency string, amount int64) {
+	if m == nil {
+		return
+	}
+	m.transfers.WithLabelValues(currency).Inc()
+	m.transferAmount.WithLabelValues(currency).Add(float64(amount))
+}
+
+func (m *Metrics) observeInsufficientBalance(operation string) {
+	if m == nil {
+		return
+	}
+	m.insufficientBalance.WithLabelValues(operation).Inc()
+}
+
+func (m *Metrics) observeVelocityRejected(operation, kind string) {
+	if m == nil {
+		return
+	}
+	m.velocityRejected.WithLabelValues(operation, kind).Inc()
+}
+
+func (m *Metrics) observeFee(currency, operation string, fee int64) {
+	if m == nil || fee == 0 {
+		return
+	}
+	m.feeAmount.WithLabelValues(currency, operation).Add(float64(fee))
+}
+
+// observeApproval counts a transfer entering status: pending when it is
+// held, then the decision or expiry that ends it
+func (m *Metrics) observeApproval(status string) {
+	if m == nil {
+		return
+	}
+	m.approvals.WithLabelValues(status).Inc()
+}
+
+// statusRecorder remembers the status code written by the wrapped handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wrote {
+		r.status = code
+		r.wrote = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wrote = true
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// poolCollector reads pgxpool.Stat lazily at scrape time
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquired     *prometheus.Desc
+	idle         *prometheus.Desc
+	total        *prometheus.Desc
+	max          *prometheus.Desc
+	acquireCount *prometheus.Desc
+	acquireWait  *prometheus.Desc
+	emptyCount   *prometheus.Desc
+	emptyWait    *prometheus.Desc
+}
+
+func newPoolCollector(pool *pgxpool.Pool) *poolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("ledger_db_pool_"+name, help, nil, nil)
+	}
+	return &poolCollector{
+		pool:         pool,
+		acquired:     desc("acquired_conns", "Connections currently checked out of the pool."),
+		idle:         desc("idle_conns", "Idle connections in the pool."),
+		total:        desc("total_conns", "Total connections in the pool."),
+		max:          desc("max_conns", "Maximum size of the pool."),
+		acquireCount: desc("acquires_total", "Successful acquires from the pool."),
+		acquireWait:  desc("acquire_duration_seconds_total", "Total time spent acquiring connections."),
+		emptyCount:   desc("empty_acquires_total", "Acquires that had to wait because the pool was empty."),
+		emptyWait:    desc("empty_acquire_wait_seconds_total", "Total time spent waiting on an empty pool."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquired
+	ch <- c.idle
+	ch <- c.total
+	ch <- c.max
+	ch <- c.acquireCount
+	ch <- c.acquireWait
+	ch <- c.emptyCount
+	ch <- c.emptyWait
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireWait, prometheus.CounterValue, s.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.emptyCount, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.emptyWait, prometheus.CounterValue, s.EmptyAcquireWaitTime().Seconds())
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics_RecordsRequestsByRoute(t *testing.T) {
+	metrics := NewMetrics()
+	store := NewStubClient()
+	store.SeedClient("client_001", 10000, "JPY")
+	handler := metrics.Middleware(NewHandler(store))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/clients/client_001/balance", nil)
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	route := "/v1/clients/{id}/balance"
+	got := testutil.ToFloat64(metrics.requests.WithLabelValues(route, http.MethodGet, "200"))
+	if got != 1 {
+		t.Errorf("got %v requests for %s, want 1", got, route)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/does-not-exist", nil)
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	got = testutil.ToFloat64(metrics.requests.WithLabelValues("unmatched", http.MethodGet, "404"))
+	if got != 1 {
+		t.Errorf("got %v unmatched requests, want 1", got)
+	}
+}
+
+func TestMetrics_CountsRateLimitRejections(t *testing.T) {
+	metrics := NewMetrics()
+	limiter := NewRateLimiter(1, 1).WithMetrics(metrics)
+	handler := limiter.Middleware(dummyHandler())
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if got := testutil.ToFloat64(metrics.rateLimited); got != 2 {
+		t.Errorf("got %v rejections, want 2", got)
+	}
+}
+
+func TestMetrics_HandlerExposesRegistry(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.observePayment("JPY", -500)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	res := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(res, req)
+
+	body := res.Body.String()
+	want := `ledger_payment_amount_total{currency="JPY",direction="debit"} 500`
+	if !strings.Contains(body, want) {
+		t.Errorf("metrics output missing %q", want)
+	}
+}
+
+func TestMetrics_NilIsSafe(t *testing.T) {
+	var metrics *Metrics
+	metrics.observePayment("JPY", 100)
+	metrics.observeTransfer("JPY", 100)
+	metrics.observeInsufficientBalance("payment")
+	metrics.observeRateLimited()
+
+	handler := metrics.Middleware(dummyHandler())
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/test", nil))
+	if res.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", res.Code, http.StatusOK)
+	}
+}
@@ -1,53 +1,285 @@
 package server
 
 import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
-	"sync"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/time/rate"
 )
 
+// Defaults for limiter eviction, see WithEviction
+const (
+	DefaultLimiterIdleTTL    = 10 * time.Minute
+	DefaultLimiterMaxEntries = 100_000
+)
+
+// limitPolicy is one bucket configuration. Every caller gets its own
+// bucket per policy.
+type limitPolicy struct {
+	// Empty for the default policy, otherwise the route pattern
+	name  string
+	limit Limit
+}
+
+type routeLimit struct {
+	policy *limitPolicy
+	cost   int
+}
+
+// RateLimiter charges each request to a bucket per caller and policy.
+// Callers are identified by their authenticated Principal, or by client IP
+// when the request is anonymous. Buckets are held by a LimiterBackend,
+// in memory unless WithBackend sets a shared one.
 type RateLimiter struct {
-	clients map[string]*rate.Limiter
-	mu sync.Mutex
-	rate rate.Limit
-	burst int
+	backend LimiterBackend
+	memory *MemoryBackend
+	defaultPolicy *limitPolicy
+	routes map[string]routeLimit
+	routeMux *http.ServeMux
+	trustedProxies []netip.Prefix
+	metrics *Metrics
+	logger *slog.Logger
 }
 
 func NewRateLimiter(rpc float64, burst int) *RateLimiter {
+	memory := NewMemoryBackend()
 	return &RateLimiter{
-		clients: make(map[string]*rate.Limiter),
-		mu: sync.Mutex{},
-		rate: rate.Limit(rpc),
-		burst: burst,
+		backend: memory,
+		memory: memory,
+		defaultPolicy: &limitPolicy{limit: Limit{Rate: rpc, Burst: burst}},
+		routes: make(map[string]routeLimit),
+		routeMux: http.NewServeMux(),
+		logger: slog.Default(),
 	}
 }
 
-func (rl *RateLimiter) getLimiter(ip string) *rate.Limiter {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+// WithMetrics makes the limiter count rejected requests and in-memory
+// buckets
+func (rl *RateLimiter) WithMetrics(m *Metrics) *RateLimiter {
+	rl.metrics = m
+	rl.memory.metrics = m
+	return rl
+}
+
+// WithLogger sets the logger used to report rejected requests
+func (rl *RateLimiter) WithLogger(logger *slog.Logger) *RateLimiter {
+	rl.logger = logger
+	return rl
+}
+
+// WithEviction makes the in-memory backend drop buckets unused for idleTTL
+// and cap the number of buckets at maxEntries
+func (rl *RateLimiter) WithEviction(idleTTL time.Duration, maxEntries int) *RateLimiter {
+	rl.memory.idleTTL = idleTTL
+	rl.memory.maxEntries = maxEntries
+	return rl
+}
+
+// WithBackend replaces the in-memory buckets, e.g. with a PostgresBackend
+// so that all replicas enforce one limit
+func (rl *RateLimiter) WithBackend(b LimiterBackend) *RateLimiter {
+	rl.backend = b
+	return rl
+}
+
+// WithTrustedProxies makes the limiter take the client address from
+// Forwarded or X-Forwarded-For when the connection comes from one of
+// these networks. Headers from anyone else are ignored.
+func (rl *RateLimiter) WithTrustedProxies(proxies []netip.Prefix) *RateLimiter {
+	rl.trustedProxies = proxies
+	return rl
+}
+
+// WithRoute overrides the limit for requests matching a ServeMux pattern
+// such as "POST /transfer". A pattern without a version prefix also matches
+// the same route under /v1. With rps > 0 the route gets its own bucket of
+// the given burst; otherwise it draws from the default bucket. Each request
+// takes cost tokens (at least 1).
+func (rl *RateLimiter) WithRoute(pattern string, rps float64, burst int, cost int) *RateLimiter {
+	policy := rl.defaultPolicy
+	if rps > 0 {
+		policy = &limitPolicy{name: pattern, limit: Limit{Rate: rps, Burst: burst}}
+	}
+	rl.routes[pattern] = routeLimit{policy: policy, cost: max(cost, 1)}
+	rl.routeMux.Handle(pattern, http.NotFoundHandler())
+	return rl
+}
 
-	limiter, exists := rl.clients[ip]
+func (rl *RateLimiter) route(r *http.Request) routeLimit {
+	if route, ok := rl.match(r); ok {
+		return route
+	}
+	if rest, ok := strings.CutPrefix(r.URL.Path, V1+"/"); ok {
+		unversioned := r.WithContext(r.Context())
+		u := *r.URL
+		u.Path, u.RawPath = "/"+rest, ""
+		unversioned.URL = &u
+		if route, ok := rl.match(unversioned); ok {
+			return route
+		}
+	}
+	return routeLimit{policy: rl.defaultPolicy, cost: 1}
+}
 
-	if !exists {
-		limiter = rate.NewLimiter(rl.rate, rl.burst)
-		rl.clients[ip] = limiter
+func (rl *RateLimiter) match(r *http.Request) (routeLimit, bool) {
+	if _, pattern := rl.routeMux.Handler(r); pattern != "" {
+		route, ok := rl.routes[pattern]
+		return route, ok
 	}
+	return routeLimit{}, false
+}
 
-	return limiter
+// identity is what a bucket belongs to
+func (rl *RateLimiter) identity(r *http.Request) string {
+	if p, ok := PrincipalFromContext(r.Context()); ok {
+		return p.ID
+	}
+	return rl.clientIP(r)
 }
 
+func bucketKey(identity string, policy *limitPolicy) string {
+	if policy.name == "" {
+		return identity
+	}
+	return policy.name + "|" + identity
+}
+
+func (rl *RateLimiter) getLimiter(ip string) *rate.Limiter {
+	return rl.memory.limiter(ip, rl.defaultPolicy.limit)
+}
+
+// Len reports how many buckets the in-memory backend holds
+func (rl *RateLimiter) Len() int {
+	return rl.memory.Len()
+}
+
+// Run does the backend's background upkeep until ctx is cancelled
+func (rl *RateLimiter) Run(ctx context.Context) {
+	if runner, ok := rl.backend.(interface{ Run(context.Context) }); ok {
+		runner.Run(ctx)
+	}
+}
+
+// Middleware charges each request against its caller's bucket and reports
+// the bucket state in RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset (seconds until the bucket is full again).
+// If the backend fails, the request is let through: an unavailable limiter
+// should not take the API down with it.
 func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
+		identity := rl.identity(r)
+		route := rl.route(r)
+		limit := route.policy.limit
+
+		d, err := rl.backend.Take(r.Context(), bucketKey(identity, route.policy), limit, route.cost)
+		if err != nil {
+			rl.logger.WarnContext(r.Context(), "rate limiter unavailable, allowing request", "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		limiter := rl.getLimiter(ip)
-		if !limiter.Allow() {
-			w.Header().Set("Retry-After", "1")
-			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.ResetAfter)))
+		if !d.Allowed {
+			rl.metrics.observeRateLimited()
+			rl.logger.InfoContext(r.Context(), "rate limit exceeded",
+				"key", identity, "policy", route.policy.name, "cost", route.cost)
+			h.Set("Retry-After", strconv.Itoa(max(ceilSeconds(d.RetryAfter), 1)))
+			writeProblem(w, r, http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded")
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// clientIP returns the address the limiter keys on: the peer IP without
+// its port, or the first untrusted hop of the forwarding headers when the
+// peer is a trusted proxy
+func (rl *RateLimiter) clientIP(r *http.Request) string {
+	peer, ok := parseIP(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+	if !rl.trusted(peer) {
+		return peer.String()
+	}
+
+	hops := forwardedFor(r.Header)
+	// Walk from the closest hop outwards; the first address that is not
+	// one of our proxies is the client
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := parseIP(hops[i])
+		if !ok {
+			break
+		}
+		if !rl.trusted(ip) {
+			return ip.String()
+		}
+		peer = ip
+	}
+	return peer.String()
+}
+
+func (rl *RateLimiter) trusted(ip netip.Addr) bool {
+	for _, p := range rl.trustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor lists the client hops from the Forwarded header (RFC 7239),
+// falling back to X-Forwarded-For
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	for _, line := range h.Values("Forwarded") {
+		for _, element := range strings.Split(line, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					hops = append(hops, strings.Trim(v, `"`))
+				}
+			}
+		}
+	}
+	if len(hops) > 0 {
+		return hops
+	}
+
+	for _, line := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(line, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseIP accepts "ip", "ip:port", "[ipv6]" and "[ipv6]:port"
+func parseIP(s string) (netip.Addr, bool) {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	ip, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
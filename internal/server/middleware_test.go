@@ -1,9 +1,13 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"testing"
+	"time"
 )
 
 func dummyHandler() http.Handler {
@@ -128,4 +132,250 @@ func TestRateLimiter_GetLimiterCreatesDifferentLimitersForDifferentIPs(t *testin
 	if l1 == l2 {
 		t.Error("expected different limiter instances for different IPs")
 	}
-}
\ No newline at end of file
+}
+
+func TestRateLimiter_KeysOnIPNotPort(t *testing.T) {
+	limiter := NewRateLimiter(1, 1)
+	handler := limiter.Middleware(dummyHandler())
+
+	for i, addr := range []string{"192.168.1.1:1111", "192.168.1.1:2222"} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = addr
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		want := http.StatusOK
+		if i == 1 {
+			want = http.StatusTooManyRequests
+		}
+		if res.Code != want {
+			t.Errorf("request from %s: got %d, want %d", addr, res.Code, want)
+		}
+	}
+	if limiter.Len() != 1 {
+		t.Errorf("got %d buckets, want 1", limiter.Len())
+	}
+}
+
+func TestRateLimiter_MemoryBoundedUnderManyIPs(t *testing.T) {
+	limiter := NewRateLimiter(10, 5).WithEviction(time.Hour, 100)
+	handler := limiter.Middleware(dummyHandler())
+
+	for i := 0; i < 10_000; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = fmt.Sprintf("10.%d.%d.%d:443", i>>16&0xff, i>>8&0xff, i&0xff)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if n := limiter.Len(); n > 100 {
+			t.Fatalf("after %d distinct IPs the limiter holds %d buckets, want at most 100", i+1, n)
+		}
+	}
+	if len(limiter.memory.clients) != limiter.memory.lru.Len() {
+		t.Errorf("map has %d entries but list has %d", len(limiter.memory.clients), limiter.memory.lru.Len())
+	}
+}
+
+func TestRateLimiter_EvictsLeastRecentlyUsed(t *testing.T) {
+	limiter := NewRateLimiter(10, 5).WithEviction(time.Hour, 2)
+
+	a := limiter.getLimiter("10.0.0.1")
+	limiter.getLimiter("10.0.0.2")
+	limiter.getLimiter("10.0.0.1") // a is now the most recently used
+	limiter.getLimiter("10.0.0.3") // evicts 10.0.0.2
+
+	if got := limiter.getLimiter("10.0.0.1"); got != a {
+		t.Error("expected the recently used bucket to survive")
+	}
+	if _, ok := limiter.memory.clients["10.0.0.2"]; ok {
+		t.Error("expected the least recently used bucket to be evicted")
+	}
+}
+
+func TestRateLimiter_EvictsIdleEntries(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(10, 5).WithEviction(time.Minute, 1000)
+	limiter.memory.now = func() time.Time { return now }
+
+	limiter.getLimiter("10.0.0.1")
+	limiter.getLimiter("10.0.0.2")
+
+	now = now.Add(30 * time.Second)
+	limiter.getLimiter("10.0.0.2")
+
+	now = now.Add(45 * time.Second)
+	limiter.memory.mu.Lock()
+	limiter.memory.evictIdleLocked(now)
+	limiter.memory.mu.Unlock()
+
+	if _, ok := limiter.memory.clients["10.0.0.1"]; ok {
+		t.Error("expected the idle bucket to be evicted")
+	}
+	if _, ok := limiter.memory.clients["10.0.0.2"]; !ok {
+		t.Error("expected the recently used bucket to be kept")
+	}
+}
+
+func TestRateLimiter_RunStopsWithContext(t *testing.T) {
+	limiter := NewRateLimiter(10, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		limiter.Run(ctx)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestRateLimiter_ClientIP(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	limiter := NewRateLimiter(10, 5).WithTrustedProxies(proxies)
+
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"no proxy", "203.0.113.9:5555", nil, "203.0.113.9"},
+		{"ipv6 peer", "[2001:db8::1]:5555", nil, "2001:db8::1"},
+		{"mapped ipv4", "[::ffff:203.0.113.9]:5555", nil, "203.0.113.9"},
+		{"untrusted peer ignores headers", "203.0.113.9:5555",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.9"},
+		{"trusted proxy uses xff", "10.0.0.5:5555",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"spoofed left-most xff entry is skipped", "10.0.0.5:5555",
+			map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.7"}, "198.51.100.1"},
+		{"forwarded header wins", "10.0.0.5:5555",
+			map[string]string{
+				"Forwarded":       `for="[2001:db8::2]:4711";proto=https, for=10.0.0.7`,
+				"X-Forwarded-For": "198.51.100.1",
+			}, "2001:db8::2"},
+		{"only proxies falls back to the last one", "10.0.0.5:5555",
+			map[string]string{"X-Forwarded-For": "10.0.0.7"}, "10.0.0.7"},
+		{"garbage stops the walk", "10.0.0.5:5555",
+			map[string]string{"X-Forwarded-For": "198.51.100.1, unknown"}, "10.0.0.5"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.RemoteAddr = c.remote
+			for k, v := range c.headers {
+				req.Header.Set(k, v)
+			}
+			if got := limiter.clientIP(req); got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_KeysOnPrincipal(t *testing.T) {
+	limiter := NewRateLimiter(1, 1)
+	handler := limiter.Middleware(dummyHandler())
+
+	send := func(p *Principal) int {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if p != nil {
+			req = req.WithContext(WithPrincipal(req.Context(), p))
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	alice := &Principal{ID: "key:alice"}
+	bob := &Principal{ID: "key:bob"}
+	if code := send(alice); code != http.StatusOK {
+		t.Fatalf("alice: got %d", code)
+	}
+	if code := send(alice); code != http.StatusTooManyRequests {
+		t.Errorf("alice again: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+	// Same address, different principal
+	if code := send(bob); code != http.StatusOK {
+		t.Errorf("bob: got %d, want %d", code, http.StatusOK)
+	}
+	if code := send(nil); code != http.StatusOK {
+		t.Errorf("anonymous: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestRateLimiter_RouteCostAndPolicy(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(10, 10).
+		WithRoute("POST /transfer", 0, 0, 5).
+		WithRoute("GET /clients/", 1, 2, 1)
+	limiter.memory.now = func() time.Time { return now }
+	handler := limiter.Middleware(dummyHandler())
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	// Transfers take 5 of the 10 default tokens, on either version
+	for i, path := range []string{"/transfer", "/v1/transfer"} {
+		if res := send(http.MethodPost, path); res.Code != http.StatusOK {
+			t.Fatalf("transfer %d: got %d", i+1, res.Code)
+		}
+	}
+	res := send(http.MethodPost, "/transfer")
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("third transfer: got %d, want %d", res.Code, http.StatusTooManyRequests)
+	}
+	// 5 tokens missing at 10 rps
+	if got := res.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+
+	// Reads have their own bucket, untouched by the transfers
+	res = send(http.MethodGet, "/clients/client_001/balance")
+	if res.Code != http.StatusOK {
+		t.Fatalf("read: got %d", res.Code)
+	}
+	if got := res.Header().Get("RateLimit-Limit"); got != "2" {
+		t.Errorf("RateLimit-Limit = %q, want 2", got)
+	}
+	if got := res.Header().Get("RateLimit-Remaining"); got != "1" {
+		t.Errorf("RateLimit-Remaining = %q, want 1", got)
+	}
+	if got := res.Header().Get("RateLimit-Reset"); got != "1" {
+		t.Errorf("RateLimit-Reset = %q, want 1", got)
+	}
+}
+
+func TestRateLimiter_RetryAfterReflectsRefillTime(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(0.25, 1)
+	limiter.memory.now = func() time.Time { return now }
+	handler := limiter.Middleware(dummyHandler())
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Code != want {
+			t.Fatalf("request %d: got %d, want %d", i+1, res.Code, want)
+		}
+		if want == http.StatusTooManyRequests {
+			if got := res.Header().Get("Retry-After"); got != "4" {
+				t.Errorf("Retry-After = %q, want 4", got)
+			}
+			if got := res.Header().Get("RateLimit-Remaining"); got != "0" {
+				t.Errorf("RateLimit-Remaining = %q, want 0", got)
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations_SortsByVersion(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0002_fees.sql": {Data: []byte("CREATE TABLE fees ();")},
+		"m/0001_init.sql": {Data: []byte("CREATE TABLE clients ();")},
+		"m/README.md":     {Data: []byte("not a migration")},
+	}
+
+	migrations, err := LoadMigrations(fsys, "m")
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("got %d migrations, want 2", len(migrations))
+	}
+	if migrations[0].Version != 1 || migrations[1].Name != "fees" {
+		t.Errorf("unexpected order: %+v", migrations)
+	}
+}
+
+func TestLoadMigrations_RejectsDuplicates(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0001_init.sql":  {Data: []byte("")},
+		"m/0001_other.sql": {Data: []byte("")},
+	}
+	if _, err := LoadMigrations(fsys, "m"); err == nil {
+		t.Error("expected an error for duplicate versions")
+	}
+}
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	migrations, err := LoadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatalf("load embedded migrations: %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Version != 1 {
+		t.Errorf("expected 0001_init first, got %+v", migrations)
+	}
+}
@@ -0,0 +1,22 @@
+-- Clients table: stores account balances
+CREATE TABLE IF NOT EXISTS clients (
+    client_id  TEXT PRIMARY KEY,
+    balance    BIGINT NOT NULL DEFAULT 0,
+    currency   TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Ledger entries: immutable transaction log
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    entry_id        UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id       TEXT NOT NULL REFERENCES clients(client_id),
+    amount          BIGINT NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    idempotency_key TEXT
+);
+
+-- Index for idempotency lookups
+CREATE INDEX IF NOT EXISTS idx_ledger_idempotency ON ledger_entries(idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+-- Index for client ledger queries
+CREATE INDEX IF NOT EXISTS idx_ledger_client ON ledger_entries(client_id);
@@ -0,0 +1,8 @@
+-- Rate-limit buckets shared by all replicas. tat is the GCRA "theoretical
+-- arrival time": the bucket is full once tat is in the past, so such rows
+-- carry no state and are swept. Unlogged because losing the table in a
+-- crash only resets the limits.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits (
+    key TEXT PRIMARY KEY,
+    tat TIMESTAMPTZ NOT NULL
+);
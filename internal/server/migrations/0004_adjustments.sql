@@ -0,0 +1,13 @@
+-- Adjustments are manual corrections posted by operators. Each one is a
+-- regular ledger entry plus this record of who posted it and why.
+CREATE TABLE IF NOT EXISTS adjustments (
+    adjustment_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    entry_id      UUID NOT NULL UNIQUE REFERENCES ledger_entries(entry_id),
+    client_id     TEXT NOT NULL REFERENCES clients(client_id),
+    amount        BIGINT NOT NULL CHECK (amount <> 0),
+    reason        TEXT NOT NULL CHECK (btrim(reason) <> ''),
+    actor         TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_adjustments_client ON adjustments(client_id, created_at);
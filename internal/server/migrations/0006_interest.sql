@@ -0,0 +1,42 @@
+-- Annual interest rates in basis points. Accrual starts on the UTC day
+-- the rate was created.
+CREATE TABLE IF NOT EXISTS interest_rates (
+    client_id              TEXT PRIMARY KEY REFERENCES clients(client_id),
+    credit_basis_points    BIGINT NOT NULL CHECK (credit_basis_points BETWEEN 0 AND 10000),
+    overdraft_basis_points BIGINT NOT NULL CHECK (overdraft_basis_points BETWEEN 0 AND 10000),
+    day_count              TEXT NOT NULL CHECK (day_count IN ('act/365', 'act/360', 'act/act', '30/360')),
+    created_at             TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at             TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- One row per client and day: the end-of-day balance, the rate applied
+-- and the interest it earned, in millionths of the minor unit. The key
+-- keeps a day from being accrued twice.
+CREATE TABLE IF NOT EXISTS interest_accruals (
+    client_id              TEXT NOT NULL REFERENCES clients(client_id),
+    accrual_date           DATE NOT NULL,
+    balance                BIGINT NOT NULL,
+    credit_basis_points    BIGINT NOT NULL,
+    overdraft_basis_points BIGINT NOT NULL,
+    day_count              TEXT NOT NULL,
+    accrued                BIGINT NOT NULL,
+    created_at             TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (client_id, accrual_date)
+);
+
+-- One row per client and month capitalized. entry_id is the client's
+-- ledger entry and counter_entry_id the house account's; both are NULL
+-- when the month rounded to zero. The residual is carried to the next
+-- month. The key keeps a month from being posted twice.
+CREATE TABLE IF NOT EXISTS interest_postings (
+    client_id        TEXT NOT NULL REFERENCES clients(client_id),
+    period           DATE NOT NULL,
+    period_end       TIMESTAMPTZ NOT NULL,
+    accrued          BIGINT NOT NULL,
+    amount           BIGINT NOT NULL,
+    residual         BIGINT NOT NULL,
+    entry_id         UUID UNIQUE REFERENCES ledger_entries(entry_id),
+    counter_entry_id UUID UNIQUE REFERENCES ledger_entries(entry_id),
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (client_id, period)
+);
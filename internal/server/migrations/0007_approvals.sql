@@ -0,0 +1,34 @@
+-- Transfers above the approval threshold wait here for a second principal
+-- to approve or reject them. hold_entry_id is the sender's debit to the
+-- approval hold account when the funds were held, NULL otherwise. The
+-- idempotency key is the one the transfer was requested with; its debit
+-- carries it once approved.
+CREATE TABLE IF NOT EXISTS pending_transfers (
+    transfer_id     UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    from_client_id  TEXT NOT NULL REFERENCES clients(client_id),
+    to_client_id    TEXT NOT NULL REFERENCES clients(client_id),
+    amount          BIGINT NOT NULL CHECK (amount > 0),
+    currency        TEXT NOT NULL,
+    idempotency_key TEXT UNIQUE,
+    status          TEXT NOT NULL CHECK (status IN ('pending', 'approved', 'rejected', 'expired')),
+    hold_entry_id   UUID UNIQUE REFERENCES ledger_entries(entry_id),
+    requested_by    TEXT NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at      TIMESTAMPTZ NOT NULL
+);
+
+-- The expiry job looks for pending transfers past their expiry
+CREATE INDEX IF NOT EXISTS idx_pending_transfers_expiry ON pending_transfers(expires_at) WHERE status = 'pending';
+
+-- Audit trail of each transfer: who requested it and who approved,
+-- rejected or expired it, and why
+CREATE TABLE IF NOT EXISTS transfer_events (
+    event_id    BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    transfer_id UUID NOT NULL REFERENCES pending_transfers(transfer_id),
+    action      TEXT NOT NULL CHECK (action IN ('requested', 'approved', 'rejected', 'expired')),
+    actor       TEXT NOT NULL,
+    reason      TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_transfer_events_transfer ON transfer_events(transfer_id, event_id);
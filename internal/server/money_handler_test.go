@@ -0,0 +1,67 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/koki1610168/go-payment-ledger/internal/memstore"
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// Transfers and ledger entries carry the client's currency and the
+// amounts as Money, in major units, next to the integer minor units
+func TestHandler_AmountsCarryCurrency(t *testing.T) {
+	ctx := context.Background()
+	store := memstore.New()
+	for _, id := range []string{"client_001", "client_002"} {
+		if _, err := store.CreateClient(ctx, id, "USD"); err != nil {
+			t.Fatalf("create %s: %v", id, err)
+		}
+	}
+	if _, err := store.PostAdjustment(ctx, server.Adjustment{
+		ClientID: "client_001", Amount: 5000, Reason: "seed", IdempotencyKey: "seed",
+	}); err != nil {
+		t.Fatalf("fund client_001: %v", err)
+	}
+	handler := newHandler(store)
+
+	res := serveAs(handler, nil, http.MethodPost, server.V1+"/transfer",
+		`{"from_client_id":"client_001","to_client_id":"client_002","amount":1250,"idempotencyKey":"t1"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("transfer: got %d: %s", res.Code, res.Body)
+	}
+	transfer := decodeJSON[server.TransferResponse](t, res)
+	if transfer.Currency != "USD" {
+		t.Errorf("transfer currency is %q, want USD", transfer.Currency)
+	}
+	wantMoney := server.TransferMoney{
+		Amount:         money.New(1250, "USD"),
+		FromNewBalance: money.New(3750, "USD"),
+		ToNewBalance:   money.New(1250, "USD"),
+	}
+	if transfer.Money != wantMoney {
+		t.Errorf("transfer money is %+v, want %+v", transfer.Money, wantMoney)
+	}
+
+	res = serveAs(handler, nil, http.MethodGet, server.V1+"/clients/client_001/ledger", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("ledger: got %d: %s", res.Code, res.Body)
+	}
+	ledger := decodeJSON[struct {
+		Entries []struct {
+			Amount   int64
+			Currency string
+			Money    money.Money `json:"money"`
+		} `json:"ledger_entires"`
+	}](t, res)
+	if len(ledger.Entries) != 2 {
+		t.Fatalf("got %d ledger entries, want 2", len(ledger.Entries))
+	}
+	for _, e := range ledger.Entries {
+		if e.Currency != "USD" || e.Money != money.New(e.Amount, "USD") {
+			t.Errorf("entry of %d has currency %q and money %+v", e.Amount, e.Currency, e.Money)
+		}
+	}
+}
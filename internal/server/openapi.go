@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+)
+
+// openAPISpec is the API contract. Handler responses are checked against
+// it in tests, and RequestValidator enforces it on incoming requests.
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// LoadOpenAPI parses and validates the embedded OpenAPI document
+func LoadOpenAPI(ctx context.Context) (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	loader.Context = ctx
+	doc, err := loader.LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, fmt.Errorf("load openapi spec: %w", err)
+	}
+	if err := doc.Validate(ctx); err != nil {
+		return nil, fmt.Errorf("validate openapi spec: %w", err)
+	}
+	return doc, nil
+}
+
+// OpenAPIHandler serves doc as JSON, e.g. at /openapi.json
+func OpenAPIHandler(doc *openapi3.T) (http.Handler, error) {
+	body, err := doc.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("encode openapi spec: %w", err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}), nil
+}
+
+// RequestValidator rejects requests whose parameters or body do not match
+// the OpenAPI document with 400 before they reach the handler. Paths and
+// methods the document does not describe are passed through so the
+// handler answers them as before.
+type RequestValidator struct {
+	router routers.Router
+}
+
+func NewRequestValidator(doc *openapi3.T) (*RequestValidator, error) {
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("build openapi router: %w", err)
+	}
+	return &RequestValidator{router: router}, nil
+}
+
+// isJSON reports whether r declares its body as application/json
+func isJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+func (v *RequestValidator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, params, err := v.router.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A body is checked the way decodeBody checks it, in the same
+		// order, so a request is answered the same with or without the
+		// validator: its media type first, then its size. kin-openapi
+		// reads the whole body, so it only ever gets one that fits.
+		if route.Operation.RequestBody != nil && !isJSON(r) {
+			writeProblem(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType,
+				"request body must be sent as application/json")
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeProblem(w, r, http.StatusRequestEntityTooLarge, CodeRequestTooLarge,
+					fmt.Sprintf("request body must be at most %d bytes", tooLarge.Limit))
+				return
+			}
+			if err != nil {
+				writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "request body could not be read")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+			r.ContentLength = int64(len(body))
+			// A body that is not JSON at all is left to decodeBody, which
+			// says where it breaks
+			if len(body) > 0 && !json.Valid(body) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		err = openapi3filter.ValidateRequest(r.Context(), &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: params,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				// Authentication is left to APIKeys and ClientCertAuth
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+				MultiError:         true,
+			},
+		})
+		if err != nil {
+			if invalid, ok := fieldErrors(err); ok {
+				writeInvalid(w, r, invalid)
+				return
+			}
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, validationMessage(err))
+			return
+		}
+		if r.GetBody != nil {
+			r.Body, _ = r.GetBody()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fieldErrors converts the errors of a request that failed validation to
+// a ValidationError, like the handler's own. It reports false when one of
+// them is not about a field, e.g. a missing body.
+func fieldErrors(err error) (ValidationError, bool) {
+	var multi openapi3.MultiError
+	if errors.As(err, &multi) {
+		var all ValidationError
+		for _, e := range multi {
+			fes, ok := fieldErrors(e)
+			if !ok {
+				return nil, false
+			}
+			all = append(all, fes...)
+		}
+		return all, len(all) > 0
+	}
+
+	var reqErr *openapi3filter.RequestError
+	if errors.As(err, &reqErr) {
+		var schemaErr *openapi3.SchemaError
+		var inner openapi3.MultiError
+		switch {
+		case errors.As(reqErr.Err, &inner):
+			return fieldErrors(inner)
+		case errors.As(reqErr.Err, &schemaErr):
+			if reqErr.Parameter != nil {
+				return ValidationError{{Field: reqErr.Parameter.Name, Detail: schemaDetail(schemaErr)}}, true
+			}
+			return ValidationError{schemaFieldError(schemaErr)}, true
+		case reqErr.Parameter != nil:
+			return ValidationError{{Field: reqErr.Parameter.Name, Detail: reqErr.Reason}}, true
+		}
+		return nil, false
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		return ValidationError{schemaFieldError(schemaErr)}, true
+	}
+	return nil, false
+}
+
+// schemaFieldError names the field of err the way validator does, e.g.
+// legs[1].amount
+func schemaFieldError(err *openapi3.SchemaError) FieldError {
+	field := ""
+	for _, p := range err.JSONPointer() {
+		if _, convErr := strconv.Atoi(p); convErr == nil {
+			field += "[" + p + "]"
+		} else if field == "" {
+			field = p
+		} else {
+			field += "." + p
+		}
+	}
+	switch err.SchemaField {
+	case "required":
+		return FieldError{Field: field, Detail: "is required"}
+	case "properties":
+		// An unknown property is reported on its parent
+		if name, ok := strings.CutPrefix(err.Reason, "property "); ok {
+			name, _ = strings.CutSuffix(name, " is unsupported")
+			if name, unquoteErr := strconv.Unquote(name); unquoteErr == nil {
+				if field != "" {
+					name = field + "." + name
+				}
+				return FieldError{Field: name, Detail: "is not a known field"}
+			}
+		}
+	}
+	if field == "" {
+		field = "body"
+	}
+	return FieldError{Field: field, Detail: schemaDetail(err)}
+}
+
+// schemaDetail is the reason of err without the "value" kin-openapi
+// starts some of them with
+func schemaDetail(err *openapi3.SchemaError) string {
+	detail, _ := strings.CutPrefix(err.Reason, "value ")
+	return detail
+}
+
+// validationMessage lists every problem, without the schema dumps
+// kin-openapi includes in its default messages
+func validationMessage(err error) string {
+	var multi openapi3.MultiError
+	if errors.As(err, &multi) {
+		msg := ""
+		for i, e := range multi {
+			if i > 0 {
+				msg += "; "
+			}
+			msg += validationMessage(e)
+		}
+		return msg
+	}
+
+	var reqErr *openapi3filter.RequestError
+	if errors.As(err, &reqErr) {
+		var schemaErr *openapi3.SchemaError
+		if errors.As(reqErr.Err, &schemaErr) {
+			return schemaMessage(schemaErr)
+		}
+		var inner openapi3.MultiError
+		if errors.As(reqErr.Err, &inner) {
+			return validationMessage(inner)
+		}
+		if reqErr.Parameter != nil {
+			return fmt.Sprintf("parameter %s: %s", reqErr.Parameter.Name, reqErr.Reason)
+		}
+		if reqErr.Reason != "" {
+			return reqErr.Reason
+		}
+		return reqErr.Err.Error()
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		return schemaMessage(schemaErr)
+	}
+	return err.Error()
+}
+
+func schemaMessage(err *openapi3.SchemaError) string {
+	if path := err.JSONPointer(); len(path) > 0 {
+		field := path[0]
+		for _, p := range path[1:] {
+			field += "." + p
+		}
+		return field + ": " + err.Reason
+	}
+	return err.Reason
+}
@@ -0,0 +1,369 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/google/uuid"
+)
+
+func loadTestOpenAPI(t *testing.T) *openapi3.T {
+	t.Helper()
+	doc, err := LoadOpenAPI(context.Background())
+	if err != nil {
+		t.Fatalf("load openapi: %v", err)
+	}
+	return doc
+}
+
+func TestOpenAPIHandler_ServesJSON(t *testing.T) {
+	h, err := OpenAPIHandler(loadTestOpenAPI(t))
+	if err != nil {
+		t.Fatalf("openapi handler: %v", err)
+	}
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	if res.Code != http.StatusOK || res.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("got %d %s", res.Code, res.Header().Get("Content-Type"))
+	}
+	var body struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.OpenAPI != "3.0.3" || body.Paths["/payments"] == nil {
+		t.Errorf("unexpected document: openapi=%q, %d paths", body.OpenAPI, len(body.Paths))
+	}
+}
+
+func TestRequestValidator(t *testing.T) {
+	v, err := NewRequestValidator(loadTestOpenAPI(t))
+	if err != nil {
+		t.Fatalf("new request validator: %v", err)
+	}
+	var reached bool
+	h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		// The body must still be readable after validation
+		if r.Body != nil {
+			io.Copy(io.Discard, r.Body)
+		}
+	}))
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantMsg  []string
+	}{
+		{"valid payment", http.MethodPost, "/payments",
+			`{"clientID":"client_001","amount":-100,"currency":"JPY","idempotencyKey":"k1"}`, http.StatusOK, nil},
+		{"missing fields", http.MethodPost, "/payments",
+			`{"clientID":"client_001","amount":100}`, http.StatusBadRequest, []string{"currency", "idempotencyKey"}},
+		{"string amount", http.MethodPost, "/transfer",
+			`{"from_client_id":"a","to_client_id":"b","amount":"100","idempotencyKey":"k"}`, http.StatusBadRequest, []string{"amount"}},
+		{"non-positive transfer", http.MethodPost, "/transfer",
+			`{"from_client_id":"a","to_client_id":"b","amount":0,"idempotencyKey":"k"}`, http.StatusBadRequest, []string{"amount"}},
+		{"bad limit kind", http.MethodPost, "/clients/client_001/limits",
+			`{"kind":"volume","max":10,"window_seconds":60}`, http.StatusBadRequest, []string{"kind"}},
+		{"versioned", http.MethodPost, "/v1/transfer",
+			`{"from_client_id":"a","to_client_id":"b","amount":0,"idempotencyKey":"k"}`, http.StatusBadRequest, []string{"amount"}},
+		{"body too large", http.MethodPost, "/payments",
+			`{"clientID":"` + strings.Repeat("x", MaxBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, []string{CodeRequestTooLarge}},
+		{"undocumented path", http.MethodGet, "/clients/client_001/unknown", "", http.StatusOK, nil},
+		{"undocumented version", http.MethodPost, "/v2/transfer", "", http.StatusOK, nil},
+		{"undocumented method", http.MethodGet, "/payments", "", http.StatusOK, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached = false
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("got %d (%s), want %d", res.Code, res.Body, tt.wantCode)
+			}
+			if reached != (tt.wantCode == http.StatusOK) {
+				t.Errorf("handler reached = %v", reached)
+			}
+			for _, want := range tt.wantMsg {
+				if !strings.Contains(res.Body.String(), want) {
+					t.Errorf("message %q does not mention %s", res.Body, want)
+				}
+			}
+		})
+	}
+}
+
+// Schema violations are reported per field, in the problem shape the
+// handler uses for its own validation
+func TestRequestValidator_ReportsEveryField(t *testing.T) {
+	v, err := NewRequestValidator(loadTestOpenAPI(t))
+	if err != nil {
+		t.Fatalf("new request validator: %v", err)
+	}
+	h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("an invalid request reached the handler")
+	}))
+
+	body := `{"legs":[{"client_id":"client_001","amount":"-100"},{"client_id":"client_002","amount":100,"memo":"x"}]}`
+	req := httptest.NewRequest(http.MethodPost, V1+"/split-transfers", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	if res.Code != http.StatusBadRequest || res.Header().Get("Content-Type") != ProblemContentType {
+		t.Fatalf("got %d %s: %s", res.Code, res.Header().Get("Content-Type"), res.Body)
+	}
+	p := decodeJSON[Problem](t, res)
+	var fields []string
+	for _, fe := range p.Errors {
+		fields = append(fields, fe.Field)
+	}
+	slices.Sort(fields)
+	want := []string{"idempotencyKey", "legs[0].amount", "legs[1].memo"}
+	if p.Code != CodeInvalidRequest || !slices.Equal(fields, want) {
+		t.Errorf("got code %s with fields %v, want %s with %v", p.Code, fields, CodeInvalidRequest, want)
+	}
+}
+
+// Through the validator, request bodies are answered as the handler
+// alone answers them, the way cmd/server chains the two
+func TestRequestValidator_KeepsHandlerBodyChecks(t *testing.T) {
+	v, err := NewRequestValidator(loadTestOpenAPI(t))
+	if err != nil {
+		t.Fatalf("new request validator: %v", err)
+	}
+	store := NewStubClient()
+	store.SeedClient("client_001", 1000, "JPY")
+	app := v.Middleware(NewHandler(store).WithLogger(discardLogger()))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantCode    string
+		wantFields  []string
+	}{
+		{"valid", "application/json",
+			`{"clientID":"client_001","amount":100,"currency":"JPY","idempotencyKey":"k1"}`, http.StatusOK, "", nil},
+		{"not json", "text/plain", `clientID=client_001`, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, nil},
+		{"no content type", "", `{}`, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, nil},
+		{"too large", "application/json",
+			`{"clientID":"` + strings.Repeat("x", MaxBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, nil},
+		{"malformed", "application/json", `{"clientID":`, http.StatusBadRequest, CodeInvalidRequest, nil},
+		{"every invalid field", "application/json", `{"clientID":"client 001","amount":0}`,
+			http.StatusBadRequest, CodeInvalidRequest, []string{"amount", "clientID", "currency", "idempotencyKey"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, V1+"/payments", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			res := httptest.NewRecorder()
+			app.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("got %d (%s), want %d", res.Code, res.Body, tt.wantStatus)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			p := decodeJSON[Problem](t, res)
+			var fields []string
+			for _, fe := range p.Errors {
+				fields = append(fields, fe.Field)
+			}
+			slices.Sort(fields)
+			if p.Code != tt.wantCode || !slices.Equal(fields, tt.wantFields) {
+				t.Errorf("got code %s with fields %v, want %s with %v", p.Code, fields, tt.wantCode, tt.wantFields)
+			}
+		})
+	}
+}
+
+// TestHandler_ResponsesConformToOpenAPI sends every documented request
+// through NewHandler and checks status, headers and body against the spec
+func TestHandler_ResponsesConformToOpenAPI(t *testing.T) {
+	router := openAPIRouter(t)
+	store := &conformanceStub{velocityStub: newVelocityStub(), entries: []Ledger{
+		{EntryId: uuid.New(), ClientId: "client_001", Amount: 500, Currency: "JPY", CreatedAt: time.Now().UTC(),
+			IdempotencyKey: sql.NullString{String: "k1", Valid: true}},
+		{EntryId: uuid.New(), ClientId: "client_001", Amount: -200, Currency: "JPY", CreatedAt: time.Now().UTC()},
+	}}
+	store.admin = &adminStub{StubStore: store.StubStore, adjustments: map[string]Adjustment{}}
+	store.SeedClient("client_002", 0, "JPY")
+	store.limits["client_001"] = []VelocityLimit{{ID: uuid.NewString(), ClientID: "client_001",
+		Kind: VelocityAmount, Max: 1000, WindowSeconds: 3600, CreatedAt: time.Now().UTC()}}
+	admin := &Principal{ID: "key:ops", ClientIDs: []string{"*"}, Admin: true}
+	portal := &Principal{ID: "key:portal", ClientIDs: []string{"client_001"}}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		as       *Principal
+		debitErr error
+		want     int
+	}{
+		{"balance", http.MethodGet, "/clients/client_001/balance", "", nil, nil, http.StatusOK},
+		{"balance unknown client", http.MethodGet, "/clients/nobody/balance", "", nil, nil, http.StatusNotFound},
+		{"balance forbidden", http.MethodGet, "/clients/client_002/balance", "", portal, nil, http.StatusForbidden},
+		{"ledger", http.MethodGet, "/clients/client_001/ledger", "", nil, nil, http.StatusOK},
+		{"ledger page", http.MethodGet, "/clients/client_001/ledger?limit=1", "", nil, nil, http.StatusOK},
+		{"ledger bad cursor", http.MethodGet, "/clients/client_001/ledger?cursor=x", "", nil, nil, http.StatusBadRequest},
+		{"payment", http.MethodPost, "/payments",
+			`{"clientID":"client_001","amount":100,"currency":"JPY","idempotencyKey":"p1"}`, nil, nil, http.StatusOK},
+		{"payment missing currency", http.MethodPost, "/payments",
+			`{"clientID":"client_001","amount":100,"currency":"","idempotencyKey":"p2"}`, nil, nil, http.StatusBadRequest},
+		{"transfer", http.MethodPost, "/transfer",
+			`{"from_client_id":"client_001","to_client_id":"client_002","amount":100,"idempotencyKey":"t1"}`, nil, nil, http.StatusOK},
+		{"transfer velocity limited", http.MethodPost, "/transfer",
+			`{"from_client_id":"client_001","to_client_id":"client_002","amount":100,"idempotencyKey":"t2"}`, nil,
+			&VelocityLimitError{Limit: store.limits["client_001"][0], Used: 1000}, http.StatusUnprocessableEntity},
+		{"transfer insufficient funds", http.MethodPost, "/transfer",
+			`{"from_client_id":"client_001","to_client_id":"client_002","amount":100,"idempotencyKey":"t4"}`, nil,
+			ErrInsufficientBalance, http.StatusUnprocessableEntity},
+		{"transfer to self", http.MethodPost, "/transfer",
+			`{"from_client_id":"client_001","to_client_id":"client_001","amount":100,"idempotencyKey":"t5"}`, nil, nil, http.StatusBadRequest},
+		{"transfer unknown field", http.MethodPost, "/transfer",
+			`{"from_client_id":"client_001","to_client_id":"client_002","amount":100,"idempotencyKey":"t6","memo":"x"}`, nil, nil, http.StatusBadRequest},
+		{"transfer unknown client", http.MethodPost, "/transfer",
+			`{"from_client_id":"client_001","to_client_id":"nobody","amount":100,"idempotencyKey":"t3"}`, nil,
+			ErrClientNotFound, http.StatusNotFound},
+		{"list limits", http.MethodGet, "/clients/client_001/limits", "", nil, nil, http.StatusOK},
+		{"create limit", http.MethodPost, "/clients/client_001/limits",
+			`{"kind":"count","max":5,"window_seconds":60}`, admin, nil, http.StatusCreated},
+		{"create limit not admin", http.MethodPost, "/clients/client_001/limits",
+			`{"kind":"count","max":5,"window_seconds":60}`, portal, nil, http.StatusForbidden},
+		{"delete limit", http.MethodDelete, "/clients/client_001/limits/" + store.limits["client_001"][0].ID, "", admin, nil, http.StatusNoContent},
+		{"delete unknown limit", http.MethodDelete, "/clients/client_001/limits/" + uuid.NewString(), "", admin, nil, http.StatusNotFound},
+		{"create client", http.MethodPost, "/clients", `{"client_id":"client_003","currency":"USD"}`, admin, nil, http.StatusCreated},
+		{"create existing client", http.MethodPost, "/clients", `{"client_id":"client_003","currency":"USD"}`, admin, nil, http.StatusConflict},
+		{"adjustment", http.MethodPost, "/clients/client_001/adjustments",
+			`{"amount":-100,"reason":"refund reversal","idempotency_key":"a1"}`, admin, nil, http.StatusCreated},
+		{"adjustment without reason", http.MethodPost, "/clients/client_001/adjustments",
+			`{"amount":-100,"reason":"","idempotency_key":"a2"}`, admin, nil, http.StatusBadRequest},
+		{"adjustment key reused", http.MethodPost, "/clients/client_001/adjustments",
+			`{"amount":50,"reason":"other","idempotency_key":"a1"}`, admin, nil, http.StatusConflict},
+		{"adjustment not admin", http.MethodPost, "/clients/client_001/adjustments",
+			`{"amount":50,"reason":"x","idempotency_key":"a3"}`, portal, nil, http.StatusForbidden},
+		{"reconcile", http.MethodPost, "/reconciliation", "", admin, nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.debitErr = tt.debitErr
+			req := httptest.NewRequest(tt.method, V1+tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if tt.as != nil {
+				req = req.WithContext(WithPrincipal(req.Context(), tt.as))
+			}
+			serveConforming(t, router, NewHandler(store), req, tt.want)
+		})
+	}
+}
+
+// openAPIRouter finds the operations of the spec
+func openAPIRouter(t *testing.T) routers.Router {
+	t.Helper()
+	router, err := legacy.NewRouter(loadTestOpenAPI(t))
+	if err != nil {
+		t.Fatalf("router: %v", err)
+	}
+	return router
+}
+
+// serveConforming serves req with h and fails the test unless it answers
+// want, and both req and the response match the spec. A request answered
+// 400 need not match it.
+func serveConforming(t *testing.T, router routers.Router, h http.Handler, req *http.Request, want int) {
+	t.Helper()
+	route, params, err := router.FindRoute(req)
+	if err != nil {
+		t.Fatalf("%s %s is not in the spec: %v", req.Method, req.URL.Path, err)
+	}
+	input := &openapi3filter.RequestValidationInput{
+		Request: req, PathParams: params, Route: route,
+		Options: &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+	}
+	if want != http.StatusBadRequest {
+		if err := openapi3filter.ValidateRequest(context.Background(), input); err != nil {
+			t.Fatalf("request does not match the spec: %v", err)
+		}
+	}
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	if res.Code != want {
+		t.Fatalf("got %d (%s), want %d", res.Code, res.Body, want)
+	}
+
+	err = openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 res.Code,
+		Header:                 res.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(res.Body.Bytes())),
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+	})
+	if err != nil {
+		t.Errorf("response does not match the spec: %v\nbody: %s", err, res.Body)
+	}
+}
+
+// conformanceStub serves a fixed ledger, successful transfers and the
+// admin API on top of velocityStub
+type conformanceStub struct {
+	*velocityStub
+	admin   *adminStub
+	entries []Ledger
+}
+
+func (s *conformanceStub) CreateClient(ctx context.Context, clientID, currency string) (Client, error) {
+	return s.admin.CreateClient(ctx, clientID, currency)
+}
+
+func (s *conformanceStub) PostAdjustment(ctx context.Context, adj Adjustment) (Adjustment, error) {
+	return s.admin.PostAdjustment(ctx, adj)
+}
+
+func (s *conformanceStub) Reconcile(ctx context.Context) (ReconciliationReport, error) {
+	return s.admin.Reconcile(ctx)
+}
+
+func (s *conformanceStub) GetLedger(ctx context.Context, clientID string) ([]Ledger, error) {
+	return s.entries, nil
+}
+
+func (s *conformanceStub) Transfer(ctx context.Context, from, to string, amount int64, key string) (int64, int64, error) {
+	if s.debitErr != nil {
+		return 0, 0, s.debitErr
+	}
+	return s.balances[from] - amount, s.balances[to] + amount, nil
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Page sizes for GET /clients/{id}/ledger?limit=
+const (
+	defaultLedgerPageSize = 100
+	maxLedgerPageSize     = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// LedgerCursor marks the last entry of a page. Entries are ordered by
+// creation time, then entry ID, so a cursor stays valid while new
+// entries are appended.
+type LedgerCursor struct {
+	CreatedAt time.Time
+	EntryID   uuid.UUID
+}
+
+func (c LedgerCursor) String() string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixNano(), 10) + ":" + c.EntryID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseLedgerCursor decodes a cursor returned as next_cursor
+func ParseLedgerCursor(s string) (LedgerCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return LedgerCursor{}, ErrInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return LedgerCursor{}, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return LedgerCursor{}, ErrInvalidCursor
+	}
+	entryID, err := uuid.Parse(id)
+	if err != nil {
+		return LedgerCursor{}, ErrInvalidCursor
+	}
+	return LedgerCursor{CreatedAt: time.Unix(0, n).UTC(), EntryID: entryID}, nil
+}
+
+func (c LedgerCursor) before(e Ledger) bool {
+	if !e.CreatedAt.Equal(c.CreatedAt) {
+		return e.CreatedAt.After(c.CreatedAt)
+	}
+	return bytes.Compare(e.EntryId[:], c.EntryID[:]) > 0
+}
+
+// LedgerPageStore is implemented by stores that can page through a
+// ledger without loading it whole. Other stores are paged in memory.
+type LedgerPageStore interface {
+	// GetLedgerPage returns up to limit entries after cursor, or from the
+	// start when cursor is nil, and whether more entries follow
+	GetLedgerPage(ctx context.Context, clientID string, cursor *LedgerCursor, limit int) ([]Ledger, bool, error)
+}
+
+func (s *Store) GetLedgerPage(
+	ctx context.Context,
+	clientID string,
+	cursor *LedgerCursor,
+	limit int,
+) (_ []Ledger, _ bool, err error) {
+	ctx, span := startSpan(ctx, "Store.GetLedgerPage",
+		attribute.String("client.id", clientID))
+	defer func() { endSpan(span, err) }()
+
+	var after *time.Time
+	var afterID uuid.UUID
+	if cursor != nil {
+		after, afterID = &cursor.CreatedAt, cursor.EntryID
+	}
+
+	// One extra row tells whether another page follows
+	rows, err := s.db.Query(ctx,
+		`SELECT e.entry_id, e.client_id, e.amount, c.currency, e.created_at, e.idempotency_key
+		FROM ledger_entries e JOIN clients c ON c.client_id = e.client_id
+		WHERE e.client_id = $1
+			AND ($2::timestamptz IS NULL OR (e.created_at, e.entry_id) > ($2, $3))
+		ORDER BY e.created_at, e.entry_id
+		LIMIT $4`, clientID, after, afterID, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	entries := make([]Ledger, 0, limit)
+	for rows.Next() {
+		var e Ledger
+		if err := rows.Scan(&e.EntryId, &e.ClientId, &e.Amount, &e.Currency, &e.CreatedAt, &e.IdempotencyKey); err != nil {
+			return nil, false, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(entries) > limit {
+		return entries[:limit], true, nil
+	}
+	return entries, false, nil
+}
+
+// pageLedger is the in-memory fallback for stores without
+// LedgerPageStore
+func pageLedger(entries []Ledger, cursor *LedgerCursor, limit int) ([]Ledger, bool) {
+	sorted := slices.Clone(entries)
+	slices.SortStableFunc(sorted, func(a, b Ledger) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.EntryId[:], b.EntryId[:])
+	})
+	if cursor != nil {
+		i := slices.IndexFunc(sorted, cursor.before)
+		if i < 0 {
+			i = len(sorted)
+		}
+		sorted = sorted[i:]
+	}
+	if len(sorted) > limit {
+		return sorted[:limit], true
+	}
+	return sorted, false
+}
+
+// getLedgerPage serves GET /clients/{id}/ledger?limit=&cursor=
+func (h *Handler) getLedgerPage(w http.ResponseWriter, r *http.Request, clientID string) {
+	query := r.URL.Query()
+	limit := defaultLedgerPageSize
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxLedgerPageSize {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest,
+				"limit must be between 1 and "+strconv.Itoa(maxLedgerPageSize))
+			return
+		}
+		limit = n
+	}
+	var cursor *LedgerCursor
+	if s := query.Get("cursor"); s != "" {
+		c, err := ParseLedgerCursor(s)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		cursor = &c
+	}
+
+	var entries []Ledger
+	var more bool
+	var err error
+	if store, ok := h.store.(LedgerPageStore); ok {
+		entries, more, err = store.GetLedgerPage(r.Context(), clientID, cursor, limit)
+	} else {
+		entries, err = h.store.GetLedger(r.Context(), clientID)
+		entries, more = pageLedger(entries, cursor, limit)
+	}
+	if err != nil {
+		h.writeError(w, r, "get ledger page failed", err, "client_id", clientID)
+		return
+	}
+
+	res := LedgerResponse{ClientID: clientID, Entries: entries}
+	if entries == nil {
+		res.Entries = []Ledger{}
+	}
+	if more {
+		last := entries[len(entries)-1]
+		res.NextCursor = LedgerCursor{CreatedAt: last.CreatedAt, EntryID: last.EntryId}.String()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ledgerStub serves a fixed ledger through GetLedger only, so the handler
+// pages it in memory
+type ledgerStub struct {
+	*StubStore
+	entries []Ledger
+}
+
+func (s *ledgerStub) GetLedger(ctx context.Context, clientID string) ([]Ledger, error) {
+	return s.entries, nil
+}
+
+func TestLedgerCursor_RoundTrip(t *testing.T) {
+	c := LedgerCursor{CreatedAt: time.Date(2026, 3, 4, 5, 6, 7, 123456000, time.UTC), EntryID: uuid.New()}
+	got, err := ParseLedgerCursor(c.String())
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !got.CreatedAt.Equal(c.CreatedAt) || got.EntryID != c.EntryID {
+		t.Errorf("got %+v, want %+v", got, c)
+	}
+
+	for _, bad := range []string{"!!", "bm9jb2xvbg", "MTIzOm5vdC1hLXV1aWQ"} {
+		if _, err := ParseLedgerCursor(bad); err != ErrInvalidCursor {
+			t.Errorf("ParseLedgerCursor(%q) = %v, want ErrInvalidCursor", bad, err)
+		}
+	}
+}
+
+func TestHandler_GetLedgerPages(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	stub := &ledgerStub{StubStore: NewStubClient()}
+	// Newest first and two entries sharing a timestamp, to check the
+	// order does not depend on the store's
+	for i := 4; i >= 0; i-- {
+		stub.entries = append(stub.entries, Ledger{EntryId: uuid.New(), ClientId: "client_001",
+			Amount: int64(i + 1), CreatedAt: start.Add(time.Duration(i/2) * time.Second)})
+	}
+	h := NewHandler(stub)
+
+	var amounts []int64
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("pagination does not terminate")
+		}
+		path := "/clients/client_001/ledger?limit=2"
+		if cursor != "" {
+			path += "&cursor=" + cursor
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		if res.Code != http.StatusOK {
+			t.Fatalf("got %d (%s)", res.Code, res.Body)
+		}
+		page := decodeJSON[LedgerResponse](t, res)
+		if len(page.Entries) > 2 {
+			t.Fatalf("page has %d entries, want at most 2", len(page.Entries))
+		}
+		for _, e := range page.Entries {
+			amounts = append(amounts, e.Amount)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if len(amounts) != 5 {
+		t.Fatalf("got %d entries across pages, want 5: %v", len(amounts), amounts)
+	}
+	for i := 1; i < len(amounts); i++ {
+		if (amounts[i]-1)/2 < (amounts[i-1]-1)/2 {
+			t.Errorf("entries are not oldest first: %v", amounts)
+		}
+	}
+	seen := map[int64]bool{}
+	for _, a := range amounts {
+		if seen[a] {
+			t.Errorf("entry %d returned twice", a)
+		}
+		seen[a] = true
+	}
+}
+
+func TestHandler_GetLedgerPageValidation(t *testing.T) {
+	h := NewHandler(&ledgerStub{StubStore: NewStubClient()})
+	for _, query := range []string{"limit=0", "limit=1001", "limit=abc", "cursor=%21%21"} {
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/clients/client_001/ledger?"+query, nil))
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", query, res.Code)
+		}
+	}
+
+	// Paged responses list no entries as [] rather than null
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/clients/client_001/ledger?limit=10", nil))
+	if got := res.Body.String(); got != `{"client_id":"client_001","ledger_entires":[]}`+"\n" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestStore_GetLedgerPage(t *testing.T) {
+	ctx, db, store := newTestStore(t)
+	if err := Migrate(ctx, db.Pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	clientID := "paging_" + uniqueKey(t)[:12]
+	seedClient(t, ctx, db, clientID, 0, "JPY")
+	for i := 0; i < 5; i++ {
+		if _, err := store.CreatePayment(ctx, clientID, int64(i+1), uniqueKey(t)); err != nil {
+			t.Fatalf("payment %d: %v", i, err)
+		}
+	}
+
+	var got []int64
+	var cursor *LedgerCursor
+	for {
+		entries, more, err := store.GetLedgerPage(ctx, clientID, cursor, 2)
+		if err != nil {
+			t.Fatalf("get ledger page: %v", err)
+		}
+		for _, e := range entries {
+			got = append(got, e.Amount)
+		}
+		if !more {
+			break
+		}
+		last := entries[len(entries)-1]
+		cursor = &LedgerCursor{CreatedAt: last.CreatedAt, EntryID: last.EntryId}
+	}
+
+	if fmt.Sprint(got) != "[1 2 3 4 5]" {
+		t.Errorf("got %v across pages, want [1 2 3 4 5]", got)
+	}
+}
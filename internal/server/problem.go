@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+)
+
+// Error codes sent in Problem.Code. They are part of the API: clients
+// branch on them, so a code never changes meaning once released.
+const (
+	CodeInvalidRequest        = "invalid_request"
+	CodeUnauthorized          = "unauthorized"
+	CodeForbidden             = "forbidden"
+	CodeNotFound              = "not_found"
+	CodeClientNotFound        = "client_not_found"
+	CodeVelocityLimitNotFound = "velocity_limit_not_found"
+	CodeFeeScheduleNotFound   = "fee_schedule_not_found"
+	CodeInterestRateNotFound  = "interest_rate_not_found"
+	CodeTransferNotFound      = "transfer_not_found"
+	CodeMethodNotAllowed      = "method_not_allowed"
+	CodeRequestTooLarge       = "request_too_large"
+	CodeUnsupportedMediaType  = "unsupported_media_type"
+	CodeClientExists          = "client_exists"
+	CodeIdempotencyConflict   = "idempotency_conflict"
+	CodeFeeScheduleExists     = "fee_schedule_exists"
+	CodeTransferNotPending    = "transfer_not_pending"
+	CodeTransferExpired       = "transfer_expired"
+	CodeSelfApproval          = "self_approval"
+	CodeInsufficientFunds     = "insufficient_funds"
+	CodeAmountOutOfRange      = "amount_out_of_range"
+	CodeCurrencyMismatch      = "currency_mismatch"
+	CodeApprovalRequired      = "approval_required"
+	CodeVelocityLimitExceeded = "velocity_limit_exceeded"
+	CodeRateLimited           = "rate_limited"
+	CodeInternal              = "internal_error"
+	CodeNotImplemented        = "not_implemented"
+)
+
+// ProblemContentType is the media type of every error response
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body. Type is always
+// "about:blank", so Title is the status text; Code tells errors with the
+// same status apart.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	// Errors lists every invalid field of a rejected request body
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// HTTPError is an error together with the status and code it is answered
+// with. Store errors are mapped to one by httpError.
+type HTTPError struct {
+	Status int
+	Code   string
+	Detail string
+	Errors []FieldError
+}
+
+func (e *HTTPError) Error() string {
+	return e.Detail
+}
+
+// httpError maps the errors stores return to their response. It returns
+// false for unexpected errors, whose details must not reach the caller.
+func httpError(err error) (*HTTPError, bool) {
+	var he *HTTPError
+	var invalid ValidationError
+	var limitErr *VelocityLimitError
+	switch {
+	case errors.As(err, &he):
+		return he, true
+	case errors.As(err, &invalid):
+		return invalid.httpError(), true
+	case errors.As(err, &limitErr):
+		return &HTTPError{Status: http.StatusUnprocessableEntity, Code: CodeVelocityLimitExceeded, Detail: limitErr.Error()}, true
+	case errors.Is(err, ErrClientNotFound):
+		return &HTTPError{Status: http.StatusNotFound, Code: CodeClientNotFound, Detail: ErrClientNotFound.Error()}, true
+	case errors.Is(err, ErrVelocityLimitNotFound):
+		return &HTTPError{Status: http.StatusNotFound, Code: CodeVelocityLimitNotFound, Detail: ErrVelocityLimitNotFound.Error()}, true
+	case errors.Is(err, ErrFeeScheduleNotFound):
+		return &HTTPError{Status: http.StatusNotFound, Code: CodeFeeScheduleNotFound, Detail: ErrFeeScheduleNotFound.Error()}, true
+	case errors.Is(err, ErrInterestRateNotFound):
+		return &HTTPError{Status: http.StatusNotFound, Code: CodeInterestRateNotFound, Detail: ErrInterestRateNotFound.Error()}, true
+	case errors.Is(err, ErrTransferNotFound):
+		return &HTTPError{Status: http.StatusNotFound, Code: CodeTransferNotFound, Detail: ErrTransferNotFound.Error()}, true
+	case errors.Is(err, ErrInsufficientBalance):
+		return &HTTPError{Status: http.StatusUnprocessableEntity, Code: CodeInsufficientFunds, Detail: ErrInsufficientBalance.Error()}, true
+	case errors.Is(err, money.ErrOverflow):
+		return &HTTPError{Status: http.StatusUnprocessableEntity, Code: CodeAmountOutOfRange, Detail: "the resulting balance is out of range"}, true
+	case errors.Is(err, money.ErrCurrencyMismatch):
+		return &HTTPError{Status: http.StatusUnprocessableEntity, Code: CodeCurrencyMismatch, Detail: "the currencies do not match"}, true
+	case errors.Is(err, ErrClientExists):
+		return &HTTPError{Status: http.StatusConflict, Code: CodeClientExists, Detail: ErrClientExists.Error()}, true
+	case errors.Is(err, ErrFeeScheduleExists):
+		return &HTTPError{Status: http.StatusConflict, Code: CodeFeeScheduleExists, Detail: ErrFeeScheduleExists.Error()}, true
+	case errors.Is(err, ErrTransferNotPending):
+		return &HTTPError{Status: http.StatusConflict, Code: CodeTransferNotPending, Detail: ErrTransferNotPending.Error()}, true
+	case errors.Is(err, ErrTransferRejected):
+		return &HTTPError{Status: http.StatusConflict, Code: CodeTransferNotPending, Detail: ErrTransferRejected.Error()}, true
+	case errors.Is(err, ErrTransferExpired):
+		return &HTTPError{Status: http.StatusConflict, Code: CodeTransferExpired, Detail: ErrTransferExpired.Error()}, true
+	case errors.Is(err, ErrSelfApproval):
+		return &HTTPError{Status: http.StatusForbidden, Code: CodeSelfApproval, Detail: ErrSelfApproval.Error()}, true
+	case errors.Is(err, ErrApproverRequired):
+		return &HTTPError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Detail: ErrApproverRequired.Error()}, true
+	case errors.Is(err, ErrIdempotencyConflict):
+		return &HTTPError{Status: http.StatusConflict, Code: CodeIdempotencyConflict, Detail: ErrIdempotencyConflict.Error()}, true
+	case errors.Is(err, ErrApprovalRequired):
+		// Only a split transfer gets here; a single one is held instead
+		return &HTTPError{Status: http.StatusUnprocessableEntity, Code: CodeApprovalRequired,
+			Detail: "the amount needs approval, which only single transfers can wait for"}, true
+	case errors.Is(err, ErrSelfTransfer):
+		return &HTTPError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: ErrSelfTransfer.Error()}, true
+	case errors.Is(err, ErrInvalidCursor):
+		return &HTTPError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: err.Error()}, true
+	}
+	return nil, false
+}
+
+// writeError logs err as msg and answers with the matching problem.
+// Unexpected errors are logged as warnings and answered 500 without their
+// details.
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, msg string, err error, attrs ...any) {
+	attrs = append(attrs, "err", err)
+	he, ok := httpError(err)
+	if !ok {
+		h.logger.WarnContext(r.Context(), msg, attrs...)
+		he = &HTTPError{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: "internal error"}
+	} else {
+		h.logger.InfoContext(r.Context(), msg, append(attrs, "code", he.Code)...)
+	}
+	writeHTTPError(w, r, he)
+}
+
+// writeProblem writes a problem details response
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	writeHTTPError(w, r, &HTTPError{Status: status, Code: code, Detail: detail})
+}
+
+func writeHTTPError(w http.ResponseWriter, r *http.Request, he *HTTPError) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(he.Status)
+	json.NewEncoder(w).Encode(Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(he.Status),
+		Status:    he.Status,
+		Detail:    he.Detail,
+		Instance:  r.URL.Path,
+		Code:      he.Code,
+		RequestID: RequestIDFromContext(r.Context()),
+		Errors:    he.Errors,
+	})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+}
+
+func writeNotFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusNotFound, CodeNotFound, "the endpoint not found")
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+)
+
+// failingStore fails every transfer with err
+type failingStore struct {
+	*StubStore
+	err error
+}
+
+func (s *failingStore) Transfer(ctx context.Context, from, to string, amount int64, key string) (int64, int64, error) {
+	return 0, 0, s.err
+}
+
+func TestHandler_ErrorsAreProblems(t *testing.T) {
+	transfer := `{"from_client_id":"client_001","to_client_id":"client_002","amount":100,"idempotencyKey":"k"}`
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		storeErr   error
+		wantStatus int
+		wantCode   string
+	}{
+		{"unknown client", http.MethodGet, "/clients/nobody/balance", "", nil, http.StatusNotFound, CodeClientNotFound},
+		{"unknown subroute", http.MethodGet, "/clients/client_001/unknown", "", nil, http.StatusNotFound, CodeNotFound},
+		{"unknown path", http.MethodGet, "/does-not-exist", "", nil, http.StatusNotFound, CodeNotFound},
+		{"wrong method", http.MethodGet, "/transfer", "", nil, http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+		{"malformed body", http.MethodPost, "/transfer", "{", nil, http.StatusBadRequest, CodeInvalidRequest},
+		{"insufficient funds", http.MethodPost, "/transfer", transfer, ErrInsufficientBalance,
+			http.StatusUnprocessableEntity, CodeInsufficientFunds},
+		{"balance out of range", http.MethodPost, "/transfer", transfer, money.ErrOverflow,
+			http.StatusUnprocessableEntity, CodeAmountOutOfRange},
+		{"currency mismatch", http.MethodPost, "/transfer", transfer, fmt.Errorf("%w: JPY and USD", money.ErrCurrencyMismatch),
+			http.StatusUnprocessableEntity, CodeCurrencyMismatch},
+		{"unknown recipient", http.MethodPost, "/transfer", transfer, ErrClientNotFound,
+			http.StatusNotFound, CodeClientNotFound},
+		{"idempotency conflict", http.MethodPost, "/transfer", transfer, ErrIdempotencyConflict,
+			http.StatusConflict, CodeIdempotencyConflict},
+		{"unexpected error", http.MethodPost, "/transfer", transfer, errors.New("pq: connection reset at 10.0.0.7"),
+			http.StatusInternalServerError, CodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &failingStore{StubStore: NewStubClient(), err: tt.storeErr}
+			store.SeedClient("client_001", 1000, "JPY")
+			h := NewHandler(store).WithLogger(discardLogger())
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			req = req.WithContext(context.WithValue(req.Context(), requestIDKey{}, "req-1"))
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("got %d (%s), want %d", res.Code, res.Body, tt.wantStatus)
+			}
+			if ct := res.Header().Get("Content-Type"); ct != ProblemContentType {
+				t.Errorf("got Content-Type %q, want %q", ct, ProblemContentType)
+			}
+			p := decodeJSON[Problem](t, res)
+			if p.Code != tt.wantCode || p.Status != tt.wantStatus || p.Title != http.StatusText(tt.wantStatus) {
+				t.Errorf("got %+v, want code %s and status %d", p, tt.wantCode, tt.wantStatus)
+			}
+			if p.Instance != tt.path || p.RequestID != "req-1" {
+				t.Errorf("got instance %q and request id %q", p.Instance, p.RequestID)
+			}
+			if strings.Contains(res.Body.String(), "10.0.0.7") {
+				t.Errorf("internal error leaked: %s", res.Body)
+			}
+		})
+	}
+}
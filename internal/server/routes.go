@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// V1 prefixes every route of the current API version
+const V1 = "/v1"
+
+// unversionedDeprecatedAt is when the routes without a version prefix
+// were deprecated in favor of /v1. They keep working; responses carry
+// Deprecation and Link headers pointing at the /v1 path.
+var unversionedDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// route is one operation of the API. path is relative to the version
+// prefix and may use ServeMux wildcards such as {id}.
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+}
+
+// v1Routes is the /v1 route table. A new version gets a table of its
+// own that reuses the handlers whose behavior it keeps, so clients of
+// older versions are never affected by it.
+func (h *Handler) v1Routes() []route {
+	return append(h.legacyRoutes(),
+		route{http.MethodGet, "/clients/{id}/fee-quote", h.quoteFee},
+		route{http.MethodPut, "/clients/{id}/fee-group", h.setFeeGroup},
+		route{http.MethodGet, "/fee-schedules", h.listFeeSchedules},
+		route{http.MethodPost, "/fee-schedules", h.createFeeSchedule},
+		route{http.MethodDelete, "/fee-schedules/{schedule_id}", h.deleteFeeSchedule},
+		route{http.MethodGet, "/clients/{id}/interest-rate", h.getInterestRate},
+		route{http.MethodPut, "/clients/{id}/interest-rate", h.setInterestRate},
+		route{http.MethodGet, "/clients/{id}/interest-postings", h.listInterestPostings},
+		route{http.MethodPost, "/interest-runs", h.runInterest},
+		route{http.MethodGet, "/transfers", h.listPendingTransfers},
+		route{http.MethodGet, "/transfers/{transfer_id}", h.getPendingTransfer},
+		route{http.MethodPost, "/transfers/{transfer_id}/approve", h.approveTransfer},
+		route{http.MethodPost, "/transfers/{transfer_id}/reject", h.rejectTransfer},
+		route{http.MethodPost, "/split-transfers", h.splitTransfer},
+	)
+}
+
+// legacyRoutes are the routes that were served before /v1. Until they are
+// removed they are also served without a prefix; routes added since are
+// only served under /v1.
+func (h *Handler) legacyRoutes() []route {
+	return []route{
+		{http.MethodPost, "/payments", h.postPayments},
+		{http.MethodPost, "/transfer", h.transferMoney},
+		{http.MethodGet, "/clients/{id}/balance", h.getBalance},
+		{http.MethodGet, "/clients/{id}/ledger", h.getLedger},
+		{http.MethodGet, "/clients/{id}/limits", h.listVelocityLimits},
+		{http.MethodPost, "/clients/{id}/limits", h.createVelocityLimit},
+		{http.MethodDelete, "/clients/{id}/limits/{limit_id}", h.deleteVelocityLimit},
+		{http.MethodPost, "/clients", h.createClient},
+		{http.MethodPost, "/clients/{id}/adjustments", h.postAdjustment},
+		{http.MethodPost, "/reconciliation", h.reconcile},
+	}
+}
+
+// register mounts the routes under prefix
+func register(mux *http.ServeMux, prefix string, routes []route) {
+	for _, rt := range routes {
+		mux.HandleFunc(rt.method+" "+prefix+rt.path, rt.handler)
+	}
+}
+
+// registerUnversioned mounts routes without a prefix, as they were served
+// before /v1, marked deprecated in favor of successor
+func registerUnversioned(mux *http.ServeMux, successor string, routes []route) {
+	deprecation := "@" + strconv.FormatInt(unversionedDeprecatedAt.Unix(), 10)
+	for _, rt := range routes {
+		next := rt.handler
+		mux.HandleFunc(rt.method+" "+rt.path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", deprecation)
+			w.Header().Add("Link", "<"+successor+r.URL.Path+`>; rel="successor-version"`)
+			next(w, r)
+		})
+	}
+}
+
+// routeOf is the path template of the route that matched r, such as
+// "/v1/clients/{id}/balance", or "" when none did. It labels metrics, logs
+// and spans; the method is reported separately.
+func routeOf(r *http.Request) string {
+	pattern := r.Pattern
+	if pattern == "" {
+		if m, ok := r.Context().Value(matchedRouteKey{}).(*matchedRoute); ok {
+			pattern = m.pattern
+		}
+	}
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		return path
+	}
+	return pattern
+}
+
+type matchedRouteKey struct{}
+
+// matchedRoute carries the pattern Handler matched back out to the
+// middleware that created it. ServeMux sets r.Pattern only on the request
+// it is given, which is a copy whenever a middleware in between replaced
+// the context.
+type matchedRoute struct {
+	pattern string
+}
+
+// withMatchedRoute returns r with a matchedRoute for Handler to fill in,
+// sharing the one of an outer middleware if there is one
+func withMatchedRoute(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(matchedRouteKey{}).(*matchedRoute); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), matchedRouteKey{}, &matchedRoute{}))
+}
+
+// setMatchedRoute records pattern for the middleware around Handler
+func setMatchedRoute(r *http.Request, pattern string) {
+	if m, ok := r.Context().Value(matchedRouteKey{}).(*matchedRoute); ok {
+		m.pattern = pattern
+	}
+}
+
+// statusProbe records the status a handler would answer with, discarding
+// the body
+type statusProbe struct {
+	header http.Header
+	status int
+}
+
+func (p *statusProbe) Header() http.Header         { return p.header }
+func (p *statusProbe) Write(b []byte) (int, error) { return len(b), nil }
+func (p *statusProbe) WriteHeader(status int)      { p.status = status }
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRoutes_VersionedAndDeprecated(t *testing.T) {
+	store := NewStubClient()
+	store.SeedClient("client_001", 10000, "JPY")
+	h := NewHandler(store)
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/v1/clients/client_001/balance", nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("/v1: got %d (%s), want %d", res.Code, res.Body, http.StatusOK)
+	}
+	if got := res.Header().Get("Deprecation"); got != "" {
+		t.Errorf("/v1 is deprecated: %q", got)
+	}
+
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/clients/client_001/balance", nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("unversioned: got %d (%s), want %d", res.Code, res.Body, http.StatusOK)
+	}
+	if got, want := res.Header().Get("Deprecation"), "@"+strconv.FormatInt(unversionedDeprecatedAt.Unix(), 10); got != want {
+		t.Errorf("got Deprecation %q, want %q", got, want)
+	}
+	if got, want := res.Header().Get("Link"), `</v1/clients/client_001/balance>; rel="successor-version"`; got != want {
+		t.Errorf("got Link %q, want %q", got, want)
+	}
+}
+
+func TestRoutes_UnmatchedAreProblems(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantAllow  string
+	}{
+		{"client without subroute", http.MethodGet, "/clients/client_001", http.StatusNotFound, ""},
+		{"short path", http.MethodGet, "/v1/clients/abc", http.StatusNotFound, ""},
+		{"unknown version", http.MethodGet, "/v2/clients/client_001/balance", http.StatusNotFound, ""},
+		{"extra segment", http.MethodDelete, "/v1/clients/client_001/limits/a/b", http.StatusNotFound, ""},
+		{"wrong method", http.MethodDelete, "/v1/clients/client_001/balance", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"wrong method unversioned", http.MethodGet, "/payments", http.StatusMethodNotAllowed, "POST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			NewHandler(NewStubClient()).ServeHTTP(res, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("got %d (%s), want %d", res.Code, res.Body, tt.wantStatus)
+			}
+			if ct := res.Header().Get("Content-Type"); ct != ProblemContentType {
+				t.Errorf("got Content-Type %q, want %q", ct, ProblemContentType)
+			}
+			if got := res.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("got Allow %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+)
+
+// MaxTransferLegs bounds the legs of one split transfer
+const MaxTransferLegs = 100
+
+// TransferLeg is one side of a split transfer: a debit of the client when
+// Amount is negative, a credit when it is positive
+type TransferLeg struct {
+	ClientID string `json:"client_id"`
+	Amount   int64  `json:"amount"`
+}
+
+// SplitTransferStore is implemented by stores that move funds between
+// more than two clients at once
+type SplitTransferStore interface {
+	// SplitTransfer posts every leg or none. The legs must pass
+	// ValidateLegs and their clients must hold one currency. Each debited
+	// client pays its transfer fee on what it sends and is held to its
+	// velocity limits. The debits carry idempotencyKey, and a replay
+	// returns the current balances. It returns the new balance of each
+	// leg's client, in leg order.
+	SplitTransfer(ctx context.Context, legs []TransferLeg, idempotencyKey string) ([]int64, error)
+}
+
+// ValidateLegs checks that legs debit and credit distinct clients and sum
+// to zero
+func ValidateLegs(legs []TransferLeg) error {
+	var v validator
+	v.legs("legs", legs)
+	return v.err()
+}
+
+func (v *validator) legs(field string, legs []TransferLeg) {
+	if len(legs) < 2 || len(legs) > MaxTransferLegs {
+		v.check(false, field, "must have 2 to %d legs", MaxTransferLegs)
+		return
+	}
+	seen := make(map[string]bool, len(legs))
+	var debits, credits, sum int64
+	for i, leg := range legs {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		v.clientID(f+".client_id", leg.ClientID)
+		v.check(!seen[leg.ClientID], f+".client_id", "must not appear in another leg")
+		seen[leg.ClientID] = true
+		v.amount(f+".amount", leg.Amount, false)
+		// Bounded by MaxAmount, MaxTransferLegs amounts cannot overflow
+		if leg.Amount < 0 {
+			debits++
+		} else {
+			credits++
+		}
+		sum += leg.Amount
+	}
+	v.check(debits > 0 && credits > 0, field, "must debit and credit at least one client each")
+	v.check(sum == 0, field, "must sum to zero, not %d", sum)
+}
+
+// SplitTotal is the amount a split transfer moves: the sum of its debits
+func SplitTotal(legs []TransferLeg) int64 {
+	var total int64
+	for _, leg := range legs {
+		if leg.Amount < 0 {
+			total -= leg.Amount
+		}
+	}
+	return total
+}
+
+// LegClientIDs returns the clients of legs in ID order, the order stores
+// lock them in so overlapping transfers cannot deadlock
+func LegClientIDs(legs []TransferLeg) []string {
+	ids := make([]string, len(legs))
+	for i, leg := range legs {
+		ids[i] = leg.ClientID
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func (s *Store) SplitTransfer(ctx context.Context, legs []TransferLeg, idempotencyKey string) (_ []int64, err error) {
+	ctx, span := startSpan(ctx, "Store.SplitTransfer",
+		attribute.Int("transfer.legs", len(legs)))
+	defer func() { endSpan(span, err) }()
+
+	if err := ValidateLegs(legs); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if idempotencyKey != "" {
+		var count int
+		err := tx.QueryRow(ctx,
+			`SELECT COUNT(*) FROM ledger_entries WHERE idempotency_key = $1`,
+			idempotencyKey).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			s.logger.DebugContext(ctx, "split transfer replayed", "idempotency_key", idempotencyKey)
+			balances, err := lockClients(ctx, tx, LegClientIDs(legs)...)
+			if err != nil {
+				return nil, err
+			}
+			return legBalances(legs, balances)
+		}
+		// The key of a transfer awaiting approval is taken
+		if _, err := s.pendingTransferByKey(ctx, tx, idempotencyKey); !errors.Is(err, ErrTransferNotFound) {
+			if err == nil {
+				err = ErrIdempotencyConflict
+			}
+			return nil, err
+		}
+	}
+
+	// A split transfer cannot be held, so one that needs approval is
+	// refused rather than let through
+	if s.approvals.Requires(SplitTotal(legs)) {
+		return nil, ErrApprovalRequired
+	}
+
+	balances, sent, fees, err := s.splitTransfer(ctx, tx, legs, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	s.metrics.observeTransfer(sent.Currency, sent.Amount)
+	for _, fee := range fees {
+		s.metrics.observeFee(fee.Currency, FeeTransfer, fee.Fee)
+	}
+	return balances, nil
+}
+
+// splitTransfer posts legs and charges each debited client's fee inside
+// tx, which the caller commits. It returns the new balances in leg order,
+// the amount moved and the fees charged.
+func (s *Store) splitTransfer(ctx context.Context, tx pgx.Tx, legs []TransferLeg, idempotencyKey string) ([]int64, money.Money, []FeeQuote, error) {
+	locked, err := lockClients(ctx, tx, LegClientIDs(legs)...)
+	if err != nil {
+		return nil, money.Money{}, nil, err
+	}
+	if _, err := legBalances(legs, locked); err != nil {
+		return nil, money.Money{}, nil, err
+	}
+
+	// Every leg is in the currency of the first; a client holding another
+	// one fails with money.ErrCurrencyMismatch
+	currency := locked[legs[0].ClientID].Currency
+	next := make([]money.Money, len(legs))
+	fees := make([]FeeQuote, len(legs))
+	for i, leg := range legs {
+		if next[i], err = locked[leg.ClientID].Add(money.New(leg.Amount, currency)); err != nil {
+			return nil, money.Money{}, nil, err
+		}
+		if leg.Amount > 0 {
+			continue
+		}
+		// Each sender pays the fee on what it sends, on top of it
+		if fees[i], err = s.quoteFee(ctx, tx, leg.ClientID, FeeTransfer, -leg.Amount, currency); err != nil {
+			return nil, money.Money{}, nil, err
+		}
+		if next[i], err = next[i].Sub(money.New(fees[i].Fee, currency)); err != nil {
+			return nil, money.Money{}, nil, err
+		}
+		if next[i].IsNegative() {
+			s.metrics.observeInsufficientBalance("transfer")
+			s.logger.InfoContext(ctx, "split transfer rejected for insufficient balance",
+				"client_id", leg.ClientID, "balance", locked[leg.ClientID].Amount, "amount", -leg.Amount, "fee", fees[i].Fee)
+			return nil, money.Money{}, nil, ErrInsufficientBalance
+		}
+		if err = s.checkVelocity(ctx, tx, "transfer", leg.ClientID, -leg.Amount); err != nil {
+			return nil, money.Money{}, nil, err
+		}
+	}
+
+	// Every balance is written before any fee is posted: posting reads the
+	// balance of the fee revenue account, which may be one of the legs
+	balances := make([]int64, len(legs))
+	for i, leg := range legs {
+		balances[i] = next[i].Amount
+		if _, err = tx.Exec(ctx,
+			`UPDATE clients SET balance = $1 WHERE client_id = $2`, balances[i], leg.ClientID); err != nil {
+			return nil, money.Money{}, nil, err
+		}
+	}
+	var charged []FeeQuote
+	for i, leg := range legs {
+		// Only the debits carry the key, like the debit of a transfer
+		var key *string
+		if leg.Amount < 0 {
+			key = &idempotencyKey
+		}
+		var entryID string
+		err = tx.QueryRow(ctx,
+			`INSERT INTO ledger_entries (entry_id, client_id, amount, idempotency_key)
+			VALUES (gen_random_uuid(), $1, $2, $3)
+			RETURNING entry_id::text`,
+			leg.ClientID, leg.Amount, key).Scan(&entryID)
+		if err != nil {
+			return nil, money.Money{}, nil, err
+		}
+		if fees[i].Fee > 0 {
+			if err = s.postFee(ctx, tx, fees[i], entryID); err != nil {
+				return nil, money.Money{}, nil, err
+			}
+			charged = append(charged, fees[i])
+		}
+	}
+	return balances, money.New(SplitTotal(legs), currency), charged, nil
+}
+
+// legBalances returns the balance of each leg's client, in leg order
+func legBalances(legs []TransferLeg, balances map[string]money.Money) ([]int64, error) {
+	out := make([]int64, len(legs))
+	for i, leg := range legs {
+		b, ok := balances[leg.ClientID]
+		if !ok {
+			return nil, ErrClientNotFound
+		}
+		out[i] = b.Amount
+	}
+	return out, nil
+}
+
+// SplitTransferRequest is the body of POST /split-transfers
+type SplitTransferRequest struct {
+	Legs           []TransferLeg `json:"legs"`
+	IdempotencyKey string        `json:"idempotencyKey"`
+}
+
+// TransferLegBalance is a posted leg and its client's balance after it
+type TransferLegBalance struct {
+	TransferLeg
+	NewBalance int64 `json:"new_balance"`
+}
+
+// SplitTransferResponse lists the legs of a split transfer in request
+// order
+type SplitTransferResponse struct {
+	Legs []TransferLegBalance `json:"legs"`
+}
+
+func (h *Handler) splitTransferStore(w http.ResponseWriter, r *http.Request) (SplitTransferStore, bool) {
+	store, ok := h.store.(SplitTransferStore)
+	if !ok {
+		writeProblem(w, r, http.StatusNotImplemented, CodeNotImplemented, "split transfers are not supported by this store")
+	}
+	return store, ok
+}
+
+// splitTransfer serves POST /split-transfers
+func (h *Handler) splitTransfer(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.splitTransferStore(w, r)
+	if !ok {
+		return
+	}
+	var req SplitTransferRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	var v validator
+	v.legs("legs", req.Legs)
+	v.idempotencyKey("idempotencyKey", req.IdempotencyKey)
+	if err := v.err(); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	// Only the paying sides need to belong to the caller
+	for _, leg := range req.Legs {
+		if leg.Amount < 0 && !authorizeClient(w, r, leg.ClientID) {
+			return
+		}
+	}
+
+	balances, err := store.SplitTransfer(r.Context(), req.Legs, req.IdempotencyKey)
+	if err != nil {
+		h.writeError(w, r, "split transfer failed", err,
+			"legs", len(req.Legs), "amount", SplitTotal(req.Legs))
+		return
+	}
+	res := SplitTransferResponse{Legs: make([]TransferLegBalance, len(req.Legs))}
+	for i, leg := range req.Legs {
+		res.Legs[i] = TransferLegBalance{TransferLeg: leg, NewBalance: balances[i]}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
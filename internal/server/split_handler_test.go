@@ -0,0 +1,140 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/koki1610168/go-payment-ledger/internal/memstore"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// newSplitStore needs approval for splits moving more than 10000, with
+// client_001 holding 10000, client_002 nothing and client_003 500
+func newSplitStore(t *testing.T) *memstore.Store {
+	t.Helper()
+	store := memstore.New().WithApprovals(server.ApprovalPolicy{Threshold: 10000, TTL: time.Hour})
+	seed(t, store, "client_001", 10000)
+	seed(t, store, "client_002", 0)
+	seed(t, store, "client_003", 500)
+	return store
+}
+
+func TestHandler_SplitTransfer(t *testing.T) {
+	store := newSplitStore(t)
+	handler := newHandler(store)
+	body := `{"legs":[{"client_id":"client_001","amount":-3000},{"client_id":"client_002","amount":2500},` +
+		`{"client_id":"client_003","amount":500}],"idempotencyKey":"s1"}`
+
+	for range 2 {
+		res := serveAs(handler, nil, http.MethodPost, server.V1+"/split-transfers", body)
+		if res.Code != http.StatusOK {
+			t.Fatalf("got %d, want %d: %s", res.Code, http.StatusOK, res.Body)
+		}
+		got := decodeJSON[server.SplitTransferResponse](t, res)
+		want := []server.TransferLegBalance{
+			{TransferLeg: server.TransferLeg{ClientID: "client_001", Amount: -3000}, NewBalance: 7000},
+			{TransferLeg: server.TransferLeg{ClientID: "client_002", Amount: 2500}, NewBalance: 2500},
+			{TransferLeg: server.TransferLeg{ClientID: "client_003", Amount: 500}, NewBalance: 1000},
+		}
+		if len(got.Legs) != len(want) {
+			t.Fatalf("got %+v, want %+v", got.Legs, want)
+		}
+		for i := range want {
+			if got.Legs[i] != want[i] {
+				t.Errorf("leg %d is %+v, want %+v", i, got.Legs[i], want[i])
+			}
+		}
+	}
+	if b := balance(t, store, "client_001"); b != 7000 {
+		t.Errorf("client_001 holds %d after a replay, want 7000", b)
+	}
+
+	// Split transfers are only served under /v1
+	res := serveAs(handler, nil, http.MethodPost, "/split-transfers", body)
+	if res.Code != http.StatusNotFound {
+		t.Errorf("unversioned: got %d, want %d", res.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandler_SplitTransferErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		principal  *server.Principal
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"unbalanced", nil,
+			`{"legs":[{"client_id":"client_001","amount":-100},{"client_id":"client_002","amount":90}],"idempotencyKey":"k"}`,
+			http.StatusBadRequest, server.CodeInvalidRequest},
+		{"missing key", nil,
+			`{"legs":[{"client_id":"client_001","amount":-100},{"client_id":"client_002","amount":100}]}`,
+			http.StatusBadRequest, server.CodeInvalidRequest},
+		{"unknown field", nil,
+			`{"legs":[{"client_id":"client_001","amount":-100,"memo":"x"},{"client_id":"client_002","amount":100}],"idempotencyKey":"k"}`,
+			http.StatusBadRequest, server.CodeInvalidRequest},
+		{"unknown client", nil,
+			`{"legs":[{"client_id":"client_001","amount":-100},{"client_id":"nobody","amount":100}],"idempotencyKey":"k"}`,
+			http.StatusNotFound, server.CodeClientNotFound},
+		{"insufficient funds", nil,
+			`{"legs":[{"client_id":"client_003","amount":-600},{"client_id":"client_002","amount":600}],"idempotencyKey":"k"}`,
+			http.StatusUnprocessableEntity, server.CodeInsufficientFunds},
+		{"needs approval", nil,
+			`{"legs":[{"client_id":"client_001","amount":-10001},{"client_id":"client_002","amount":10001}],"idempotencyKey":"k"}`,
+			http.StatusUnprocessableEntity, server.CodeApprovalRequired},
+		// A key may pay into any client but only debit its own
+		{"debit of another client", &server.Principal{ID: "key:seller", ClientIDs: []string{"client_002"}},
+			`{"legs":[{"client_id":"client_001","amount":-100},{"client_id":"client_002","amount":100}],"idempotencyKey":"k"}`,
+			http.StatusForbidden, server.CodeForbidden},
+		{"credit of another client", &server.Principal{ID: "key:buyer", ClientIDs: []string{"client_001"}},
+			`{"legs":[{"client_id":"client_001","amount":-100},{"client_id":"client_002","amount":100}],"idempotencyKey":"k"}`,
+			http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newHandler(newSplitStore(t))
+			res := serveAs(handler, tt.principal, http.MethodPost, server.V1+"/split-transfers", tt.body)
+			if res.Code != tt.wantStatus {
+				t.Fatalf("got %d, want %d: %s", res.Code, tt.wantStatus, res.Body)
+			}
+			if tt.wantCode != "" {
+				if p := decodeJSON[server.Problem](t, res); p.Code != tt.wantCode {
+					t.Errorf("got code %q, want %q", p.Code, tt.wantCode)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_SplitResponsesConformToOpenAPI(t *testing.T) {
+	router := server.OpenAPIRouter(t)
+	handler := newHandler(newSplitStore(t))
+	portal := &server.Principal{ID: "key:portal", ClientIDs: []string{"client_001"}}
+
+	tests := []struct {
+		name string
+		body string
+		as   *server.Principal
+		want int
+	}{
+		{"split transfer",
+			`{"legs":[{"client_id":"client_001","amount":-100},{"client_id":"client_002","amount":100}],"idempotencyKey":"s1"}`,
+			portal, http.StatusOK},
+		{"split transfer unbalanced",
+			`{"legs":[{"client_id":"client_001","amount":-100},{"client_id":"client_002","amount":90}],"idempotencyKey":"s2"}`,
+			nil, http.StatusBadRequest},
+		{"split transfer needs approval",
+			`{"legs":[{"client_id":"client_001","amount":-10001},{"client_id":"client_002","amount":10001}],"idempotencyKey":"s3"}`,
+			nil, http.StatusUnprocessableEntity},
+		{"split transfer forbidden",
+			`{"legs":[{"client_id":"client_002","amount":-100},{"client_id":"client_001","amount":100}],"idempotencyKey":"s4"}`,
+			portal, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request(http.MethodPost, server.V1+"/split-transfers", tt.body, tt.as)
+			server.ServeConforming(t, router, handler, req, tt.want)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateLegs(t *testing.T) {
+	many := make([]TransferLeg, MaxTransferLegs+1)
+	for i := range many {
+		many[i] = TransferLeg{ClientID: "client_" + strings.Repeat("x", i+1), Amount: 1}
+	}
+	many[0].Amount = -MaxTransferLegs
+
+	tests := []struct {
+		name      string
+		legs      []TransferLeg
+		wantField string
+	}{
+		{"one debit, two credits", []TransferLeg{{"a", -300}, {"b", 100}, {"c", 200}}, ""},
+		{"two debits, two credits", []TransferLeg{{"a", -300}, {"b", -100}, {"c", 200}, {"d", 200}}, ""},
+		{"no legs", nil, "legs"},
+		{"one leg", []TransferLeg{{"a", 0}}, "legs"},
+		{"too many legs", many, "legs"},
+		{"invalid client", []TransferLeg{{"a", -100}, {"b c", 100}}, "legs[1].client_id"},
+		{"system account", []TransferLeg{{"a", -100}, {FeeRevenueAccount("JPY"), 100}}, "legs[1].client_id"},
+		{"repeated client", []TransferLeg{{"a", -100}, {"a", 100}}, "legs[1].client_id"},
+		{"zero amount", []TransferLeg{{"a", -100}, {"b", 100}, {"c", 0}}, "legs[2].amount"},
+		{"amount too large", []TransferLeg{{"a", -(MaxAmount + 1)}, {"b", MaxAmount + 1}}, "legs[0].amount"},
+		{"credits only", []TransferLeg{{"a", 100}, {"b", 100}}, "legs"},
+		{"unbalanced", []TransferLeg{{"a", -100}, {"b", 99}}, "legs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLegs(tt.legs)
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("got %v, want no error", err)
+				}
+				return
+			}
+			var invalid ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("got %v, want a ValidationError", err)
+			}
+			for _, fe := range invalid {
+				if fe.Field == tt.wantField {
+					return
+				}
+			}
+			t.Errorf("got %v, want an error for %s", invalid, tt.wantField)
+		})
+	}
+}
+
+func TestSplitTotalAndLegClientIDs(t *testing.T) {
+	got := LegClientIDs([]TransferLeg{{"c", 1}, {"a", -2}, {"b", 1}})
+	if strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("got %v, want a,b,c", got)
+	}
+	if total := SplitTotal([]TransferLeg{{"c", 1}, {"a", -2}, {"d", -3}, {"b", 4}}); total != 5 {
+		t.Errorf("SplitTotal = %d, want 5", total)
+	}
+}
+
+func TestHandler_SplitTransferUnsupportedStore(t *testing.T) {
+	body := `{"legs":[{"client_id":"client_001","amount":-100},{"client_id":"client_002","amount":100}],"idempotencyKey":"k"}`
+	res := serveAs(NewHandler(NewStubClient()), nil, http.MethodPost, V1+"/split-transfers", body)
+	if res.Code != http.StatusNotImplemented {
+		t.Errorf("got %d, want %d", res.Code, http.StatusNotImplemented)
+	}
+}
@@ -5,30 +5,67 @@ import (
 	"errors"
 	"time"
 	"database/sql"
+	"encoding/json"
+	"log/slog"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
 )
 
 var ErrClientNotFound = errors.New("client not found")
 var ErrInsufficientBalance = errors.New("insufficient balance")
+var ErrSelfTransfer = errors.New("cannot transfer to the same client")
 
 type Ledger struct {
 	EntryId uuid.UUID
 	ClientId string
 	Amount int64
+	// Currency is the client's, which Amount is in
+	Currency string
 	CreatedAt time.Time
 	IdempotencyKey sql.NullString
 }
 
+// Money is the amount of the entry in its currency
+func (l Ledger) Money() money.Money {
+	return money.New(l.Amount, l.Currency)
+}
+
+// MarshalJSON adds the amount as a decimal string in major units
+func (l Ledger) MarshalJSON() ([]byte, error) {
+	type entry Ledger
+	return json.Marshal(struct {
+		entry
+		Money money.Money `json:"money"`
+	}{entry(l), l.Money()})
+}
+
 type Store struct {
 	db *pgxpool.Pool
+	metrics *Metrics
+	logger *slog.Logger
+	approvals ApprovalPolicy
 }
 
 func NewStore(db *pgxpool.Pool) *Store {
-	return &Store{db: db}
+	return &Store{db: db, logger: slog.Default()}
+}
+
+// WithLogger sets the logger used for idempotent replays and rejections
+func (s *Store) WithLogger(logger *slog.Logger) *Store {
+	s.logger = logger
+	return s
+}
+
+// WithMetrics makes the store count committed payments and transfers
+func (s *Store) WithMetrics(m *Metrics) *Store {
+	s.metrics = m
+	return s
 }
 
 func (s *Store) CreatePayment(
@@ -36,7 +73,11 @@ func (s *Store) CreatePayment(
 	clientID string,
 	amount int64,
 	idempotencyKey string,
-) (int64, error) {
+) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "Store.CreatePayment",
+		attribute.String("client.id", clientID))
+	defer func() { endSpan(span, err) }()
+
 	// Start Transaction. THhis ensures that sequence of SQL executions run atomically
 	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
@@ -56,6 +97,8 @@ func (s *Store) CreatePayment(
 			`, idempotencyKey).Scan(&existing_balance)
 
 		if err == nil {
+			s.logger.DebugContext(ctx, "payment replayed",
+				"client_id", clientID, "idempotency_key", idempotencyKey)
 			return existing_balance, nil
 		}
 
@@ -65,9 +108,10 @@ func (s *Store) CreatePayment(
 	}
 
 	var balance int64
+	var currency string
 	err = tx.QueryRow(ctx,
-		`SELECT balance FROM clients WHERE client_id = $1 FOR UPDATE`,
-		clientID).Scan(&balance)
+		`SELECT balance, currency FROM clients WHERE client_id = $1 FOR UPDATE`,
+		clientID).Scan(&balance, &currency)
 
 	if err == pgx.ErrNoRows {
 		return 0, ErrClientNotFound
@@ -76,12 +120,33 @@ func (s *Store) CreatePayment(
 		return 0, err
 	}
 
-	newBalance := balance + amount
+	next, err := money.New(balance, currency).Add(money.New(amount, currency))
+	if err != nil {
+		return 0, err
+	}
+	// The fee is taken from the client in the same transaction
+	fee, err := s.quoteFee(ctx, tx, clientID, FeePayment, amount, currency)
+	if err != nil {
+		return 0, err
+	}
+	if next, err = next.Sub(money.New(fee.Fee, currency)); err != nil {
+		return 0, err
+	}
+	newBalance := next.Amount
 
 	if newBalance < 0 {
+		s.metrics.observeInsufficientBalance("payment")
+		s.logger.InfoContext(ctx, "payment rejected for insufficient balance",
+			"client_id", clientID, "balance", balance, "amount", amount)
 		return 0, ErrInsufficientBalance
 	}
 
+	if amount < 0 {
+		if err = s.checkVelocity(ctx, tx, "payment", clientID, -amount); err != nil {
+			return 0, err
+		}
+	}
+
 	_, err = tx.Exec(ctx,
 		`UPDATE clients SET balance = $1 WHERE client_id = $2`, newBalance, clientID,
 	)
@@ -90,25 +155,41 @@ func (s *Store) CreatePayment(
 		return 0, err
 	}
 
-	_, err = tx.Exec(ctx,
-		`INSERT INTO ledger_entries (entry_id, client_id, amount, idempotency_key) VALUES (gen_random_uuid(), $1, $2, $3)`, clientID, amount, idempotencyKey)
+	var entryID string
+	err = tx.QueryRow(ctx,
+		`INSERT INTO ledger_entries (entry_id, client_id, amount, idempotency_key) VALUES (gen_random_uuid(), $1, $2, $3)
+		RETURNING entry_id::text`, clientID, amount, idempotencyKey).Scan(&entryID)
 
 	if err != nil {
 		return 0, err
 	}
 
+	if fee.Fee > 0 {
+		if err = s.postFee(ctx, tx, fee, entryID); err != nil {
+			return 0, err
+		}
+	}
+
 	if err = tx.Commit(ctx); err != nil {
 		return 0, err
 	}
+	s.metrics.observePayment(currency, amount)
+	s.metrics.observeFee(currency, FeePayment, fee.Fee)
 	return newBalance, nil
 }
 
 func (s *Store) GetLedger(
 	ctx context.Context,
 	clientId string,
-) ([]Ledger, error){
-	
-	rows, err := s.db.Query(ctx, `SELECT * FROM ledger_entries WHERE client_id = $1`, clientId)
+) (_ []Ledger, err error){
+	ctx, span := startSpan(ctx, "Store.GetLedger",
+		attribute.String("client.id", clientId))
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.Query(ctx,
+		`SELECT e.entry_id, e.client_id, e.amount, c.currency, e.created_at, e.idempotency_key
+		FROM ledger_entries e JOIN clients c ON c.client_id = e.client_id
+		WHERE e.client_id = $1`, clientId)
 	if err != nil {
 		return nil, err
 	}
@@ -117,7 +198,7 @@ func (s *Store) GetLedger(
 	for rows.Next() {
 		var ledger Ledger
 		if err := rows.Scan(&ledger.EntryId, &ledger.ClientId, 
-				&ledger.Amount, &ledger.CreatedAt, &ledger.IdempotencyKey); err != nil {
+				&ledger.Amount, &ledger.Currency, &ledger.CreatedAt, &ledger.IdempotencyKey); err != nil {
 			return ledger_entries, err
 		}
 		ledger_entries = append(ledger_entries, ledger)
@@ -131,11 +212,14 @@ func (s *Store) GetLedger(
 func (s *Store) GetBalance(
 	ctx context.Context,
 	clientId string,
-) (int64, string, error) {
+) (_ int64, _ string, err error) {
+	ctx, span := startSpan(ctx, "Store.GetBalance",
+		attribute.String("client.id", clientId))
+	defer func() { endSpan(span, err) }()
 
 	var balance int64
 	var currency string
-	err := s.db.QueryRow(ctx,
+	err = s.db.QueryRow(ctx,
 		`SELECT balance, currency FROM clients WHERE client_id = $1`, clientId).Scan(&balance, &currency)
 
 	if err == pgx.ErrNoRows {
@@ -154,7 +238,17 @@ func (s *Store) Transfer(
 	toClientId string,
 	amount int64,
 	idempotencyKey string,
-) (int64, int64, error) {
+) (_ int64, _ int64, err error) {
+	ctx, span := startSpan(ctx, "Store.Transfer",
+		attribute.String("client.from_id", fromClientId),
+		attribute.String("client.to_id", toClientId))
+	defer func() { endSpan(span, err) }()
+
+	// Both legs would land on one row, the credit overwriting the debit
+	if fromClientId == toClientId {
+		return 0, 0, ErrSelfTransfer
+	}
+
 	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
 		return 0, 0, err
@@ -173,7 +267,19 @@ func (s *Store) Transfer(
 			return 0, 0, err
 		}
 
+		// A transfer held for approval is replayed as it stands until it
+		// is approved, after which its debit carries the key
+		pending, err := s.pendingTransferByKey(ctx, tx, idempotencyKey)
+		if err != nil && !errors.Is(err, ErrTransferNotFound) {
+			return 0, 0, err
+		}
+		if err == nil && count == 0 {
+			return 0, 0, ReplayPendingTransfer(pending)
+		}
+
 		if count > 0 {
+			s.logger.DebugContext(ctx, "transfer replayed",
+				"from_client_id", fromClientId, "to_client_id", toClientId, "idempotency_key", idempotencyKey)
 			var fromBalance, toBalance int64
 			err = tx.QueryRow(ctx,
 			`SELECT balance FROM clients WHERE client_id = $1`, fromClientId).Scan(&fromBalance)
@@ -190,51 +296,102 @@ func (s *Store) Transfer(
 		}
 	}
 
-	var oldFromBalance, oldToBalance int64
-	err = tx.QueryRow(ctx,
-		`SELECT balance FROM clients WHERE client_id = $1`, 
-		fromClientId).Scan(&oldFromBalance)
-
-	if err == pgx.ErrNoRows {
-		return 0, 0, ErrClientNotFound
+	if s.approvals.Requires(amount) {
+		pending, err := s.requestApproval(ctx, tx, fromClientId, toClientId, amount, idempotencyKey)
+		if err != nil {
+			return 0, 0, err
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return 0, 0, err
+		}
+		return 0, 0, &ApprovalRequiredError{Transfer: pending}
 	}
+
+	newFromBalance, newToBalance, fee, err := s.transfer(ctx, tx, fromClientId, toClientId, amount, idempotencyKey)
 	if err != nil {
 		return 0, 0, err
 	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, 0, err
+	}
+	s.metrics.observeTransfer(fee.Currency, amount)
+	s.metrics.observeFee(fee.Currency, FeeTransfer, fee.Fee)
+	return newFromBalance, newToBalance, nil
+}
 
-	err = tx.QueryRow(ctx,
-		`SELECT balance FROM clients WHERE client_id = $1`, 
-		toClientId).Scan(&oldToBalance)
+// transfer moves amount and charges the sender's fee inside tx, which
+// the caller commits. It returns both new balances and the fee charged.
+func (s *Store) transfer(ctx context.Context, tx pgx.Tx, fromClientId, toClientId string, amount int64, idempotencyKey string) (int64, int64, FeeQuote, error) {
+	// Both rows are locked, so concurrent transfers cannot overwrite each
+	// other's balances and velocity checks see each other. Locking in
+	// client ID order keeps opposite transfers from deadlocking.
+	balances, err := lockClients(ctx, tx, fromClientId, toClientId)
+	if err != nil {
+		return 0, 0, FeeQuote{}, err
+	}
 
-	if err == pgx.ErrNoRows {
-		return 0, 0, ErrClientNotFound
+	from, ok1 := balances[fromClientId]
+	to, ok2 := balances[toClientId]
+	if !ok1 || !ok2 {
+		return 0, 0, FeeQuote{}, ErrClientNotFound
 	}
+	currency := from.Currency
+	oldFromBalance := from.Amount
+
+	// The amount is in the sender's currency; crediting it to a client
+	// holding another one fails with money.ErrCurrencyMismatch
+	sent := money.New(amount, currency)
+	newFrom, err := from.Sub(sent)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, FeeQuote{}, err
+	}
+	newTo, err := to.Add(sent)
+	if err != nil {
+		return 0, 0, FeeQuote{}, err
+	}
+	// The sender pays the fee on top of the amount
+	fee, err := s.quoteFee(ctx, tx, fromClientId, FeeTransfer, amount, currency)
+	if err != nil {
+		return 0, 0, FeeQuote{}, err
+	}
+	if newFrom, err = newFrom.Sub(money.New(fee.Fee, currency)); err != nil {
+		return 0, 0, FeeQuote{}, err
+	}
+
+	if newFrom.IsNegative() {
+		s.metrics.observeInsufficientBalance("transfer")
+		s.logger.InfoContext(ctx, "transfer rejected for insufficient balance",
+			"from_client_id", fromClientId, "balance", oldFromBalance, "amount", amount, "fee", fee.Fee)
+		return 0, 0, FeeQuote{}, ErrInsufficientBalance
+	}
+
+	if err = s.checkVelocity(ctx, tx, "transfer", fromClientId, amount); err != nil {
+		return 0, 0, FeeQuote{}, err
 	}
 
-	newFromBalance := oldFromBalance - amount
-	newToBalance := oldToBalance + amount
+	newFromBalance, newToBalance := newFrom.Amount, newTo.Amount
 
 	_, err = tx.Exec(ctx,
 		`UPDATE clients SET balance = $1 WHERE client_id = $2`, newFromBalance, fromClientId)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, FeeQuote{}, err
 	}
 
 	_, err = tx.Exec(ctx,
 		`UPDATE clients SET balance = $1 WHERE client_id = $2`, newToBalance, toClientId)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, FeeQuote{}, err
 	}
 
 
-	_, err = tx.Exec(ctx,
-		`INSERT INTO ledger_entries (entry_id, client_id, amount, idempotency_key) VALUES (gen_random_uuid(), $1, $2, $3)`, 
-		fromClientId, -amount, idempotencyKey)
+	var entryID string
+	err = tx.QueryRow(ctx,
+		`INSERT INTO ledger_entries (entry_id, client_id, amount, idempotency_key) VALUES (gen_random_uuid(), $1, $2, $3)
+		RETURNING entry_id::text`, 
+		fromClientId, -amount, idempotencyKey).Scan(&entryID)
 
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, FeeQuote{}, err
 	}
 
 	_, err = tx.Exec(ctx,
@@ -242,11 +399,39 @@ func (s *Store) Transfer(
 		toClientId, amount)
 
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, FeeQuote{}, err
 	}
 
-	if err = tx.Commit(ctx); err != nil {
-		return 0, 0, err
+	if fee.Fee > 0 {
+		if err = s.postFee(ctx, tx, fee, entryID); err != nil {
+			return 0, 0, FeeQuote{}, err
+		}
 	}
-	return newFromBalance, newToBalance, nil
+
+	return newFromBalance, newToBalance, fee, nil
+}
+
+// lockClients locks the rows of the clients in client ID order, so
+// transactions locking overlapping clients cannot deadlock, and returns
+// their balances. Clients that do not exist are missing from the map.
+func lockClients(ctx context.Context, tx pgx.Tx, clientIDs ...string) (map[string]money.Money, error) {
+	rows, err := tx.Query(ctx,
+		`SELECT client_id, balance, currency FROM clients
+		WHERE client_id = ANY($1) ORDER BY client_id FOR UPDATE`,
+		clientIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := make(map[string]money.Money, len(clientIDs))
+	for rows.Next() {
+		var id, cur string
+		var balance int64
+		if err := rows.Scan(&id, &balance, &cur); err != nil {
+			return nil, err
+		}
+		balances[id] = money.New(balance, cur)
+	}
+	return balances, rows.Err()
 }
@@ -62,6 +62,7 @@ func TestTransferCorrectly(t *testing.T) {
         })
 
         req, _ := http.NewRequest(http.MethodPost, "/transfer", &buf)
+        req.Header.Set("Content-Type", "application/json")
         res := httptest.NewRecorder()
         handler.mux.ServeHTTP(res, req)
 
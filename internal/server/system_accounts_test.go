@@ -0,0 +1,55 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/koki1610168/go-payment-ledger/internal/memstore"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// House accounts only move with the operation that owns them, so no route
+// may name one
+func TestHandler_RejectsSystemAccounts(t *testing.T) {
+	admin := &server.Principal{ID: "key:ops", ClientIDs: []string{server.AnyClient}, Admin: true}
+	revenue := server.FeeRevenueAccount("JPY")
+	holds := server.ApprovalHoldAccount("JPY")
+
+	tests := []struct {
+		name      string
+		path      string
+		body      string
+		wantField string
+	}{
+		{"payment", server.V1 + "/payments",
+			`{"clientID":"` + revenue + `","amount":100,"currency":"JPY","idempotencyKey":"k1"}`, "clientID"},
+		{"transfer from", server.V1 + "/transfer",
+			`{"from_client_id":"` + revenue + `","to_client_id":"client_001","amount":100,"idempotencyKey":"k2"}`, "from_client_id"},
+		{"transfer to", server.V1 + "/transfer",
+			`{"from_client_id":"client_001","to_client_id":"` + holds + `","amount":100,"idempotencyKey":"k3"}`, "to_client_id"},
+		{"split leg", server.V1 + "/split-transfers",
+			`{"legs":[{"client_id":"client_001","amount":-100},{"client_id":"` + server.InterestExpenseAccount("JPY") + `","amount":100}],"idempotencyKey":"k4"}`,
+			"legs[1].client_id"},
+		{"adjustment", server.V1 + "/clients/" + revenue + "/adjustments",
+			`{"amount":-100,"reason":"sweep","idempotency_key":"k5"}`, "client_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := memstore.New()
+			seed(t, store, "client_001", 1000)
+			// Charging a fee creates the revenue account
+			seed(t, store, revenue, 0)
+
+			res := serveAs(newHandler(store), admin, http.MethodPost, tt.path, tt.body)
+			if res.Code != http.StatusBadRequest {
+				t.Fatalf("got %d (%s), want %d", res.Code, res.Body, http.StatusBadRequest)
+			}
+			if !hasField(t, res, tt.wantField) {
+				t.Errorf("want an error for %s", tt.wantField)
+			}
+			if b := balance(t, store, "client_001"); b != 1000 {
+				t.Errorf("client_001 holds %d, want 1000", b)
+			}
+		})
+	}
+}
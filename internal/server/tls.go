@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/koki1610168/go-payment-ledger/internal/config"
+)
+
+// CertReloader serves the certificate and client CA bundle from disk and
+// picks up rotated files without a restart
+type CertReloader struct {
+	cfg    config.TLS
+	logger *slog.Logger
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// NewCertReloader loads the files once and fails if they are unusable
+func NewCertReloader(cfg config.TLS, logger *slog.Logger) (*CertReloader, error) {
+	cr := &CertReloader{cfg: cfg, logger: logger}
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// TLSConfig builds the server configuration. Every handshake reads the
+// current certificate and CA bundle, so a reload applies to new
+// connections immediately.
+func (cr *CertReloader) TLSConfig() *tls.Config {
+	clientAuth := tls.NoClientCert
+	if cr.cfg.ClientCAFile != "" {
+		clientAuth = tls.VerifyClientCertIfGiven
+		if cr.cfg.RequireClientCert {
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		ClientAuth: clientAuth,
+	}
+	base.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cr.mu.RLock()
+		defer cr.mu.RUnlock()
+		return cr.cert, nil
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cr.mu.RLock()
+		cfg.ClientCAs = cr.clientCA
+		cr.mu.RUnlock()
+		return cfg, nil
+	}
+	return base
+}
+
+// Run checks the files every ReloadInterval until ctx is cancelled.
+// A failed reload is logged and the previous certificate stays in use.
+func (cr *CertReloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(cr.cfg.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := cr.changed()
+			if err != nil {
+				cr.logger.Warn("stat tls files", "err", err)
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if err := cr.load(); err != nil {
+				cr.logger.Error("reload tls files, keeping previous certificate", "err", err)
+				continue
+			}
+			cr.logger.Info("reloaded tls certificate", "cert_file", cr.cfg.CertFile)
+		}
+	}
+}
+
+func (cr *CertReloader) files() []string {
+	files := []string{cr.cfg.CertFile, cr.cfg.KeyFile}
+	if cr.cfg.ClientCAFile != "" {
+		files = append(files, cr.cfg.ClientCAFile)
+	}
+	return files
+}
+
+func (cr *CertReloader) changed() (bool, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	for _, f := range cr.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return false, err
+		}
+		if !info.ModTime().Equal(cr.modTimes[f]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (cr *CertReloader) load() error {
+	modTimes := map[string]time.Time{}
+	for _, f := range cr.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[f] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.cfg.CertFile, cr.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load tls key pair: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if cr.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cr.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("read client ca file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("client ca file %s contains no certificates", cr.cfg.ClientCAFile)
+		}
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.clientCA = pool
+	cr.modTimes = modTimes
+	cr.mu.Unlock()
+	return nil
+}
+
+var errUnknownSubject = errors.New("client certificate not authorized")
+
+// ClientCertAuth maps a verified client certificate to a Principal using
+// its subject common name. Certificates whose subject is not in subjects
+// are rejected. Requests without a certificate pass through unchanged;
+// set tls.require_client_cert to refuse them during the handshake.
+func ClientCertAuth(subjects map[string][]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := certPrincipal(subjects, r.TLS)
+		if err != nil {
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, err.Error())
+			return
+		}
+		if p == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+// certPrincipal returns nil without an error when the connection carries
+// no verified certificate
+func certPrincipal(subjects map[string][]string, state *tls.ConnectionState) (*Principal, error) {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil, nil
+	}
+
+	cn := state.VerifiedChains[0][0].Subject.CommonName
+	clientIDs, ok := subjects[cn]
+	if !ok {
+		return nil, errUnknownSubject
+	}
+	return &Principal{ID: "cert:" + cn, ClientIDs: clientIDs}, nil
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/koki1610168/go-payment-ledger/internal/config"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// startTLSServer serves NewHandler behind ClientCertAuth with mTLS
+func startTLSServer(t *testing.T, ca *testCert, subjects map[string][]string) (addr string, caPool *x509.CertPool) {
+	t.Helper()
+	dir := t.TempDir()
+	server := newTestCert(t, "ledger", ca, false)
+	certFile, keyFile := server.write(t, dir, "server")
+	caFile, _ := ca.write(t, dir, "ca")
+
+	cfg := config.Default().TLS
+	cfg.CertFile, cfg.KeyFile, cfg.ClientCAFile = certFile, keyFile, caFile
+	cfg.RequireClientCert = true
+
+	certs, err := NewCertReloader(cfg, discardLogger())
+	if err != nil {
+		t.Fatalf("new cert reloader: %v", err)
+	}
+
+	store := NewStubClient()
+	store.SeedClient("client_001", 10000, "JPY")
+	store.SeedClient("client_002", 5000, "JPY")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := NewHTTPServer(config.Default().Server, ClientCertAuth(subjects, NewHandler(store)))
+	srv.TLSConfig = certs.TLSConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Serve(ctx, srv, ln, ServeOptions{ShutdownTimeout: time.Second})
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	caPool = x509.NewCertPool()
+	caPool.AddCert(ca.cert)
+	return ln.Addr().String(), caPool
+}
+
+func tlsClient(roots *x509.CertPool, cert *testCert) *http.Client {
+	cfg := &tls.Config{RootCAs: roots}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{cert.tlsCertificate()}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+}
+
+func TestTLS_ClientSubjectMapsToClientIDs(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil, true)
+	addr, roots := startTLSServer(t, ca, map[string][]string{
+		"merchant-portal": {"client_001"},
+	})
+	client := tlsClient(roots, newTestCert(t, "merchant-portal", ca, false))
+
+	res, err := client.Get("https://" + addr + "/clients/client_001/balance")
+	if err != nil {
+		t.Fatalf("get own balance: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("own client: got status %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	res, err = client.Get("https://" + addr + "/clients/client_002/balance")
+	if err != nil {
+		t.Fatalf("get other balance: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("other client: got status %d, want %d", res.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestTLS_UnknownSubjectIsForbidden(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil, true)
+	addr, roots := startTLSServer(t, ca, map[string][]string{"merchant-portal": {AnyClient}})
+	client := tlsClient(roots, newTestCert(t, "someone-else", ca, false))
+
+	res, err := client.Get("https://" + addr + "/clients/client_001/balance")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", res.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestTLS_RequiredClientCertificate(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil, true)
+	addr, roots := startTLSServer(t, ca, map[string][]string{"merchant-portal": {AnyClient}})
+
+	if _, err := tlsClient(roots, nil).Get("https://" + addr + "/clients/client_001/balance"); err == nil {
+		t.Error("expected the handshake to fail without a client certificate")
+	}
+
+	untrusted := newTestCert(t, "merchant-portal", newTestCert(t, "other-ca", nil, true), false)
+	if _, err := tlsClient(roots, untrusted).Get("https://" + addr + "/clients/client_001/balance"); err == nil {
+		t.Error("expected the handshake to fail with a certificate from another CA")
+	}
+}
+
+func TestCertReloader_PicksUpRotatedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil, true)
+	first := newTestCert(t, "ledger", ca, false)
+	certFile, keyFile := first.write(t, dir, "server")
+
+	cfg := config.Default().TLS
+	cfg.CertFile, cfg.KeyFile = certFile, keyFile
+	cfg.ReloadInterval = 10 * time.Millisecond
+
+	certs, err := NewCertReloader(cfg, discardLogger())
+	if err != nil {
+		t.Fatalf("new cert reloader: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go certs.Run(ctx)
+
+	second := newTestCert(t, "ledger", ca, false)
+	second.write(t, dir, "server")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+
+	getCert := certs.TLSConfig().GetCertificate
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		got, _ := getCert(nil)
+		if got.Leaf != nil && got.Leaf.SerialNumber.Cmp(second.cert.SerialNumber) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("rotated certificate was not picked up")
+}
+
+func TestCertReloader_KeepsCertificateOnBadReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil, true)
+	first := newTestCert(t, "ledger", ca, false)
+	certFile, keyFile := first.write(t, dir, "server")
+
+	cfg := config.Default().TLS
+	cfg.CertFile, cfg.KeyFile = certFile, keyFile
+
+	certs, err := NewCertReloader(cfg, discardLogger())
+	if err != nil {
+		t.Fatalf("new cert reloader: %v", err)
+	}
+
+	os.WriteFile(certFile, []byte("not a certificate"), 0o600)
+	if err := certs.load(); err == nil {
+		t.Fatal("expected load to fail")
+	}
+
+	got, _ := certs.TLSConfig().GetCertificate(nil)
+	if got.Leaf.SerialNumber.Cmp(first.cert.SerialNumber) != 0 {
+		t.Error("expected the previous certificate to stay in use")
+	}
+}
+
+func TestHandler_PrincipalLimitedToOwnClients(t *testing.T) {
+	store := NewStubClient()
+	store.SeedClient("client_001", 10000, "JPY")
+	store.SeedClient("client_002", 10000, "JPY")
+	handler := NewHandler(store)
+
+	p := &Principal{ID: "cert:merchant", ClientIDs: []string{"client_002"}}
+	req := httptest.NewRequest(http.MethodGet, "/clients/client_001/ledger", nil)
+	req = req.WithContext(WithPrincipal(req.Context(), p))
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", res.Code, http.StatusForbidden)
+	}
+}
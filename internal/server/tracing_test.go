@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { tp.Shutdown(t.Context()) })
+	return recorder
+}
+
+func TestTracing_ContinuesIncomingTrace(t *testing.T) {
+	recorder := newSpanRecorder(t)
+
+	store := NewStubClient()
+	store.SeedClient("client_001", 10000, "JPY")
+	handler := Tracing(NewHandler(store))
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/clients/client_001/balance", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if got := spans[0].SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("got trace id %s, want %s", got, traceID)
+	}
+	if got, want := spans[0].Name(), "GET /clients/{id}/balance"; got != want {
+		t.Errorf("got span name %q, want %q", got, want)
+	}
+}
+
+func TestSQLSpanName(t *testing.T) {
+	cases := map[string]string{
+		`SELECT balance FROM clients WHERE client_id = $1`:            "SELECT",
+		`SELECT balance FROM clients WHERE client_id = $1 FOR UPDATE`: "SELECT FOR UPDATE",
+		`begin`: "BEGIN",
+		``:      "SQL",
+	}
+	for sql, want := range cases {
+		if got := sqlSpanName(sql); got != want {
+			t.Errorf("sqlSpanName(%q) = %q, want %q", sql, got, want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Velocity limit kinds
+const (
+	// VelocityAmount caps the outgoing volume in minor units
+	VelocityAmount = "amount"
+	// VelocityCount caps the number of outgoing debits
+	VelocityCount = "count"
+)
+
+// Longest window a velocity limit may look back over
+const maxVelocityWindow = 366 * 24 * time.Hour
+
+var ErrVelocityLimitExceeded = errors.New("velocity limit exceeded")
+var ErrVelocityLimitNotFound = errors.New("velocity limit not found")
+
+// VelocityLimit caps a client's debits (withdrawals and outgoing
+// transfers) within a sliding window, e.g. 1,000,000 JPY per 24h or
+// 20 debits per hour
+type VelocityLimit struct {
+	ID            string    `json:"id"`
+	ClientID      string    `json:"client_id"`
+	Kind          string    `json:"kind"`
+	Max           int64     `json:"max"`
+	WindowSeconds int64     `json:"window_seconds"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func (l VelocityLimit) Window() time.Duration {
+	return time.Duration(l.WindowSeconds) * time.Second
+}
+
+// Validate reports every problem with l before it is stored
+func (l VelocityLimit) Validate() error {
+	var v validator
+	v.check(l.Kind == VelocityAmount || l.Kind == VelocityCount, "kind", "must be amount or count")
+	v.check(l.Max > 0, "max", "must be positive")
+	v.check(l.WindowSeconds > 0 && l.Window() <= maxVelocityWindow,
+		"window_seconds", "must be between 1 and %d", int64(maxVelocityWindow/time.Second))
+	return v.err()
+}
+
+// VelocityLimitError is returned when a debit would exceed a limit.
+// It matches ErrVelocityLimitExceeded with errors.Is.
+type VelocityLimitError struct {
+	Limit VelocityLimit
+	// Used is the volume or count already spent within the window
+	Used int64
+}
+
+func (e *VelocityLimitError) Error() string {
+	return fmt.Sprintf("velocity limit exceeded: %d of %d %s used in the last %s",
+		e.Used, e.Limit.Max, e.Limit.Kind, e.Limit.Window())
+}
+
+func (e *VelocityLimitError) Unwrap() error {
+	return ErrVelocityLimitExceeded
+}
+
+// VelocityLimitStore is implemented by stores that enforce velocity
+// limits. Every debit they post, whether a withdrawal, a transfer or a
+// split leg, is checked against the limits of its client and fails with
+// a *VelocityLimitError before anything is written.
+type VelocityLimitStore interface {
+	ListVelocityLimits(ctx context.Context, clientID string) ([]VelocityLimit, error)
+	CreateVelocityLimit(ctx context.Context, limit VelocityLimit) (VelocityLimit, error)
+	DeleteVelocityLimit(ctx context.Context, clientID string, limitID string) error
+}
+
+func (s *Store) ListVelocityLimits(ctx context.Context, clientID string) (_ []VelocityLimit, err error) {
+	ctx, span := startSpan(ctx, "Store.ListVelocityLimits",
+		attribute.String("client.id", clientID))
+	defer func() { endSpan(span, err) }()
+
+	var exists bool
+	err = s.db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM clients WHERE client_id = $1)`, clientID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrClientNotFound
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT limit_id::text, client_id, kind, max_value, window_seconds, created_at
+		FROM velocity_limits WHERE client_id = $1 ORDER BY created_at, limit_id`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	limits := []VelocityLimit{}
+	for rows.Next() {
+		var l VelocityLimit
+		if err := rows.Scan(&l.ID, &l.ClientID, &l.Kind, &l.Max, &l.WindowSeconds, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		limits = append(limits, l)
+	}
+	return limits, rows.Err()
+}
+
+func (s *Store) CreateVelocityLimit(ctx context.Context, limit VelocityLimit) (_ VelocityLimit, err error) {
+	ctx, span := startSpan(ctx, "Store.CreateVelocityLimit",
+		attribute.String("client.id", limit.ClientID))
+	defer func() { endSpan(span, err) }()
+
+	if err := limit.Validate(); err != nil {
+		return VelocityLimit{}, err
+	}
+
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO velocity_limits (client_id, kind, max_value, window_seconds)
+		VALUES ($1, $2, $3, $4)
+		RETURNING limit_id::text, created_at`,
+		limit.ClientID, limit.Kind, limit.Max, limit.WindowSeconds,
+	).Scan(&limit.ID, &limit.CreatedAt)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" { // foreign_key_violation
+		return VelocityLimit{}, ErrClientNotFound
+	}
+	if err != nil {
+		return VelocityLimit{}, err
+	}
+	return limit, nil
+}
+
+func (s *Store) DeleteVelocityLimit(ctx context.Context, clientID string, limitID string) (err error) {
+	ctx, span := startSpan(ctx, "Store.DeleteVelocityLimit",
+		attribute.String("client.id", clientID))
+	defer func() { endSpan(span, err) }()
+
+	id, err := uuid.Parse(limitID)
+	if err != nil {
+		return ErrVelocityLimitNotFound
+	}
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM velocity_limits WHERE client_id = $1 AND limit_id = $2`, clientID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrVelocityLimitNotFound
+	}
+	return nil
+}
+
+// checkVelocity fails with a *VelocityLimitError if debiting amount from
+// clientID would exceed one of its limits. Fees, interest and approval
+// holds count towards no limit; a held transfer counts once approved.
+// The caller must hold the client's row lock, so concurrent debits are
+// checked one after another.
+func (s *Store) checkVelocity(ctx context.Context, tx pgx.Tx, operation string, clientID string, amount int64) error {
+	rows, err := tx.Query(ctx,
+		`SELECT v.limit_id::text, v.client_id, v.kind, v.max_value, v.window_seconds, v.created_at,
+			used.volume, used.debits
+		FROM velocity_limits v
+		CROSS JOIN LATERAL (
+			SELECT COALESCE(SUM(-e.amount), 0)::bigint AS volume, COUNT(*) AS debits
+			FROM ledger_entries e
+			WHERE e.client_id = v.client_id
+				AND e.amount < 0
+				AND NOT EXISTS (SELECT 1 FROM fee_charges f WHERE f.debit_entry_id = e.entry_id)
+				AND NOT EXISTS (SELECT 1 FROM interest_postings p WHERE p.entry_id = e.entry_id)
+				AND NOT EXISTS (SELECT 1 FROM pending_transfers t WHERE t.hold_entry_id = e.entry_id)
+				AND e.created_at > now() - make_interval(secs => v.window_seconds::float8)
+		) used
+		WHERE v.client_id = $1
+		ORDER BY v.created_at, v.limit_id`, clientID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l VelocityLimit
+		var volume, debits int64
+		if err := rows.Scan(&l.ID, &l.ClientID, &l.Kind, &l.Max, &l.WindowSeconds, &l.CreatedAt, &volume, &debits); err != nil {
+			return err
+		}
+
+		used, requested := volume, amount
+		if l.Kind == VelocityCount {
+			used, requested = debits, 1
+		}
+		if used+requested > l.Max {
+			s.metrics.observeVelocityRejected(operation, l.Kind)
+			s.logger.InfoContext(ctx, "debit rejected by velocity limit",
+				"operation", operation, "client_id", clientID, "amount", amount,
+				"limit_id", l.ID, "kind", l.Kind, "max", l.Max, "used", used)
+			return &VelocityLimitError{Limit: l, Used: used}
+		}
+	}
+	return rows.Err()
+}
+
+// VelocityLimitRequest is the body of POST /clients/{id}/limits
+type VelocityLimitRequest struct {
+	Kind          string `json:"kind"`
+	Max           int64  `json:"max"`
+	WindowSeconds int64  `json:"window_seconds"`
+}
+
+// VelocityLimitsResponse lists a client's velocity limits
+type VelocityLimitsResponse struct {
+	ClientID string          `json:"client_id"`
+	Limits   []VelocityLimit `json:"limits"`
+}
+
+// velocityStore returns the handler's store as a VelocityLimitStore, or
+// answers 501. Features beyond ClientStore are optional interfaces found
+// by type assertion, so a store serves the core ledger without them and
+// only the routes of what it lacks are unavailable. Every such feature
+// has a helper like this one.
+func (h *Handler) velocityStore(w http.ResponseWriter, r *http.Request) (VelocityLimitStore, bool) {
+	store, ok := h.store.(VelocityLimitStore)
+	if !ok {
+		writeProblem(w, r, http.StatusNotImplemented, CodeNotImplemented, "velocity limits are not supported by this store")
+	}
+	return store, ok
+}
+
+// listVelocityLimits serves GET /clients/{id}/limits. Clients may read
+// their own limits; changing them takes an admin principal.
+func (h *Handler) listVelocityLimits(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.velocityStore(w, r)
+	if !ok {
+		return
+	}
+	clientID := r.PathValue("id")
+	if !authorizeClient(w, r, clientID) {
+		return
+	}
+	limits, err := store.ListVelocityLimits(r.Context(), clientID)
+	if err != nil {
+		h.writeError(w, r, "list velocity limits failed", err, "client_id", clientID)
+		return
+	}
+	writeJSON(w, http.StatusOK, VelocityLimitsResponse{ClientID: clientID, Limits: limits})
+}
+
+// createVelocityLimit serves POST /clients/{id}/limits
+func (h *Handler) createVelocityLimit(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.velocityStore(w, r)
+	if !ok {
+		return
+	}
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	clientID := r.PathValue("id")
+	var req VelocityLimitRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	limit := VelocityLimit{ClientID: clientID, Kind: req.Kind, Max: req.Max, WindowSeconds: req.WindowSeconds}
+	if err := limit.Validate(); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	created, err := store.CreateVelocityLimit(r.Context(), limit)
+	if err != nil {
+		h.writeError(w, r, "create velocity limit failed", err, "client_id", clientID)
+		return
+	}
+	h.logger.InfoContext(r.Context(), "velocity limit created",
+		"client_id", clientID, "limit_id", created.ID, "kind", created.Kind, "max", created.Max)
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// deleteVelocityLimit serves DELETE /clients/{id}/limits/{limit_id}
+func (h *Handler) deleteVelocityLimit(w http.ResponseWriter, r *http.Request) {
+	store, ok := h.velocityStore(w, r)
+	if !ok {
+		return
+	}
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	clientID, limitID := r.PathValue("id"), r.PathValue("limit_id")
+	if err := store.DeleteVelocityLimit(r.Context(), clientID, limitID); err != nil {
+		h.writeError(w, r, "delete velocity limit failed", err, "client_id", clientID)
+		return
+	}
+	h.logger.InfoContext(r.Context(), "velocity limit deleted", "client_id", clientID, "limit_id", limitID)
+	w.WriteHeader(http.StatusNoContent)
+}
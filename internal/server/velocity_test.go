@@ -0,0 +1,304 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/config"
+)
+
+// velocityStub adds velocity limits to StubStore and fails debits with
+// debitErr
+type velocityStub struct {
+	*StubStore
+	limits   map[string][]VelocityLimit
+	debitErr error
+}
+
+func newVelocityStub() *velocityStub {
+	s := &velocityStub{StubStore: NewStubClient(), limits: map[string][]VelocityLimit{}}
+	s.SeedClient("client_001", 10000, "JPY")
+	return s
+}
+
+func (s *velocityStub) Transfer(ctx context.Context, from, to string, amount int64, key string) (int64, int64, error) {
+	return 0, 0, s.debitErr
+}
+
+func (s *velocityStub) ListVelocityLimits(ctx context.Context, clientID string) ([]VelocityLimit, error) {
+	if _, ok := s.balances[clientID]; !ok {
+		return nil, ErrClientNotFound
+	}
+	return append([]VelocityLimit{}, s.limits[clientID]...), nil
+}
+
+func (s *velocityStub) CreateVelocityLimit(ctx context.Context, l VelocityLimit) (VelocityLimit, error) {
+	if _, ok := s.balances[l.ClientID]; !ok {
+		return VelocityLimit{}, ErrClientNotFound
+	}
+	l.ID = uuid.NewString()
+	s.limits[l.ClientID] = append(s.limits[l.ClientID], l)
+	return l, nil
+}
+
+func (s *velocityStub) DeleteVelocityLimit(ctx context.Context, clientID, limitID string) error {
+	for i, l := range s.limits[clientID] {
+		if l.ID == limitID {
+			s.limits[clientID] = append(s.limits[clientID][:i], s.limits[clientID][i+1:]...)
+			return nil
+		}
+	}
+	return ErrVelocityLimitNotFound
+}
+
+func decodeJSON[T any](t testing.TB, res *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return v
+}
+
+func serveAs(h http.Handler, p *Principal, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p != nil {
+		req = req.WithContext(WithPrincipal(req.Context(), p))
+	}
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func TestHandler_VelocityLimits(t *testing.T) {
+	store := newVelocityStub()
+	handler := NewHandler(store).WithLogger(discardLogger())
+	admin := &Principal{ID: "key:risk", ClientIDs: []string{AnyClient}, Admin: true}
+	owner := &Principal{ID: "key:portal", ClientIDs: []string{"client_001"}}
+
+	res := serveAs(handler, owner, http.MethodPost, "/clients/client_001/limits",
+		`{"kind":"amount","max":1000000,"window_seconds":86400}`)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("create as owner: got %d, want %d", res.Code, http.StatusForbidden)
+	}
+
+	res = serveAs(handler, admin, http.MethodPost, "/clients/client_001/limits",
+		`{"kind":"amount","max":1000000,"window_seconds":86400}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("create as admin: got %d, want %d: %s", res.Code, http.StatusCreated, res.Body)
+	}
+	created := decodeJSON[VelocityLimit](t, res)
+	if created.ID == "" || created.ClientID != "client_001" || created.Window() != 24*time.Hour {
+		t.Errorf("unexpected limit %+v", created)
+	}
+
+	res = serveAs(handler, owner, http.MethodGet, "/clients/client_001/limits", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("list as owner: got %d", res.Code)
+	}
+	if got := decodeJSON[VelocityLimitsResponse](t, res); len(got.Limits) != 1 || got.Limits[0].ID != created.ID {
+		t.Errorf("unexpected limits %+v", got)
+	}
+
+	res = serveAs(handler, owner, http.MethodDelete, "/clients/client_001/limits/"+created.ID, "")
+	if res.Code != http.StatusForbidden {
+		t.Errorf("delete as owner: got %d, want %d", res.Code, http.StatusForbidden)
+	}
+	res = serveAs(handler, admin, http.MethodDelete, "/clients/client_001/limits/"+created.ID, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("delete as admin: got %d, want %d", res.Code, http.StatusNoContent)
+	}
+	res = serveAs(handler, admin, http.MethodDelete, "/clients/client_001/limits/"+created.ID, "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("delete again: got %d, want %d", res.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandler_VelocityLimitValidation(t *testing.T) {
+	handler := NewHandler(newVelocityStub())
+	admin := &Principal{ID: "key:risk", ClientIDs: []string{AnyClient}, Admin: true}
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"unknown kind", "/clients/client_001/limits", `{"kind":"daily","max":1,"window_seconds":60}`, http.StatusBadRequest},
+		{"zero max", "/clients/client_001/limits", `{"kind":"count","max":0,"window_seconds":60}`, http.StatusBadRequest},
+		{"window too long", "/clients/client_001/limits", `{"kind":"count","max":1,"window_seconds":99999999}`, http.StatusBadRequest},
+		{"unknown client", "/clients/client_404/limits", `{"kind":"count","max":1,"window_seconds":60}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serveAs(handler, admin, http.MethodPost, tt.path, tt.body)
+			if res.Code != tt.wantStatus {
+				t.Errorf("got %d, want %d: %s", res.Code, tt.wantStatus, res.Body)
+			}
+		})
+	}
+}
+
+// A leaked client key must not lift its own limits, with or without
+// the key on the request
+func TestHandler_VelocityLimitsNeedAdminKey(t *testing.T) {
+	store := newVelocityStub()
+	keys, err := NewAPIKeys([]config.APIKey{
+		{Name: "portal", KeySHA256: hashKey("portal-secret"), ClientIDs: []string{"client_001"}},
+		{Name: "risk", KeySHA256: hashKey("risk-secret"), ClientIDs: []string{AnyClient}, Admin: true},
+	})
+	if err != nil {
+		t.Fatalf("new api keys: %v", err)
+	}
+	handler := keys.Middleware(NewHandler(store).WithLogger(discardLogger()))
+	serve := func(key, method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	const path = "/clients/client_001/limits"
+	const body = `{"kind":"count","max":1,"window_seconds":60}`
+	if code := serve("risk-secret", http.MethodPost, path, body); code != http.StatusCreated {
+		t.Fatalf("create as admin: got %d, want %d", code, http.StatusCreated)
+	}
+	limitPath := path + "/" + store.limits["client_001"][0].ID
+
+	tests := []struct {
+		name   string
+		key    string
+		method string
+		path   string
+		want   int
+	}{
+		{"create without key", "", http.MethodPost, path, http.StatusUnauthorized},
+		{"create with client key", "portal-secret", http.MethodPost, path, http.StatusForbidden},
+		{"delete without key", "", http.MethodDelete, limitPath, http.StatusUnauthorized},
+		{"delete with client key", "portal-secret", http.MethodDelete, limitPath, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := serve(tt.key, tt.method, tt.path, body); code != tt.want {
+				t.Errorf("got %d, want %d", code, tt.want)
+			}
+		})
+	}
+	if n := len(store.limits["client_001"]); n != 1 {
+		t.Errorf("client_001 has %d limits, want 1", n)
+	}
+}
+
+func TestHandler_VelocityLimitsUnsupportedStore(t *testing.T) {
+	res := serveAs(NewHandler(NewStubClient()), nil, http.MethodGet, "/clients/client_001/limits", "")
+	if res.Code != http.StatusNotImplemented {
+		t.Errorf("got %d, want %d", res.Code, http.StatusNotImplemented)
+	}
+}
+
+func TestHandler_TransferRejectedByVelocityLimit(t *testing.T) {
+	store := newVelocityStub()
+	store.debitErr = &VelocityLimitError{
+		Limit: VelocityLimit{Kind: VelocityCount, Max: 20, WindowSeconds: 3600},
+		Used:  20,
+	}
+	handler := NewHandler(store).WithLogger(discardLogger())
+
+	res := serveAs(handler, nil, http.MethodPost, "/transfer",
+		`{"from_client_id":"client_001","to_client_id":"client_002","amount":100,"idempotencyKey":"k1"}`)
+	if res.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got %d, want %d", res.Code, http.StatusUnprocessableEntity)
+	}
+	if !strings.Contains(res.Body.String(), "20 of 20 count") {
+		t.Errorf("body %q does not describe the limit", res.Body)
+	}
+	if !errors.Is(store.debitErr, ErrVelocityLimitExceeded) {
+		t.Error("VelocityLimitError should match ErrVelocityLimitExceeded")
+	}
+}
+
+func TestStore_VelocityLimits(t *testing.T) {
+	ctx, db, store := newTestStore(t)
+	if err := Migrate(ctx, db.Pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	from := "velocity_" + uniqueKey(t)[:12]
+	to := "velocity_" + uniqueKey(t)[:12]
+	seedClient(t, ctx, db, from, 100000, "JPY")
+	seedClient(t, ctx, db, to, 0, "JPY")
+
+	amountLimit, err := store.CreateVelocityLimit(ctx, VelocityLimit{
+		ClientID: from, Kind: VelocityAmount, Max: 1000, WindowSeconds: 86400,
+	})
+	if err != nil {
+		t.Fatalf("create amount limit: %v", err)
+	}
+	if _, err := store.CreateVelocityLimit(ctx, VelocityLimit{
+		ClientID: from, Kind: VelocityCount, Max: 3, WindowSeconds: 3600,
+	}); err != nil {
+		t.Fatalf("create count limit: %v", err)
+	}
+
+	transfer := func(amount int64) error {
+		_, _, err := store.Transfer(ctx, from, to, amount, uniqueKey(t))
+		return err
+	}
+
+	if err := transfer(600); err != nil {
+		t.Fatalf("first transfer: %v", err)
+	}
+	var limitErr *VelocityLimitError
+	if err := transfer(500); !errors.As(err, &limitErr) || limitErr.Limit.Kind != VelocityAmount || limitErr.Used != 600 {
+		t.Fatalf("got %v, want the amount limit with 600 used", err)
+	}
+	// Withdrawals count towards the same limits
+	if _, err := store.CreatePayment(ctx, from, -400, uniqueKey(t)); err != nil {
+		t.Fatalf("withdrawal: %v", err)
+	}
+	// Incoming money is never limited
+	if _, err := store.CreatePayment(ctx, from, 5000, uniqueKey(t)); err != nil {
+		t.Fatalf("deposit: %v", err)
+	}
+
+	if err := store.DeleteVelocityLimit(ctx, from, amountLimit.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := transfer(10); err != nil {
+		t.Fatalf("third debit: %v", err)
+	}
+	if err := transfer(10); !errors.As(err, &limitErr) || limitErr.Limit.Kind != VelocityCount {
+		t.Fatalf("got %v, want the count limit", err)
+	}
+
+	if got := getBalance(t, ctx, db, from); got != 100000-600-400+5000-10 {
+		t.Errorf("rejected debits changed the balance: got %d", got)
+	}
+}
+
+func TestStore_CreateVelocityLimitUnknownClient(t *testing.T) {
+	ctx, db, store := newTestStore(t)
+	if err := Migrate(ctx, db.Pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	_, err := store.CreateVelocityLimit(ctx, VelocityLimit{
+		ClientID: "no_such_client", Kind: VelocityCount, Max: 1, WindowSeconds: 60,
+	})
+	if !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("got %v, want %v", err, ErrClientNotFound)
+	}
+}
@@ -0,0 +1,185 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+func (s *Store) CreateClient(ctx context.Context, clientID, currency string) (server.Client, error) {
+	c := server.Client{ID: clientID, Currency: currency, CreatedAt: s.now()}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO clients (client_id, balance, currency, created_at) VALUES (?, 0, ?, ?)`,
+		clientID, currency, formatTime(c.CreatedAt))
+	if isConstraint(err, "UNIQUE") {
+		return server.Client{}, server.ErrClientExists
+	}
+	if err != nil {
+		return server.Client{}, err
+	}
+	return c, nil
+}
+
+// ListClients returns every client ordered by ID
+func (s *Store) ListClients(ctx context.Context) ([]server.Client, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT client_id, currency, balance, created_at FROM clients ORDER BY client_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []server.Client
+	for rows.Next() {
+		var c server.Client
+		var createdAt string
+		if err := rows.Scan(&c.ID, &c.Currency, &c.Balance, &createdAt); err != nil {
+			return nil, err
+		}
+		if c.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	return clients, rows.Err()
+}
+
+// PostAdjustment applies adj.Amount to the client's balance, with the
+// same replay and conflict rules as server.Store.PostAdjustment
+func (s *Store) PostAdjustment(ctx context.Context, adj server.Adjustment) (server.Adjustment, error) {
+	if err := adj.Validate(); err != nil {
+		return server.Adjustment{}, err
+	}
+
+	var result server.Adjustment
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		// Adjustment columns are NULL when the key belongs to a payment or
+		// transfer
+		existing := server.Adjustment{IdempotencyKey: adj.IdempotencyKey}
+		var id, reason, actor, createdAt sql.NullString
+		err := tx.QueryRowContext(ctx,
+			`SELECT a.adjustment_id, le.client_id, le.amount, a.reason, a.actor,
+				le.entry_id, c.balance, a.created_at
+			FROM ledger_entries le
+			JOIN clients c ON c.client_id = le.client_id
+			LEFT JOIN adjustments a ON a.entry_id = le.entry_id
+			WHERE le.idempotency_key = ?
+			LIMIT 1`, adj.IdempotencyKey,
+		).Scan(&id, &existing.ClientID, &existing.Amount, &reason, &actor,
+			&existing.EntryID, &existing.Balance, &createdAt)
+		if err == nil {
+			if !id.Valid || existing.ClientID != adj.ClientID ||
+				existing.Amount != adj.Amount || reason.String != adj.Reason {
+				return server.ErrIdempotencyConflict
+			}
+			existing.ID, existing.Reason, existing.Actor = id.String, reason.String, actor.String
+			if existing.CreatedAt, err = parseTime(createdAt.String); err != nil {
+				return err
+			}
+			s.logger.DebugContext(ctx, "adjustment replayed",
+				"client_id", adj.ClientID, "idempotency_key", adj.IdempotencyKey)
+			result = existing
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		balance, err := lockClient(ctx, tx, adj.ClientID)
+		if err != nil {
+			return err
+		}
+		currency := balance.Currency
+		next, err := balance.Add(money.New(adj.Amount, currency))
+		if err != nil {
+			return err
+		}
+		adj.Balance = next.Amount
+		if adj.Balance < 0 {
+			return server.ErrInsufficientBalance
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE clients SET balance = ? WHERE client_id = ?`, adj.Balance, adj.ClientID); err != nil {
+			return err
+		}
+		adj.CreatedAt = s.now()
+		if adj.EntryID, err = s.insertEntry(ctx, tx, adj.ClientID, adj.Amount, adj.IdempotencyKey, adj.CreatedAt); err != nil {
+			return err
+		}
+		adj.ID = uuid.NewString()
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO adjustments (adjustment_id, entry_id, client_id, amount, reason, actor, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			adj.ID, adj.EntryID, adj.ClientID, adj.Amount, adj.Reason, adj.Actor, formatTime(adj.CreatedAt))
+		if err != nil {
+			return err
+		}
+
+		s.logger.InfoContext(ctx, "adjustment posted",
+			"client_id", adj.ClientID, "amount", adj.Amount, "currency", currency,
+			"actor", adj.Actor, "reason", adj.Reason, "adjustment_id", adj.ID)
+		result = adj
+		return nil
+	})
+	if err != nil {
+		return server.Adjustment{}, err
+	}
+	return result, nil
+}
+
+// Reconcile compares every client's balance with the sum of its ledger
+// entries, from one consistent snapshot
+func (s *Store) Reconcile(ctx context.Context) (server.ReconciliationReport, error) {
+	report := server.ReconciliationReport{Discrepancies: []server.Discrepancy{}}
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		report.CheckedAt = s.now()
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM clients`).Scan(&report.ClientsChecked); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx,
+			`SELECT c.client_id, c.balance, COALESCE(SUM(e.amount), 0)
+			FROM clients c
+			LEFT JOIN ledger_entries e ON e.client_id = c.client_id
+			GROUP BY c.client_id, c.balance
+			HAVING c.balance <> COALESCE(SUM(e.amount), 0)
+			ORDER BY c.client_id`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var d server.Discrepancy
+			if err := rows.Scan(&d.ClientID, &d.Balance, &d.LedgerSum); err != nil {
+				return err
+			}
+			d.Difference = d.Balance - d.LedgerSum
+			report.Discrepancies = append(report.Discrepancies, d)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return server.ReconciliationReport{}, err
+	}
+
+	if n := len(report.Discrepancies); n > 0 {
+		s.logger.WarnContext(ctx, "reconciliation found discrepancies",
+			"clients_checked", report.ClientsChecked, "discrepancies", n)
+	}
+	return report, nil
+}
+
+// isConstraint reports whether err is a violated constraint of the given
+// kind, e.g. "UNIQUE" or "FOREIGN KEY"
+func isConstraint(err error, kind string) bool {
+	return err != nil && strings.Contains(err.Error(), "constraint failed: "+kind) ||
+		err != nil && strings.Contains(err.Error(), kind+" constraint failed")
+}
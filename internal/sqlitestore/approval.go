@@ -0,0 +1,418 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// WithApprovals holds the transfers policy requires approval for
+func (s *Store) WithApprovals(policy server.ApprovalPolicy) *Store {
+	s.approvals = policy
+	return s
+}
+
+const pendingTransferColumns = `transfer_id, from_client_id, to_client_id, amount, currency,
+	COALESCE(idempotency_key, ''), status, hold_entry_id IS NOT NULL, requested_by, created_at, expires_at`
+
+// scanPendingTransfer reads one row of pendingTransferColumns from a
+// *sql.Row or *sql.Rows
+func scanPendingTransfer(row interface{ Scan(...any) error }) (server.PendingTransfer, error) {
+	var t server.PendingTransfer
+	var createdAt, expiresAt string
+	err := row.Scan(&t.ID, &t.FromClientID, &t.ToClientID, &t.Amount, &t.Currency,
+		&t.IdempotencyKey, &t.Status, &t.Held, &t.RequestedBy, &createdAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return server.PendingTransfer{}, server.ErrTransferNotFound
+	}
+	if err != nil {
+		return server.PendingTransfer{}, err
+	}
+	if t.CreatedAt, err = parseTime(createdAt); err != nil {
+		return server.PendingTransfer{}, err
+	}
+	t.ExpiresAt, err = parseTime(expiresAt)
+	return t, err
+}
+
+func transferEvents(ctx context.Context, q querier, id string) ([]server.TransferEvent, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT action, actor, reason, created_at
+		FROM transfer_events WHERE transfer_id = ? ORDER BY event_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []server.TransferEvent{}
+	for rows.Next() {
+		var e server.TransferEvent
+		var createdAt string
+		if err := rows.Scan(&e.Action, &e.Actor, &e.Reason, &createdAt); err != nil {
+			return nil, err
+		}
+		if e.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *Store) GetPendingTransfer(ctx context.Context, id string) (server.PendingTransfer, error) {
+	t, err := scanPendingTransfer(s.db.QueryRowContext(ctx,
+		`SELECT `+pendingTransferColumns+` FROM pending_transfers WHERE transfer_id = ?`, id))
+	if err != nil {
+		return server.PendingTransfer{}, err
+	}
+	if t.Events, err = transferEvents(ctx, s.db, id); err != nil {
+		return server.PendingTransfer{}, err
+	}
+	return t, nil
+}
+
+func (s *Store) ListPendingTransfers(ctx context.Context, status string) ([]server.PendingTransfer, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+pendingTransferColumns+`
+		FROM pending_transfers WHERE ? = '' OR status = ?
+		ORDER BY created_at, transfer_id`, status, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []server.PendingTransfer{}
+	for rows.Next() {
+		t, err := scanPendingTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, rows.Err()
+}
+
+func (s *Store) ApproveTransfer(ctx context.Context, id string, reason string) (server.ApprovedTransfer, error) {
+	var approved server.ApprovedTransfer
+	var expired bool
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		t, err := s.decide(ctx, tx, id)
+		if errors.Is(err, server.ErrTransferExpired) {
+			// The expiry is committed
+			expired = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if t.Held {
+			if _, err := s.moveHold(ctx, tx, t.FromClientID, money.New(-t.Amount, t.Currency)); err != nil {
+				return err
+			}
+		}
+		fromBalance, toBalance, err := s.transfer(ctx, tx, t.FromClientID, t.ToClientID, t.Amount, t.IdempotencyKey)
+		if err != nil {
+			return err
+		}
+		if err := s.decideTransfer(ctx, tx, &t, server.TransferApproved, server.Actor(ctx), reason); err != nil {
+			return err
+		}
+		approved = server.ApprovedTransfer{PendingTransfer: t, FromNewBalance: fromBalance, ToNewBalance: toBalance}
+		return nil
+	})
+	if err != nil {
+		return server.ApprovedTransfer{}, err
+	}
+	if expired {
+		return server.ApprovedTransfer{}, server.ErrTransferExpired
+	}
+	return approved, nil
+}
+
+func (s *Store) RejectTransfer(ctx context.Context, id string, reason string) (server.PendingTransfer, error) {
+	var rejected server.PendingTransfer
+	var expired bool
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		t, err := s.decide(ctx, tx, id)
+		if errors.Is(err, server.ErrTransferExpired) {
+			expired = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if t.Held {
+			if _, err := s.moveHold(ctx, tx, t.FromClientID, money.New(-t.Amount, t.Currency)); err != nil {
+				return err
+			}
+		}
+		if err := s.decideTransfer(ctx, tx, &t, server.TransferRejected, server.Actor(ctx), reason); err != nil {
+			return err
+		}
+		rejected = t
+		return nil
+	})
+	if err != nil {
+		return server.PendingTransfer{}, err
+	}
+	if expired {
+		return server.PendingTransfer{}, server.ErrTransferExpired
+	}
+	return rejected, nil
+}
+
+func (s *Store) ExpireTransfers(ctx context.Context, now time.Time) (int, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT transfer_id FROM pending_transfers
+		WHERE status = 'pending' AND expires_at <= ?
+		ORDER BY expires_at, transfer_id`, formatTime(now))
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Each transfer is expired in a transaction of its own, so one that was
+	// decided meanwhile is skipped instead of failing the others
+	expired := 0
+	for _, id := range ids {
+		done := false
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			t, err := pendingTransfer(ctx, tx, id)
+			if err != nil || t.Status != server.TransferPending || t.ExpiresAt.After(now) {
+				return err
+			}
+			done = true
+			return s.expire(ctx, tx, &t)
+		})
+		if err != nil {
+			return expired, err
+		}
+		if done {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// requestApproval records a transfer that needs approval inside tx,
+// holding its amount when the policy says so. The transfer must be
+// possible as requested: both clients exist in one currency, and the
+// sender can cover the amount if it is held.
+func (s *Store) requestApproval(ctx context.Context, tx *sql.Tx, fromClientID, toClientID string, amount int64, idempotencyKey string) (server.PendingTransfer, error) {
+	from, err := lockClient(ctx, tx, fromClientID)
+	if err != nil {
+		return server.PendingTransfer{}, err
+	}
+	to, err := lockClient(ctx, tx, toClientID)
+	if err != nil {
+		return server.PendingTransfer{}, err
+	}
+	sent := money.New(amount, from.Currency)
+	newFrom, err := from.Sub(sent)
+	if err != nil {
+		return server.PendingTransfer{}, err
+	}
+	if _, err := to.Add(sent); err != nil {
+		return server.PendingTransfer{}, err
+	}
+
+	now := s.now()
+	t := server.PendingTransfer{
+		ID:             uuid.NewString(),
+		FromClientID:   fromClientID,
+		ToClientID:     toClientID,
+		Amount:         amount,
+		Currency:       from.Currency,
+		IdempotencyKey: idempotencyKey,
+		Status:         server.TransferPending,
+		Held:           s.approvals.Hold,
+		RequestedBy:    server.Actor(ctx),
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(s.approvals.TTL).Truncate(time.Microsecond),
+	}
+	var holdEntryID *string
+	if t.Held {
+		if newFrom.IsNegative() {
+			s.logger.InfoContext(ctx, "transfer rejected for insufficient balance",
+				"from_client_id", fromClientID, "balance", from.Amount, "amount", amount)
+			return server.PendingTransfer{}, server.ErrInsufficientBalance
+		}
+		entryID, err := s.moveHold(ctx, tx, fromClientID, sent)
+		if err != nil {
+			return server.PendingTransfer{}, err
+		}
+		holdEntryID = &entryID
+	}
+
+	var key *string
+	if idempotencyKey != "" {
+		key = &idempotencyKey
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO pending_transfers (transfer_id, from_client_id, to_client_id, amount, currency,
+			idempotency_key, status, hold_entry_id, requested_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.FromClientID, t.ToClientID, t.Amount, t.Currency, key, t.Status, holdEntryID,
+		t.RequestedBy, formatTime(t.CreatedAt), formatTime(t.ExpiresAt))
+	if isConstraint(err, "UNIQUE") {
+		return server.PendingTransfer{}, server.ErrIdempotencyConflict
+	}
+	if err != nil {
+		return server.PendingTransfer{}, err
+	}
+	if err := s.recordTransferEvent(ctx, tx, &t, server.TransferRequested, t.RequestedBy, ""); err != nil {
+		return server.PendingTransfer{}, err
+	}
+	s.logger.InfoContext(ctx, "transfer held for approval",
+		"transfer_id", t.ID, "from_client_id", fromClientID, "to_client_id", toClientID,
+		"amount", amount, "held", t.Held, "actor", t.RequestedBy)
+	return t, nil
+}
+
+// pendingTransferByKey returns the transfer held for approval under
+// idempotencyKey
+func pendingTransferByKey(ctx context.Context, tx *sql.Tx, idempotencyKey string) (server.PendingTransfer, error) {
+	t, err := scanPendingTransfer(tx.QueryRowContext(ctx,
+		`SELECT `+pendingTransferColumns+` FROM pending_transfers WHERE idempotency_key = ?`, idempotencyKey))
+	if err != nil {
+		return server.PendingTransfer{}, err
+	}
+	t.Events, err = transferEvents(ctx, tx, t.ID)
+	return t, err
+}
+
+// pendingTransfer reads a transfer inside a write transaction, which
+// holds the write lock until it ends
+func pendingTransfer(ctx context.Context, tx *sql.Tx, id string) (server.PendingTransfer, error) {
+	return scanPendingTransfer(tx.QueryRowContext(ctx,
+		`SELECT `+pendingTransferColumns+` FROM pending_transfers WHERE transfer_id = ?`, id))
+}
+
+// decide reads transfer id so the principal in ctx can approve or reject
+// it. A transfer past its expiry is expired instead, and
+// ErrTransferExpired returned; the caller commits the expiry.
+func (s *Store) decide(ctx context.Context, tx *sql.Tx, id string) (server.PendingTransfer, error) {
+	if _, ok := server.PrincipalFromContext(ctx); !ok {
+		return server.PendingTransfer{}, server.ErrApproverRequired
+	}
+	t, err := pendingTransfer(ctx, tx, id)
+	if err != nil {
+		return server.PendingTransfer{}, err
+	}
+	if t.Status != server.TransferPending {
+		return server.PendingTransfer{}, server.ErrTransferNotPending
+	}
+	if !time.Now().Before(t.ExpiresAt) {
+		if err := s.expire(ctx, tx, &t); err != nil {
+			return server.PendingTransfer{}, err
+		}
+		return t, server.ErrTransferExpired
+	}
+	if server.Actor(ctx) == t.RequestedBy {
+		return server.PendingTransfer{}, server.ErrSelfApproval
+	}
+	return t, nil
+}
+
+// expire releases the hold of t and marks it expired
+func (s *Store) expire(ctx context.Context, tx *sql.Tx, t *server.PendingTransfer) error {
+	if t.Held {
+		if _, err := s.moveHold(ctx, tx, t.FromClientID, money.New(-t.Amount, t.Currency)); err != nil {
+			return err
+		}
+	}
+	return s.decideTransfer(ctx, tx, t, server.TransferExpired, server.SystemActor, "")
+}
+
+// decideTransfer moves t to status and records who did it
+func (s *Store) decideTransfer(ctx context.Context, tx *sql.Tx, t *server.PendingTransfer, status, actor, reason string) error {
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE pending_transfers SET status = ? WHERE transfer_id = ?`, status, t.ID); err != nil {
+		return err
+	}
+	t.Status = status
+	if err := s.recordTransferEvent(ctx, tx, t, status, actor, reason); err != nil {
+		return err
+	}
+	s.logger.InfoContext(ctx, "transfer "+status,
+		"transfer_id", t.ID, "from_client_id", t.FromClientID, "to_client_id", t.ToClientID,
+		"amount", t.Amount, "actor", actor, "reason", reason)
+	return nil
+}
+
+// recordTransferEvent appends an event to the audit trail of t and
+// reloads it
+func (s *Store) recordTransferEvent(ctx context.Context, tx *sql.Tx, t *server.PendingTransfer, action, actor, reason string) error {
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO transfer_events (transfer_id, action, actor, reason, created_at) VALUES (?, ?, ?, ?, ?)`,
+		t.ID, action, actor, reason, formatTime(s.now())); err != nil {
+		return err
+	}
+	events, err := transferEvents(ctx, tx, t.ID)
+	if err != nil {
+		return err
+	}
+	t.Events = events
+	return nil
+}
+
+// moveHold moves amount from the client to the approval hold account of
+// its currency, creating it on first use, or back when amount is
+// negative, and returns the client's ledger entry
+func (s *Store) moveHold(ctx context.Context, tx *sql.Tx, clientID string, amount money.Money) (string, error) {
+	account := server.ApprovalHoldAccount(amount.Currency)
+	now := s.now()
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO clients (client_id, balance, currency, created_at) VALUES (?, 0, ?, ?)
+		ON CONFLICT (client_id) DO NOTHING`, account, amount.Currency, formatTime(now)); err != nil {
+		return "", err
+	}
+	client, err := lockClient(ctx, tx, clientID)
+	if err != nil {
+		return "", err
+	}
+	hold, err := lockClient(ctx, tx, account)
+	if err != nil {
+		return "", err
+	}
+	if client, err = client.Sub(amount); err != nil {
+		return "", err
+	}
+	if hold, err = hold.Add(amount); err != nil {
+		return "", err
+	}
+	for _, update := range []struct {
+		id      string
+		balance int64
+	}{{clientID, client.Amount}, {account, hold.Amount}} {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE clients SET balance = ? WHERE client_id = ?`, update.balance, update.id); err != nil {
+			return "", err
+		}
+	}
+
+	// Like transfer credits, hold entries carry no idempotency key
+	entryID := uuid.NewString()
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO ledger_entries (entry_id, client_id, amount, created_at) VALUES (?, ?, ?, ?), (?, ?, ?, ?)`,
+		entryID, clientID, -amount.Amount, formatTime(now), uuid.NewString(), account, amount.Amount, formatTime(now))
+	return entryID, err
+}
@@ -0,0 +1,187 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+const feeScheduleColumns = `schedule_id, COALESCE(client_id, ''), COALESCE(fee_group, ''), operation, kind,
+	fixed, basis_points, tiers, min_fee, max_fee, created_at`
+
+func (s *Store) ListFeeSchedules(ctx context.Context) ([]server.FeeSchedule, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+feeScheduleColumns+` FROM fee_schedules ORDER BY created_at, schedule_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []server.FeeSchedule{}
+	for rows.Next() {
+		f, err := scanFeeSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, f)
+	}
+	return schedules, rows.Err()
+}
+
+func (s *Store) CreateFeeSchedule(ctx context.Context, schedule server.FeeSchedule) (server.FeeSchedule, error) {
+	if err := schedule.Validate(); err != nil {
+		return server.FeeSchedule{}, err
+	}
+	tiers, err := json.Marshal(schedule.Tiers)
+	if err != nil {
+		return server.FeeSchedule{}, err
+	}
+
+	schedule.ID, schedule.CreatedAt = uuid.NewString(), s.now()
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO fee_schedules (schedule_id, client_id, fee_group, operation, kind,
+			fixed, basis_points, tiers, min_fee, max_fee, created_at)
+		VALUES (?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, ?)`,
+		schedule.ID, schedule.ClientID, schedule.Group, schedule.Operation, schedule.Kind,
+		schedule.Fixed, schedule.BasisPoints, string(tiers), schedule.Min, schedule.Max,
+		formatTime(schedule.CreatedAt))
+	switch {
+	case isConstraint(err, "FOREIGN KEY"):
+		return server.FeeSchedule{}, server.ErrClientNotFound
+	case isConstraint(err, "UNIQUE"):
+		return server.FeeSchedule{}, server.ErrFeeScheduleExists
+	case err != nil:
+		return server.FeeSchedule{}, err
+	}
+	return schedule, nil
+}
+
+func (s *Store) DeleteFeeSchedule(ctx context.Context, scheduleID string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM fee_schedules WHERE schedule_id = ?`, scheduleID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return server.ErrFeeScheduleNotFound
+	}
+	return nil
+}
+
+func (s *Store) SetFeeGroup(ctx context.Context, clientID string, group string) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE clients SET fee_group = NULLIF(?, '') WHERE client_id = ?`, group, clientID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return server.ErrClientNotFound
+	}
+	return nil
+}
+
+func (s *Store) QuoteFee(ctx context.Context, clientID string, operation string, amount int64) (server.FeeQuote, error) {
+	var currency string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT currency FROM clients WHERE client_id = ?`, clientID).Scan(&currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return server.FeeQuote{}, server.ErrClientNotFound
+	}
+	if err != nil {
+		return server.FeeQuote{}, err
+	}
+	return quoteFee(ctx, s.db, clientID, operation, amount, currency)
+}
+
+// queryer is a *sql.DB or a *sql.Tx
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// quoteFee prices an operation with the client's own schedule, or its
+// group's when it has none
+func quoteFee(ctx context.Context, q queryer, clientID, operation string, amount int64, currency string) (server.FeeQuote, error) {
+	schedule, err := scanFeeSchedule(q.QueryRowContext(ctx,
+		`SELECT `+feeScheduleColumns+`
+		FROM fee_schedules
+		WHERE operation = ?2
+			AND (client_id = ?1 OR fee_group = (SELECT fee_group FROM clients WHERE client_id = ?1))
+		ORDER BY client_id IS NULL
+		LIMIT 1`, clientID, operation))
+	if errors.Is(err, sql.ErrNoRows) {
+		return server.NewFeeQuote(clientID, operation, amount, currency, nil)
+	}
+	if err != nil {
+		return server.FeeQuote{}, err
+	}
+	return server.NewFeeQuote(clientID, operation, amount, currency, &schedule)
+}
+
+// postFee credits q.Fee to the fee revenue account and records the debit
+// of the client, whose balance the caller has already lowered by it. Both
+// entries are linked to entryID, the entry of the operation charged for.
+func (s *Store) postFee(ctx context.Context, tx *sql.Tx, q server.FeeQuote, entryID string, at time.Time) error {
+	account := server.FeeRevenueAccount(q.Currency)
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO clients (client_id, balance, currency, created_at) VALUES (?, 0, ?, ?)
+		ON CONFLICT (client_id) DO NOTHING`, account, q.Currency, formatTime(at)); err != nil {
+		return err
+	}
+	revenue, err := lockClient(ctx, tx, account)
+	if err != nil {
+		return err
+	}
+	if revenue, err = revenue.Add(money.New(q.Fee, q.Currency)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE clients SET balance = ? WHERE client_id = ?`, revenue.Amount, account); err != nil {
+		return err
+	}
+
+	// Like transfer credits, fee entries carry no idempotency key
+	debitID, creditID := uuid.NewString(), uuid.NewString()
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO ledger_entries (entry_id, client_id, amount, created_at) VALUES (?, ?, ?, ?), (?, ?, ?, ?)`,
+		debitID, q.ClientID, -q.Fee, formatTime(at), creditID, account, q.Fee, formatTime(at))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO fee_charges (charge_id, entry_id, debit_entry_id, credit_entry_id, schedule_id,
+			client_id, operation, amount, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		uuid.NewString(), entryID, debitID, creditID, q.ScheduleID,
+		q.ClientID, q.Operation, q.Fee, formatTime(at))
+	return err
+}
+
+// scanFeeSchedule reads one row of feeScheduleColumns from a *sql.Row or
+// *sql.Rows
+func scanFeeSchedule(row interface{ Scan(...any) error }) (server.FeeSchedule, error) {
+	var f server.FeeSchedule
+	var tiers, createdAt string
+	err := row.Scan(&f.ID, &f.ClientID, &f.Group, &f.Operation, &f.Kind,
+		&f.Fixed, &f.BasisPoints, &tiers, &f.Min, &f.Max, &createdAt)
+	if err != nil {
+		return server.FeeSchedule{}, err
+	}
+	if f.CreatedAt, err = parseTime(createdAt); err != nil {
+		return server.FeeSchedule{}, err
+	}
+	return f, json.Unmarshal([]byte(tiers), &f.Tiers)
+}
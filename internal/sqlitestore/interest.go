@@ -0,0 +1,309 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// dateLayout stores accrual days and periods
+const dateLayout = "2006-01-02"
+
+const interestRateColumns = `client_id, credit_basis_points, overdraft_basis_points, day_count, created_at, updated_at`
+
+func (s *Store) GetInterestRate(ctx context.Context, clientID string) (server.InterestRate, error) {
+	rate, err := scanInterestRate(s.db.QueryRowContext(ctx,
+		`SELECT `+interestRateColumns+` FROM interest_rates WHERE client_id = ?`, clientID))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return rate, err
+	}
+	var exists bool
+	if err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM clients WHERE client_id = ?)`, clientID).Scan(&exists); err != nil {
+		return server.InterestRate{}, err
+	}
+	if !exists {
+		return server.InterestRate{}, server.ErrClientNotFound
+	}
+	return server.InterestRate{}, server.ErrInterestRateNotFound
+}
+
+func (s *Store) SetInterestRate(ctx context.Context, rate server.InterestRate) (server.InterestRate, error) {
+	if err := rate.Validate(); err != nil {
+		return server.InterestRate{}, err
+	}
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		now := formatTime(s.now())
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO interest_rates (client_id, credit_basis_points, overdraft_basis_points, day_count,
+				created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (client_id) DO UPDATE SET
+				credit_basis_points = excluded.credit_basis_points,
+				overdraft_basis_points = excluded.overdraft_basis_points,
+				day_count = excluded.day_count,
+				updated_at = excluded.updated_at`,
+			rate.ClientID, rate.CreditBasisPoints, rate.OverdraftBasisPoints, rate.DayCount, now, now)
+		if isConstraint(err, "FOREIGN KEY") {
+			return server.ErrClientNotFound
+		}
+		if err != nil {
+			return err
+		}
+		rate, err = scanInterestRate(tx.QueryRowContext(ctx,
+			`SELECT `+interestRateColumns+` FROM interest_rates WHERE client_id = ?`, rate.ClientID))
+		return err
+	})
+	if err != nil {
+		return server.InterestRate{}, err
+	}
+	return rate, nil
+}
+
+func (s *Store) ListInterestPostings(ctx context.Context, clientID string) ([]server.InterestPosting, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM clients WHERE client_id = ?)`, clientID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, server.ErrClientNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT p.client_id, p.period, c.currency, p.accrued, p.amount, p.residual,
+			COALESCE(p.entry_id, ''), p.created_at
+		FROM interest_postings p
+		JOIN clients c ON c.client_id = p.client_id
+		WHERE p.client_id = ?
+		ORDER BY p.period`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	postings := []server.InterestPosting{}
+	for rows.Next() {
+		var p server.InterestPosting
+		var period, createdAt string
+		if err := rows.Scan(&p.ClientID, &period, &p.Currency, &p.Accrued, &p.Amount, &p.Residual,
+			&p.EntryID, &createdAt); err != nil {
+			return nil, err
+		}
+		if p.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, err
+		}
+		p.Period = period[:len("2006-01")]
+		postings = append(postings, p)
+	}
+	return postings, rows.Err()
+}
+
+func (s *Store) RunInterest(ctx context.Context, asOf time.Time) (server.InterestRun, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT client_id FROM interest_rates ORDER BY client_id`)
+	if err != nil {
+		return server.InterestRun{}, err
+	}
+	var clientIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return server.InterestRun{}, err
+		}
+		clientIDs = append(clientIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return server.InterestRun{}, err
+	}
+
+	run := server.InterestRun{AsOf: asOf, Postings: []server.InterestPosting{}, Failed: []string{}}
+	for _, clientID := range clientIDs {
+		var days int
+		var postings []server.InterestPosting
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			var err error
+			days, postings, err = s.accrueInterest(ctx, tx, clientID, asOf)
+			return err
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return server.InterestRun{}, ctx.Err()
+			}
+			s.logger.ErrorContext(ctx, "interest accrual failed", "client_id", clientID, "err", err)
+			run.Failed = append(run.Failed, clientID)
+			continue
+		}
+		if days > 0 {
+			run.ClientsAccrued++
+			run.DaysAccrued += days
+		}
+		for _, p := range postings {
+			s.logger.InfoContext(ctx, "interest capitalized",
+				"client_id", p.ClientID, "period", p.Period, "amount", p.Amount, "currency", p.Currency)
+		}
+		run.Postings = append(run.Postings, postings...)
+	}
+	return run, nil
+}
+
+// accrueInterest accrues the client's days up to asOf, and capitalizes
+// each month as soon as its last day is accrued
+func (s *Store) accrueInterest(ctx context.Context, tx *sql.Tx, clientID string, asOf time.Time) (int, []server.InterestPosting, error) {
+	balance, err := lockClient(ctx, tx, clientID)
+	if err != nil {
+		return 0, nil, err
+	}
+	rate, err := scanInterestRate(tx.QueryRowContext(ctx,
+		`SELECT `+interestRateColumns+` FROM interest_rates WHERE client_id = ?`, clientID))
+	if err != nil {
+		return 0, nil, err
+	}
+	var last sql.NullString
+	err = tx.QueryRowContext(ctx,
+		`SELECT MAX(accrual_date) FROM interest_accruals WHERE client_id = ?`, clientID).Scan(&last)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	day := server.InterestDay(rate.CreatedAt)
+	if last.Valid {
+		if day, err = time.Parse(dateLayout, last.String); err != nil {
+			return 0, nil, err
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	days, postings := 0, []server.InterestPosting{}
+	for end := server.InterestDay(asOf); day.Before(end); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		// Capitalized interest counts from the end of its month, whenever
+		// it was posted
+		var eod int64
+		err := tx.QueryRowContext(ctx,
+			`SELECT
+				(SELECT COALESCE(SUM(e.amount), 0) FROM ledger_entries e
+				WHERE e.client_id = ?1 AND e.created_at < ?2
+					AND NOT EXISTS (SELECT 1 FROM interest_postings p WHERE p.entry_id = e.entry_id))
+				+ (SELECT COALESCE(SUM(amount), 0) FROM interest_postings
+				WHERE client_id = ?1 AND period_end < ?2)`,
+			clientID, formatTime(next)).Scan(&eod)
+		if err != nil {
+			return 0, nil, err
+		}
+		accrued, err := rate.Accrue(day, eod)
+		if err != nil {
+			return 0, nil, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO interest_accruals (client_id, accrual_date, balance, credit_basis_points,
+				overdraft_basis_points, day_count, accrued, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			clientID, day.Format(dateLayout), eod, rate.CreditBasisPoints, rate.OverdraftBasisPoints,
+			rate.DayCount, accrued, formatTime(s.now())); err != nil {
+			return 0, nil, err
+		}
+		days++
+
+		if next.Day() != 1 {
+			continue
+		}
+		p, err := s.capitalizeInterest(ctx, tx, &balance, clientID, server.InterestPeriod(day))
+		if err != nil {
+			return 0, nil, err
+		}
+		postings = append(postings, p)
+	}
+	return days, postings, nil
+}
+
+// capitalizeInterest posts the interest accrued over period against the
+// house account, and moves balance, the client's balance, along
+func (s *Store) capitalizeInterest(ctx context.Context, tx *sql.Tx, balance *money.Money, clientID string, period time.Time) (server.InterestPosting, error) {
+	end := period.AddDate(0, 1, 0)
+	var carried, accrued int64
+	err := tx.QueryRowContext(ctx,
+		`SELECT
+			COALESCE((SELECT residual FROM interest_postings WHERE client_id = ?1 ORDER BY period DESC LIMIT 1), 0),
+			(SELECT COALESCE(SUM(accrued), 0) FROM interest_accruals
+			WHERE client_id = ?1 AND accrual_date >= ?2 AND accrual_date < ?3)`,
+		clientID, period.Format(dateLayout), end.Format(dateLayout)).Scan(&carried, &accrued)
+	if err != nil {
+		return server.InterestPosting{}, err
+	}
+	p, err := server.NewInterestPosting(clientID, balance.Currency, period, carried, accrued)
+	if err != nil {
+		return server.InterestPosting{}, err
+	}
+
+	now := s.now()
+	var entryID, counterID sql.NullString
+	if p.Amount != 0 {
+		next, err := balance.Add(money.New(p.Amount, balance.Currency))
+		if err != nil {
+			return server.InterestPosting{}, err
+		}
+		account := p.Account()
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO clients (client_id, balance, currency, created_at) VALUES (?, 0, ?, ?)
+			ON CONFLICT (client_id) DO NOTHING`, account, p.Currency, formatTime(now)); err != nil {
+			return server.InterestPosting{}, err
+		}
+		house, err := lockClient(ctx, tx, account)
+		if err != nil {
+			return server.InterestPosting{}, err
+		}
+		if house, err = house.Sub(money.New(p.Amount, p.Currency)); err != nil {
+			return server.InterestPosting{}, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE clients SET balance = ? WHERE client_id = ?`, next.Amount, clientID); err != nil {
+			return server.InterestPosting{}, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE clients SET balance = ? WHERE client_id = ?`, house.Amount, account); err != nil {
+			return server.InterestPosting{}, err
+		}
+		*balance = next
+
+		entryID = sql.NullString{String: uuid.NewString(), Valid: true}
+		counterID = sql.NullString{String: uuid.NewString(), Valid: true}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO ledger_entries (entry_id, client_id, amount, created_at) VALUES (?, ?, ?, ?), (?, ?, ?, ?)`,
+			entryID, clientID, p.Amount, formatTime(now), counterID, account, -p.Amount, formatTime(now))
+		if err != nil {
+			return server.InterestPosting{}, err
+		}
+		p.EntryID = entryID.String
+	}
+
+	p.CreatedAt = now
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO interest_postings (client_id, period, period_end, accrued, amount, residual,
+			entry_id, counter_entry_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		clientID, period.Format(dateLayout), formatTime(end), p.Accrued, p.Amount, p.Residual,
+		entryID, counterID, formatTime(now))
+	return p, err
+}
+
+// scanInterestRate reads one row of interestRateColumns
+func scanInterestRate(row *sql.Row) (server.InterestRate, error) {
+	var r server.InterestRate
+	var createdAt, updatedAt string
+	err := row.Scan(&r.ClientID, &r.CreditBasisPoints, &r.OverdraftBasisPoints, &r.DayCount, &createdAt, &updatedAt)
+	if err != nil {
+		return server.InterestRate{}, err
+	}
+	if r.CreatedAt, err = parseTime(createdAt); err != nil {
+		return server.InterestRate{}, err
+	}
+	r.UpdatedAt, err = parseTime(updatedAt)
+	return r, err
+}
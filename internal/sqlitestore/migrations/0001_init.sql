@@ -0,0 +1,26 @@
+-- SQLite dialect of the PostgreSQL migrations in internal/server, with
+-- the same versions and names. IDs are generated by the store, and times
+-- are UTC text in a fixed-width format, so they sort chronologically.
+
+-- Clients table: stores account balances
+CREATE TABLE IF NOT EXISTS clients (
+    client_id  TEXT PRIMARY KEY,
+    balance    INTEGER NOT NULL DEFAULT 0,
+    currency   TEXT NOT NULL,
+    created_at TEXT NOT NULL
+);
+
+-- Ledger entries: immutable transaction log
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    entry_id        TEXT PRIMARY KEY,
+    client_id       TEXT NOT NULL REFERENCES clients(client_id),
+    amount          INTEGER NOT NULL,
+    created_at      TEXT NOT NULL,
+    idempotency_key TEXT
+);
+
+-- Index for idempotency lookups
+CREATE INDEX IF NOT EXISTS idx_ledger_idempotency ON ledger_entries(idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+-- Index for client ledger queries
+CREATE INDEX IF NOT EXISTS idx_ledger_client ON ledger_entries(client_id);
@@ -0,0 +1,6 @@
+-- Rate-limit buckets are only shared between replicas through PostgreSQL.
+-- The table exists so that both schemas stay alike.
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key TEXT PRIMARY KEY,
+    tat TEXT NOT NULL
+);
@@ -0,0 +1,16 @@
+-- Velocity limits cap how much a client may send out within a sliding
+-- window: by volume in minor units (kind 'amount') or by number of debits
+-- (kind 'count')
+CREATE TABLE IF NOT EXISTS velocity_limits (
+    limit_id       TEXT PRIMARY KEY,
+    client_id      TEXT NOT NULL REFERENCES clients(client_id),
+    kind           TEXT NOT NULL CHECK (kind IN ('amount', 'count')),
+    max_value      INTEGER NOT NULL CHECK (max_value > 0),
+    window_seconds INTEGER NOT NULL CHECK (window_seconds > 0),
+    created_at     TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_velocity_limits_client ON velocity_limits(client_id);
+
+-- Velocity checks sum a client's recent debits
+CREATE INDEX IF NOT EXISTS idx_ledger_client_created ON ledger_entries(client_id, created_at);
@@ -0,0 +1,41 @@
+-- Clients may belong to a fee group, whose fee schedules apply to every
+-- member without a schedule of its own
+ALTER TABLE clients ADD COLUMN fee_group TEXT;
+
+-- Fee schedules price payments or transfers for one client or for a fee
+-- group. Tiers are a JSON array of {up_to, fixed, basis_points}.
+CREATE TABLE IF NOT EXISTS fee_schedules (
+    schedule_id  TEXT PRIMARY KEY,
+    client_id    TEXT REFERENCES clients(client_id),
+    fee_group    TEXT,
+    operation    TEXT NOT NULL CHECK (operation IN ('payment', 'transfer')),
+    kind         TEXT NOT NULL CHECK (kind IN ('fixed', 'percentage', 'tiered')),
+    fixed        INTEGER NOT NULL DEFAULT 0 CHECK (fixed >= 0),
+    basis_points INTEGER NOT NULL DEFAULT 0 CHECK (basis_points BETWEEN 0 AND 10000),
+    tiers        TEXT NOT NULL DEFAULT '[]',
+    min_fee      INTEGER NOT NULL DEFAULT 0 CHECK (min_fee >= 0),
+    max_fee      INTEGER NOT NULL DEFAULT 0 CHECK (max_fee >= 0),
+    created_at   TEXT NOT NULL,
+    CHECK ((client_id IS NULL) <> (fee_group IS NULL))
+);
+
+-- One schedule per operation for each client and each group
+CREATE UNIQUE INDEX IF NOT EXISTS idx_fee_schedules_client ON fee_schedules(client_id, operation) WHERE client_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_fee_schedules_group ON fee_schedules(fee_group, operation) WHERE fee_group IS NOT NULL;
+
+-- Each fee charged is a debit of the client and a credit of the fee
+-- revenue account, linked to the entry of the payment or transfer it was
+-- charged for
+CREATE TABLE IF NOT EXISTS fee_charges (
+    charge_id       TEXT PRIMARY KEY,
+    entry_id        TEXT NOT NULL UNIQUE REFERENCES ledger_entries(entry_id),
+    debit_entry_id  TEXT NOT NULL UNIQUE REFERENCES ledger_entries(entry_id),
+    credit_entry_id TEXT NOT NULL UNIQUE REFERENCES ledger_entries(entry_id),
+    schedule_id     TEXT REFERENCES fee_schedules(schedule_id) ON DELETE SET NULL,
+    client_id       TEXT NOT NULL REFERENCES clients(client_id),
+    operation       TEXT NOT NULL,
+    amount          INTEGER NOT NULL CHECK (amount > 0),
+    created_at      TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_fee_charges_client ON fee_charges(client_id, created_at);
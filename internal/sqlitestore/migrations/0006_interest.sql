@@ -0,0 +1,42 @@
+-- Annual interest rates in basis points. Accrual starts on the UTC day
+-- the rate was created.
+CREATE TABLE IF NOT EXISTS interest_rates (
+    client_id              TEXT PRIMARY KEY REFERENCES clients(client_id),
+    credit_basis_points    INTEGER NOT NULL CHECK (credit_basis_points BETWEEN 0 AND 10000),
+    overdraft_basis_points INTEGER NOT NULL CHECK (overdraft_basis_points BETWEEN 0 AND 10000),
+    day_count              TEXT NOT NULL CHECK (day_count IN ('act/365', 'act/360', 'act/act', '30/360')),
+    created_at             TEXT NOT NULL,
+    updated_at             TEXT NOT NULL
+);
+
+-- One row per client and day: the end-of-day balance, the rate applied
+-- and the interest it earned, in millionths of the minor unit. Days are
+-- YYYY-MM-DD. The key keeps a day from being accrued twice.
+CREATE TABLE IF NOT EXISTS interest_accruals (
+    client_id              TEXT NOT NULL REFERENCES clients(client_id),
+    accrual_date           TEXT NOT NULL,
+    balance                INTEGER NOT NULL,
+    credit_basis_points    INTEGER NOT NULL,
+    overdraft_basis_points INTEGER NOT NULL,
+    day_count              TEXT NOT NULL,
+    accrued                INTEGER NOT NULL,
+    created_at             TEXT NOT NULL,
+    PRIMARY KEY (client_id, accrual_date)
+);
+
+-- One row per client and month capitalized. entry_id is the client's
+-- ledger entry and counter_entry_id the house account's; both are NULL
+-- when the month rounded to zero. The residual is carried to the next
+-- month. The key keeps a month from being posted twice.
+CREATE TABLE IF NOT EXISTS interest_postings (
+    client_id        TEXT NOT NULL REFERENCES clients(client_id),
+    period           TEXT NOT NULL,
+    period_end       TEXT NOT NULL,
+    accrued          INTEGER NOT NULL,
+    amount           INTEGER NOT NULL,
+    residual         INTEGER NOT NULL,
+    entry_id         TEXT UNIQUE REFERENCES ledger_entries(entry_id),
+    counter_entry_id TEXT UNIQUE REFERENCES ledger_entries(entry_id),
+    created_at       TEXT NOT NULL,
+    PRIMARY KEY (client_id, period)
+);
@@ -0,0 +1,34 @@
+-- Transfers above the approval threshold wait here for a second principal
+-- to approve or reject them. hold_entry_id is the sender's debit to the
+-- approval hold account when the funds were held, NULL otherwise. The
+-- idempotency key is the one the transfer was requested with; its debit
+-- carries it once approved.
+CREATE TABLE IF NOT EXISTS pending_transfers (
+    transfer_id     TEXT PRIMARY KEY,
+    from_client_id  TEXT NOT NULL REFERENCES clients(client_id),
+    to_client_id    TEXT NOT NULL REFERENCES clients(client_id),
+    amount          INTEGER NOT NULL CHECK (amount > 0),
+    currency        TEXT NOT NULL,
+    idempotency_key TEXT UNIQUE,
+    status          TEXT NOT NULL CHECK (status IN ('pending', 'approved', 'rejected', 'expired')),
+    hold_entry_id   TEXT UNIQUE REFERENCES ledger_entries(entry_id),
+    requested_by    TEXT NOT NULL,
+    created_at      TEXT NOT NULL,
+    expires_at      TEXT NOT NULL
+);
+
+-- The expiry job looks for pending transfers past their expiry
+CREATE INDEX IF NOT EXISTS idx_pending_transfers_expiry ON pending_transfers(expires_at) WHERE status = 'pending';
+
+-- Audit trail of each transfer: who requested it and who approved,
+-- rejected or expired it, and why
+CREATE TABLE IF NOT EXISTS transfer_events (
+    event_id    INTEGER PRIMARY KEY,
+    transfer_id TEXT NOT NULL REFERENCES pending_transfers(transfer_id),
+    action      TEXT NOT NULL CHECK (action IN ('requested', 'approved', 'rejected', 'expired')),
+    actor       TEXT NOT NULL,
+    reason      TEXT NOT NULL DEFAULT '',
+    created_at  TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_transfer_events_transfer ON transfer_events(transfer_id, event_id);
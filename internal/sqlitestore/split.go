@@ -0,0 +1,132 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+func (s *Store) SplitTransfer(ctx context.Context, legs []server.TransferLeg, idempotencyKey string) ([]int64, error) {
+	if err := server.ValidateLegs(legs); err != nil {
+		return nil, err
+	}
+	var balances []int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if idempotencyKey != "" {
+			var count int
+			err := tx.QueryRowContext(ctx,
+				`SELECT COUNT(*) FROM ledger_entries WHERE idempotency_key = ?`, idempotencyKey).Scan(&count)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				s.logger.DebugContext(ctx, "split transfer replayed", "idempotency_key", idempotencyKey)
+				balances = make([]int64, len(legs))
+				for i, leg := range legs {
+					b, err := lockClient(ctx, tx, leg.ClientID)
+					if err != nil {
+						return err
+					}
+					balances[i] = b.Amount
+				}
+				return nil
+			}
+			// The key of a transfer awaiting approval is taken
+			if _, err := pendingTransferByKey(ctx, tx, idempotencyKey); !errors.Is(err, server.ErrTransferNotFound) {
+				if err == nil {
+					err = server.ErrIdempotencyConflict
+				}
+				return err
+			}
+		}
+		if s.approvals.Requires(server.SplitTotal(legs)) {
+			return server.ErrApprovalRequired
+		}
+		var err error
+		balances, err = s.splitTransfer(ctx, tx, legs, idempotencyKey)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// splitTransfer posts legs and charges each debited client's fee inside
+// tx. It returns the new balances in leg order.
+func (s *Store) splitTransfer(ctx context.Context, tx *sql.Tx, legs []server.TransferLeg, idempotencyKey string) ([]int64, error) {
+	locked := make([]money.Money, len(legs))
+	for i, leg := range legs {
+		var err error
+		if locked[i], err = lockClient(ctx, tx, leg.ClientID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Every leg is in the currency of the first
+	currency := locked[0].Currency
+	next := make([]money.Money, len(legs))
+	fees := make([]server.FeeQuote, len(legs))
+	for i, leg := range legs {
+		var err error
+		if next[i], err = locked[i].Add(money.New(leg.Amount, currency)); err != nil {
+			return nil, err
+		}
+		if leg.Amount > 0 {
+			continue
+		}
+		// Each sender pays the fee on what it sends, on top of it
+		if fees[i], err = quoteFee(ctx, tx, leg.ClientID, server.FeeTransfer, -leg.Amount, currency); err != nil {
+			return nil, err
+		}
+		if next[i], err = next[i].Sub(money.New(fees[i].Fee, currency)); err != nil {
+			return nil, err
+		}
+		if next[i].IsNegative() {
+			s.logger.InfoContext(ctx, "split transfer rejected for insufficient balance",
+				"client_id", leg.ClientID, "balance", locked[i].Amount, "amount", -leg.Amount, "fee", fees[i].Fee)
+			return nil, server.ErrInsufficientBalance
+		}
+		if err := s.checkVelocity(ctx, tx, "transfer", leg.ClientID, -leg.Amount); err != nil {
+			return nil, err
+		}
+	}
+
+	// Every balance is written before any fee is posted: posting reads the
+	// balance of the fee revenue account, which may be one of the legs
+	balances := make([]int64, len(legs))
+	for i, leg := range legs {
+		balances[i] = next[i].Amount
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE clients SET balance = ? WHERE client_id = ?`, balances[i], leg.ClientID); err != nil {
+			return nil, err
+		}
+	}
+	now := s.now()
+	for i, leg := range legs {
+		// Only the debits carry the key, like the debit of a transfer
+		if leg.Amount > 0 {
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO ledger_entries (entry_id, client_id, amount, created_at) VALUES (?, ?, ?, ?)`,
+				uuid.NewString(), leg.ClientID, leg.Amount, formatTime(now)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		entryID, err := s.insertEntry(ctx, tx, leg.ClientID, leg.Amount, idempotencyKey, now)
+		if err != nil {
+			return nil, err
+		}
+		if fees[i].Fee > 0 {
+			if err := s.postFee(ctx, tx, fees[i], entryID, s.now()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return balances, nil
+}
@@ -0,0 +1,408 @@
+// Package sqlitestore keeps the ledger in an embedded SQLite database, so
+// the server can run without PostgreSQL. It uses a pure-Go driver and
+// needs no cgo.
+//
+// It has the same semantics as the PostgreSQL store, which the storetest
+// suite checks for both. Every write transaction starts with BEGIN
+// IMMEDIATE and holds the database's single write lock until it ends,
+// which serializes writers the way the PostgreSQL store's row locks do.
+// Readers are not blocked, because the database runs in WAL mode.
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// timeLayout stores times as fixed-width UTC text, which sorts in time
+// order and keeps PostgreSQL's microsecond precision
+const timeLayout = "2006-01-02T15:04:05.000000Z"
+
+type Store struct {
+	db        *sql.DB
+	logger    *slog.Logger
+	approvals server.ApprovalPolicy
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// Open opens or creates the database file at path. A path may also be a
+// "file:" URI. Use Migrate to create the schema.
+func Open(ctx context.Context, path string) (*Store, error) {
+	if path == "" {
+		return nil, errors.New("sqlite database path is not set")
+	}
+	dsn := path
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	dsn += sep + "_txlock=immediate" +
+		"&_pragma=foreign_keys(1)" +
+		"&_pragma=journal_mode(WAL)" +
+		"&_pragma=busy_timeout(10000)"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	return &Store{db: db, logger: slog.Default()}, nil
+}
+
+// WithLogger sets the logger used for idempotent replays and rejections
+func (s *Store) WithLogger(logger *slog.Logger) *Store {
+	s.logger = logger
+	return s
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// now returns the current time, later than any time it returned before,
+// so that entries posted one after another sort in that order
+func (s *Store) now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := time.Now().UTC().Truncate(time.Microsecond)
+	if !t.After(s.last) {
+		t = s.last.Add(time.Microsecond)
+	}
+	s.last = t
+	return t
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(timeLayout, s)
+}
+
+// inTx runs fn in a write transaction and commits it if fn succeeds
+func (s *Store) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) GetBalance(ctx context.Context, clientID string) (int64, string, error) {
+	var balance int64
+	var currency string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT balance, currency FROM clients WHERE client_id = ?`, clientID).Scan(&balance, &currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", server.ErrClientNotFound
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	return balance, currency, nil
+}
+
+// GetLedger returns the client's entries oldest first
+func (s *Store) GetLedger(ctx context.Context, clientID string) ([]server.Ledger, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT e.entry_id, e.client_id, e.amount, c.currency, e.created_at, e.idempotency_key
+		FROM ledger_entries e JOIN clients c ON c.client_id = e.client_id
+		WHERE e.client_id = ?
+		ORDER BY e.created_at, e.entry_id`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	return scanLedger(rows)
+}
+
+// GetLedgerPage implements server.LedgerPageStore
+func (s *Store) GetLedgerPage(ctx context.Context, clientID string, cursor *server.LedgerCursor, limit int) ([]server.Ledger, bool, error) {
+	var after, afterID any
+	if cursor != nil {
+		after, afterID = formatTime(cursor.CreatedAt), cursor.EntryID.String()
+	}
+
+	// One extra row tells whether another page follows
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT e.entry_id, e.client_id, e.amount, c.currency, e.created_at, e.idempotency_key
+		FROM ledger_entries e JOIN clients c ON c.client_id = e.client_id
+		WHERE e.client_id = ?1
+			AND (?2 IS NULL OR (e.created_at, e.entry_id) > (?2, ?3))
+		ORDER BY e.created_at, e.entry_id
+		LIMIT ?4`, clientID, after, afterID, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	entries, err := scanLedger(rows)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(entries) > limit {
+		return entries[:limit], true, nil
+	}
+	return entries, false, nil
+}
+
+func scanLedger(rows *sql.Rows) ([]server.Ledger, error) {
+	defer rows.Close()
+
+	var entries []server.Ledger
+	for rows.Next() {
+		var e server.Ledger
+		var entryID, createdAt string
+		if err := rows.Scan(&entryID, &e.ClientId, &e.Amount, &e.Currency, &createdAt, &e.IdempotencyKey); err != nil {
+			return nil, err
+		}
+		var err error
+		if e.EntryId, err = uuid.Parse(entryID); err != nil {
+			return nil, err
+		}
+		if e.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (s *Store) CreatePayment(ctx context.Context, clientID string, amount int64, idempotencyKey string) (int64, error) {
+	var newBalance int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if idempotencyKey != "" {
+			err := tx.QueryRowContext(ctx,
+				`SELECT c.balance
+				FROM ledger_entries le
+				JOIN clients c ON c.client_id = le.client_id
+				WHERE le.idempotency_key = ?`, idempotencyKey).Scan(&newBalance)
+			if err == nil {
+				s.logger.DebugContext(ctx, "payment replayed",
+					"client_id", clientID, "idempotency_key", idempotencyKey)
+				return nil
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+
+		balance, err := lockClient(ctx, tx, clientID)
+		if err != nil {
+			return err
+		}
+		next, err := balance.Add(money.New(amount, balance.Currency))
+		if err != nil {
+			return err
+		}
+		fee, err := quoteFee(ctx, tx, clientID, server.FeePayment, amount, balance.Currency)
+		if err != nil {
+			return err
+		}
+		if next, err = next.Sub(money.New(fee.Fee, balance.Currency)); err != nil {
+			return err
+		}
+		newBalance = next.Amount
+		if newBalance < 0 {
+			s.logger.InfoContext(ctx, "payment rejected for insufficient balance",
+				"client_id", clientID, "balance", balance.Amount, "amount", amount)
+			return server.ErrInsufficientBalance
+		}
+		if amount < 0 {
+			if err := s.checkVelocity(ctx, tx, "payment", clientID, -amount); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE clients SET balance = ? WHERE client_id = ?`, newBalance, clientID); err != nil {
+			return err
+		}
+		entryID, err := s.insertEntry(ctx, tx, clientID, amount, idempotencyKey, s.now())
+		if err != nil || fee.Fee == 0 {
+			return err
+		}
+		return s.postFee(ctx, tx, fee, entryID, s.now())
+	})
+	if err != nil {
+		return 0, err
+	}
+	return newBalance, nil
+}
+
+// Transfer moves amount between two clients. The sender may not go below
+// zero. Transfers the approval policy requires approval for are held
+// instead, and returned in a *server.ApprovalRequiredError.
+func (s *Store) Transfer(ctx context.Context, fromClientID, toClientID string, amount int64, idempotencyKey string) (int64, int64, error) {
+	if fromClientID == toClientID {
+		return 0, 0, server.ErrSelfTransfer
+	}
+	var fromBalance, toBalance int64
+	var held *server.PendingTransfer
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if idempotencyKey != "" {
+			var count int
+			err := tx.QueryRowContext(ctx,
+				`SELECT COUNT(*) FROM ledger_entries WHERE idempotency_key = ?`, idempotencyKey).Scan(&count)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				s.logger.DebugContext(ctx, "transfer replayed",
+					"from_client_id", fromClientID, "to_client_id", toClientID, "idempotency_key", idempotencyKey)
+				from, err := lockClient(ctx, tx, fromClientID)
+				if err != nil {
+					return err
+				}
+				to, err := lockClient(ctx, tx, toClientID)
+				fromBalance, toBalance = from.Amount, to.Amount
+				return err
+			}
+			// A transfer held for approval is replayed as it stands until
+			// it is approved, after which its debit carries the key
+			t, err := pendingTransferByKey(ctx, tx, idempotencyKey)
+			if err == nil {
+				return server.ReplayPendingTransfer(t)
+			}
+			if !errors.Is(err, server.ErrTransferNotFound) {
+				return err
+			}
+		}
+
+		if s.approvals.Requires(amount) {
+			t, err := s.requestApproval(ctx, tx, fromClientID, toClientID, amount, idempotencyKey)
+			if err != nil {
+				return err
+			}
+			held = &t
+			return nil
+		}
+		var err error
+		fromBalance, toBalance, err = s.transfer(ctx, tx, fromClientID, toClientID, amount, idempotencyKey)
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if held != nil {
+		return 0, 0, &server.ApprovalRequiredError{Transfer: *held}
+	}
+	return fromBalance, toBalance, nil
+}
+
+// transfer moves amount and charges the sender's fee inside tx. It
+// returns both new balances.
+func (s *Store) transfer(ctx context.Context, tx *sql.Tx, fromClientID, toClientID string, amount int64, idempotencyKey string) (int64, int64, error) {
+	from, err := lockClient(ctx, tx, fromClientID)
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := lockClient(ctx, tx, toClientID)
+	if err != nil {
+		return 0, 0, err
+	}
+	// The amount is in the sender's currency
+	sent := money.New(amount, from.Currency)
+	newFrom, err := from.Sub(sent)
+	if err != nil {
+		return 0, 0, err
+	}
+	newTo, err := to.Add(sent)
+	if err != nil {
+		return 0, 0, err
+	}
+	// The sender pays the fee on top of the amount
+	fee, err := quoteFee(ctx, tx, fromClientID, server.FeeTransfer, amount, from.Currency)
+	if err != nil {
+		return 0, 0, err
+	}
+	if newFrom, err = newFrom.Sub(money.New(fee.Fee, from.Currency)); err != nil {
+		return 0, 0, err
+	}
+	if newFrom.IsNegative() {
+		s.logger.InfoContext(ctx, "transfer rejected for insufficient balance",
+			"from_client_id", fromClientID, "balance", from.Amount, "amount", amount, "fee", fee.Fee)
+		return 0, 0, server.ErrInsufficientBalance
+	}
+	if err := s.checkVelocity(ctx, tx, "transfer", fromClientID, amount); err != nil {
+		return 0, 0, err
+	}
+
+	for _, update := range []struct {
+		id      string
+		balance int64
+	}{{fromClientID, newFrom.Amount}, {toClientID, newTo.Amount}} {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE clients SET balance = ? WHERE client_id = ?`, update.balance, update.id); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	now := s.now()
+	entryID, err := s.insertEntry(ctx, tx, fromClientID, -amount, idempotencyKey, now)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO ledger_entries (entry_id, client_id, amount, created_at) VALUES (?, ?, ?, ?)`,
+		uuid.NewString(), toClientID, amount, formatTime(now))
+	if err != nil {
+		return 0, 0, err
+	}
+	if fee.Fee > 0 {
+		if err := s.postFee(ctx, tx, fee, entryID, s.now()); err != nil {
+			return 0, 0, err
+		}
+	}
+	return newFrom.Amount, newTo.Amount, nil
+}
+
+// lockClient reads a client's balance inside a write transaction. The
+// transaction already holds the write lock, so nothing can change the
+// row before it ends.
+func lockClient(ctx context.Context, tx *sql.Tx, clientID string) (money.Money, error) {
+	var balance money.Money
+	err := tx.QueryRowContext(ctx,
+		`SELECT balance, currency FROM clients WHERE client_id = ?`, clientID).Scan(&balance.Amount, &balance.Currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return money.Money{}, server.ErrClientNotFound
+	}
+	return balance, err
+}
+
+// insertEntry writes a ledger entry carrying idempotencyKey and returns
+// its ID
+func (s *Store) insertEntry(ctx context.Context, tx *sql.Tx, clientID string, amount int64, idempotencyKey string, at time.Time) (string, error) {
+	id := uuid.NewString()
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO ledger_entries (entry_id, client_id, amount, created_at, idempotency_key)
+		VALUES (?, ?, ?, ?, ?)`, id, clientID, amount, formatTime(at), idempotencyKey)
+	return id, err
+}
@@ -0,0 +1,213 @@
+package sqlitestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+	"github.com/koki1610168/go-payment-ledger/internal/storetest"
+)
+
+func newTestStore(t *testing.T) (context.Context, *Store) {
+	t.Helper()
+	ctx := context.Background()
+	s, err := Open(ctx, filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	s.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return ctx, s
+}
+
+func newClient(t *testing.T, ctx context.Context, s *Store, balance int64) string {
+	t.Helper()
+	id := "sqlite_" + uuid.NewString()[:12]
+	if _, err := s.CreateClient(ctx, id, "JPY"); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if balance != 0 {
+		if _, err := s.CreatePayment(ctx, id, balance, uuid.NewString()); err != nil {
+			t.Fatalf("fund client: %v", err)
+		}
+	}
+	return id
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Store {
+		_, s := newTestStore(t)
+		return s
+	})
+}
+
+func TestConformance_Approvals(t *testing.T) {
+	storetest.RunApprovals(t, func(t *testing.T, policy server.ApprovalPolicy) storetest.Store {
+		_, s := newTestStore(t)
+		return s.WithApprovals(policy)
+	})
+}
+
+func TestMigrate_Idempotent(t *testing.T) {
+	ctx, s := newTestStore(t)
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatalf("second migrate: %v", err)
+	}
+	pending, err := s.PendingMigrations(ctx)
+	if err != nil {
+		t.Fatalf("pending migrations: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("got pending migrations %v after migrating", pending)
+	}
+}
+
+func TestPendingMigrations_FreshDatabase(t *testing.T) {
+	ctx := context.Background()
+	s, err := Open(ctx, filepath.Join(t.TempDir(), "fresh.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer s.Close()
+
+	pending, err := s.PendingMigrations(ctx)
+	if err != nil {
+		t.Fatalf("pending migrations: %v", err)
+	}
+	if len(pending) == 0 || pending[0] != "0001_init" {
+		t.Errorf("got %v, want every migration starting with 0001_init", pending)
+	}
+}
+
+// The SQLite schema must keep step with the PostgreSQL one, so a database
+// can be described by its migration versions whichever backend it uses
+func TestMigrations_MatchPostgres(t *testing.T) {
+	names := func(dir string) []string {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("read %s: %v", dir, err)
+		}
+		var out []string
+		for _, e := range entries {
+			if strings.HasSuffix(e.Name(), ".sql") {
+				out = append(out, e.Name())
+			}
+		}
+		return out
+	}
+
+	got, want := names("migrations"), names("../server/migrations")
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got migrations %v, want %v", got, want)
+	}
+}
+
+func TestGetLedgerPage(t *testing.T) {
+	ctx, s := newTestStore(t)
+	clientID := newClient(t, ctx, s, 0)
+	for i := 0; i < 5; i++ {
+		if _, err := s.CreatePayment(ctx, clientID, int64(i+1), uuid.NewString()); err != nil {
+			t.Fatalf("payment %d: %v", i, err)
+		}
+	}
+
+	var got []int64
+	var cursor *server.LedgerCursor
+	for {
+		entries, more, err := s.GetLedgerPage(ctx, clientID, cursor, 2)
+		if err != nil {
+			t.Fatalf("get ledger page: %v", err)
+		}
+		for _, e := range entries {
+			got = append(got, e.Amount)
+		}
+		if !more {
+			break
+		}
+		last := entries[len(entries)-1]
+		cursor = &server.LedgerCursor{CreatedAt: last.CreatedAt, EntryID: last.EntryId}
+	}
+
+	if fmt.Sprint(got) != "[1 2 3 4 5]" {
+		t.Errorf("got %v across pages, want [1 2 3 4 5]", got)
+	}
+}
+
+func TestVelocityLimits(t *testing.T) {
+	ctx, s := newTestStore(t)
+	from := newClient(t, ctx, s, 100000)
+	to := newClient(t, ctx, s, 0)
+
+	amountLimit, err := s.CreateVelocityLimit(ctx, server.VelocityLimit{
+		ClientID: from, Kind: server.VelocityAmount, Max: 1000, WindowSeconds: 86400,
+	})
+	if err != nil {
+		t.Fatalf("create amount limit: %v", err)
+	}
+	if _, err := s.CreateVelocityLimit(ctx, server.VelocityLimit{
+		ClientID: from, Kind: server.VelocityCount, Max: 3, WindowSeconds: 3600,
+	}); err != nil {
+		t.Fatalf("create count limit: %v", err)
+	}
+	if limits, err := s.ListVelocityLimits(ctx, from); err != nil || len(limits) != 2 {
+		t.Fatalf("got %d limits, %v; want 2", len(limits), err)
+	}
+
+	transfer := func(amount int64) error {
+		_, _, err := s.Transfer(ctx, from, to, amount, uuid.NewString())
+		return err
+	}
+
+	if err := transfer(600); err != nil {
+		t.Fatalf("first transfer: %v", err)
+	}
+	var limitErr *server.VelocityLimitError
+	if err := transfer(500); !errors.As(err, &limitErr) || limitErr.Limit.Kind != server.VelocityAmount || limitErr.Used != 600 {
+		t.Fatalf("got %v, want the amount limit with 600 used", err)
+	}
+	if _, err := s.CreatePayment(ctx, from, -400, uuid.NewString()); err != nil {
+		t.Fatalf("withdrawal: %v", err)
+	}
+
+	if err := s.DeleteVelocityLimit(ctx, from, amountLimit.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := s.DeleteVelocityLimit(ctx, from, amountLimit.ID); !errors.Is(err, server.ErrVelocityLimitNotFound) {
+		t.Errorf("second delete: got %v, want %v", err, server.ErrVelocityLimitNotFound)
+	}
+	if err := transfer(10); err != nil {
+		t.Fatalf("third debit: %v", err)
+	}
+	if err := transfer(10); !errors.As(err, &limitErr) || limitErr.Limit.Kind != server.VelocityCount {
+		t.Fatalf("got %v, want the count limit", err)
+	}
+
+	if got, _, _ := s.GetBalance(ctx, from); got != 100000-600-400-10 {
+		t.Errorf("rejected debits changed the balance: got %d", got)
+	}
+}
+
+func TestVelocityLimitsUnknownClient(t *testing.T) {
+	ctx, s := newTestStore(t)
+	_, err := s.CreateVelocityLimit(ctx, server.VelocityLimit{
+		ClientID: "no_such_client", Kind: server.VelocityCount, Max: 1, WindowSeconds: 60,
+	})
+	if !errors.Is(err, server.ErrClientNotFound) {
+		t.Errorf("create: got %v, want %v", err, server.ErrClientNotFound)
+	}
+	if _, err := s.ListVelocityLimits(ctx, "no_such_client"); !errors.Is(err, server.ErrClientNotFound) {
+		t.Errorf("list: got %v, want %v", err, server.ErrClientNotFound)
+	}
+}
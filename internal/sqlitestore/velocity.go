@@ -0,0 +1,140 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+func (s *Store) ListVelocityLimits(ctx context.Context, clientID string) ([]server.VelocityLimit, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM clients WHERE client_id = ?)`, clientID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, server.ErrClientNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT limit_id, client_id, kind, max_value, window_seconds, created_at
+		FROM velocity_limits WHERE client_id = ? ORDER BY created_at, limit_id`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	limits := []server.VelocityLimit{}
+	for rows.Next() {
+		l, err := scanLimit(rows)
+		if err != nil {
+			return nil, err
+		}
+		limits = append(limits, l)
+	}
+	return limits, rows.Err()
+}
+
+func (s *Store) CreateVelocityLimit(ctx context.Context, limit server.VelocityLimit) (server.VelocityLimit, error) {
+	if err := limit.Validate(); err != nil {
+		return server.VelocityLimit{}, err
+	}
+
+	limit.ID, limit.CreatedAt = uuid.NewString(), s.now()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO velocity_limits (limit_id, client_id, kind, max_value, window_seconds, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		limit.ID, limit.ClientID, limit.Kind, limit.Max, limit.WindowSeconds, formatTime(limit.CreatedAt))
+	if isConstraint(err, "FOREIGN KEY") {
+		return server.VelocityLimit{}, server.ErrClientNotFound
+	}
+	if err != nil {
+		return server.VelocityLimit{}, err
+	}
+	return limit, nil
+}
+
+func (s *Store) DeleteVelocityLimit(ctx context.Context, clientID string, limitID string) error {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM velocity_limits WHERE client_id = ? AND limit_id = ?`, clientID, limitID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return server.ErrVelocityLimitNotFound
+	}
+	return nil
+}
+
+// checkVelocity fails with a *server.VelocityLimitError if debiting
+// amount from clientID would exceed one of its limits. Fees, interest and
+// approval holds count towards no limit. It must run in a write transaction, so
+// concurrent debits are checked one after another.
+func (s *Store) checkVelocity(ctx context.Context, tx *sql.Tx, operation string, clientID string, amount int64) error {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT limit_id, client_id, kind, max_value, window_seconds, created_at
+		FROM velocity_limits WHERE client_id = ? ORDER BY created_at, limit_id`, clientID)
+	if err != nil {
+		return err
+	}
+	var limits []server.VelocityLimit
+	for rows.Next() {
+		l, err := scanLimit(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		limits = append(limits, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := s.now()
+	for _, l := range limits {
+		var volume, debits int64
+		err := tx.QueryRowContext(ctx,
+			`SELECT COALESCE(SUM(-amount), 0), COUNT(*)
+			FROM ledger_entries e
+			WHERE client_id = ? AND amount < 0 AND created_at > ?
+				AND NOT EXISTS (SELECT 1 FROM fee_charges f WHERE f.debit_entry_id = e.entry_id)
+				AND NOT EXISTS (SELECT 1 FROM interest_postings p WHERE p.entry_id = e.entry_id)
+				AND NOT EXISTS (SELECT 1 FROM pending_transfers t WHERE t.hold_entry_id = e.entry_id)`,
+			clientID, formatTime(now.Add(-l.Window()))).Scan(&volume, &debits)
+		if err != nil {
+			return err
+		}
+
+		used, requested := volume, amount
+		if l.Kind == server.VelocityCount {
+			used, requested = debits, 1
+		}
+		if used+requested > l.Max {
+			s.logger.InfoContext(ctx, "debit rejected by velocity limit",
+				"operation", operation, "client_id", clientID, "amount", amount,
+				"limit_id", l.ID, "kind", l.Kind, "max", l.Max, "used", used)
+			return &server.VelocityLimitError{Limit: l, Used: used}
+		}
+	}
+	return nil
+}
+
+func scanLimit(rows *sql.Rows) (server.VelocityLimit, error) {
+	var l server.VelocityLimit
+	var createdAt string
+	if err := rows.Scan(&l.ID, &l.ClientID, &l.Kind, &l.Max, &l.WindowSeconds, &createdAt); err != nil {
+		return server.VelocityLimit{}, err
+	}
+	var err error
+	l.CreatedAt, err = parseTime(createdAt)
+	return l, err
+}
@@ -0,0 +1,1388 @@
+// Package storetest is a conformance suite for ledger stores. Every store
+// must pass it, so the handler can rely on the same behavior whichever
+// store it is given:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) storetest.Store { return memstore.New() })
+//	}
+//
+// Each test creates its own clients under random IDs, so a store may be
+// shared between tests and may already hold other data.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/money"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// Store is what the suite needs: the ledger, and CreateClient to set up
+// its clients
+type Store interface {
+	server.ClientStore
+	server.AdminStore
+}
+
+// Run runs the suite as subtests of t. newStore is called once per test.
+func Run(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s *suite)
+	}{
+		{"GetBalance", testGetBalance},
+		{"CreatePayment", testCreatePayment},
+		{"CreatePaymentInsufficientBalance", testCreatePaymentInsufficientBalance},
+		{"CreatePaymentIdempotent", testCreatePaymentIdempotent},
+		{"Transfer", testTransfer},
+		{"TransferIdempotent", testTransferIdempotent},
+		{"TransferInsufficientBalance", testTransferInsufficientBalance},
+		{"TransferUnknownClient", testTransferUnknownClient},
+		{"TransferToSelf", testTransferToSelf},
+		{"TransferCurrencyMismatch", testTransferCurrencyMismatch},
+		{"BalanceOverflow", testBalanceOverflow},
+		{"ConcurrentTransfers", testConcurrentTransfers},
+		{"CreateClient", testCreateClient},
+		{"PostAdjustment", testPostAdjustment},
+		{"Reconcile", testReconcile},
+		{"FeeSchedules", testFeeSchedules},
+		{"TransferFee", testTransferFee},
+		{"PaymentFeeGroup", testPaymentFeeGroup},
+		{"InterestRates", testInterestRates},
+		{"InterestAccrual", testInterestAccrual},
+		{"SplitTransfer", testSplitTransfer},
+		{"SplitTransferManyToMany", testSplitTransferManyToMany},
+		{"SplitTransferIdempotent", testSplitTransferIdempotent},
+		{"SplitTransferInsufficientBalance", testSplitTransferInsufficientBalance},
+		{"SplitTransferInvalid", testSplitTransferInvalid},
+		{"SplitTransferFee", testSplitTransferFee},
+		{"ConcurrentSplitTransfers", testConcurrentSplitTransfers},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			t.Cleanup(cancel)
+			tt.fn(t, &suite{ctx: ctx, store: newStore(t)})
+		})
+	}
+}
+
+type suite struct {
+	ctx   context.Context
+	store Store
+}
+
+// client creates a client with a unique ID, funded with balance
+func (s *suite) client(t *testing.T, balance int64) string {
+	t.Helper()
+	id := "storetest_" + uuid.NewString()[:13]
+	if _, err := s.store.CreateClient(s.ctx, id, "JPY"); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if balance != 0 {
+		if _, err := s.store.CreatePayment(s.ctx, id, balance, key()); err != nil {
+			t.Fatalf("fund client: %v", err)
+		}
+	}
+	return id
+}
+
+func (s *suite) balance(t *testing.T, clientID string) int64 {
+	t.Helper()
+	b, _, err := s.store.GetBalance(s.ctx, clientID)
+	if err != nil {
+		t.Fatalf("get balance of %s: %v", clientID, err)
+	}
+	return b
+}
+
+// ledger returns the client's entries and the sum of their amounts.
+// Every entry must be in the client's currency.
+func (s *suite) ledger(t *testing.T, clientID string) ([]server.Ledger, int64) {
+	t.Helper()
+	entries, err := s.store.GetLedger(s.ctx, clientID)
+	if err != nil {
+		t.Fatalf("get ledger of %s: %v", clientID, err)
+	}
+	_, currency, err := s.store.GetBalance(s.ctx, clientID)
+	if err != nil {
+		t.Fatalf("get balance of %s: %v", clientID, err)
+	}
+	var sum int64
+	for _, e := range entries {
+		if e.ClientId != clientID {
+			t.Errorf("ledger of %s has an entry of %s", clientID, e.ClientId)
+		}
+		if e.Currency != currency {
+			t.Errorf("ledger of %s has an entry in %q, want %s", clientID, e.Currency, currency)
+		}
+		sum += e.Amount
+	}
+	return entries, sum
+}
+
+// assertLedger checks that the client has n entries summing to its balance
+func (s *suite) assertLedger(t *testing.T, clientID string, n int) {
+	t.Helper()
+	entries, sum := s.ledger(t, clientID)
+	if len(entries) != n {
+		t.Errorf("%s has %d ledger entries, want %d", clientID, len(entries), n)
+	}
+	if b := s.balance(t, clientID); sum != b {
+		t.Errorf("%s: ledger sums to %d, balance is %d", clientID, sum, b)
+	}
+}
+
+func key() string {
+	return "storetest-" + uuid.NewString()
+}
+
+func testGetBalance(t *testing.T, s *suite) {
+	id := s.client(t, 1000)
+	b, currency, err := s.store.GetBalance(s.ctx, id)
+	if err != nil || b != 1000 || currency != "JPY" {
+		t.Errorf("got %d %q, %v; want 1000 JPY", b, currency, err)
+	}
+
+	_, _, err = s.store.GetBalance(s.ctx, "storetest_missing_"+uuid.NewString()[:8])
+	if !errors.Is(err, server.ErrClientNotFound) {
+		t.Errorf("unknown client: got %v, want ErrClientNotFound", err)
+	}
+}
+
+func testCreatePayment(t *testing.T, s *suite) {
+	id := s.client(t, 0)
+
+	for _, tt := range []struct{ amount, want int64 }{{500, 500}, {-200, 300}, {-300, 0}} {
+		b, err := s.store.CreatePayment(s.ctx, id, tt.amount, key())
+		if err != nil || b != tt.want {
+			t.Fatalf("payment of %d: got %d, %v; want %d", tt.amount, b, err, tt.want)
+		}
+	}
+	s.assertLedger(t, id, 3)
+
+	entries, _ := s.ledger(t, id)
+	for _, e := range entries {
+		if !e.IdempotencyKey.Valid || e.IdempotencyKey.String == "" {
+			t.Errorf("payment entry %v has no idempotency key", e.EntryId)
+		}
+		if e.CreatedAt.IsZero() || e.EntryId == uuid.Nil {
+			t.Errorf("entry is missing its ID or time: %+v", e)
+		}
+	}
+
+	_, err := s.store.CreatePayment(s.ctx, "storetest_missing_"+uuid.NewString()[:8], 100, key())
+	if !errors.Is(err, server.ErrClientNotFound) {
+		t.Errorf("unknown client: got %v, want ErrClientNotFound", err)
+	}
+}
+
+func testCreatePaymentInsufficientBalance(t *testing.T, s *suite) {
+	id := s.client(t, 100)
+	_, err := s.store.CreatePayment(s.ctx, id, -101, key())
+	if !errors.Is(err, server.ErrInsufficientBalance) {
+		t.Fatalf("got %v, want ErrInsufficientBalance", err)
+	}
+	if b := s.balance(t, id); b != 100 {
+		t.Errorf("balance is %d after a rejected payment, want 100", b)
+	}
+	s.assertLedger(t, id, 1)
+}
+
+func testCreatePaymentIdempotent(t *testing.T, s *suite) {
+	id := s.client(t, 0)
+	k := key()
+	for range 3 {
+		b, err := s.store.CreatePayment(s.ctx, id, 250, k)
+		if err != nil || b != 250 {
+			t.Fatalf("got %d, %v; want 250", b, err)
+		}
+	}
+	s.assertLedger(t, id, 1)
+}
+
+func testTransfer(t *testing.T, s *suite) {
+	from, to := s.client(t, 1000), s.client(t, 50)
+	k := key()
+
+	fromBalance, toBalance, err := s.store.Transfer(s.ctx, from, to, 300, k)
+	if err != nil || fromBalance != 700 || toBalance != 350 {
+		t.Fatalf("got %d/%d, %v; want 700/350", fromBalance, toBalance, err)
+	}
+	s.assertLedger(t, from, 2)
+	s.assertLedger(t, to, 2)
+
+	// The debit carries the key; the credit does not, so the key stays
+	// unique to the sender's side
+	fromEntries, _ := s.ledger(t, from)
+	debit := fromEntries[len(fromEntries)-1]
+	for _, e := range fromEntries {
+		if e.Amount == -300 {
+			debit = e
+		}
+	}
+	if debit.Amount != -300 || debit.IdempotencyKey.String != k {
+		t.Errorf("debit entry: got %+v", debit)
+	}
+	toEntries, _ := s.ledger(t, to)
+	for _, e := range toEntries {
+		if e.Amount == 300 && e.IdempotencyKey.Valid {
+			t.Errorf("credit entry carries a key: %+v", e)
+		}
+	}
+}
+
+func testTransferIdempotent(t *testing.T, s *suite) {
+	from, to := s.client(t, 1000), s.client(t, 0)
+	k := key()
+	for range 3 {
+		fromBalance, toBalance, err := s.store.Transfer(s.ctx, from, to, 100, k)
+		if err != nil || fromBalance != 900 || toBalance != 100 {
+			t.Fatalf("got %d/%d, %v; want 900/100", fromBalance, toBalance, err)
+		}
+	}
+	s.assertLedger(t, from, 2)
+	s.assertLedger(t, to, 1)
+}
+
+func testTransferInsufficientBalance(t *testing.T, s *suite) {
+	from, to := s.client(t, 100), s.client(t, 0)
+
+	_, _, err := s.store.Transfer(s.ctx, from, to, 101, key())
+	if !errors.Is(err, server.ErrInsufficientBalance) {
+		t.Fatalf("got %v, want ErrInsufficientBalance", err)
+	}
+	if b := s.balance(t, from); b != 100 {
+		t.Errorf("sender balance is %d after a rejected transfer, want 100", b)
+	}
+	s.assertLedger(t, from, 1)
+	s.assertLedger(t, to, 0)
+
+	// The whole balance may be sent
+	fromBalance, toBalance, err := s.store.Transfer(s.ctx, from, to, 100, key())
+	if err != nil || fromBalance != 0 || toBalance != 100 {
+		t.Fatalf("got %d/%d, %v; want 0/100", fromBalance, toBalance, err)
+	}
+}
+
+func testTransferToSelf(t *testing.T, s *suite) {
+	id := s.client(t, 1000)
+
+	if _, _, err := s.store.Transfer(s.ctx, id, id, 100, key()); !errors.Is(err, server.ErrSelfTransfer) {
+		t.Fatalf("got %v, want ErrSelfTransfer", err)
+	}
+	if b := s.balance(t, id); b != 1000 {
+		t.Errorf("balance is %d after a self-transfer, want 1000", b)
+	}
+	s.assertLedger(t, id, 1)
+}
+
+func testTransferCurrencyMismatch(t *testing.T, s *suite) {
+	from := s.client(t, 1000)
+	to := "storetest_" + uuid.NewString()[:13]
+	if _, err := s.store.CreateClient(s.ctx, to, "USD"); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	if _, _, err := s.store.Transfer(s.ctx, from, to, 100, key()); !errors.Is(err, money.ErrCurrencyMismatch) {
+		t.Fatalf("got %v, want ErrCurrencyMismatch", err)
+	}
+	if b := s.balance(t, from); b != 1000 {
+		t.Errorf("sender balance is %d after a rejected transfer, want 1000", b)
+	}
+	s.assertLedger(t, to, 0)
+}
+
+// Balances near the int64 limit are rejected instead of wrapping around
+func testBalanceOverflow(t *testing.T, s *suite) {
+	from, to := s.client(t, math.MaxInt64), s.client(t, 1)
+
+	if _, err := s.store.CreatePayment(s.ctx, from, 1, key()); !errors.Is(err, money.ErrOverflow) {
+		t.Errorf("payment: got %v, want ErrOverflow", err)
+	}
+	if _, _, err := s.store.Transfer(s.ctx, from, to, math.MaxInt64, key()); !errors.Is(err, money.ErrOverflow) {
+		t.Errorf("transfer: got %v, want ErrOverflow", err)
+	}
+	adj := server.Adjustment{ClientID: from, Amount: 1, Reason: "overflow", IdempotencyKey: key()}
+	if _, err := s.store.PostAdjustment(s.ctx, adj); !errors.Is(err, money.ErrOverflow) {
+		t.Errorf("adjustment: got %v, want ErrOverflow", err)
+	}
+	if b := s.balance(t, from); b != math.MaxInt64 {
+		t.Errorf("balance is %d, want %d", b, int64(math.MaxInt64))
+	}
+	s.assertLedger(t, from, 1)
+	s.assertLedger(t, to, 1)
+}
+
+func testTransferUnknownClient(t *testing.T, s *suite) {
+	id := s.client(t, 1000)
+	missing := "storetest_missing_" + uuid.NewString()[:8]
+
+	if _, _, err := s.store.Transfer(s.ctx, id, missing, 100, key()); !errors.Is(err, server.ErrClientNotFound) {
+		t.Errorf("unknown recipient: got %v, want ErrClientNotFound", err)
+	}
+	if _, _, err := s.store.Transfer(s.ctx, missing, id, 100, key()); !errors.Is(err, server.ErrClientNotFound) {
+		t.Errorf("unknown sender: got %v, want ErrClientNotFound", err)
+	}
+	if b := s.balance(t, id); b != 1000 {
+		t.Errorf("balance is %d after failed transfers, want 1000", b)
+	}
+	s.assertLedger(t, id, 1)
+}
+
+// testConcurrentTransfers moves money back and forth between two clients
+// from many goroutines. Nothing may be lost or created.
+func testConcurrentTransfers(t *testing.T, s *suite) {
+	a, b := s.client(t, 10_000), s.client(t, 10_000)
+	const workers, transfers = 8, 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*transfers)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			from, to := a, b
+			if w%2 == 1 {
+				from, to = b, a
+			}
+			for i := range transfers {
+				if _, _, err := s.store.Transfer(s.ctx, from, to, int64(i+1), key()); err != nil {
+					errs <- fmt.Errorf("worker %d: %w", w, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// Half the workers send each way with the same amounts
+	if got := s.balance(t, a) + s.balance(t, b); got != 20_000 {
+		t.Errorf("balances sum to %d, want 20000", got)
+	}
+	s.assertLedger(t, a, 1+workers*transfers)
+	s.assertLedger(t, b, 1+workers*transfers)
+}
+
+func testCreateClient(t *testing.T, s *suite) {
+	id := "storetest_" + uuid.NewString()[:13]
+	c, err := s.store.CreateClient(s.ctx, id, "EUR")
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if c.ID != id || c.Currency != "EUR" || c.Balance != 0 || c.CreatedAt.IsZero() {
+		t.Errorf("got %+v", c)
+	}
+	if _, err := s.store.CreateClient(s.ctx, id, "EUR"); !errors.Is(err, server.ErrClientExists) {
+		t.Errorf("second create: got %v, want ErrClientExists", err)
+	}
+	if b, currency, err := s.store.GetBalance(s.ctx, id); err != nil || b != 0 || currency != "EUR" {
+		t.Errorf("new client: got %d %q, %v", b, currency, err)
+	}
+}
+
+func testPostAdjustment(t *testing.T, s *suite) {
+	id := s.client(t, 100)
+	adj := server.Adjustment{ClientID: id, Amount: -40, Reason: "duplicate capture", Actor: "storetest", IdempotencyKey: key()}
+
+	posted, err := s.store.PostAdjustment(s.ctx, adj)
+	if err != nil {
+		t.Fatalf("adjust: %v", err)
+	}
+	if posted.Balance != 60 || posted.ID == "" || posted.EntryID == "" || posted.Actor != "storetest" {
+		t.Errorf("got %+v", posted)
+	}
+
+	replay, err := s.store.PostAdjustment(s.ctx, adj)
+	if err != nil || replay.ID != posted.ID || replay.Balance != 60 {
+		t.Errorf("replay: got %+v, %v", replay, err)
+	}
+	s.assertLedger(t, id, 2)
+
+	tests := []struct {
+		name string
+		adj  server.Adjustment
+		want error
+	}{
+		{"key reused", server.Adjustment{ClientID: id, Amount: 1, Reason: "other", IdempotencyKey: adj.IdempotencyKey}, server.ErrIdempotencyConflict},
+		{"overdraw", server.Adjustment{ClientID: id, Amount: -61, Reason: "x", IdempotencyKey: key()}, server.ErrInsufficientBalance},
+		{"unknown client", server.Adjustment{ClientID: "storetest_missing", Amount: 1, Reason: "x", IdempotencyKey: key()}, server.ErrClientNotFound},
+	}
+	for _, tt := range tests {
+		if _, err := s.store.PostAdjustment(s.ctx, tt.adj); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	paymentKey := key()
+	if _, err := s.store.CreatePayment(s.ctx, id, 10, paymentKey); err != nil {
+		t.Fatalf("payment: %v", err)
+	}
+	_, err = s.store.PostAdjustment(s.ctx, server.Adjustment{ClientID: id, Amount: 10, Reason: "x", IdempotencyKey: paymentKey})
+	if !errors.Is(err, server.ErrIdempotencyConflict) {
+		t.Errorf("payment key: got %v, want ErrIdempotencyConflict", err)
+	}
+
+	if _, err := s.store.PostAdjustment(s.ctx, server.Adjustment{ClientID: id, Amount: 5, IdempotencyKey: key()}); err == nil {
+		t.Error("adjustment without a reason was posted")
+	}
+	s.assertLedger(t, id, 3)
+}
+
+func testReconcile(t *testing.T, s *suite) {
+	from, to := s.client(t, 500), s.client(t, 0)
+	if _, _, err := s.store.Transfer(s.ctx, from, to, 200, key()); err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+
+	report, err := s.store.Reconcile(s.ctx)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if report.ClientsChecked < 2 || report.CheckedAt.IsZero() {
+		t.Errorf("got %+v", report)
+	}
+	for _, d := range report.Discrepancies {
+		if d.ClientID == from || d.ClientID == to {
+			t.Errorf("unexpected discrepancy %+v", d)
+		}
+	}
+}
+
+// fees returns the store as a server.FeeStore, skipping the test if it
+// has no fee engine
+func (s *suite) fees(t *testing.T) server.FeeStore {
+	t.Helper()
+	fs, ok := s.store.(server.FeeStore)
+	if !ok {
+		t.Skip("store does not implement server.FeeStore")
+	}
+	return fs
+}
+
+// schedule creates a fee schedule, failing the test if it cannot
+func (s *suite) schedule(t *testing.T, f server.FeeSchedule) server.FeeSchedule {
+	t.Helper()
+	created, err := s.fees(t).CreateFeeSchedule(s.ctx, f)
+	if err != nil {
+		t.Fatalf("create fee schedule: %v", err)
+	}
+	return created
+}
+
+func testFeeSchedules(t *testing.T, s *suite) {
+	fs := s.fees(t)
+	id := s.client(t, 0)
+	f := s.schedule(t, server.FeeSchedule{ClientID: id, Operation: server.FeeTransfer, Kind: server.FeeFixed, Fixed: 5})
+	if f.ID == "" || f.CreatedAt.IsZero() {
+		t.Errorf("created schedule has no id or time: %+v", f)
+	}
+
+	dup := server.FeeSchedule{ClientID: id, Operation: server.FeeTransfer, Kind: server.FeeFixed, Fixed: 7}
+	if _, err := fs.CreateFeeSchedule(s.ctx, dup); !errors.Is(err, server.ErrFeeScheduleExists) {
+		t.Errorf("duplicate: got %v, want ErrFeeScheduleExists", err)
+	}
+	missing := server.FeeSchedule{ClientID: "storetest_missing", Operation: server.FeeTransfer, Kind: server.FeeFixed, Fixed: 7}
+	if _, err := fs.CreateFeeSchedule(s.ctx, missing); !errors.Is(err, server.ErrClientNotFound) {
+		t.Errorf("unknown client: got %v, want ErrClientNotFound", err)
+	}
+	var invalid server.ValidationError
+	if _, err := fs.CreateFeeSchedule(s.ctx, server.FeeSchedule{ClientID: id, Operation: server.FeePayment}); !errors.As(err, &invalid) {
+		t.Errorf("invalid schedule: got %v, want a ValidationError", err)
+	}
+
+	schedules, err := fs.ListFeeSchedules(s.ctx)
+	if err != nil {
+		t.Fatalf("list fee schedules: %v", err)
+	}
+	found := false
+	for _, got := range schedules {
+		if got.ID == f.ID {
+			found = true
+			if got.ClientID != id || got.Kind != server.FeeFixed || got.Fixed != 5 {
+				t.Errorf("listed %+v, want %+v", got, f)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("schedule %s is not listed", f.ID)
+	}
+
+	if err := fs.DeleteFeeSchedule(s.ctx, f.ID); err != nil {
+		t.Fatalf("delete fee schedule: %v", err)
+	}
+	if err := fs.DeleteFeeSchedule(s.ctx, f.ID); !errors.Is(err, server.ErrFeeScheduleNotFound) {
+		t.Errorf("second delete: got %v, want ErrFeeScheduleNotFound", err)
+	}
+	if err := fs.SetFeeGroup(s.ctx, "storetest_missing", "gold"); !errors.Is(err, server.ErrClientNotFound) {
+		t.Errorf("set group of unknown client: got %v, want ErrClientNotFound", err)
+	}
+	if _, err := fs.QuoteFee(s.ctx, "storetest_missing", server.FeePayment, 100); !errors.Is(err, server.ErrClientNotFound) {
+		t.Errorf("quote for unknown client: got %v, want ErrClientNotFound", err)
+	}
+}
+
+// A transfer fee is debited from the sender on top of the amount and
+// credited to the fee revenue account in the same transaction
+func testTransferFee(t *testing.T, s *suite) {
+	fs := s.fees(t)
+	from, to := s.client(t, 2000), s.client(t, 0)
+	revenue := server.FeeRevenueAccount("JPY")
+	s.schedule(t, server.FeeSchedule{ClientID: from, Operation: server.FeeTransfer, Kind: server.FeePercentage,
+		BasisPoints: 250, Fixed: 10, Max: 40})
+
+	q, err := fs.QuoteFee(s.ctx, from, server.FeeTransfer, 1000)
+	if err != nil {
+		t.Fatalf("quote: %v", err)
+	}
+	if q.Fee != 35 || q.Currency != "JPY" || q.ScheduleID == "" {
+		t.Errorf("quote is %+v, want a fee of 35 JPY", q)
+	}
+
+	// The revenue account is created by the first fee charged
+	before, _, err := s.store.GetBalance(s.ctx, revenue)
+	if err != nil && !errors.Is(err, server.ErrClientNotFound) {
+		t.Fatalf("get fee revenue: %v", err)
+	}
+	k := key()
+	for range 2 {
+		fromBalance, toBalance, err := s.store.Transfer(s.ctx, from, to, 1000, k)
+		if err != nil {
+			t.Fatalf("transfer: %v", err)
+		}
+		if fromBalance != 965 || toBalance != 1000 {
+			t.Errorf("balances are %d and %d, want 965 and 1000", fromBalance, toBalance)
+		}
+	}
+	if got := s.balance(t, revenue) - before; got != 35 {
+		t.Errorf("fee revenue grew by %d, want 35", got)
+	}
+	s.assertLedger(t, from, 3)
+	s.assertLedger(t, to, 1)
+	if _, sum := s.ledger(t, revenue); sum != s.balance(t, revenue) {
+		t.Errorf("fee revenue ledger sums to %d, balance is %d", sum, s.balance(t, revenue))
+	}
+
+	// 940 plus its fee of 34 is more than the 965 left
+	if _, _, err := s.store.Transfer(s.ctx, from, to, 940, key()); !errors.Is(err, server.ErrInsufficientBalance) {
+		t.Fatalf("got %v, want ErrInsufficientBalance", err)
+	}
+	if b := s.balance(t, from); b != 965 {
+		t.Errorf("sender balance is %d after a rejected transfer, want 965", b)
+	}
+	s.assertLedger(t, from, 3)
+
+	report, err := s.store.Reconcile(s.ctx)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	for _, d := range report.Discrepancies {
+		if d.ClientID == from || d.ClientID == to || d.ClientID == revenue {
+			t.Errorf("unexpected discrepancy %+v", d)
+		}
+	}
+}
+
+// Clients of a fee group pay its schedule unless they have their own
+func testPaymentFeeGroup(t *testing.T, s *suite) {
+	fs := s.fees(t)
+	group := "storetest-" + uuid.NewString()[:8]
+	member, own, outsider, empty := s.client(t, 0), s.client(t, 0), s.client(t, 0), s.client(t, 0)
+	for _, id := range []string{member, own, empty} {
+		if err := fs.SetFeeGroup(s.ctx, id, group); err != nil {
+			t.Fatalf("set fee group: %v", err)
+		}
+	}
+	s.schedule(t, server.FeeSchedule{Group: group, Operation: server.FeePayment, Kind: server.FeeFixed, Fixed: 100})
+	s.schedule(t, server.FeeSchedule{ClientID: own, Operation: server.FeePayment, Kind: server.FeeTiered,
+		Tiers: []server.FeeTier{{UpTo: 1000, Fixed: 5}, {BasisPoints: 100}}})
+
+	payments := []struct {
+		clientID string
+		amount   int64
+		want     int64
+	}{
+		{member, 5000, 4900},
+		{own, 500, 495},
+		{own, 2000, 2475},
+		{outsider, 5000, 5000},
+	}
+	for _, p := range payments {
+		got, err := s.store.CreatePayment(s.ctx, p.clientID, p.amount, key())
+		if err != nil {
+			t.Fatalf("payment of %d: %v", p.amount, err)
+		}
+		if got != p.want {
+			t.Errorf("payment of %d: balance is %d, want %d", p.amount, got, p.want)
+		}
+	}
+	s.assertLedger(t, member, 2)
+	s.assertLedger(t, own, 4)
+	s.assertLedger(t, outsider, 1)
+
+	// The fee of a payment may not overdraw the account it credits
+	if _, err := s.store.CreatePayment(s.ctx, empty, 50, key()); !errors.Is(err, server.ErrInsufficientBalance) {
+		t.Errorf("got %v, want ErrInsufficientBalance", err)
+	}
+	s.assertLedger(t, empty, 0)
+
+	if err := fs.SetFeeGroup(s.ctx, member, ""); err != nil {
+		t.Fatalf("clear fee group: %v", err)
+	}
+	if got, err := s.store.CreatePayment(s.ctx, member, 100, key()); err != nil || got != 5000 {
+		t.Errorf("payment after leaving the group: got %d, %v, want 5000", got, err)
+	}
+}
+
+// interest returns the store as a server.InterestStore, skipping the
+// test if it does not accrue interest
+func (s *suite) interest(t *testing.T) server.InterestStore {
+	t.Helper()
+	is, ok := s.store.(server.InterestStore)
+	if !ok {
+		t.Skip("store does not implement server.InterestStore")
+	}
+	return is
+}
+
+func testInterestRates(t *testing.T, s *suite) {
+	is := s.interest(t)
+	id := s.client(t, 0)
+	if _, err := is.GetInterestRate(s.ctx, id); !errors.Is(err, server.ErrInterestRateNotFound) {
+		t.Errorf("before set: got %v, want ErrInterestRateNotFound", err)
+	}
+	if _, err := is.GetInterestRate(s.ctx, "storetest_missing"); !errors.Is(err, server.ErrClientNotFound) {
+		t.Errorf("unknown client: got %v, want ErrClientNotFound", err)
+	}
+
+	set, err := is.SetInterestRate(s.ctx, server.InterestRate{ClientID: id, CreditBasisPoints: 150, DayCount: server.DayCountActual365})
+	if err != nil {
+		t.Fatalf("set interest rate: %v", err)
+	}
+	if set.CreatedAt.IsZero() || set.UpdatedAt.IsZero() {
+		t.Errorf("set rate has no times: %+v", set)
+	}
+	changed, err := is.SetInterestRate(s.ctx, server.InterestRate{ClientID: id, CreditBasisPoints: 200,
+		OverdraftBasisPoints: 1800, DayCount: server.DayCount30360})
+	if err != nil {
+		t.Fatalf("change interest rate: %v", err)
+	}
+	if !changed.CreatedAt.Equal(set.CreatedAt) || changed.UpdatedAt.Before(set.UpdatedAt) {
+		t.Errorf("changed rate has times %v and %v, set had %v and %v",
+			changed.CreatedAt, changed.UpdatedAt, set.CreatedAt, set.UpdatedAt)
+	}
+	got, err := is.GetInterestRate(s.ctx, id)
+	if err != nil {
+		t.Fatalf("get interest rate: %v", err)
+	}
+	if got.CreditBasisPoints != 200 || got.OverdraftBasisPoints != 1800 || got.DayCount != server.DayCount30360 {
+		t.Errorf("got %+v, want the changed rate", got)
+	}
+
+	missing := server.InterestRate{ClientID: "storetest_missing", DayCount: server.DayCountActual360}
+	if _, err := is.SetInterestRate(s.ctx, missing); !errors.Is(err, server.ErrClientNotFound) {
+		t.Errorf("set for unknown client: got %v, want ErrClientNotFound", err)
+	}
+	var invalid server.ValidationError
+	if _, err := is.SetInterestRate(s.ctx, server.InterestRate{ClientID: id, CreditBasisPoints: -1}); !errors.As(err, &invalid) {
+		t.Errorf("invalid rate: got %v, want a ValidationError", err)
+	}
+	if _, err := is.ListInterestPostings(s.ctx, "storetest_missing"); !errors.Is(err, server.ErrClientNotFound) {
+		t.Errorf("postings of unknown client: got %v, want ErrClientNotFound", err)
+	}
+}
+
+// Interest accrues daily on end-of-day balances and is capitalized after
+// each month, with what rounding leaves carried forward. Runs are
+// repeated here with an asOf in the future to play out several months.
+func testInterestAccrual(t *testing.T, s *suite) {
+	is := s.interest(t)
+	const funded = 1_234_567
+	id := s.client(t, funded)
+	rate, err := is.SetInterestRate(s.ctx, server.InterestRate{ClientID: id, CreditBasisPoints: 475, DayCount: server.DayCountActual365})
+	if err != nil {
+		t.Fatalf("set interest rate: %v", err)
+	}
+
+	// What the runs should post, month by month
+	start := server.InterestDay(rate.CreatedAt)
+	asOf := start.AddDate(0, 2, 12)
+	var want []server.InterestPosting
+	balance, accrued, carried := int64(funded), int64(0), int64(0)
+	for day := start; day.Before(asOf); day = day.AddDate(0, 0, 1) {
+		a, err := rate.Accrue(day, balance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accrued += a
+		if day.AddDate(0, 0, 1).Day() != 1 {
+			continue
+		}
+		p, err := server.NewInterestPosting(id, "JPY", server.InterestPeriod(day), carried, accrued)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, p)
+		balance, accrued, carried = balance+p.Amount, 0, p.Residual
+	}
+
+	// The first run stops a month short; the second picks up after it
+	// and the third finds nothing left to do
+	for i, until := range []time.Time{asOf.AddDate(0, -1, 0), asOf, asOf} {
+		run, err := is.RunInterest(s.ctx, until)
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		for _, failed := range run.Failed {
+			if failed == id {
+				t.Errorf("run %d failed for %s", i, id)
+			}
+		}
+		if i == 2 {
+			for _, p := range run.Postings {
+				if p.ClientID == id {
+					t.Errorf("rerun posted %+v", p)
+				}
+			}
+		}
+	}
+
+	postings, err := is.ListInterestPostings(s.ctx, id)
+	if err != nil {
+		t.Fatalf("list interest postings: %v", err)
+	}
+	if len(postings) != len(want) {
+		t.Fatalf("got %d postings, want %d: %+v", len(postings), len(want), postings)
+	}
+	entries := 1
+	for i, p := range postings {
+		w := want[i]
+		if p.Period != w.Period || p.Accrued != w.Accrued || p.Amount != w.Amount || p.Residual != w.Residual || p.Currency != "JPY" {
+			t.Errorf("posting %d is %+v, want %+v", i, p, w)
+		}
+		if p.Amount != 0 {
+			entries++
+			if p.EntryID == "" {
+				t.Errorf("posting %d of %d has no ledger entry", i, p.Amount)
+			}
+		}
+	}
+	if got := s.balance(t, id); got != balance {
+		t.Errorf("balance is %d, want %d", got, balance)
+	}
+	s.assertLedger(t, id, entries)
+	expense := server.InterestExpenseAccount("JPY")
+	if _, sum := s.ledger(t, expense); sum != s.balance(t, expense) {
+		t.Errorf("interest expense ledger sums to %d, balance is %d", sum, s.balance(t, expense))
+	}
+}
+
+// split returns the store as a server.SplitTransferStore, skipping the
+// test if it cannot split transfers
+func (s *suite) split(t *testing.T) server.SplitTransferStore {
+	t.Helper()
+	ss, ok := s.store.(server.SplitTransferStore)
+	if !ok {
+		t.Skip("store does not implement server.SplitTransferStore")
+	}
+	return ss
+}
+
+// One debit pays several clients, and every leg is posted under its own
+// entry
+func testSplitTransfer(t *testing.T, s *suite) {
+	ss := s.split(t)
+	buyer, seller, platform, shipper := s.client(t, 10_000), s.client(t, 0), s.client(t, 500), s.client(t, 0)
+	k := key()
+	legs := []server.TransferLeg{
+		{ClientID: buyer, Amount: -3000},
+		{ClientID: seller, Amount: 2500},
+		{ClientID: platform, Amount: 300},
+		{ClientID: shipper, Amount: 200},
+	}
+
+	balances, err := ss.SplitTransfer(s.ctx, legs, k)
+	if err != nil {
+		t.Fatalf("split transfer: %v", err)
+	}
+	if want := []int64{7000, 2500, 800, 200}; !slices.Equal(balances, want) {
+		t.Errorf("balances are %v, want %v", balances, want)
+	}
+	s.assertLedger(t, buyer, 2)
+	s.assertLedger(t, seller, 1)
+	s.assertLedger(t, platform, 2)
+	s.assertLedger(t, shipper, 1)
+
+	// As with a transfer, only the debit carries the key
+	for _, leg := range legs {
+		entries, _ := s.ledger(t, leg.ClientID)
+		for _, e := range entries {
+			if e.Amount != leg.Amount {
+				continue
+			}
+			if keyed := e.IdempotencyKey.Valid && e.IdempotencyKey.String == k; keyed != (leg.Amount < 0) {
+				t.Errorf("entry %+v of leg %+v: carries the key is %v", e, leg, keyed)
+			}
+		}
+	}
+}
+
+func testSplitTransferManyToMany(t *testing.T, s *suite) {
+	ss := s.split(t)
+	a, b, c, d := s.client(t, 1000), s.client(t, 1000), s.client(t, 0), s.client(t, 0)
+
+	balances, err := ss.SplitTransfer(s.ctx, []server.TransferLeg{
+		{ClientID: c, Amount: 900},
+		{ClientID: a, Amount: -600},
+		{ClientID: d, Amount: 100},
+		{ClientID: b, Amount: -400},
+	}, key())
+	if err != nil {
+		t.Fatalf("split transfer: %v", err)
+	}
+	if want := []int64{900, 400, 100, 600}; !slices.Equal(balances, want) {
+		t.Errorf("balances are %v, want %v in leg order", balances, want)
+	}
+	for _, id := range []string{a, b} {
+		s.assertLedger(t, id, 2)
+	}
+	for _, id := range []string{c, d} {
+		s.assertLedger(t, id, 1)
+	}
+}
+
+func testSplitTransferIdempotent(t *testing.T, s *suite) {
+	ss := s.split(t)
+	from, to1, to2 := s.client(t, 1000), s.client(t, 0), s.client(t, 0)
+	legs := []server.TransferLeg{{ClientID: from, Amount: -300}, {ClientID: to1, Amount: 100}, {ClientID: to2, Amount: 200}}
+	k := key()
+	for range 3 {
+		balances, err := ss.SplitTransfer(s.ctx, legs, k)
+		if want := []int64{700, 100, 200}; err != nil || !slices.Equal(balances, want) {
+			t.Fatalf("got %v, %v; want %v", balances, err, want)
+		}
+	}
+	s.assertLedger(t, from, 2)
+	s.assertLedger(t, to1, 1)
+	s.assertLedger(t, to2, 1)
+
+	// A key spent by a transfer is replayed too
+	tk := key()
+	if _, _, err := s.store.Transfer(s.ctx, from, to1, 50, tk); err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+	if _, err := ss.SplitTransfer(s.ctx, legs, tk); err != nil {
+		t.Fatalf("split transfer under a spent key: %v", err)
+	}
+	s.assertLedger(t, from, 3)
+}
+
+// A leg that cannot be posted fails the whole transfer
+func testSplitTransferInsufficientBalance(t *testing.T, s *suite) {
+	ss := s.split(t)
+	rich, poor, to := s.client(t, 1000), s.client(t, 100), s.client(t, 0)
+
+	_, err := ss.SplitTransfer(s.ctx, []server.TransferLeg{
+		{ClientID: rich, Amount: -500},
+		{ClientID: poor, Amount: -101},
+		{ClientID: to, Amount: 601},
+	}, key())
+	if !errors.Is(err, server.ErrInsufficientBalance) {
+		t.Fatalf("got %v, want ErrInsufficientBalance", err)
+	}
+	if s.balance(t, rich) != 1000 || s.balance(t, poor) != 100 || s.balance(t, to) != 0 {
+		t.Errorf("balances are %d/%d/%d after a rejected transfer, want 1000/100/0",
+			s.balance(t, rich), s.balance(t, poor), s.balance(t, to))
+	}
+	s.assertLedger(t, rich, 1)
+	s.assertLedger(t, poor, 1)
+	s.assertLedger(t, to, 0)
+}
+
+func testSplitTransferInvalid(t *testing.T, s *suite) {
+	ss := s.split(t)
+	from, to := s.client(t, 1000), s.client(t, 0)
+	usd := "storetest_" + uuid.NewString()[:13]
+	if _, err := s.store.CreateClient(s.ctx, usd, "USD"); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	missing := "storetest_missing_" + uuid.NewString()[:8]
+
+	var invalid server.ValidationError
+	tests := []struct {
+		name string
+		legs []server.TransferLeg
+		is   func(error) bool
+	}{
+		{"unbalanced", []server.TransferLeg{{ClientID: from, Amount: -100}, {ClientID: to, Amount: 99}},
+			func(err error) bool { return errors.As(err, &invalid) }},
+		{"repeated client", []server.TransferLeg{{ClientID: from, Amount: -100}, {ClientID: from, Amount: 100}},
+			func(err error) bool { return errors.As(err, &invalid) }},
+		{"unknown client", []server.TransferLeg{{ClientID: from, Amount: -100}, {ClientID: missing, Amount: 100}},
+			func(err error) bool { return errors.Is(err, server.ErrClientNotFound) }},
+		{"other currency", []server.TransferLeg{{ClientID: from, Amount: -100}, {ClientID: to, Amount: 50}, {ClientID: usd, Amount: 50}},
+			func(err error) bool { return errors.Is(err, money.ErrCurrencyMismatch) }},
+	}
+	for _, tt := range tests {
+		if _, err := ss.SplitTransfer(s.ctx, tt.legs, key()); !tt.is(err) {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+	if b := s.balance(t, from); b != 1000 {
+		t.Errorf("balance is %d after rejected transfers, want 1000", b)
+	}
+	s.assertLedger(t, from, 1)
+	s.assertLedger(t, to, 0)
+	s.assertLedger(t, usd, 0)
+}
+
+// Each debited client pays its own transfer fee on what it sends
+func testSplitTransferFee(t *testing.T, s *suite) {
+	s.fees(t)
+	ss := s.split(t)
+	a, b, to := s.client(t, 2000), s.client(t, 2000), s.client(t, 0)
+	revenue := server.FeeRevenueAccount("JPY")
+	s.schedule(t, server.FeeSchedule{ClientID: a, Operation: server.FeeTransfer, Kind: server.FeeFixed, Fixed: 20})
+
+	before, _, err := s.store.GetBalance(s.ctx, revenue)
+	if err != nil && !errors.Is(err, server.ErrClientNotFound) {
+		t.Fatalf("get fee revenue: %v", err)
+	}
+	balances, err := ss.SplitTransfer(s.ctx, []server.TransferLeg{
+		{ClientID: a, Amount: -1000},
+		{ClientID: b, Amount: -500},
+		{ClientID: to, Amount: 1500},
+	}, key())
+	if want := []int64{980, 1500, 1500}; err != nil || !slices.Equal(balances, want) {
+		t.Fatalf("got %v, %v; want %v", balances, err, want)
+	}
+	if got := s.balance(t, revenue) - before; got != 20 {
+		t.Errorf("fee revenue grew by %d, want 20", got)
+	}
+	s.assertLedger(t, a, 3)
+	s.assertLedger(t, b, 2)
+	s.assertLedger(t, to, 1)
+
+	// 970 plus its fee of 20 is more than the 980 left
+	_, err = ss.SplitTransfer(s.ctx, []server.TransferLeg{{ClientID: a, Amount: -970}, {ClientID: to, Amount: 970}}, key())
+	if !errors.Is(err, server.ErrInsufficientBalance) {
+		t.Fatalf("got %v, want ErrInsufficientBalance", err)
+	}
+	s.assertLedger(t, a, 3)
+}
+
+// testConcurrentSplitTransfers runs split transfers over the same clients
+// listed in different orders. They must neither deadlock nor lose money.
+func testConcurrentSplitTransfers(t *testing.T, s *suite) {
+	ss := s.split(t)
+	a, b, c := s.client(t, 10_000), s.client(t, 10_000), s.client(t, 10_000)
+	const workers, transfers = 6, 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*transfers)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids := []string{a, b, c}
+			// Each worker debits a different client and lists the others
+			// in turn
+			from, to1, to2 := ids[w%3], ids[(w+1)%3], ids[(w+2)%3]
+			if w%2 == 1 {
+				to1, to2 = to2, to1
+			}
+			for i := range transfers {
+				legs := []server.TransferLeg{
+					{ClientID: to1, Amount: int64(i + 1)},
+					{ClientID: from, Amount: -2 * int64(i+1)},
+					{ClientID: to2, Amount: int64(i + 1)},
+				}
+				if _, err := ss.SplitTransfer(s.ctx, legs, key()); err != nil {
+					errs <- fmt.Errorf("worker %d: %w", w, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := s.balance(t, a) + s.balance(t, b) + s.balance(t, c); got != 30_000 {
+		t.Errorf("balances sum to %d, want 30000", got)
+	}
+	// Each client is in every transfer
+	for _, id := range []string{a, b, c} {
+		s.assertLedger(t, id, 1+workers*transfers)
+	}
+}
+
+// RunApprovals runs the maker-checker tests as subtests of t, skipping
+// them for stores that do not implement server.ApprovalStore. newStore is
+// called once per test with the policy the test needs.
+func RunApprovals(t *testing.T, newStore func(t *testing.T, policy server.ApprovalPolicy) Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s *approvalSuite)
+	}{
+		{"BelowThreshold", testApprovalBelowThreshold},
+		{"Approve", testApprovalApprove},
+		{"Reject", testApprovalReject},
+		{"WithoutHold", testApprovalWithoutHold},
+		{"Expiry", testApprovalExpiry},
+		{"UnknownTransfer", testApprovalUnknownTransfer},
+		{"SplitTransfer", testApprovalSplitTransfer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			t.Cleanup(cancel)
+			newSuite := func(policy server.ApprovalPolicy) *suite {
+				return &suite{ctx: ctx, store: newStore(t, policy)}
+			}
+			tt.fn(t, &approvalSuite{ctx: ctx, newSuite: newSuite})
+		})
+	}
+}
+
+// approvalThreshold is the policy threshold of every approval test
+const approvalThreshold = 1000
+
+type approvalSuite struct {
+	ctx      context.Context
+	newSuite func(policy server.ApprovalPolicy) *suite
+}
+
+// store returns a suite whose store holds transfers above
+// approvalThreshold, with the hold and TTL given
+func (a *approvalSuite) store(t *testing.T, hold bool, ttl time.Duration) (*suite, server.ApprovalStore) {
+	t.Helper()
+	s := a.newSuite(server.ApprovalPolicy{Threshold: approvalThreshold, Hold: hold, TTL: ttl})
+	as, ok := s.store.(server.ApprovalStore)
+	if !ok {
+		t.Skip("store does not implement server.ApprovalStore")
+	}
+	return s, as
+}
+
+// as returns the suite's context acting as principal id
+func (s *suite) as(id string) context.Context {
+	return server.WithPrincipal(s.ctx, &server.Principal{ID: id, Admin: true})
+}
+
+// requestTransfer makes a transfer that needs approval as the maker,
+// failing the test unless it is held
+func (s *suite) requestTransfer(t *testing.T, from, to string, amount int64, k string) server.PendingTransfer {
+	t.Helper()
+	_, _, err := s.store.Transfer(s.as("storetest-maker"), from, to, amount, k)
+	var approvalErr *server.ApprovalRequiredError
+	if !errors.As(err, &approvalErr) || !errors.Is(err, server.ErrApprovalRequired) {
+		t.Fatalf("transfer of %d: got %v, want an *ApprovalRequiredError", amount, err)
+	}
+	return approvalErr.Transfer
+}
+
+// actions lists the actions of a transfer's audit trail
+func actions(events []server.TransferEvent) []string {
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Action)
+	}
+	return got
+}
+
+func testApprovalBelowThreshold(t *testing.T, a *approvalSuite) {
+	s, _ := a.store(t, true, time.Hour)
+	from, to := s.client(t, 5000), s.client(t, 0)
+
+	fromBalance, toBalance, err := s.store.Transfer(s.as("storetest-maker"), from, to, approvalThreshold, key())
+	if err != nil || fromBalance != 4000 || toBalance != approvalThreshold {
+		t.Fatalf("got %d/%d, %v; want 4000/1000", fromBalance, toBalance, err)
+	}
+}
+
+func testApprovalApprove(t *testing.T, a *approvalSuite) {
+	s, as := a.store(t, true, time.Hour)
+	from, to := s.client(t, 10000), s.client(t, 0)
+	k := key()
+
+	pending := s.requestTransfer(t, from, to, 6000, k)
+	if pending.ID == "" || len(pending.Events) != 1 || pending.Status != server.TransferPending || !pending.Held ||
+		pending.RequestedBy != "storetest-maker" || pending.Amount != 6000 || pending.Currency != "JPY" ||
+		pending.IdempotencyKey != k || !pending.ExpiresAt.After(pending.CreatedAt) {
+		t.Errorf("got pending transfer %+v", pending)
+	}
+	// The amount is held, so it cannot be spent meanwhile
+	if b := s.balance(t, from); b != 4000 {
+		t.Errorf("sender balance is %d while held, want 4000", b)
+	}
+	if _, _, err := s.store.Transfer(s.ctx, from, to, 4001, key()); !errors.Is(err, server.ErrInsufficientBalance) {
+		t.Errorf("holding the held funds again: got %v, want ErrInsufficientBalance", err)
+	}
+	if _, _, err := s.store.Transfer(s.ctx, from, to, 500, key()); err != nil {
+		t.Fatalf("transfer of the rest: %v", err)
+	}
+
+	// Replays return the same transfer without holding the amount again
+	replayed := s.requestTransfer(t, from, to, 6000, k)
+	if replayed.ID != pending.ID || !slices.Equal(actions(replayed.Events), []string{server.TransferRequested}) {
+		t.Errorf("replay returned transfer %s with events %v, want %s as requested", replayed.ID, actions(replayed.Events), pending.ID)
+	}
+	if b := s.balance(t, from); b != 3500 {
+		t.Errorf("sender balance is %d after the replay, want 3500", b)
+	}
+
+	listed, err := as.ListPendingTransfers(s.ctx, server.TransferPending)
+	if err != nil {
+		t.Fatalf("list pending transfers: %v", err)
+	}
+	found := false
+	for _, l := range listed {
+		found = found || l.ID == pending.ID
+		if l.Status != server.TransferPending {
+			t.Errorf("listed %s transfer %s", l.Status, l.ID)
+		}
+	}
+	if !found {
+		t.Errorf("pending transfer %s is not listed", pending.ID)
+	}
+
+	if _, err := as.ApproveTransfer(s.as("storetest-maker"), pending.ID, ""); !errors.Is(err, server.ErrSelfApproval) {
+		t.Errorf("approved by its maker: got %v, want ErrSelfApproval", err)
+	}
+	if _, err := as.ApproveTransfer(s.ctx, pending.ID, ""); !errors.Is(err, server.ErrApproverRequired) {
+		t.Errorf("approved without a principal: got %v, want ErrApproverRequired", err)
+	}
+
+	approved, err := as.ApproveTransfer(s.as("storetest-checker"), pending.ID, "invoice 42")
+	if err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	if approved.Status != server.TransferApproved || approved.FromNewBalance != 3500 || approved.ToNewBalance != 6500 {
+		t.Errorf("got %+v, want approved with balances 3500/6500", approved)
+	}
+	if s.balance(t, from) != 3500 || s.balance(t, to) != 6500 {
+		t.Errorf("balances are %d/%d, want 3500/6500", s.balance(t, from), s.balance(t, to))
+	}
+
+	got, err := as.GetPendingTransfer(s.ctx, pending.ID)
+	if err != nil {
+		t.Fatalf("get pending transfer: %v", err)
+	}
+	want := []string{server.TransferRequested, server.TransferApproved}
+	if got.Status != server.TransferApproved || !slices.Equal(actions(got.Events), want) {
+		t.Fatalf("got %s with events %v, want approved with %v", got.Status, actions(got.Events), want)
+	}
+	if e := got.Events[1]; e.Actor != "storetest-checker" || e.Reason != "invoice 42" {
+		t.Errorf("approval event is %+v", e)
+	}
+	if e := got.Events[0]; e.Actor != "storetest-maker" {
+		t.Errorf("request event is %+v", e)
+	}
+
+	// Once approved, the key replays like any executed transfer
+	fromBalance, toBalance, err := s.store.Transfer(s.as("storetest-maker"), from, to, 6000, k)
+	if err != nil || fromBalance != 3500 || toBalance != 6500 {
+		t.Errorf("replay after approval: got %d/%d, %v; want 3500/6500", fromBalance, toBalance, err)
+	}
+	if _, err := as.ApproveTransfer(s.as("storetest-checker"), pending.ID, ""); !errors.Is(err, server.ErrTransferNotPending) {
+		t.Errorf("second approval: got %v, want ErrTransferNotPending", err)
+	}
+	if _, err := as.RejectTransfer(s.as("storetest-checker"), pending.ID, "late"); !errors.Is(err, server.ErrTransferNotPending) {
+		t.Errorf("rejection after approval: got %v, want ErrTransferNotPending", err)
+	}
+
+	// Funding, the hold and its release, the rest and the approved debit
+	s.assertLedger(t, from, 5)
+	s.assertLedger(t, to, 2)
+	hold := server.ApprovalHoldAccount("JPY")
+	if _, sum := s.ledger(t, hold); sum != s.balance(t, hold) {
+		t.Errorf("approval hold ledger sums to %d, balance is %d", sum, s.balance(t, hold))
+	}
+}
+
+func testApprovalReject(t *testing.T, a *approvalSuite) {
+	s, as := a.store(t, true, time.Hour)
+	from, to := s.client(t, 10000), s.client(t, 0)
+	k := key()
+
+	if _, _, err := s.store.Transfer(s.ctx, from, to, 10001, key()); !errors.Is(err, server.ErrInsufficientBalance) {
+		t.Errorf("holding more than the balance: got %v, want ErrInsufficientBalance", err)
+	}
+
+	pending := s.requestTransfer(t, from, to, 7000, k)
+	if _, err := as.RejectTransfer(s.as("storetest-maker"), pending.ID, "mine"); !errors.Is(err, server.ErrSelfApproval) {
+		t.Errorf("rejected by its maker: got %v, want ErrSelfApproval", err)
+	}
+	if _, err := as.RejectTransfer(s.ctx, pending.ID, "anonymous"); !errors.Is(err, server.ErrApproverRequired) {
+		t.Errorf("rejected without a principal: got %v, want ErrApproverRequired", err)
+	}
+	rejected, err := as.RejectTransfer(s.as("storetest-checker"), pending.ID, "unknown payee")
+	if err != nil {
+		t.Fatalf("reject: %v", err)
+	}
+	want := []string{server.TransferRequested, server.TransferRejected}
+	if rejected.Status != server.TransferRejected || !slices.Equal(actions(rejected.Events), want) {
+		t.Errorf("got %s with events %v, want rejected with %v", rejected.Status, actions(rejected.Events), want)
+	}
+	if s.balance(t, from) != 10000 || s.balance(t, to) != 0 {
+		t.Errorf("balances are %d/%d after rejection, want 10000/0", s.balance(t, from), s.balance(t, to))
+	}
+
+	// Its key cannot request the transfer again
+	if _, _, err := s.store.Transfer(s.as("storetest-maker"), from, to, 7000, k); !errors.Is(err, server.ErrTransferRejected) {
+		t.Errorf("replay after rejection: got %v, want ErrTransferRejected", err)
+	}
+	if b := s.balance(t, from); b != 10000 {
+		t.Errorf("sender balance is %d after the replay, want 10000", b)
+	}
+	if _, err := as.ApproveTransfer(s.as("storetest-checker"), pending.ID, ""); !errors.Is(err, server.ErrTransferNotPending) {
+		t.Errorf("approval after rejection: got %v, want ErrTransferNotPending", err)
+	}
+	s.assertLedger(t, from, 3)
+	s.assertLedger(t, to, 0)
+}
+
+func testApprovalWithoutHold(t *testing.T, a *approvalSuite) {
+	s, as := a.store(t, false, time.Hour)
+	from, to := s.client(t, 5500), s.client(t, 0)
+
+	pending := s.requestTransfer(t, from, to, 5000, key())
+	if pending.Held {
+		t.Errorf("got a held transfer without holds: %+v", pending)
+	}
+	if b := s.balance(t, from); b != 5500 {
+		t.Errorf("sender balance is %d while pending, want 5500", b)
+	}
+	// Nothing is held, so the funds can be spent meanwhile and the
+	// approval fails until they are back
+	if _, _, err := s.store.Transfer(s.ctx, from, to, 1000, key()); err != nil {
+		t.Fatalf("transfer below the threshold: %v", err)
+	}
+	if _, err := as.ApproveTransfer(s.as("storetest-checker"), pending.ID, ""); !errors.Is(err, server.ErrInsufficientBalance) {
+		t.Errorf("approve without funds: got %v, want ErrInsufficientBalance", err)
+	}
+	if got, err := as.GetPendingTransfer(s.ctx, pending.ID); err != nil || got.Status != server.TransferPending {
+		t.Errorf("after a failed approval: got %s, %v; want it still pending", got.Status, err)
+	}
+
+	if _, err := s.store.CreatePayment(s.ctx, from, 500, key()); err != nil {
+		t.Fatalf("payment: %v", err)
+	}
+	approved, err := as.ApproveTransfer(s.as("storetest-checker"), pending.ID, "")
+	if err != nil || approved.FromNewBalance != 0 || approved.ToNewBalance != 6000 {
+		t.Fatalf("approve: got %+v, %v; want balances 0/6000", approved, err)
+	}
+	s.assertLedger(t, from, 4)
+	s.assertLedger(t, to, 2)
+}
+
+func testApprovalExpiry(t *testing.T, a *approvalSuite) {
+	s, as := a.store(t, true, time.Millisecond)
+	from, to := s.client(t, 10000), s.client(t, 0)
+
+	// A transfer approved too late is expired instead
+	lateKey := key()
+	late := s.requestTransfer(t, from, to, 2000, lateKey)
+	time.Sleep(10 * time.Millisecond)
+	if _, err := as.ApproveTransfer(s.as("storetest-checker"), late.ID, ""); !errors.Is(err, server.ErrTransferExpired) {
+		t.Fatalf("late approval: got %v, want ErrTransferExpired", err)
+	}
+	got, err := as.GetPendingTransfer(s.ctx, late.ID)
+	want := []string{server.TransferRequested, server.TransferExpired}
+	if err != nil || got.Status != server.TransferExpired || !slices.Equal(actions(got.Events), want) {
+		t.Fatalf("got %s with events %v, %v; want expired with %v", got.Status, actions(got.Events), err, want)
+	}
+	if e := got.Events[1]; e.Actor != server.SystemActor {
+		t.Errorf("expiry event is %+v, want it by %s", e, server.SystemActor)
+	}
+
+	// The others are expired by the sweep
+	sweptKey := key()
+	swept := s.requestTransfer(t, from, to, 3000, sweptKey)
+	if b := s.balance(t, from); b != 7000 {
+		t.Errorf("sender balance is %d while held, want 7000", b)
+	}
+	n, err := as.ExpireTransfers(s.ctx, swept.CreatedAt.Add(-time.Hour))
+	if err != nil || n != 0 {
+		t.Errorf("sweep before the expiry: got %d, %v; want nothing expired", n, err)
+	}
+	n, err = as.ExpireTransfers(s.ctx, swept.ExpiresAt)
+	if err != nil || n < 1 {
+		t.Fatalf("sweep: got %d, %v; want the transfer expired", n, err)
+	}
+	if got, err := as.GetPendingTransfer(s.ctx, swept.ID); err != nil || got.Status != server.TransferExpired {
+		t.Errorf("after the sweep: got %s, %v; want expired", got.Status, err)
+	}
+	if s.balance(t, from) != 10000 || s.balance(t, to) != 0 {
+		t.Errorf("balances are %d/%d after expiry, want 10000/0", s.balance(t, from), s.balance(t, to))
+	}
+	if _, err := as.RejectTransfer(s.as("storetest-checker"), swept.ID, "too late"); !errors.Is(err, server.ErrTransferNotPending) {
+		t.Errorf("rejection after expiry: got %v, want ErrTransferNotPending", err)
+	}
+	// Neither key can request its transfer again
+	for k, amount := range map[string]int64{lateKey: late.Amount, sweptKey: swept.Amount} {
+		if _, _, err := s.store.Transfer(s.as("storetest-maker"), from, to, amount, k); !errors.Is(err, server.ErrTransferExpired) {
+			t.Errorf("replay of %d after expiry: got %v, want ErrTransferExpired", amount, err)
+		}
+	}
+	// Funding, and a hold and its release for each transfer
+	s.assertLedger(t, from, 5)
+}
+
+func testApprovalUnknownTransfer(t *testing.T, a *approvalSuite) {
+	s, as := a.store(t, true, time.Hour)
+	for _, id := range []string{uuid.NewString(), "not-a-uuid"} {
+		if _, err := as.GetPendingTransfer(s.ctx, id); !errors.Is(err, server.ErrTransferNotFound) {
+			t.Errorf("get %s: got %v, want ErrTransferNotFound", id, err)
+		}
+		if _, err := as.ApproveTransfer(s.as("storetest-checker"), id, ""); !errors.Is(err, server.ErrTransferNotFound) {
+			t.Errorf("approve %s: got %v, want ErrTransferNotFound", id, err)
+		}
+		if _, err := as.RejectTransfer(s.as("storetest-checker"), id, "no"); !errors.Is(err, server.ErrTransferNotFound) {
+			t.Errorf("reject %s: got %v, want ErrTransferNotFound", id, err)
+		}
+	}
+}
+
+// A split transfer cannot be held, so one above the threshold is refused
+func testApprovalSplitTransfer(t *testing.T, a *approvalSuite) {
+	s, _ := a.store(t, true, time.Hour)
+	ss := s.split(t)
+	from, to1, to2 := s.client(t, 5000), s.client(t, 0), s.client(t, 0)
+
+	above := []server.TransferLeg{{ClientID: from, Amount: -2000}, {ClientID: to1, Amount: 1000}, {ClientID: to2, Amount: 1000}}
+	if _, err := ss.SplitTransfer(s.as("storetest-maker"), above, key()); !errors.Is(err, server.ErrApprovalRequired) {
+		t.Fatalf("split above the threshold: got %v, want ErrApprovalRequired", err)
+	}
+	s.assertLedger(t, from, 1)
+	s.assertLedger(t, to1, 0)
+
+	// Nor may it take the key of a transfer awaiting approval
+	k := key()
+	s.requestTransfer(t, from, to1, 2000, k)
+	below := []server.TransferLeg{{ClientID: from, Amount: -approvalThreshold}, {ClientID: to1, Amount: 600}, {ClientID: to2, Amount: 400}}
+	if _, err := ss.SplitTransfer(s.as("storetest-maker"), below, k); !errors.Is(err, server.ErrIdempotencyConflict) {
+		t.Errorf("split under a pending key: got %v, want ErrIdempotencyConflict", err)
+	}
+
+	balances, err := ss.SplitTransfer(s.as("storetest-maker"), below, key())
+	if want := []int64{2000, 600, 400}; err != nil || !slices.Equal(balances, want) {
+		t.Fatalf("split at the threshold: got %v, %v; want %v", balances, err, want)
+	}
+}
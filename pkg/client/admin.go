@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The calls in this file need an API key with the admin role.
+
+// Account is a ledger client as created by CreateClient
+type Account struct {
+	ID        string
+	Currency  string
+	Balance   int64
+	CreatedAt time.Time
+}
+
+// AdjustmentRequest corrects a client's balance by Amount. Reason is
+// required and kept with the ledger entry. A random IdempotencyKey is
+// used when it is empty.
+type AdjustmentRequest struct {
+	ClientID       string
+	Amount         int64
+	Reason         string
+	IdempotencyKey string
+}
+
+type Adjustment struct {
+	ID             string
+	ClientID       string
+	Amount         int64
+	Reason         string
+	Actor          string
+	IdempotencyKey string
+	EntryID        string
+	// Balance is the client's balance right after the adjustment
+	Balance   int64
+	CreatedAt time.Time
+}
+
+// Discrepancy is a client whose balance differs from the sum of its
+// ledger entries
+type Discrepancy struct {
+	ClientID   string
+	Balance    int64
+	LedgerSum  int64
+	Difference int64
+}
+
+type ReconciliationReport struct {
+	CheckedAt      time.Time
+	ClientsChecked int
+	Discrepancies  []Discrepancy
+}
+
+// CreateClient opens an account with a zero balance. It fails with
+// ErrConflict when the client already exists.
+func (c *Client) CreateClient(ctx context.Context, clientID, currency string) (Account, error) {
+	body := createClientJSON{ClientID: clientID, Currency: currency}
+	var res accountJSON
+	if err := c.do(ctx, http.MethodPost, "/clients", body, &res); err != nil {
+		return Account{}, err
+	}
+	return Account(res), nil
+}
+
+// PostAdjustment applies a manual correction. It fails with ErrConflict
+// when the idempotency key was already used for a different request.
+func (c *Client) PostAdjustment(ctx context.Context, req AdjustmentRequest) (Adjustment, error) {
+	if strings.TrimSpace(req.Reason) == "" {
+		return Adjustment{}, errors.New("adjustment reason is required")
+	}
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = uuid.NewString()
+	}
+	body := adjustmentJSON{Amount: req.Amount, Reason: req.Reason, IdempotencyKey: req.IdempotencyKey}
+	var res adjustmentResultJSON
+	path := "/clients/" + url.PathEscape(req.ClientID) + "/adjustments"
+	if err := c.do(ctx, http.MethodPost, path, body, &res); err != nil {
+		return Adjustment{}, err
+	}
+	return Adjustment(res), nil
+}
+
+// Reconcile compares every client's balance with its ledger. Running it
+// changes nothing, so it is safe to retry.
+func (c *Client) Reconcile(ctx context.Context) (ReconciliationReport, error) {
+	var res reconciliationJSON
+	if err := c.do(ctx, http.MethodPost, "/reconciliation", nil, &res); err != nil {
+		return ReconciliationReport{}, err
+	}
+	report := ReconciliationReport{
+		CheckedAt:      res.CheckedAt,
+		ClientsChecked: res.ClientsChecked,
+		Discrepancies:  make([]Discrepancy, len(res.Discrepancies)),
+	}
+	for i, d := range res.Discrepancies {
+		report.Discrepancies[i] = Discrepancy(d)
+	}
+	return report, nil
+}
+
+type createClientJSON struct {
+	ClientID string `json:"client_id"`
+	Currency string `json:"currency"`
+}
+
+type accountJSON struct {
+	ID        string    `json:"client_id"`
+	Currency  string    `json:"currency"`
+	Balance   int64     `json:"balance"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type adjustmentJSON struct {
+	Amount         int64  `json:"amount"`
+	Reason         string `json:"reason"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+type adjustmentResultJSON struct {
+	ID             string    `json:"id"`
+	ClientID       string    `json:"client_id"`
+	Amount         int64     `json:"amount"`
+	Reason         string    `json:"reason"`
+	Actor          string    `json:"actor"`
+	IdempotencyKey string    `json:"idempotency_key"`
+	EntryID        string    `json:"entry_id"`
+	Balance        int64     `json:"balance"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type reconciliationJSON struct {
+	CheckedAt      time.Time `json:"checked_at"`
+	ClientsChecked int       `json:"clients_checked"`
+	Discrepancies  []struct {
+		ClientID   string `json:"client_id"`
+		Balance    int64  `json:"balance"`
+		LedgerSum  int64  `json:"ledger_sum"`
+		Difference int64  `json:"difference"`
+	} `json:"discrepancies"`
+}
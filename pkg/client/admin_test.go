@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/config"
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// adminFakeStore adds server.AdminStore to fakeStore
+type adminFakeStore struct {
+	*fakeStore
+	adjustments map[string]server.Adjustment
+}
+
+func (s *adminFakeStore) CreateClient(ctx context.Context, clientID, currency string) (server.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.balances[clientID]; ok {
+		return server.Client{}, server.ErrClientExists
+	}
+	s.balances[clientID] = 0
+	return server.Client{ID: clientID, Currency: currency, CreatedAt: time.Now().UTC()}, nil
+}
+
+func (s *adminFakeStore) PostAdjustment(ctx context.Context, adj server.Adjustment) (server.Adjustment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.adjustments[adj.IdempotencyKey]; ok {
+		if prev.Amount != adj.Amount || prev.Reason != adj.Reason {
+			return server.Adjustment{}, server.ErrIdempotencyConflict
+		}
+		return prev, nil
+	}
+	b, ok := s.balances[adj.ClientID]
+	if !ok {
+		return server.Adjustment{}, server.ErrClientNotFound
+	}
+	s.balances[adj.ClientID] = b + adj.Amount
+	adj.ID, adj.EntryID, adj.Balance = uuid.NewString(), uuid.NewString(), b+adj.Amount
+	s.adjustments[adj.IdempotencyKey] = adj
+	return adj, nil
+}
+
+func (s *adminFakeStore) Reconcile(ctx context.Context) (server.ReconciliationReport, error) {
+	return server.ReconciliationReport{
+		CheckedAt:      time.Now().UTC(),
+		ClientsChecked: len(s.balances),
+		Discrepancies:  []server.Discrepancy{{ClientID: "client_002", Balance: 10, LedgerSum: 0, Difference: 10}},
+	}, nil
+}
+
+func TestClient_AdminOperations(t *testing.T) {
+	store := &adminFakeStore{fakeStore: newFakeStore(), adjustments: map[string]server.Adjustment{}}
+	sum := sha256.Sum256([]byte("ops-secret"))
+	keys, err := server.NewAPIKeys([]config.APIKey{
+		{Name: "ops", KeySHA256: hex.EncodeToString(sum[:]), ClientIDs: []string{server.AnyClient}, Admin: true},
+	})
+	if err != nil {
+		t.Fatalf("api keys: %v", err)
+	}
+	c, _ := newTestClient(t, store, keys.Middleware)
+	ctx := context.Background()
+
+	if _, err := c.CreateClient(ctx, "client_003", "EUR"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("without a key: got %v, want ErrUnauthorized", err)
+	}
+	c.WithAPIKey("ops-secret")
+
+	acct, err := c.CreateClient(ctx, "client_003", "EUR")
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if acct.ID != "client_003" || acct.Currency != "EUR" {
+		t.Errorf("got %+v", acct)
+	}
+	if _, err := c.CreateClient(ctx, "client_003", "EUR"); !errors.Is(err, ErrConflict) {
+		t.Errorf("existing client: got %v, want ErrConflict", err)
+	}
+
+	adj, err := c.PostAdjustment(ctx, AdjustmentRequest{ClientID: "client_001", Amount: -100, Reason: "fee refund reversal"})
+	if err != nil {
+		t.Fatalf("adjust: %v", err)
+	}
+	if adj.Balance != 900 || adj.IdempotencyKey == "" || adj.Reason != "fee refund reversal" {
+		t.Errorf("got %+v", adj)
+	}
+	_, err = c.PostAdjustment(ctx, AdjustmentRequest{ClientID: "client_001", Amount: 5, Reason: "other", IdempotencyKey: adj.IdempotencyKey})
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("reused key: got %v, want ErrConflict", err)
+	}
+	if _, err := c.PostAdjustment(ctx, AdjustmentRequest{ClientID: "client_001", Amount: 5}); err == nil {
+		t.Error("adjustment without a reason was sent")
+	}
+
+	report, err := c.Reconcile(ctx)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if len(report.Discrepancies) != 1 || report.Discrepancies[0].Difference != 10 {
+		t.Errorf("got %+v", report)
+	}
+}
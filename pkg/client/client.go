@@ -0,0 +1,502 @@
+// Package client is a typed Go client for the payment ledger HTTP API.
+//
+//	c := client.New("https://ledger.internal:8080").WithAPIKey(key)
+//	bal, err := c.GetBalance(ctx, "client_001")
+//	if errors.Is(err, client.ErrClientNotFound) { ... }
+//
+// Payments and transfers get a random idempotency key unless the caller
+// sets one, and the same key is reused when a call is retried. Calls are
+// retried on 429 after the server's Retry-After, and on network errors
+// when they are idempotent, which is every call this package makes.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Defaults for New
+const (
+	DefaultMaxRetries = 3
+	DefaultBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Second
+	// DefaultPageSize is used by GetLedger when pageSize is not positive
+	DefaultPageSize = 100
+)
+
+// apiPrefix is the API version the client speaks; every path is under it
+const apiPrefix = "/v1"
+
+// Longest error body kept in APIError.Message
+const maxErrorBody = 64 << 10
+
+type Client struct {
+	baseURL    string
+	http       *http.Client
+	apiKey     string
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	// sleep waits between attempts; tests replace it
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// New returns a client for the server at baseURL, e.g.
+// "http://localhost:8080"
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		http:       http.DefaultClient,
+		maxRetries: DefaultMaxRetries,
+		backoff:    DefaultBackoff,
+		maxBackoff: DefaultMaxBackoff,
+		sleep:      sleepContext,
+	}
+}
+
+// WithHTTPClient sets the client used for requests, e.g. one with mTLS
+// certificates or a timeout
+func (c *Client) WithHTTPClient(hc *http.Client) *Client {
+	c.http = hc
+	return c
+}
+
+// WithAPIKey sends key as a bearer token
+func (c *Client) WithAPIKey(key string) *Client {
+	c.apiKey = key
+	return c
+}
+
+// WithRetries sets how often a call is retried. Zero disables retries.
+func (c *Client) WithRetries(n int) *Client {
+	c.maxRetries = max(n, 0)
+	return c
+}
+
+// WithBackoff sets the delay before the first retry after a network
+// error, doubled on every further attempt up to maxDelay
+func (c *Client) WithBackoff(base, maxDelay time.Duration) *Client {
+	c.backoff, c.maxBackoff = base, maxDelay
+	return c
+}
+
+type Balance struct {
+	ClientID string
+	Balance  int64
+	Currency string
+}
+
+// LedgerEntry is one movement on a client's account. Credits of incoming
+// transfers have no idempotency key.
+type LedgerEntry struct {
+	ID             string
+	ClientID       string
+	Amount         int64
+	Currency       string
+	CreatedAt      time.Time
+	IdempotencyKey string
+}
+
+// LedgerPage is one page of a client's ledger, oldest entry first
+type LedgerPage struct {
+	Entries []LedgerEntry
+	// NextCursor fetches the following page. Empty on the last page.
+	NextCursor string
+}
+
+// PaymentRequest credits (positive Amount) or debits (negative Amount)
+// a client. A random IdempotencyKey is used when it is empty.
+type PaymentRequest struct {
+	ClientID       string
+	Amount         int64
+	Currency       string
+	IdempotencyKey string
+}
+
+// TransferRequest moves Amount from one client to another. A random
+// IdempotencyKey is used when it is empty.
+type TransferRequest struct {
+	FromClientID   string
+	ToClientID     string
+	Amount         int64
+	IdempotencyKey string
+}
+
+type TransferResult struct {
+	FromClientID string
+	ToClientID   string
+	Amount       int64
+	FromBalance  int64
+	ToBalance    int64
+	Currency     string
+}
+
+// TransferLeg debits its client when Amount is negative and credits it
+// when it is positive
+type TransferLeg struct {
+	ClientID string
+	Amount   int64
+}
+
+// SplitTransferRequest moves funds between the clients of Legs, which
+// must sum to zero. A random IdempotencyKey is used when it is empty.
+type SplitTransferRequest struct {
+	Legs           []TransferLeg
+	IdempotencyKey string
+}
+
+// TransferLegResult is a posted leg and its client's balance after it
+type TransferLegResult struct {
+	ClientID string
+	Amount   int64
+	Balance  int64
+}
+
+func (c *Client) GetBalance(ctx context.Context, clientID string) (Balance, error) {
+	var res balanceJSON
+	if err := c.do(ctx, http.MethodGet, "/clients/"+url.PathEscape(clientID)+"/balance", nil, &res); err != nil {
+		return Balance{}, err
+	}
+	return Balance(res), nil
+}
+
+func (c *Client) CreatePayment(ctx context.Context, req PaymentRequest) (Balance, error) {
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = uuid.NewString()
+	}
+	body := paymentJSON{
+		ClientID:       req.ClientID,
+		Amount:         req.Amount,
+		Currency:       req.Currency,
+		IdempotencyKey: req.IdempotencyKey,
+	}
+	var res balanceJSON
+	if err := c.do(ctx, http.MethodPost, "/payments", body, &res); err != nil {
+		return Balance{}, err
+	}
+	return Balance(res), nil
+}
+
+// Transfer moves the funds, or fails with a *PendingTransferError when
+// the amount is above the server's approval threshold
+func (c *Client) Transfer(ctx context.Context, req TransferRequest) (TransferResult, error) {
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = uuid.NewString()
+	}
+	body := transferJSON{
+		FromClientID:   req.FromClientID,
+		ToClientID:     req.ToClientID,
+		Amount:         req.Amount,
+		IdempotencyKey: req.IdempotencyKey,
+	}
+	var res transferResultJSON
+	if err := c.do(ctx, http.MethodPost, "/transfer", body, &res); err != nil {
+		return TransferResult{}, err
+	}
+	// Only a transfer held for approval is answered with a status
+	if res.Status != "" {
+		return TransferResult{}, &PendingTransferError{ID: res.ID, Status: res.Status, ExpiresAt: res.ExpiresAt}
+	}
+	return TransferResult{
+		FromClientID: res.FromClientID,
+		ToClientID:   res.ToClientID,
+		Amount:       res.Amount,
+		FromBalance:  res.FromNewBalance,
+		ToBalance:    res.ToNewBalance,
+		Currency:     res.Currency,
+	}, nil
+}
+
+// SplitTransfer posts every leg or none and returns each leg with its
+// client's balance after it. A split transfer is never held: one above
+// the server's approval threshold fails with ErrApprovalRequired.
+func (c *Client) SplitTransfer(ctx context.Context, req SplitTransferRequest) ([]TransferLegResult, error) {
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = uuid.NewString()
+	}
+	body := splitTransferJSON{Legs: make([]transferLegJSON, len(req.Legs)), IdempotencyKey: req.IdempotencyKey}
+	for i, leg := range req.Legs {
+		body.Legs[i] = transferLegJSON{ClientID: leg.ClientID, Amount: leg.Amount}
+	}
+	var res splitTransferResultJSON
+	if err := c.do(ctx, http.MethodPost, "/split-transfers", body, &res); err != nil {
+		return nil, err
+	}
+	legs := make([]TransferLegResult, len(res.Legs))
+	for i, leg := range res.Legs {
+		legs[i] = TransferLegResult{ClientID: leg.ClientID, Amount: leg.Amount, Balance: leg.NewBalance}
+	}
+	return legs, nil
+}
+
+// LedgerPage fetches up to limit entries after cursor. Pass an empty
+// cursor for the first page.
+func (c *Client) LedgerPage(ctx context.Context, clientID string, cursor string, limit int) (LedgerPage, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	query := url.Values{"limit": {strconv.Itoa(limit)}}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	path := "/clients/" + url.PathEscape(clientID) + "/ledger?" + query.Encode()
+
+	var res ledgerJSON
+	if err := c.do(ctx, http.MethodGet, path, nil, &res); err != nil {
+		return LedgerPage{}, err
+	}
+	page := LedgerPage{Entries: make([]LedgerEntry, len(res.Entries)), NextCursor: res.NextCursor}
+	for i, e := range res.Entries {
+		page.Entries[i] = LedgerEntry{
+			ID:             e.EntryID,
+			ClientID:       e.ClientID,
+			Amount:         e.Amount,
+			Currency:       e.Currency,
+			CreatedAt:      e.CreatedAt,
+			IdempotencyKey: e.IdempotencyKey.String,
+		}
+	}
+	return page, nil
+}
+
+// GetLedger iterates over a client's whole ledger, oldest entry first,
+// fetching pageSize entries at a time. Iteration stops after the first
+// error, which is yielded with a zero entry.
+//
+//	for entry, err := range c.GetLedger(ctx, "client_001", 0) {
+//		if err != nil { return err }
+//		...
+//	}
+func (c *Client) GetLedger(ctx context.Context, clientID string, pageSize int) iter.Seq2[LedgerEntry, error] {
+	return func(yield func(LedgerEntry, error) bool) {
+		cursor := ""
+		for {
+			page, err := c.LedgerPage(ctx, clientID, cursor, pageSize)
+			if err != nil {
+				yield(LedgerEntry{}, err)
+				return
+			}
+			for _, e := range page.Entries {
+				if !yield(e, nil) {
+					return
+				}
+			}
+			if page.NextCursor == "" {
+				return
+			}
+			cursor = page.NextCursor
+		}
+	}
+}
+
+// do sends the request, retrying as described in the package comment,
+// and decodes a 2xx JSON response into out. Every call is idempotent:
+// GETs by nature and POSTs through their idempotency key.
+func (c *Client) do(ctx context.Context, method, path string, body any, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := c.send(ctx, method, path, payload)
+		if err != nil {
+			if ctx.Err() != nil || attempt >= c.maxRetries {
+				return err
+			}
+			if err := c.sleep(ctx, c.backoffFor(attempt)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if res.StatusCode == http.StatusTooManyRequests && attempt < c.maxRetries {
+			wait, ok := retryAfter(res.Header.Get("Retry-After"), time.Now())
+			if !ok {
+				wait = c.backoffFor(attempt)
+			}
+			drain(res)
+			if err := c.sleep(ctx, wait); err != nil {
+				return err
+			}
+			continue
+		}
+
+		return decodeResponse(res, out)
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+apiPrefix+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json, application/problem+json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	return c.http.Do(req)
+}
+
+func decodeResponse(res *http.Response, out any) error {
+	defer drain(res)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+		apiErr := &APIError{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(msg))}
+		var p problem
+		if isProblem(res.Header.Get("Content-Type")) && json.Unmarshal(msg, &p) == nil {
+			apiErr.Code, apiErr.Message, apiErr.RequestID, apiErr.Fields = p.Code, p.Detail, p.RequestID, p.Errors
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+func isProblem(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/problem+json"
+}
+
+// backoffFor returns the delay before retry attempt+1: exponential, with
+// jitter so clients that failed together do not retry together
+func (c *Client) backoffFor(attempt int) time.Duration {
+	d := c.backoff << min(attempt, 30)
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an
+// HTTP date
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+func drain(res *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(res.Body, maxErrorBody))
+	res.Body.Close()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wire formats of the current API
+
+type balanceJSON struct {
+	ClientID string
+	Balance  int64
+	Currency string
+}
+
+type paymentJSON struct {
+	ClientID       string `json:"clientID"`
+	Amount         int64  `json:"amount"`
+	Currency       string `json:"currency"`
+	IdempotencyKey string `json:"idempotencyKey"`
+}
+
+type transferJSON struct {
+	FromClientID   string `json:"from_client_id"`
+	ToClientID     string `json:"to_client_id"`
+	Amount         int64  `json:"amount"`
+	IdempotencyKey string `json:"idempotencyKey"`
+}
+
+type transferResultJSON struct {
+	FromClientID   string `json:"from_client_id"`
+	ToClientID     string `json:"to_client_id"`
+	Amount         int64  `json:"amount"`
+	FromNewBalance int64  `json:"from_new_balance"`
+	ToNewBalance   int64  `json:"to_new_balance"`
+	Currency       string `json:"currency"`
+	// Set instead when the transfer is held for approval
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type transferLegJSON struct {
+	ClientID string `json:"client_id"`
+	Amount   int64  `json:"amount"`
+}
+
+type splitTransferJSON struct {
+	Legs           []transferLegJSON `json:"legs"`
+	IdempotencyKey string            `json:"idempotencyKey"`
+}
+
+type splitTransferResultJSON struct {
+	Legs []struct {
+		ClientID   string `json:"client_id"`
+		Amount     int64  `json:"amount"`
+		NewBalance int64  `json:"new_balance"`
+	} `json:"legs"`
+}
+
+type ledgerJSON struct {
+	ClientID   string            `json:"client_id"`
+	Entries    []ledgerEntryJSON `json:"ledger_entires"`
+	NextCursor string            `json:"next_cursor"`
+}
+
+type ledgerEntryJSON struct {
+	EntryID        string    `json:"EntryId"`
+	ClientID       string    `json:"ClientId"`
+	Amount         int64     `json:"Amount"`
+	Currency       string    `json:"Currency"`
+	CreatedAt      time.Time `json:"CreatedAt"`
+	IdempotencyKey struct {
+		String string
+		Valid  bool
+	} `json:"IdempotencyKey"`
+}
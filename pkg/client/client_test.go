@@ -0,0 +1,508 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/koki1610168/go-payment-ledger/internal/server"
+)
+
+// fakeStore is a minimal in-memory server.ClientStore
+type fakeStore struct {
+	mu       sync.Mutex
+	balances map[string]int64
+	ledger   map[string][]server.Ledger
+	keys     map[string]int64
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		balances: map[string]int64{"client_001": 1000, "client_002": 0},
+		ledger:   map[string][]server.Ledger{},
+		keys:     map[string]int64{},
+	}
+}
+
+func (s *fakeStore) GetBalance(ctx context.Context, clientID string) (int64, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.balances[clientID]
+	if !ok {
+		return 0, "", server.ErrClientNotFound
+	}
+	return b, "JPY", nil
+}
+
+func (s *fakeStore) GetLedger(ctx context.Context, clientID string) ([]server.Ledger, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]server.Ledger{}, s.ledger[clientID]...), nil
+}
+
+func (s *fakeStore) CreatePayment(ctx context.Context, clientID string, amount int64, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.keys[key]; ok {
+		return b, nil
+	}
+	b, ok := s.balances[clientID]
+	if !ok {
+		return 0, server.ErrClientNotFound
+	}
+	if b+amount < 0 {
+		return 0, server.ErrInsufficientBalance
+	}
+	s.balances[clientID] = b + amount
+	s.keys[key] = b + amount
+	s.appendEntry(clientID, amount)
+	return b + amount, nil
+}
+
+func (s *fakeStore) Transfer(ctx context.Context, from, to string, amount int64, key string) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fromBalance, ok1 := s.balances[from]
+	toBalance, ok2 := s.balances[to]
+	if !ok1 || !ok2 {
+		return 0, 0, server.ErrClientNotFound
+	}
+	if fromBalance < amount {
+		return 0, 0, server.ErrInsufficientBalance
+	}
+	s.balances[from] -= amount
+	s.balances[to] += amount
+	s.appendEntry(from, -amount)
+	s.appendEntry(to, amount)
+	return fromBalance - amount, toBalance + amount, nil
+}
+
+func (s *fakeStore) appendEntry(clientID string, amount int64) {
+	s.ledger[clientID] = append(s.ledger[clientID], server.Ledger{
+		EntryId: uuid.New(), ClientId: clientID, Amount: amount, Currency: "JPY",
+		CreatedAt: time.Now().UTC().Add(time.Duration(len(s.ledger[clientID])) * time.Millisecond),
+	})
+}
+
+// newTestClient serves store through NewHandler, wrapped by wrap when set.
+// Requests to deprecated routes fail the test.
+func newTestClient(t *testing.T, store server.ClientStore, wrap func(http.Handler) http.Handler) (*Client, *[]time.Duration) {
+	t.Helper()
+	var h http.Handler = server.NewHandler(store)
+	if wrap != nil {
+		h = wrap(h)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+		if w.Header().Get("Deprecation") != "" {
+			t.Errorf("%s %s is deprecated", r.Method, r.URL.Path)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	var sleeps []time.Duration
+	c := New(srv.URL + "/")
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return ctx.Err()
+	}
+	return c, &sleeps
+}
+
+func TestClient_BalancePaymentTransfer(t *testing.T) {
+	c, _ := newTestClient(t, newFakeStore(), nil)
+	ctx := context.Background()
+
+	bal, err := c.GetBalance(ctx, "client_001")
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+	if bal != (Balance{ClientID: "client_001", Balance: 1000, Currency: "JPY"}) {
+		t.Errorf("got %+v", bal)
+	}
+
+	bal, err = c.CreatePayment(ctx, PaymentRequest{ClientID: "client_001", Amount: 500, Currency: "JPY"})
+	if err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+	if bal.Balance != 1500 {
+		t.Errorf("got balance %d after payment, want 1500", bal.Balance)
+	}
+
+	res, err := c.Transfer(ctx, TransferRequest{FromClientID: "client_001", ToClientID: "client_002", Amount: 300})
+	if err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+	want := TransferResult{FromClientID: "client_001", ToClientID: "client_002", Amount: 300, FromBalance: 1200, ToBalance: 300, Currency: "JPY"}
+	if res != want {
+		t.Errorf("got %+v, want %+v", res, want)
+	}
+}
+
+func TestClient_GeneratesAndKeepsIdempotencyKeys(t *testing.T) {
+	var keys []string
+	capture := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var req struct {
+				Key string `json:"idempotencyKey"`
+			}
+			json.Unmarshal(body, &req)
+			keys = append(keys, req.Key)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+	store := newFakeStore()
+	c, _ := newTestClient(t, store, capture)
+	ctx := context.Background()
+
+	for range 2 {
+		if _, err := c.CreatePayment(ctx, PaymentRequest{ClientID: "client_001", Amount: 1, Currency: "JPY"}); err != nil {
+			t.Fatalf("create payment: %v", err)
+		}
+	}
+	if _, err := c.CreatePayment(ctx, PaymentRequest{ClientID: "client_001", Amount: 1, Currency: "JPY", IdempotencyKey: "mine"}); err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+
+	if len(keys) != 3 || keys[0] == "" || keys[0] == keys[1] || keys[2] != "mine" {
+		t.Errorf("got keys %q, want two distinct generated keys and then %q", keys, "mine")
+	}
+}
+
+func TestClient_ErrorsMatchSentinels(t *testing.T) {
+	c, _ := newTestClient(t, newFakeStore(), nil)
+	ctx := context.Background()
+
+	_, err := c.GetBalance(ctx, "nobody")
+	if !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("unknown client: got %v, want ErrClientNotFound", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Code != CodeClientNotFound {
+		t.Errorf("got %v, want an *APIError with status 404 and code %s", err, CodeClientNotFound)
+	}
+
+	_, err = c.CreatePayment(ctx, PaymentRequest{ClientID: "client_001", Amount: -5000, Currency: "JPY"})
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("overdrawn payment: got %v, want ErrInsufficientBalance", err)
+	}
+
+	_, err = c.Transfer(ctx, TransferRequest{FromClientID: "client_001", ToClientID: "client_002", Amount: 5000})
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("overdrawn transfer: got %v, want ErrInsufficientBalance", err)
+	}
+	if !errors.As(err, &apiErr) || apiErr.Code != CodeInsufficientFunds || apiErr.Message != "insufficient balance" {
+		t.Errorf("got %+v, want code %s with the problem's detail", apiErr, CodeInsufficientFunds)
+	}
+
+	_, err = c.Transfer(ctx, TransferRequest{FromClientID: "client_001", ToClientID: "nobody", Amount: 5})
+	if !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("transfer to unknown client: got %v, want ErrClientNotFound", err)
+	}
+	_, err = c.Transfer(ctx, TransferRequest{FromClientID: "client_001", ToClientID: "client_001", Amount: 0})
+	if !errors.Is(err, ErrInvalidRequest) || !errors.As(err, &apiErr) {
+		t.Fatalf("invalid transfer: got %v, want ErrInvalidRequest", err)
+	}
+	if len(apiErr.Fields) != 2 || apiErr.Fields[0].Field != "to_client_id" || apiErr.Fields[1].Field != "amount" {
+		t.Errorf("got invalid fields %+v, want to_client_id and amount", apiErr.Fields)
+	}
+}
+
+// heldStore holds every transfer for approval
+type heldStore struct {
+	*fakeStore
+	expiresAt time.Time
+}
+
+func (s heldStore) Transfer(ctx context.Context, from, to string, amount int64, key string) (int64, int64, error) {
+	return 0, 0, &server.ApprovalRequiredError{Transfer: server.PendingTransfer{
+		ID: "8c4f6f0e-6a57-4a8e-9f0b-1d2a0c9e7b11", FromClientID: from, ToClientID: to, Amount: amount,
+		Status: server.TransferPending, ExpiresAt: s.expiresAt,
+	}}
+}
+
+func TestClient_TransferHeldForApproval(t *testing.T) {
+	expiresAt := time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)
+	c, _ := newTestClient(t, heldStore{newFakeStore(), expiresAt}, nil)
+
+	_, err := c.Transfer(context.Background(), TransferRequest{FromClientID: "client_001", ToClientID: "client_002", Amount: 300})
+	if !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("got %v, want ErrApprovalRequired", err)
+	}
+	var pending *PendingTransferError
+	if !errors.As(err, &pending) {
+		t.Fatalf("got %T, want a *PendingTransferError", err)
+	}
+	want := PendingTransferError{ID: "8c4f6f0e-6a57-4a8e-9f0b-1d2a0c9e7b11", Status: "pending", ExpiresAt: expiresAt}
+	if *pending != want {
+		t.Errorf("got %+v, want %+v", *pending, want)
+	}
+}
+
+// splitStore adds split transfers to fakeStore. Splits moving more than
+// 1000 need approval.
+type splitStore struct {
+	*fakeStore
+}
+
+func (s splitStore) SplitTransfer(ctx context.Context, legs []server.TransferLeg, key string) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if server.SplitTotal(legs) > 1000 {
+		return nil, server.ErrApprovalRequired
+	}
+	for _, leg := range legs {
+		if s.balances[leg.ClientID]+leg.Amount < 0 {
+			return nil, server.ErrInsufficientBalance
+		}
+	}
+	balances := make([]int64, len(legs))
+	for i, leg := range legs {
+		s.balances[leg.ClientID] += leg.Amount
+		s.appendEntry(leg.ClientID, leg.Amount)
+		balances[i] = s.balances[leg.ClientID]
+	}
+	return balances, nil
+}
+
+func TestClient_SplitTransfer(t *testing.T) {
+	store := newFakeStore()
+	store.balances["client_003"] = 0
+	c, _ := newTestClient(t, splitStore{store}, nil)
+	ctx := context.Background()
+
+	legs, err := c.SplitTransfer(ctx, SplitTransferRequest{Legs: []TransferLeg{
+		{ClientID: "client_001", Amount: -500},
+		{ClientID: "client_002", Amount: 400},
+		{ClientID: "client_003", Amount: 100},
+	}})
+	if err != nil {
+		t.Fatalf("split transfer: %v", err)
+	}
+	want := []TransferLegResult{{"client_001", -500, 500}, {"client_002", 400, 400}, {"client_003", 100, 100}}
+	if len(legs) != len(want) {
+		t.Fatalf("got %+v, want %+v", legs, want)
+	}
+	for i := range want {
+		if legs[i] != want[i] {
+			t.Errorf("leg %d is %+v, want %+v", i, legs[i], want[i])
+		}
+	}
+
+	_, err = c.SplitTransfer(ctx, SplitTransferRequest{Legs: []TransferLeg{
+		{ClientID: "client_001", Amount: -600},
+		{ClientID: "client_002", Amount: 600},
+	}})
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("overdrawn split transfer: got %v, want ErrInsufficientBalance", err)
+	}
+	_, err = c.SplitTransfer(ctx, SplitTransferRequest{Legs: []TransferLeg{
+		{ClientID: "client_002", Amount: -1001},
+		{ClientID: "client_001", Amount: 1001},
+	}})
+	var apiErr *APIError
+	if !errors.Is(err, ErrApprovalRequired) || !errors.As(err, &apiErr) || apiErr.Code != CodeApprovalRequired {
+		t.Errorf("split transfer above the threshold: got %v, want ErrApprovalRequired", err)
+	}
+	_, err = c.SplitTransfer(ctx, SplitTransferRequest{Legs: []TransferLeg{{ClientID: "client_001", Amount: -1}}})
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("single leg: got %v, want ErrInvalidRequest", err)
+	}
+}
+
+// Responses that are not problem documents, e.g. from a proxy, are
+// classified by status
+func TestClient_ErrorsWithoutProblemBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err := New(srv.URL).GetBalance(context.Background(), "client_001")
+	var apiErr *APIError
+	if !errors.Is(err, ErrServer) || !errors.As(err, &apiErr) {
+		t.Fatalf("got %v, want ErrServer", err)
+	}
+	if apiErr.Code != "" || apiErr.Message != "upstream unavailable" {
+		t.Errorf("got code %q and message %q", apiErr.Code, apiErr.Message)
+	}
+}
+
+func TestClient_GetLedgerIteratesPages(t *testing.T) {
+	store := newFakeStore()
+	var requests int
+	count := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			next.ServeHTTP(w, r)
+		})
+	}
+	c, _ := newTestClient(t, store, count)
+	ctx := context.Background()
+
+	for i := 1; i <= 5; i++ {
+		if _, err := c.CreatePayment(ctx, PaymentRequest{ClientID: "client_001", Amount: int64(i), Currency: "JPY"}); err != nil {
+			t.Fatalf("create payment: %v", err)
+		}
+	}
+
+	requests = 0
+	var amounts []int64
+	for e, err := range c.GetLedger(ctx, "client_001", 2) {
+		if err != nil {
+			t.Fatalf("get ledger: %v", err)
+		}
+		if e.ClientID != "client_001" || e.ID == "" || e.CreatedAt.IsZero() {
+			t.Errorf("incomplete entry %+v", e)
+		}
+		amounts = append(amounts, e.Amount)
+	}
+	if len(amounts) != 5 || amounts[0] != 1 || amounts[4] != 5 {
+		t.Errorf("got %v, want 1..5", amounts)
+	}
+	if requests != 3 {
+		t.Errorf("fetched %d pages, want 3", requests)
+	}
+
+	// Breaking out of the loop stops fetching
+	requests = 0
+	for range c.GetLedger(ctx, "client_001", 2) {
+		break
+	}
+	if requests != 1 {
+		t.Errorf("fetched %d pages after break, want 1", requests)
+	}
+}
+
+func TestClient_RetriesRateLimitedCalls(t *testing.T) {
+	var attempts int
+	var keys []string
+	limited := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			body, _ := io.ReadAll(r.Body)
+			var req struct {
+				Key string `json:"idempotencyKey"`
+			}
+			json.Unmarshal(body, &req)
+			keys = append(keys, req.Key)
+			if attempts <= 2 {
+				w.Header().Set("Retry-After", "7")
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+	c, sleeps := newTestClient(t, newFakeStore(), limited)
+
+	bal, err := c.CreatePayment(context.Background(), PaymentRequest{ClientID: "client_001", Amount: 10, Currency: "JPY"})
+	if err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+	if bal.Balance != 1010 {
+		t.Errorf("got balance %d, want 1010", bal.Balance)
+	}
+	if len(*sleeps) != 2 || (*sleeps)[0] != 7*time.Second || (*sleeps)[1] != 7*time.Second {
+		t.Errorf("slept %v, want Retry-After twice", *sleeps)
+	}
+	if keys[0] != keys[1] || keys[1] != keys[2] {
+		t.Errorf("retries changed the idempotency key: %q", keys)
+	}
+
+	// Out of retries the 429 is returned
+	attempts = 0
+	_, err = c.WithRetries(1).GetBalance(context.Background(), "client_001")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("got %v, want ErrRateLimited", err)
+	}
+}
+
+func TestClient_RetriesNetworkErrors(t *testing.T) {
+	var attempts int
+	flaky := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts == 1 {
+				// Drop the connection after the request has been read,
+				// as a crashing proxy would
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	store := newFakeStore()
+	c, sleeps := newTestClient(t, store, flaky)
+	c.WithBackoff(time.Second, 4*time.Second)
+
+	res, err := c.Transfer(context.Background(), TransferRequest{FromClientID: "client_001", ToClientID: "client_002", Amount: 100})
+	if err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+	if res.FromBalance != 900 || attempts != 2 {
+		t.Errorf("got %+v after %d attempts", res, attempts)
+	}
+	if len(*sleeps) != 1 || (*sleeps)[0] < 500*time.Millisecond || (*sleeps)[0] > time.Second {
+		t.Errorf("slept %v, want one jittered backoff of at most 1s", *sleeps)
+	}
+}
+
+func TestClient_StopsRetryingWhenContextEnds(t *testing.T) {
+	limited := func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		})
+	}
+	c, _ := newTestClient(t, newFakeStore(), limited)
+	c.sleep = sleepContext
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.GetBalance(ctx, "client_001")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("waited out Retry-After despite the deadline")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"3", 3 * time.Second, true},
+		{"0", 0, true},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.header, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %v, %v, want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Errors returned by the service. Every *APIError matches one of them
+// with errors.Is.
+var (
+	ErrClientNotFound        = errors.New("client not found")
+	ErrInsufficientBalance   = errors.New("insufficient balance")
+	ErrVelocityLimitExceeded = errors.New("velocity limit exceeded")
+	ErrInvalidRequest        = errors.New("invalid request")
+	ErrUnauthorized          = errors.New("unauthorized")
+	ErrForbidden             = errors.New("forbidden")
+	ErrRateLimited           = errors.New("rate limited")
+	ErrConflict              = errors.New("conflict")
+	ErrNotFound              = errors.New("not found")
+	ErrServer                = errors.New("server error")
+	// ErrApprovalRequired is matched by the *PendingTransferError of a
+	// transfer held for approval, and by the error of a split transfer
+	// that would need approval
+	ErrApprovalRequired = errors.New("transfer requires approval")
+)
+
+// Error codes the server sends in APIError.Code. They never change
+// meaning, so they are safe to branch on where the sentinels above are
+// too coarse, e.g. to tell CodeClientExists from CodeIdempotencyConflict.
+const (
+	CodeInvalidRequest        = "invalid_request"
+	CodeUnauthorized          = "unauthorized"
+	CodeForbidden             = "forbidden"
+	CodeNotFound              = "not_found"
+	CodeClientNotFound        = "client_not_found"
+	CodeVelocityLimitNotFound = "velocity_limit_not_found"
+	CodeFeeScheduleNotFound   = "fee_schedule_not_found"
+	CodeInterestRateNotFound  = "interest_rate_not_found"
+	CodeTransferNotFound      = "transfer_not_found"
+	CodeMethodNotAllowed      = "method_not_allowed"
+	CodeRequestTooLarge       = "request_too_large"
+	CodeUnsupportedMediaType  = "unsupported_media_type"
+	CodeClientExists          = "client_exists"
+	CodeIdempotencyConflict   = "idempotency_conflict"
+	CodeFeeScheduleExists     = "fee_schedule_exists"
+	CodeTransferNotPending    = "transfer_not_pending"
+	CodeTransferExpired       = "transfer_expired"
+	CodeSelfApproval          = "self_approval"
+	CodeInsufficientFunds     = "insufficient_funds"
+	CodeAmountOutOfRange      = "amount_out_of_range"
+	CodeCurrencyMismatch      = "currency_mismatch"
+	CodeApprovalRequired      = "approval_required"
+	CodeVelocityLimitExceeded = "velocity_limit_exceeded"
+	CodeRateLimited           = "rate_limited"
+	CodeInternal              = "internal_error"
+	CodeNotImplemented        = "not_implemented"
+)
+
+var sentinelForCode = map[string]error{
+	CodeInvalidRequest:        ErrInvalidRequest,
+	CodeUnauthorized:          ErrUnauthorized,
+	CodeForbidden:             ErrForbidden,
+	CodeNotFound:              ErrNotFound,
+	CodeClientNotFound:        ErrClientNotFound,
+	CodeVelocityLimitNotFound: ErrNotFound,
+	CodeFeeScheduleNotFound:   ErrNotFound,
+	CodeInterestRateNotFound:  ErrNotFound,
+	CodeTransferNotFound:      ErrNotFound,
+	CodeMethodNotAllowed:      ErrInvalidRequest,
+	CodeRequestTooLarge:       ErrInvalidRequest,
+	CodeUnsupportedMediaType:  ErrInvalidRequest,
+	CodeClientExists:          ErrConflict,
+	CodeIdempotencyConflict:   ErrConflict,
+	CodeFeeScheduleExists:     ErrConflict,
+	CodeTransferNotPending:    ErrConflict,
+	CodeTransferExpired:       ErrConflict,
+	CodeSelfApproval:          ErrForbidden,
+	CodeInsufficientFunds:     ErrInsufficientBalance,
+	CodeAmountOutOfRange:      ErrInvalidRequest,
+	CodeCurrencyMismatch:      ErrInvalidRequest,
+	CodeApprovalRequired:      ErrApprovalRequired,
+	CodeVelocityLimitExceeded: ErrVelocityLimitExceeded,
+	CodeRateLimited:           ErrRateLimited,
+	CodeInternal:              ErrServer,
+	CodeNotImplemented:        ErrServer,
+}
+
+// APIError is a non-2xx response
+type APIError struct {
+	StatusCode int
+	// Code is the problem's error code, or empty when the response was
+	// not a problem document, e.g. from a proxy in front of the server
+	Code string
+	// Message is the problem's detail, or the response body as sent
+	Message string
+	// RequestID identifies the request in the server's logs
+	RequestID string
+	// Fields lists every invalid field of a rejected request body
+	Fields []FieldError
+}
+
+// FieldError is one invalid field of a request body
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("ledger api: %d %s: %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Code, e.Message)
+	}
+	return fmt.Sprintf("ledger api: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Unwrap classifies the response by its code. Responses without a known
+// code, from older servers or from proxies, are classified by status.
+func (e *APIError) Unwrap() error {
+	if err, ok := sentinelForCode[e.Code]; ok {
+		return err
+	}
+
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrClientNotFound
+	case http.StatusUnprocessableEntity:
+		return ErrVelocityLimitExceeded
+	case http.StatusBadRequest:
+		return ErrInvalidRequest
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusConflict:
+		return ErrConflict
+	}
+	return ErrServer
+}
+
+// PendingTransferError is returned by Transfer when the server accepted
+// the transfer but holds it until a second principal approves it. Nothing
+// has moved yet. Replaying the idempotency key returns it again, with the
+// transfer's status, until the transfer is approved.
+type PendingTransferError struct {
+	// ID names the transfer at /v1/transfers/{id}
+	ID     string
+	Status string
+	// ExpiresAt is when the transfer expires unless decided
+	ExpiresAt time.Time
+}
+
+func (e *PendingTransferError) Error() string {
+	return fmt.Sprintf("ledger api: transfer %s requires approval: %s", e.ID, e.Status)
+}
+
+func (e *PendingTransferError) Unwrap() error {
+	return ErrApprovalRequired
+}
+
+// problem is the server's RFC 7807 error body
+type problem struct {
+	Detail    string       `json:"detail"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id"`
+	Errors    []FieldError `json:"errors"`
+}